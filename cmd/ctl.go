@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/ovh/svfs/svfs"
+	"github.com/spf13/cobra"
+)
+
+var ctlSocket string
+
+func init() {
+	ctlCmd.PersistentFlags().StringVar(&ctlSocket, "admin-socket", "", "Unix socket serving the admin API of the mount")
+	ctlCmd.AddCommand(ctlOptionsCmd, ctlInvalidateCmd, ctlChangesCmd, ctlHandlesCmd, ctlReauthenticateCmd, ctlLogLevelCmd)
+
+	RootCmd.AddCommand(ctlCmd)
+}
+
+// Talk to the admin API of a running mount.
+var ctlCmd = &cobra.Command{
+	Use:   "ctl",
+	Short: "Inspect and control a mounted filesystem",
+	Long: "Send requests to the admin API of a filesystem mounted with\n" +
+		"the --admin-socket option.",
+}
+
+var ctlOptionsCmd = &cobra.Command{
+	Use:   "options",
+	Short: "Show effective mount options",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return ctlRequest(cmd, "GET", "/options", nil)
+	},
+}
+
+var ctlInvalidateCmd = &cobra.Command{
+	Use:   "invalidate [path]",
+	Short: "Drop cached directory listings",
+	Long: "Drop cached listings of a directory, given relative to the\n" +
+		"mountpoint, and its subdirectories. All listings are dropped\n" +
+		"when no path is given.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return fmt.Errorf("At most one path is expected")
+		}
+		path := "/cache"
+		if len(args) == 1 {
+			path += "?path=" + url.QueryEscape(args[0])
+		}
+		return ctlRequest(cmd, "DELETE", path, nil)
+	},
+}
+
+var ctlChangesCmd = &cobra.Command{
+	Use:   "changes",
+	Short: "List files being modified or uploaded",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return ctlRequest(cmd, "GET", "/changes", nil)
+	},
+}
+
+var ctlHandlesCmd = &cobra.Command{
+	Use:   "handles",
+	Short: "List open files with bytes uploaded",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return ctlRequest(cmd, "GET", "/handles", nil)
+	},
+}
+
+var ctlReauthenticateCmd = &cobra.Command{
+	Use:   "reauthenticate",
+	Short: "Renew the authentication token",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return ctlRequest(cmd, "POST", "/reauthenticate", nil)
+	},
+}
+
+var ctlLogLevelCmd = &cobra.Command{
+	Use:   "log-level [level]",
+	Short: "Show or change the log level",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch len(args) {
+		case 0:
+			return ctlRequest(cmd, "GET", "/log-level", nil)
+		case 1:
+			return ctlRequest(cmd, "PUT", "/log-level", svfs.AdminLogLevel{Level: args[0]})
+		}
+		return fmt.Errorf("At most one level is expected")
+	},
+}
+
+// ctlRequest sends a request to the admin socket and prints the
+// JSON response.
+func ctlRequest(cmd *cobra.Command, method, path string, body interface{}) error {
+	cmd.SilenceUsage = true
+
+	if ctlSocket == "" {
+		return fmt.Errorf("Admin socket is required")
+	}
+
+	var rd io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rd = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, "http://svfs"+path, rd)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return net.Dial("unix", ctlSocket)
+		},
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("%s", strings.TrimSpace(string(data)))
+	}
+
+	if len(data) == 0 {
+		color.Green("Done.")
+		return nil
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, data, "", "  "); err != nil {
+		return err
+	}
+	fmt.Println(strings.TrimSpace(out.String()))
+
+	return nil
+}
@@ -0,0 +1,238 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"time"
+
+	fuse "bazil.org/fuse"
+	fusefs "bazil.org/fuse/fs"
+	"github.com/Sirupsen/logrus"
+	"github.com/ovh/svfs/config"
+	"github.com/ovh/svfs/svfs"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	daemonConfigFile string
+	daemonMounts     = make(map[string]*daemonMount)
+)
+
+// daemonMount is a filesystem served by the daemon.
+type daemonMount struct {
+	config config.Mount
+	fs     *svfs.SVFS
+	conn   *fuse.Conn
+	admin  net.Listener
+	served chan struct{}
+}
+
+func init() {
+	flags := daemonCmd.PersistentFlags()
+	setClientFlags(flags)
+	flags.StringVar(&daemonConfigFile, "config-file", "/etc/svfs/mounts.yaml", "Configuration file listing mounts")
+	flags.BoolVar(&debug, "debug", false, "Enable fuse debug log")
+	flags.StringVar(&metricsAddr, "metrics-bind", "", "Prometheus metrics will be served at this address")
+
+	RootCmd.AddCommand(daemonCmd)
+}
+
+// Serve several filesystems from a single process.
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Mount filesystems listed in a configuration file",
+	Long: "Mount every filesystem listed in a configuration file and serve\n" +
+		"them from a single process. The configuration file is read\n" +
+		"again on SIGHUP : new filesystems are mounted, removed ones are\n" +
+		"unmounted and changed ones are mounted again.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		// Debug
+		if debug {
+			setDebug()
+		}
+
+		// Prometheus metrics
+		if metricsAddr != "" {
+			go func() {
+				if err := http.ListenAndServe(metricsAddr, svfs.MetricsHandler()); err != nil {
+					logrus.Fatal(err)
+				}
+			}()
+		}
+
+		cfg, err := config.LoadDaemon(daemonConfigFile)
+		if err != nil {
+			return err
+		}
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+
+		reloadMounts(cfg)
+
+		for sig := range signals {
+			if sig != syscall.SIGHUP {
+				break
+			}
+
+			logrus.Infoln("Reloading configuration")
+			cfg, err := config.LoadDaemon(daemonConfigFile)
+			if err != nil {
+				logrus.Errorln("Failed to reload configuration :", err)
+				continue
+			}
+			reloadMounts(cfg)
+		}
+
+		// Unmount everything before exiting
+		reloadMounts(new(config.Daemon))
+
+		return nil
+	},
+}
+
+// reloadMounts unmounts filesystems removed from the configuration,
+// changed or unmounted by someone else, then mounts the missing ones.
+// Failures are logged and retried on the next reload.
+func reloadMounts(cfg *config.Daemon) {
+	for name, m := range daemonMounts {
+		if mc, ok := cfg.Mounts[name]; ok && reflect.DeepEqual(mc, m.config) && !m.stopped() {
+			continue
+		}
+
+		log := logrus.WithField("mount", name)
+		if err := m.unmount(); err != nil {
+			log.Errorln("Failed to unmount :", err)
+			continue
+		}
+		delete(daemonMounts, name)
+		log.Infoln("Unmounted", m.config.Mountpoint)
+	}
+
+	for name, mc := range cfg.Mounts {
+		if _, ok := daemonMounts[name]; ok {
+			continue
+		}
+
+		log := logrus.WithField("mount", name)
+		m, err := mountFilesystem(name, mc)
+		if err != nil {
+			log.Errorln("Failed to mount :", err)
+			continue
+		}
+		daemonMounts[name] = m
+		log.Infoln("Mounted", mc.Mountpoint)
+	}
+}
+
+// mountFilesystem mounts and serves a filesystem configured with
+// the options of the mount command.
+func mountFilesystem(name string, mc config.Mount) (*daemonMount, error) {
+	var localDir, adminSocket string
+
+	fs := svfs.New()
+	flags := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	setSwiftFlags(flags, fs.Connection)
+	setFilesystemFlags(flags, fs, &localDir)
+	setAdminFlags(flags, &adminSocket)
+
+	for option, value := range mc.Options {
+		if err := flags.Set(option, value); err != nil {
+			return nil, fmt.Errorf("Invalid option %s : %v", option, err)
+		}
+	}
+
+	// Pending operations must not be replayed by another mount,
+	// nor their staged files removed by it
+	fs.JournalDir = filepath.Join(fs.JournalDir, name)
+	fs.SpoolDir = filepath.Join(fs.SpoolDir, name)
+
+	if err := checkOptions(fs); err != nil {
+		return nil, err
+	}
+	if err := setStorage(fs, localDir); err != nil {
+		return nil, err
+	}
+
+	c, err := fuse.Mount(mc.Mountpoint, mountOptions(fs, mc.Device)...)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &daemonMount{
+		config: mc,
+		fs:     fs,
+		conn:   c,
+		served: make(chan struct{}),
+	}
+
+	fs.MountTime = time.Now()
+	if err = fs.Init(); err != nil {
+		fs.Stop()
+		fuse.Unmount(mc.Mountpoint)
+		c.Close()
+		return nil, err
+	}
+
+	if adminSocket != "" {
+		if m.admin, err = serveAdmin(fs, adminSocket); err != nil {
+			fs.Stop()
+			fuse.Unmount(mc.Mountpoint)
+			c.Close()
+			return nil, err
+		}
+	}
+
+	srv := fusefs.New(c, serverConfig())
+	go func() {
+		if err := srv.Serve(fs); err != nil {
+			logrus.WithField("mount", name).Errorln("Failed to serve filesystem :", err)
+		}
+		close(m.served)
+	}()
+
+	// Check for mount errors
+	<-c.Ready
+	if err = c.MountError; err != nil {
+		m.unmount()
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// stopped tells whether the filesystem isn't served anymore.
+func (m *daemonMount) stopped() bool {
+	select {
+	case <-m.served:
+		return true
+	default:
+		return false
+	}
+}
+
+// unmount unmounts the filesystem unless already done, waits
+// for pending requests to be served and stops its background
+// services.
+func (m *daemonMount) unmount() error {
+	if !m.stopped() {
+		if err := fuse.Unmount(m.config.Mountpoint); err != nil {
+			return err
+		}
+		<-m.served
+	}
+	if m.admin != nil {
+		m.admin.Close()
+	}
+	m.fs.Stop()
+	return m.conn.Close()
+}
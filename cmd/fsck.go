@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/fatih/color"
+	"github.com/ovh/svfs/svfs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	fsckRepair bool
+	checker    = new(svfs.Checker)
+)
+
+func init() {
+	flags := fsckCmd.PersistentFlags()
+	setClientFlags(flags)
+	setSwiftFlags(flags, fs.Connection)
+	flags.StringVar(&checker.Container, "os-container-name", "", "Container to check")
+	flags.Uint64Var(&checker.Concurrency, "concurrency", 20, "Objects inspected concurrently")
+	flags.BoolVar(&checker.StaticLargeObjects, "static-large-objects", true, "Inspect every object to find static large object manifests")
+	flags.BoolVar(&fsckRepair, "repair", false, "Repair problems when possible")
+	flags.BoolVar(&checker.ReplaceBrokenManifests, "replace-broken-manifests", false, "Allow repairs replacing manifests referencing no segments with empty objects")
+
+	RootCmd.AddCommand(fsckCmd)
+}
+
+// Check a container consistency.
+var fsckCmd = &cobra.Command{
+	Use:   "fsck --os-container-name name",
+	Short: "Check consistency of a container and its segments",
+	Long: "Walk a container and its segment container, checking large object\n" +
+		"manifests, directory markers and symlinks. Problems are reported\n" +
+		"and repaired when possible if --repair is given.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		if checker.Container == "" {
+			return fmt.Errorf("A container name is required")
+		}
+
+		// Use config file or ENV var if set
+		bindSwiftFlags(cmd.PersistentFlags())
+		useConfiguration()
+
+		if err := fs.Connection.Authenticate(); err != nil {
+			return err
+		}
+		checker.Storage = fs.Connection
+
+		problems, err := checker.Check()
+		if err != nil {
+			return err
+		}
+
+		if len(problems) == 0 {
+			color.Green("No problems found.")
+			return nil
+		}
+
+		var repaired, failed int
+		for _, problem := range problems {
+			fmt.Printf("%s: %s\n", problem.Path, problem.Description)
+			if !fsckRepair || !problem.Repairable() {
+				continue
+			}
+			if err := problem.Repair(); err != nil {
+				color.Red("  repair failed: %v", err)
+				failed++
+				continue
+			}
+			color.Green("  repaired")
+			repaired++
+		}
+
+		color.Yellow("\nFound %d problems.", len(problems))
+		if fsckRepair {
+			color.White("Repaired %d problems, %d repairs failed.", repaired, failed)
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d repairs failed", failed)
+		}
+
+		return nil
+	},
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/ovh/svfs/svfs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	gcApply   bool
+	collector = new(svfs.GarbageCollector)
+)
+
+func init() {
+	flags := gcCmd.PersistentFlags()
+	setClientFlags(flags)
+	setSwiftFlags(flags, fs.Connection)
+	flags.StringVar(&collector.Container, "os-container-name", "", "Only collect segments of this container")
+	flags.DurationVar(&collector.MinAge, "min-age", 24*time.Hour, "Ignore segments more recent than this")
+	flags.Uint64Var(&collector.Concurrency, "concurrency", 20, "Objects inspected or deleted concurrently")
+	flags.BoolVar(&gcApply, "apply", false, "Delete orphaned segments")
+
+	RootCmd.AddCommand(gcCmd)
+}
+
+// Find and delete orphaned segments.
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Find segments not referenced by any manifest",
+	Long: "Scan large object manifests and report segments they don't\n" +
+		"reference, usually left behind by interrupted writes.\n" +
+		"Orphaned segments are deleted when --apply is given.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		// Use config file or ENV var if set
+		bindSwiftFlags(cmd.PersistentFlags())
+		useConfiguration()
+
+		if err := fs.Connection.Authenticate(); err != nil {
+			return err
+		}
+		collector.Storage = fs.Connection
+
+		orphans, err := collector.Find()
+		if err != nil {
+			return err
+		}
+
+		if len(orphans) == 0 {
+			color.Green("No orphaned segments found.")
+			return nil
+		}
+
+		var segments, size int64
+		for _, orphan := range orphans {
+			fmt.Printf("%s/%s (%d segments, %d bytes)\n", orphan.Container, orphan.Prefix, len(orphan.Segments), orphan.Bytes)
+			segments += int64(len(orphan.Segments))
+			size += orphan.Bytes
+		}
+		color.Yellow("\nFound %d orphaned segments using %d bytes.", segments, size)
+
+		if !gcApply {
+			color.White("Use --apply to delete them.")
+			return nil
+		}
+
+		if err := collector.Delete(orphans); err != nil {
+			return err
+		}
+
+		color.Green("Orphaned segments deleted.")
+
+		return nil
+	},
+}
@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/fatih/color"
+	"github.com/ovh/svfs/config"
+	"github.com/ovh/svfs/svfs"
+	"github.com/spf13/cobra"
+)
+
+var (
+	hubicClientID     string
+	hubicClientSecret string
+	hubicRedirectURL  string
+	hubicConfigFile   string
+)
+
+// hubicCallback is the result of an authorization request, as
+// received by the redirect listener.
+type hubicCallback struct {
+	code string
+	err  error
+}
+
+func init() {
+	flags := hubicAuthCmd.PersistentFlags()
+	flags.StringVar(&hubicClientID, "client-id", "", "Client ID of the hubiC application")
+	flags.StringVar(&hubicClientSecret, "client-secret", "", "Client secret of the hubiC application")
+	flags.StringVar(&hubicRedirectURL, "redirect-url", "http://localhost:8080/", "Redirect URL of the hubiC application, served locally")
+	flags.StringVar(&hubicConfigFile, "config-file", "/etc/svfs.yaml", "Configuration file where credentials are written")
+
+	RootCmd.AddCommand(hubicAuthCmd)
+}
+
+// Register a hubiC application for svfs.
+var hubicAuthCmd = &cobra.Command{
+	Use:   "hubic-auth",
+	Short: "Get hubiC credentials for an application",
+	Long: "Authorize a hubiC application to access your credentials and\n" +
+		"store the resulting hubic_auth and hubic_token options in a\n" +
+		"configuration file. The redirect URL of the application must\n" +
+		"point to this host, where a listener receives the authorization.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		if hubicClientID == "" || hubicClientSecret == "" {
+			return fmt.Errorf("Client ID and client secret are required")
+		}
+
+		redirect, err := url.Parse(hubicRedirectURL)
+		if err != nil || redirect.Scheme != "http" || redirect.Host == "" {
+			return fmt.Errorf("Redirect URL must be an http URL pointing to this host")
+		}
+
+		state, err := randomState()
+		if err != nil {
+			return err
+		}
+
+		// Wait for the browser to be redirected with the authorization code
+		listener, err := net.Listen("tcp", redirect.Host)
+		if err != nil {
+			return err
+		}
+
+		callbacks := make(chan hubicCallback, 1)
+		server := &http.Server{Handler: hubicRedirectHandler(redirect.Path, state, callbacks)}
+		go server.Serve(listener)
+		defer server.Close()
+
+		color.White("Open the following URL in your browser and grant access to your credentials :\n")
+		fmt.Println(svfs.HubicAuthorizationURL(hubicClientID, hubicRedirectURL, state))
+
+		callback := <-callbacks
+		if callback.err != nil {
+			return callback.err
+		}
+
+		auth, token, err := svfs.HubicRequestRefreshToken(hubicClientID, hubicClientSecret, hubicRedirectURL, callback.code)
+		if err != nil {
+			return err
+		}
+
+		if err := config.UpdateFile(hubicConfigFile, map[string]string{
+			"hubic_auth":  auth,
+			"hubic_token": token,
+		}); err != nil {
+			return err
+		}
+
+		color.Green("\nCredentials written to %s.", hubicConfigFile)
+
+		return nil
+	},
+}
+
+// hubicRedirectHandler receives the authorization code once the
+// browser is redirected by hubiC.
+func hubicRedirectHandler(path, state string, callbacks chan<- hubicCallback) http.Handler {
+	if path == "" {
+		path = "/"
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			http.NotFound(w, r)
+			return
+		}
+
+		query := r.URL.Query()
+
+		// Ignore requests not originating from our authorization request
+		if query.Get("state") != state {
+			http.Error(w, "Invalid state", http.StatusBadRequest)
+			return
+		}
+
+		var callback hubicCallback
+		if e := query.Get("error"); e != "" {
+			if description := query.Get("error_description"); description != "" {
+				e += " (" + description + ")"
+			}
+			callback.err = fmt.Errorf("Authorization denied : %s", e)
+			http.Error(w, callback.err.Error(), http.StatusForbidden)
+		} else if callback.code = query.Get("code"); callback.code == "" {
+			callback.err = fmt.Errorf("No authorization code in redirect URL")
+			http.Error(w, callback.err.Error(), http.StatusBadRequest)
+		} else {
+			fmt.Fprintln(w, "Authorization received, you can close this window.")
+		}
+
+		select {
+		case callbacks <- callback:
+		default:
+		}
+	})
+}
+
+func randomState() (string, error) {
+	state := make([]byte, 16)
+	if _, err := rand.Read(state); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(state), nil
+}
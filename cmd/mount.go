@@ -2,10 +2,12 @@ package cmd
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	_ "net/http/pprof" // profiling server
 	"os"
 	"os/user"
+	"path/filepath"
 	"runtime/pprof"
 	"strconv"
 	"time"
@@ -17,6 +19,7 @@ import (
 	"github.com/ovh/svfs/config"
 	"github.com/ovh/svfs/svfs"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"github.com/xlucas/swift"
 )
@@ -26,9 +29,12 @@ var (
 	debug       bool
 	gid         uint64
 	uid         uint64
-	fs          svfs.SVFS
+	fs          = svfs.New()
 	srv         *fusefs.Server
 	profAddr    string
+	metricsAddr string
+	adminSocket string
+	localDir    string
 	cpuProf     string
 	memProf     string
 	cfgFile     string
@@ -75,7 +81,7 @@ var mountCmd = &cobra.Command{
 		}
 
 		// Mount time
-		svfs.MountTime = time.Now()
+		fs.MountTime = time.Now()
 
 		// Config validation
 		if configError != nil {
@@ -89,6 +95,7 @@ var mountCmd = &cobra.Command{
 		cmd.MarkPersistentFlagRequired("mountpoint")
 
 		// Use config file or ENV var if set
+		bindSwiftFlags(cmd.PersistentFlags())
 		useConfiguration()
 
 		// Live profiling
@@ -100,6 +107,15 @@ var mountCmd = &cobra.Command{
 			}()
 		}
 
+		// Prometheus metrics
+		if metricsAddr != "" {
+			go func() {
+				if err := http.ListenAndServe(metricsAddr, svfs.MetricsHandler()); err != nil {
+					logrus.Fatal(err)
+				}
+			}()
+		}
+
 		// CPU profiling
 		if cpuProf != "" {
 			createCPUProf(cpuProf)
@@ -107,12 +123,17 @@ var mountCmd = &cobra.Command{
 		}
 
 		// Check segment size
-		if err := checkOptions(); err != nil {
+		if err := checkOptions(fs); err != nil {
+			logrus.Fatal(err)
+		}
+
+		// Select object storage
+		if err := setStorage(fs, localDir); err != nil {
 			logrus.Fatal(err)
 		}
 
 		// Mount SVFS
-		c, err := fuse.Mount(mountpoint, mountOptions(device)...)
+		c, err := fuse.Mount(mountpoint, mountOptions(fs, device)...)
 		if err != nil {
 			goto Err
 		}
@@ -123,10 +144,20 @@ var mountCmd = &cobra.Command{
 		if err = fs.Init(); err != nil {
 			goto Err
 		}
+		defer fs.Stop()
+
+		// Admin API
+		if adminSocket != "" {
+			var admin net.Listener
+			if admin, err = serveAdmin(fs, adminSocket); err != nil {
+				goto Err
+			}
+			defer admin.Close()
+		}
 
 		// Serve SVFS
-		srv = fusefs.New(c, nil)
-		if err = srv.Serve(&fs); err != nil {
+		srv = fusefs.New(c, serverConfig())
+		if err = srv.Serve(fs); err != nil {
 			goto Err
 		}
 
@@ -153,105 +184,255 @@ var mountCmd = &cobra.Command{
 func setFlags() {
 	flags := mountCmd.PersistentFlags()
 
-	//Swift options
-	flags.StringVar(&svfs.SwiftConnection.AuthUrl, "os-auth-url", "https://auth.cloud.ovh.net/v2.0", "Authentification URL")
-	flags.StringVar(&svfs.TargetContainer, "os-container-name", "", "Container name")
-	flags.StringVar(&svfs.SwiftConnection.AuthToken, "os-auth-token", "", "Authentification token")
-	flags.StringVar(&svfs.SwiftConnection.UserName, "os-username", "", "Username")
-	flags.StringVar(&svfs.SwiftConnection.ApiKey, "os-password", "", "User password")
-	flags.StringVar(&svfs.SwiftConnection.Region, "os-region-name", "", "Region name")
-	flags.StringVar(&svfs.SwiftConnection.StorageUrl, "os-storage-url", "", "Storage URL")
-	flags.BoolVar(&svfs.SwiftConnection.Internal, "os-internal-endpoint", false, "Use internal storage URL")
-	flags.StringVar(&svfs.SwiftConnection.Tenant, "os-tenant-name", "", "Tenant name")
-	flags.IntVar(&svfs.SwiftConnection.AuthVersion, "os-auth-version", 0, "Authentification version, 0 = auto")
-	flags.DurationVar(&svfs.SwiftConnection.ConnectTimeout, "os-connect-timeout", 15*time.Second, "Swift connection timeout")
-	flags.DurationVar(&svfs.SwiftConnection.Timeout, "os-request-timeout", 5*time.Minute, "Swift operation timeout")
-	flags.Uint64Var(&svfs.SegmentSize, "os-segment-size", 256, "Swift segment size in MiB")
-	flags.StringVar(&svfs.StoragePolicy, "os-storage-policy", "", "Only show containers using this storage policy")
-	flags.StringVar(&swift.DefaultUserAgent, "user-agent", "svfs/"+svfs.Version, "Default User-Agent")
-	flags.StringVar(&swift.ClientIP, "client-ip", "", "Client IP")
-
-	//HubiC options
-	flags.StringVar(&svfs.HubicAuthorization, "hubic-authorization", "", "hubiC authorization code")
-	flags.StringVar(&svfs.HubicRefreshToken, "hubic-refresh-token", "", "hubiC refresh token")
-	flags.BoolVar(&svfs.HubicTimes, "hubic-times", false, "Use file times set by hubiC synchronization clients")
-
-	// Permissions
-	flags.Uint64Var(&svfs.DefaultUID, "default-uid", uid, "Default UID")
-	flags.Uint64Var(&svfs.DefaultGID, "default-gid", gid, "Default GID")
-	flags.Uint64Var(&svfs.DefaultMode, "default-mode", 0700, "Default permissions")
-	flags.BoolVar(&svfs.AllowRoot, "allow-root", false, "Fuse allow-root option")
-	flags.BoolVar(&svfs.AllowOther, "allow-other", true, "Fuse allow_other option")
-	flags.BoolVar(&svfs.DefaultPermissions, "default-permissions", true, "Fuse default_permissions option")
-	flags.BoolVar(&svfs.ReadOnly, "read-only", false, "Read only access")
-
-	// Prefetch
-	flags.Uint64Var(&svfs.ListerConcurrency, "readdir-concurrency", 20, "Directory listing concurrency")
-	flags.BoolVar(&svfs.Attr, "readdir-base-attributes", false, "Fetch base attributes")
-	flags.BoolVar(&svfs.Xattr, "readdir-extended-attributes", false, "Fetch extended attributes")
-	flags.UintVar(&svfs.BlockSize, "block-size", 4096, "Block size in bytes")
-	flags.UintVar(&svfs.ReadAheadSize, "readahead-size", 128, "Per file readhead size in KiB")
-	flags.IntVar(&svfs.TransferMode, "transfer-mode", 0, "Transfer optimizations mode")
-
-	// Cache Options
-	flags.DurationVar(&svfs.CacheTimeout, "cache-ttl", 1*time.Minute, "Cache timeout")
-	flags.Int64Var(&svfs.CacheMaxEntries, "cache-max-entries", -1, "Maximum overall entries allowed in cache")
-	flags.Int64Var(&svfs.CacheMaxAccess, "cache-max-access", -1, "Maximum access count to cached entries")
+	setClientFlags(flags)
+	setSwiftFlags(flags, fs.Connection)
+	setFilesystemFlags(flags, fs, &localDir)
 
 	// Debug and profiling
 	flags.BoolVar(&debug, "debug", false, "Enable fuse debug log")
 	flags.StringVar(&profAddr, "profile-bind", "", "Profiling information will be served at this address")
 	flags.StringVar(&cpuProf, "profile-cpu", "", "Write cpu profile to this file")
 	flags.StringVar(&memProf, "profile-ram", "", "Write memory profile to this file")
+	flags.StringVar(&metricsAddr, "metrics-bind", "", "Prometheus metrics will be served at this address")
+	setAdminFlags(flags, &adminSocket)
 
 	// Mandatory flags
 	flags.StringVar(&device, "device", "", "Device name")
 	flags.StringVar(&mountpoint, "mountpoint", "", "Mountpoint")
 
 	mountCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+}
+
+// setFilesystemFlags adds flags configuring the given filesystem to
+// the given flag set, the local storage directory being stored in
+// localDir.
+func setFilesystemFlags(flags *pflag.FlagSet, fs *svfs.SVFS, localDir *string) {
+	//Swift options
+	flags.StringVar(&fs.TargetContainer, "os-container-name", "", "Container name")
+	flags.Uint64Var(&fs.SegmentSize, "os-segment-size", 256, "Swift segment size in MiB")
+	flags.Uint64Var(&fs.SegmentConcurrency, "os-segment-concurrency", 1, "Swift segments uploaded concurrently per file")
+	flags.Uint64Var(&fs.SegmentBufferSize, "os-segment-buffer", 16, "Memory buffer size in MiB for each segment upload")
+	flags.StringVar(&fs.StoragePolicy, "os-storage-policy", "", "Only show containers using this storage policy")
+	flags.BoolVar(&fs.StaticLargeObjects, "os-static-large-objects", false, "Write segmented files as static large objects")
+	flags.StringVar(&fs.MimeTypesFile, "mime-types-file", "", "File mapping extensions to content types of uploaded files, in the mime.types format")
+
+	// Local storage options
+	flags.StringVar(localDir, "local-dir", "", "Serve containers stored in this local directory instead of swift")
+
+	//HubiC options
+	flags.BoolVar(&fs.HubicTimes, "hubic-times", false, "Use file times set by hubiC synchronization clients")
+
+	// Permissions
+	flags.Uint64Var(&fs.DefaultUID, "default-uid", uid, "Default UID")
+	flags.Uint64Var(&fs.DefaultGID, "default-gid", gid, "Default GID")
+	flags.Uint64Var(&fs.DefaultMode, "default-mode", 0700, "Default permissions")
+	flags.BoolVar(&fs.AllowRoot, "allow-root", false, "Fuse allow-root option")
+	flags.BoolVar(&fs.AllowOther, "allow-other", true, "Fuse allow_other option")
+	flags.BoolVar(&fs.DefaultPermissions, "default-permissions", true, "Fuse default_permissions option")
+	flags.BoolVar(&fs.FilePermissions, "file-permissions", false, "Store per-file mode, uid and gid in object metadata")
+	flags.BoolVar(&fs.ReadOnly, "read-only", false, "Read only access")
+
+	// Prefetch
+	flags.Uint64Var(&fs.ListerConcurrency, "readdir-concurrency", 20, "Directory listing concurrency")
+	flags.BoolVar(&fs.Attr, "readdir-base-attributes", false, "Fetch base attributes")
+	flags.BoolVar(&fs.Xattr, "readdir-extended-attributes", false, "Fetch extended attributes")
+	flags.BoolVar(&fs.UpdateParentMtime, "update-parent-mtime", false, "Update directory modification time when entries are added or removed")
+	flags.UintVar(&fs.BlockSize, "block-size", 4096, "Block size in bytes")
+	flags.UintVar(&fs.ReadAheadSize, "readahead-size", 128, "Per file readhead size in KiB")
+	flags.Uint64Var(&fs.PrefetchConcurrency, "prefetch-concurrency", 0, "Chunks fetched concurrently ahead of sequential readers, 0 = disabled")
+	flags.Uint64Var(&fs.PrefetchChunkSize, "prefetch-chunk-size", 4, "Size of prefetched chunks in MiB, the block cache block size is used instead when enabled")
+	flags.IntVar(&fs.TransferMode, "transfer-mode", 0, "Transfer optimizations mode")
+
+	// Rename options
+	flags.StringVar(&fs.JournalDir, "journal-dir", filepath.Join(os.TempDir(), "svfs-journal"), "Directory used to record pending directory renames and uploads")
+	flags.Uint64Var(&fs.RenameConcurrency, "rename-concurrency", 20, "Directory renaming concurrency")
+
+	// Spool options
+	flags.StringVar(&fs.SpoolDir, "spool-dir", filepath.Join(os.TempDir(), "svfs"), "Directory used to stage files opened in read-write mode")
+	flags.Uint64Var(&fs.SpoolMaxSize, "spool-max-size", 1024, "Maximum size of staged files in MiB, 0 = unlimited")
+	flags.Uint64Var(&fs.RetrySpoolMaxSize, "retry-spool-max-size", 1024, "Maximum size of uploads kept to be retried in MiB, 0 = unlimited")
+
+	// Write-back options
+	flags.BoolVar(&fs.WriteBack, "write-back", false, "Upload files in the background once closed")
+	flags.Uint64Var(&fs.WriteBackWorkers, "write-back-workers", 4, "Files uploaded concurrently in write-back mode")
+	flags.Uint64Var(&fs.WriteBackRetries, "write-back-retries", 5, "Upload retries of a file in write-back mode")
 
-	// Bind cobra flags to viper flags
-	viper.BindPFlag("os_auth_url", mountCmd.PersistentFlags().Lookup("os-auth-url"))
-	viper.BindPFlag("os_username", mountCmd.PersistentFlags().Lookup("os-username"))
-	viper.BindPFlag("os_password", mountCmd.PersistentFlags().Lookup("os-password"))
-	viper.BindPFlag("os_tenant_name", mountCmd.PersistentFlags().Lookup("os-tenant-name"))
-	viper.BindPFlag("os_region_name", mountCmd.PersistentFlags().Lookup("os-region-name"))
-	viper.BindPFlag("os_auth_token", mountCmd.PersistentFlags().Lookup("os-auth-token"))
-	viper.BindPFlag("os_storage_url", mountCmd.PersistentFlags().Lookup("os-storage-url"))
-	viper.BindPFlag("hubic_auth", mountCmd.PersistentFlags().Lookup("hubic-authorization"))
-	viper.BindPFlag("hubic_token", mountCmd.PersistentFlags().Lookup("hubic-refresh-token"))
+	// Cache Options
+	flags.DurationVar(&fs.CacheTimeout, "cache-ttl", 1*time.Minute, "Cache timeout")
+	flags.Int64Var(&fs.CacheMaxEntries, "cache-max-entries", -1, "Maximum overall entries allowed in cache")
+	flags.Int64Var(&fs.CacheMaxAccess, "cache-max-access", -1, "Maximum access count to cached entries")
+	flags.StringVar(&fs.BlockCacheDir, "block-cache-dir", "", "Directory used to cache blocks of objects read, empty = disabled")
+	flags.Uint64Var(&fs.BlockCacheMaxSize, "block-cache-max-size", 1024, "Maximum size of the block cache in MiB, 0 = unlimited")
+	flags.Uint64Var(&fs.BlockCacheBlockSize, "block-cache-block-size", 1024, "Size of cached blocks in KiB")
 }
 
-func mountOptions(device string) (options []fuse.MountOption) {
-	if svfs.AllowOther {
+// setAdminFlags adds flags configuring the admin API of a mount
+// to the given flag set.
+func setAdminFlags(flags *pflag.FlagSet, socket *string) {
+	flags.StringVar(socket, "admin-socket", "", "Admin API will be served on a unix socket created at this path")
+}
+
+// setClientFlags adds flags identifying the client to swift to the
+// given flag set. They apply to all connections of the process.
+func setClientFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&swift.DefaultUserAgent, "user-agent", "svfs/"+svfs.Version, "Default User-Agent")
+	flags.StringVar(&swift.ClientIP, "client-ip", "", "Client IP")
+}
+
+// setSwiftFlags adds flags needed to connect to swift to the
+// given flag set.
+func setSwiftFlags(flags *pflag.FlagSet, conn *svfs.Connection) {
+	flags.StringVar(&conn.AuthUrl, "os-auth-url", "https://auth.cloud.ovh.net/v2.0", "Authentification URL")
+	flags.StringVar(&conn.AuthToken, "os-auth-token", "", "Authentification token")
+	flags.StringVar(&conn.UserName, "os-username", "", "Username")
+	flags.StringVar(&conn.ApiKey, "os-password", "", "User password")
+	flags.StringVar(&conn.Region, "os-region-name", "", "Region name")
+	flags.StringVar(&conn.StorageUrl, "os-storage-url", "", "Storage URL")
+	flags.BoolVar(&conn.Internal, "os-internal-endpoint", false, "Use internal storage URL")
+	flags.StringVar(&conn.Tenant, "os-tenant-name", "", "Tenant name")
+	flags.StringVar(&conn.TenantId, "os-project-id", "", "Project ID (v3 auth only)")
+	flags.StringVar(&conn.TenantDomain, "os-project-domain-name", "", "Project domain name (v3 auth only)")
+	flags.StringVar(&conn.Domain, "os-user-domain-name", "", "User domain name (v3 auth only)")
+	flags.StringVar(&conn.KeystoneApplicationCredentialID, "os-application-credential-id", "", "Application credential ID (v3 auth only)")
+	flags.StringVar(&conn.KeystoneApplicationCredentialSecret, "os-application-credential-secret", "", "Application credential secret (v3 auth only)")
+	flags.StringVar(&conn.KeystoneApplicationCredentialSecretFile, "os-application-credential-secret-file", "", "Read the application credential secret from this file")
+	flags.StringVar(&conn.KeystonePasswordFile, "os-password-file", "", "Read the user password from this file")
+	flags.StringVar(&conn.KeystoneTokenFile, "os-auth-token-file", "", "Read the authentification token from this file")
+	flags.DurationVar(&conn.TokenRefreshMargin, "os-token-refresh-margin", 5*time.Minute, "Renew the authentification token this long before it expires, 0 = disabled")
+	flags.IntVar(&conn.AuthVersion, "os-auth-version", 0, "Authentification version, 0 = auto")
+	flags.DurationVar(&conn.ConnectTimeout, "os-connect-timeout", 15*time.Second, "Swift connection timeout")
+	flags.DurationVar(&conn.Timeout, "os-request-timeout", 5*time.Minute, "Swift operation timeout")
+	flags.Uint64Var(&conn.MaxRetries, "os-retries", 3, "Retries of swift requests failing with transient errors, 0 = disabled")
+	flags.DurationVar(&conn.RetryDelay, "os-retry-delay", 1*time.Second, "Delay before the first retry of a swift request")
+	flags.DurationVar(&conn.RetryMaxDelay, "os-retry-max-delay", 30*time.Second, "Maximum delay between swift request retries")
+
+	//HubiC options
+	flags.StringVar(&conn.HubicAuthorization, "hubic-authorization", "", "hubiC authorization code")
+	flags.StringVar(&conn.HubicRefreshToken, "hubic-refresh-token", "", "hubiC refresh token")
+}
+
+// bindSwiftFlags binds flags added by setSwiftFlags to viper
+// configuration keys.
+func bindSwiftFlags(flags *pflag.FlagSet) {
+	viper.BindPFlag("os_auth_url", flags.Lookup("os-auth-url"))
+	viper.BindPFlag("os_username", flags.Lookup("os-username"))
+	viper.BindPFlag("os_password", flags.Lookup("os-password"))
+	viper.BindPFlag("os_tenant_name", flags.Lookup("os-tenant-name"))
+	viper.BindPFlag("os_region_name", flags.Lookup("os-region-name"))
+	viper.BindPFlag("os_auth_token", flags.Lookup("os-auth-token"))
+	viper.BindPFlag("os_storage_url", flags.Lookup("os-storage-url"))
+	viper.BindPFlag("os_project_id", flags.Lookup("os-project-id"))
+	viper.BindPFlag("os_project_domain_name", flags.Lookup("os-project-domain-name"))
+	viper.BindPFlag("os_user_domain_name", flags.Lookup("os-user-domain-name"))
+	viper.BindPFlag("os_application_credential_id", flags.Lookup("os-application-credential-id"))
+	viper.BindPFlag("os_application_credential_secret", flags.Lookup("os-application-credential-secret"))
+	viper.BindPFlag("os_application_credential_secret_file", flags.Lookup("os-application-credential-secret-file"))
+	viper.BindPFlag("os_password_file", flags.Lookup("os-password-file"))
+	viper.BindPFlag("os_auth_token_file", flags.Lookup("os-auth-token-file"))
+	viper.BindPFlag("hubic_auth", flags.Lookup("hubic-authorization"))
+	viper.BindPFlag("hubic_token", flags.Lookup("hubic-refresh-token"))
+}
+
+func mountOptions(fs *svfs.SVFS, device string) (options []fuse.MountOption) {
+	if fs.AllowOther {
 		options = append(options, fuse.AllowOther())
 	}
-	if svfs.AllowRoot {
+	if fs.AllowRoot {
 		options = append(options, fuse.AllowRoot())
 	}
-	if svfs.DefaultPermissions {
+	if fs.DefaultPermissions {
 		options = append(options, fuse.DefaultPermissions())
 	}
-	if svfs.ReadOnly {
+	if fs.ReadOnly {
 		options = append(options, fuse.ReadOnly())
 	}
 
-	options = append(options, fuse.MaxReadahead(uint32(svfs.ReadAheadSize)))
+	options = append(options, fuse.MaxReadahead(uint32(fs.ReadAheadSize)))
 	options = append(options, fuse.Subtype("svfs"))
 	options = append(options, fuse.FSName(device))
 
 	return options
 }
 
-func checkOptions() error {
+// serveAdmin serves the admin API of the filesystem on a unix socket
+// created at the given path, replacing a socket left by a previous mount.
+func serveAdmin(fs *svfs.SVFS, path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the owner may control the filesystem
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	go http.Serve(listener, fs.AdminHandler())
+
+	return listener, nil
+}
+
+func serverConfig() *fusefs.Config {
+	if metricsAddr == "" {
+		return nil
+	}
+	return &fusefs.Config{WithContext: svfs.WithMetrics}
+}
+
+func checkOptions(fs *svfs.SVFS) error {
 	// Convert to MB
-	svfs.SegmentSize *= (1 << 20)
-	svfs.ReadAheadSize *= (1 << 10)
+	fs.SegmentSize *= (1 << 20)
+	fs.SpoolMaxSize *= (1 << 20)
+	fs.RetrySpoolMaxSize *= (1 << 20)
+	fs.SegmentBufferSize *= (1 << 20)
+	fs.BlockCacheMaxSize *= (1 << 20)
+	fs.BlockCacheBlockSize *= (1 << 10)
+	fs.PrefetchChunkSize *= (1 << 20)
+	fs.ReadAheadSize *= (1 << 10)
+
+	if fs.BlockCacheDir != "" && fs.BlockCacheBlockSize == 0 {
+		return fmt.Errorf("Block cache block size can't be 0")
+	}
+	if fs.PrefetchConcurrency > 0 && fs.PrefetchChunkSize == 0 {
+		return fmt.Errorf("Prefetch chunk size can't be 0")
+	}
 
 	// Should not exceed swift maximum object size.
-	if svfs.SegmentSize > 5*(1<<30) {
+	if fs.SegmentSize > 5*(1<<30) {
 		return fmt.Errorf("Segment size can't exceed 5 GiB")
 	}
+
+	// Each streamed upload reserves a whole segment in the retry spool
+	if fs.Connection.MaxRetries > 0 && fs.RetrySpoolMaxSize > 0 && fs.RetrySpoolMaxSize < fs.SegmentSize {
+		return fmt.Errorf("Retry spool size can't be lower than segment size")
+	}
+
+	// Writers only move to the next segment once the current one
+	// is buffered, otherwise uploads would still be serial.
+	if fs.SegmentConcurrency > 1 && fs.SegmentBufferSize < fs.SegmentSize {
+		return fmt.Errorf("Segment buffer size can't be lower than segment size when uploading segments concurrently")
+	}
+	return nil
+}
+
+// setStorage selects the object storage backing the filesystem,
+// serving containers from localDir if set.
+func setStorage(fs *svfs.SVFS, localDir string) error {
+	if localDir == "" {
+		return nil
+	}
+
+	local, err := svfs.NewLocalBackend(localDir)
+	if err != nil {
+		return err
+	}
+	fs.Storage = local
+
 	return nil
 }
 
@@ -283,15 +464,24 @@ func createMemProf(memProf string) {
 }
 
 func useConfiguration() {
-	svfs.HubicAuthorization = viper.GetString("hubic_auth")
-	svfs.HubicRefreshToken = viper.GetString("hubic_token")
-
-	svfs.SwiftConnection.AuthToken = viper.GetString("os_auth_token")
-	svfs.SwiftConnection.StorageUrl = viper.GetString("os_storage_url")
-
-	svfs.SwiftConnection.AuthUrl = viper.GetString("os_auth_url")
-	svfs.SwiftConnection.Tenant = viper.GetString("os_tenant_name")
-	svfs.SwiftConnection.UserName = viper.GetString("os_username")
-	svfs.SwiftConnection.ApiKey = viper.GetString("os_password")
-	svfs.SwiftConnection.Region = viper.GetString("os_region_name")
+	fs.Connection.HubicAuthorization = viper.GetString("hubic_auth")
+	fs.Connection.HubicRefreshToken = viper.GetString("hubic_token")
+
+	fs.Connection.AuthToken = viper.GetString("os_auth_token")
+	fs.Connection.StorageUrl = viper.GetString("os_storage_url")
+
+	fs.Connection.AuthUrl = viper.GetString("os_auth_url")
+	fs.Connection.Tenant = viper.GetString("os_tenant_name")
+	fs.Connection.UserName = viper.GetString("os_username")
+	fs.Connection.ApiKey = viper.GetString("os_password")
+	fs.Connection.Region = viper.GetString("os_region_name")
+	fs.Connection.TenantId = viper.GetString("os_project_id")
+	fs.Connection.TenantDomain = viper.GetString("os_project_domain_name")
+	fs.Connection.Domain = viper.GetString("os_user_domain_name")
+
+	fs.Connection.KeystoneApplicationCredentialID = viper.GetString("os_application_credential_id")
+	fs.Connection.KeystoneApplicationCredentialSecret = viper.GetString("os_application_credential_secret")
+	fs.Connection.KeystoneApplicationCredentialSecretFile = viper.GetString("os_application_credential_secret_file")
+	fs.Connection.KeystonePasswordFile = viper.GetString("os_password_file")
+	fs.Connection.KeystoneTokenFile = viper.GetString("os_auth_token_file")
 }
@@ -1,6 +1,14 @@
 package config
 
-import v "github.com/spf13/viper"
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	v "github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v2"
+)
 
 // LoadConfig reads configuration from a configuration file or the environment.
 func LoadConfig() error {
@@ -15,8 +23,70 @@ func LoadConfig() error {
 	v.BindEnv("os_password")
 	v.BindEnv("os_region_name")
 	v.BindEnv("os_storage_url")
+	v.BindEnv("os_project_id")
+	v.BindEnv("os_project_domain_name")
+	v.BindEnv("os_user_domain_name")
+	v.BindEnv("os_application_credential_id")
+	v.BindEnv("os_application_credential_secret")
+	v.BindEnv("os_application_credential_secret_file")
+	v.BindEnv("os_password_file")
+	v.BindEnv("os_auth_token_file")
 	v.BindEnv("hubic_auth")
 	v.BindEnv("hubic_token")
 
 	return v.ReadInConfig()
 }
+
+// UpdateFile sets keys of a YAML configuration file, creating it if
+// missing. Other keys are left untouched.
+func UpdateFile(path string, values map[string]string) error {
+	var content yaml.MapSlice
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := yaml.Unmarshal(data, &content); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		found := false
+		for i := range content {
+			if content[i].Key == key {
+				content[i].Value = values[key]
+				found = true
+			}
+		}
+		if !found {
+			content = append(content, yaml.MapItem{Key: key, Value: values[key]})
+		}
+	}
+
+	if data, err = yaml.Marshal(content); err != nil {
+		return err
+	}
+
+	// Credentials must not be readable by others, nor partially written
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
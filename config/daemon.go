@@ -0,0 +1,57 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Mount describes a filesystem served by the daemon.
+type Mount struct {
+	// Device is the name of the mounted device, defaulting to
+	// the name of the mount.
+	Device string `yaml:"device"`
+	// Mountpoint is the directory the filesystem is mounted at.
+	Mountpoint string `yaml:"mountpoint"`
+	// Options are mount command flags, without leading dashes,
+	// and their value.
+	Options map[string]string `yaml:"options"`
+}
+
+// Daemon is the configuration of the svfs daemon.
+type Daemon struct {
+	// Mounts are the filesystems to serve, by name.
+	Mounts map[string]Mount `yaml:"mounts"`
+}
+
+// LoadDaemon reads the daemon configuration from a YAML file.
+func LoadDaemon(path string) (*Daemon, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	daemon := new(Daemon)
+	if err := yaml.Unmarshal(data, daemon); err != nil {
+		return nil, err
+	}
+
+	mountpoints := make(map[string]string)
+	for name, mount := range daemon.Mounts {
+		if mount.Mountpoint == "" {
+			return nil, fmt.Errorf("Mount %s has no mountpoint", name)
+		}
+		if other, ok := mountpoints[mount.Mountpoint]; ok {
+			return nil, fmt.Errorf("Mounts %s and %s share the same mountpoint", other, name)
+		}
+		mountpoints[mount.Mountpoint] = name
+
+		if mount.Device == "" {
+			mount.Device = name
+			daemon.Mounts[name] = mount
+		}
+	}
+
+	return daemon, nil
+}
@@ -26,10 +26,6 @@ ARM_VERSIONS = {
 }
 
 FILES_LINUX = {
-  "scripts/hubic-application.rb" => {
-    :target => "/usr/local/bin/hubic-application",
-    :mode   => 0755,
-  },
   "scripts/mount.svfs" => {
     :target => "/sbin/mount.svfs",
     :mode   => 0755,
@@ -37,10 +33,6 @@ FILES_LINUX = {
 }
 
 FILES_MACOS = {
-  "scripts/hubic-application.rb" => {
-    :target => "/usr/local/bin/hubic-application",
-    :mode => 0755,
-  },
   "scripts/mount.svfs" => {
     :target => "/usr/local/bin/mount_svfs",
     :mode => 0755,
@@ -0,0 +1,173 @@
+package svfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// AdminChange is an object being modified, as reported by the
+// admin API.
+type AdminChange struct {
+	Container string `json:"container"`
+	Path      string `json:"path"`
+	Upload    string `json:"upload,omitempty"`
+}
+
+// AdminHandle is an open object handle, as reported by the admin API.
+type AdminHandle struct {
+	Container string    `json:"container"`
+	Path      string    `json:"path"`
+	Mode      string    `json:"mode"`
+	Opened    time.Time `json:"opened"`
+	Uploaded  uint64    `json:"uploaded"`
+}
+
+// AdminInvalidation is the result of a directory cache invalidation.
+type AdminInvalidation struct {
+	Entries int `json:"entries"`
+}
+
+// AdminLogLevel is the current log level.
+type AdminLogLevel struct {
+	Level string `json:"level"`
+}
+
+// AdminHandler returns an HTTP handler serving a JSON API to inspect
+// and control the filesystem while it's mounted :
+//   - GET /options returns effective options.
+//   - DELETE /cache?path=dir removes cached listings of a directory,
+//     given relative to the mountpoint, and its subdirectories. The
+//     whole cache is flushed without path.
+//   - GET /changes returns objects being modified.
+//   - GET /handles returns open file handles.
+//   - POST /reauthenticate renews the swift authentication token.
+//   - GET /log-level and PUT /log-level get or set the log level.
+func (s *SVFS) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/options", adminMethod("GET", s.adminOptions))
+	mux.HandleFunc("/cache", adminMethod("DELETE", s.adminInvalidate))
+	mux.HandleFunc("/changes", adminMethod("GET", s.adminChanges))
+	mux.HandleFunc("/handles", adminMethod("GET", s.adminHandles))
+	mux.HandleFunc("/reauthenticate", adminMethod("POST", s.adminReauthenticate))
+	mux.HandleFunc("/log-level", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			writeAdminJSON(w, AdminLogLevel{Level: logrus.GetLevel().String()})
+		case "PUT":
+			adminSetLogLevel(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	return mux
+}
+
+// adminMethod restricts a handler to the given HTTP method.
+func adminMethod(method string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func writeAdminJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *SVFS) adminOptions(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, s.Options)
+}
+
+func (s *SVFS) adminInvalidate(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Query().Get("path"), "/")
+	if path == "" {
+		writeAdminJSON(w, AdminInvalidation{Entries: s.directoryCache.Flush()})
+		return
+	}
+
+	// Cache keys are made of the container name and the
+	// path of directories within the container.
+	container := s.TargetContainer
+	if container == "" {
+		parts := strings.SplitN(path, "/", 2)
+		container, path = parts[0], ""
+		if len(parts) > 1 {
+			path = parts[1]
+		}
+	}
+	if path != "" {
+		path += "/"
+	}
+
+	writeAdminJSON(w, AdminInvalidation{Entries: s.directoryCache.DeletePrefix(container, path)})
+}
+
+func (s *SVFS) adminChanges(w http.ResponseWriter, r *http.Request) {
+	changes := []AdminChange{}
+	for _, node := range s.changeCache.All() {
+		o, ok := node.(*Object)
+		if !ok {
+			continue
+		}
+		changes = append(changes, AdminChange{
+			Container: o.c.Name,
+			Path:      o.path,
+			Upload:    s.writeBackQueue.State(o.c.Name, o.path),
+		})
+	}
+	writeAdminJSON(w, changes)
+}
+
+func (s *SVFS) adminHandles(w http.ResponseWriter, r *http.Request) {
+	handles := []AdminHandle{}
+	for _, fh := range s.openHandles.list() {
+		handles = append(handles, AdminHandle{
+			Container: fh.target.c.Name,
+			Path:      fh.target.path,
+			Mode:      fh.mode(),
+			Opened:    fh.opened,
+			Uploaded:  atomic.LoadUint64(&fh.sent),
+		})
+	}
+	writeAdminJSON(w, handles)
+}
+
+func (s *SVFS) adminReauthenticate(w http.ResponseWriter, r *http.Request) {
+	if !s.usingSwift() {
+		http.Error(w, "Storage doesn't require authentication", http.StatusBadRequest)
+		return
+	}
+	if err := s.Connection.Reauthenticate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func adminSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var request AdminLogLevel
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request : %v", err), http.StatusBadRequest)
+		return
+	}
+
+	level, err := logrus.ParseLevel(request.Level)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logrus.SetLevel(level)
+	logrus.WithField("level", level).Infoln("Log level changed")
+	writeAdminJSON(w, AdminLogLevel{Level: level.String()})
+}
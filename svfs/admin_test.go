@@ -0,0 +1,139 @@
+package svfs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/xlucas/swift"
+)
+
+type AdminTestSuite struct {
+	suite.Suite
+	fs     *SVFS
+	server *httptest.Server
+	object *Object
+}
+
+func (suite *AdminTestSuite) SetupTest() {
+	suite.fs = &SVFS{
+		changeCache:    NewSimpleCache(),
+		directoryCache: NewCache(5*time.Minute, -1, -1),
+	}
+	suite.fs.SegmentSize = 1024
+	suite.fs.writeBackQueue = NewWriteBackQueue(suite.fs)
+	suite.server = httptest.NewServer(suite.fs.AdminHandler())
+	suite.object = &Object{
+		fs:   suite.fs,
+		name: "item",
+		path: "dir/item",
+		c:    &swift.Container{Name: "container"},
+	}
+}
+
+func (suite *AdminTestSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+func (suite *AdminTestSuite) request(method, path, body string, result interface{}) int {
+	req, err := http.NewRequest(method, suite.server.URL+path, strings.NewReader(body))
+	require.Nil(suite.T(), err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(suite.T(), err)
+	defer resp.Body.Close()
+
+	if result != nil && resp.StatusCode == http.StatusOK {
+		require.Nil(suite.T(), json.NewDecoder(resp.Body).Decode(result))
+	}
+	return resp.StatusCode
+}
+
+func (suite *AdminTestSuite) TestOptions() {
+	var options Options
+
+	assert.Equal(suite.T(), http.StatusOK, suite.request("GET", "/options", "", &options))
+	assert.Equal(suite.T(), uint64(1024), options.SegmentSize)
+	assert.Equal(suite.T(), http.StatusMethodNotAllowed, suite.request("POST", "/options", "", nil))
+}
+
+func (suite *AdminTestSuite) TestInvalidate() {
+	var result AdminInvalidation
+	cache := suite.fs.directoryCache
+	cache.AddAll("", "", nil, map[string]Node{})
+	cache.AddAll("container", "", nil, map[string]Node{})
+	cache.AddAll("container", "dir/", nil, map[string]Node{})
+	cache.AddAll("container", "dir/sub/", nil, map[string]Node{})
+
+	assert.Equal(suite.T(), http.StatusOK, suite.request("DELETE", "/cache?path=/container/dir", "", &result))
+	assert.Equal(suite.T(), 2, result.Entries)
+
+	assert.Equal(suite.T(), http.StatusOK, suite.request("DELETE", "/cache?path=container", "", &result))
+	assert.Equal(suite.T(), 1, result.Entries)
+
+	assert.Equal(suite.T(), http.StatusOK, suite.request("DELETE", "/cache", "", &result))
+	assert.Equal(suite.T(), 1, result.Entries)
+	assert.Len(suite.T(), cache.content, 0)
+}
+
+func (suite *AdminTestSuite) TestInvalidateTargetContainer() {
+	var result AdminInvalidation
+	suite.fs.TargetContainer = "container"
+	suite.fs.directoryCache.AddAll("container", "dir/", nil, map[string]Node{})
+
+	assert.Equal(suite.T(), http.StatusOK, suite.request("DELETE", "/cache?path=dir", "", &result))
+	assert.Equal(suite.T(), 1, result.Entries)
+}
+
+func (suite *AdminTestSuite) TestChanges() {
+	var changes []AdminChange
+	suite.fs.changeCache.Add("container", "dir/item", suite.object)
+
+	assert.Equal(suite.T(), http.StatusOK, suite.request("GET", "/changes", "", &changes))
+	assert.Equal(suite.T(), []AdminChange{{Container: "container", Path: "dir/item"}}, changes)
+}
+
+func (suite *AdminTestSuite) TestHandles() {
+	var handles []AdminHandle
+	fh := &ObjectHandle{target: suite.object, locked: true, sent: 42}
+	suite.fs.openHandles.add(fh)
+
+	assert.Equal(suite.T(), http.StatusOK, suite.request("GET", "/handles", "", &handles))
+	require.Len(suite.T(), handles, 1)
+	assert.Equal(suite.T(), "dir/item", handles[0].Path)
+	assert.Equal(suite.T(), "write", handles[0].Mode)
+	assert.Equal(suite.T(), uint64(42), handles[0].Uploaded)
+
+	suite.fs.openHandles.remove(fh)
+	assert.Equal(suite.T(), http.StatusOK, suite.request("GET", "/handles", "", &handles))
+	assert.Empty(suite.T(), handles)
+}
+
+func (suite *AdminTestSuite) TestReauthenticateWithoutSwift() {
+	assert.Equal(suite.T(), http.StatusBadRequest, suite.request("POST", "/reauthenticate", "", nil))
+}
+
+func (suite *AdminTestSuite) TestLogLevel() {
+	var level AdminLogLevel
+	defer logrus.SetLevel(logrus.GetLevel())
+
+	assert.Equal(suite.T(), http.StatusOK, suite.request("PUT", "/log-level", `{"level": "debug"}`, &level))
+	assert.Equal(suite.T(), "debug", level.Level)
+	assert.Equal(suite.T(), logrus.DebugLevel, logrus.GetLevel())
+
+	assert.Equal(suite.T(), http.StatusOK, suite.request("GET", "/log-level", "", &level))
+	assert.Equal(suite.T(), "debug", level.Level)
+
+	assert.Equal(suite.T(), http.StatusBadRequest, suite.request("PUT", "/log-level", `{"level": "loud"}`, nil))
+}
+
+func TestAdminSuite(t *testing.T) {
+	suite.Run(t, new(AdminTestSuite))
+}
@@ -0,0 +1,115 @@
+package svfs
+
+import (
+	"errors"
+	"io"
+
+	"github.com/xlucas/swift"
+)
+
+// Backend is an object storage holding containers of objects. Objects,
+// containers and their metadata are described by swift types whatever
+// the backend, and missing ones are reported with swift errors such as
+// swift.ObjectNotFound or swift.ContainerNotFound.
+type Backend interface {
+	// Account returns usage information about the storage.
+	Account() (swift.Account, swift.Headers, error)
+	// Container returns information about a container.
+	Container(container string) (swift.Container, swift.Headers, error)
+	// ContainerCreate creates a container or updates its metadata.
+	ContainerCreate(container string, h swift.Headers) error
+	// ContainerDelete deletes an empty container.
+	ContainerDelete(container string) error
+	// ContainerNamesAll lists container names.
+	ContainerNamesAll(opts *swift.ContainersOpts) ([]string, error)
+	// ContainerUpdate updates the metadata of a container. Metadata
+	// given with an empty value is removed.
+	ContainerUpdate(container string, h swift.Headers) error
+	// ContainersAll lists containers.
+	ContainersAll(opts *swift.ContainersOpts) ([]swift.Container, error)
+	// CreateStaticManifest uploads a static large object manifest
+	// referencing the given segments, along with extra headers.
+	CreateStaticManifest(container, name string, segments []StaticSegment, h swift.Headers) error
+	// DeleteStaticLargeObject removes a static large object manifest
+	// along with all its segments.
+	DeleteStaticLargeObject(container, name string) error
+	// GetStaticManifest retrieves segments referenced by a static
+	// large object manifest.
+	GetStaticManifest(container, name string) ([]StaticSegment, error)
+	// ManifestCopy copies a manifest, not the content it references.
+	ManifestCopy(srcContainer, srcName, dstContainer, dstName string, h swift.Headers) (swift.Headers, error)
+	// ManifestUpdate replaces the metadata of a manifest.
+	ManifestUpdate(container, name string, h swift.Headers) error
+	// Object returns information about an object.
+	Object(container, name string) (swift.Object, swift.Headers, error)
+	// ObjectCopy copies an object, along with its metadata overridden
+	// by the given headers.
+	ObjectCopy(srcContainer, srcName, dstContainer, dstName string, h swift.Headers) (swift.Headers, error)
+	// ObjectCreate returns a writer streaming data to a new object.
+	ObjectCreate(container, name string, checkHash bool, hash, contentType string, h swift.Headers) (io.WriteCloser, error)
+	// ObjectDelete deletes an object.
+	ObjectDelete(container, name string) error
+	// ObjectMove moves an object, along with its metadata.
+	ObjectMove(srcContainer, srcName, dstContainer, dstName string) error
+	// ObjectNamesAll lists object names of a container.
+	ObjectNamesAll(container string, opts *swift.ObjectsOpts) ([]string, error)
+	// ObjectOpen opens an object for reading. A single range of bytes
+	// may be requested through the Range header.
+	ObjectOpen(container, name string, checkHash bool, h swift.Headers) (ObjectReader, swift.Headers, error)
+	// ObjectPut uploads an object from a reader.
+	ObjectPut(container, name string, contents io.Reader, checkHash bool, hash, contentType string, h swift.Headers) (swift.Headers, error)
+	// ObjectPutBytes uploads an object from a byte slice.
+	ObjectPutBytes(container, name string, contents []byte, contentType string) error
+	// ObjectUpdate replaces the metadata of an object.
+	ObjectUpdate(container, name string, h swift.Headers) error
+	// ObjectsAll lists objects of a container.
+	ObjectsAll(container string, opts *swift.ObjectsOpts) ([]swift.Object, error)
+}
+
+// errUploadAborted fails uploads discarded before completion.
+var errUploadAborted = errors.New("Upload aborted")
+
+// writeAborter is implemented by writers returned by ObjectCreate
+// which can discard the object instead of completing its upload.
+type writeAborter interface {
+	abort()
+}
+
+// abortWriter discards an object being written. Writers which can't
+// be aborted are closed.
+func abortWriter(w io.WriteCloser) {
+	if a, ok := w.(writeAborter); ok {
+		a.abort()
+		return
+	}
+	w.Close()
+}
+
+// ObjectReader reads the content of an object.
+type ObjectReader interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// usingSwift tells whether the filesystem is backed by the swift
+// connection, which requires authentication.
+func (s *SVFS) usingSwift() bool {
+	return s.Connection != nil && s.Storage == Backend(s.Connection)
+}
+
+// storageID identifies the storage the filesystem is backed by. It's
+// recorded in journals, so that mounts of other accounts sharing the
+// journal directory never replay them.
+func (s *SVFS) storageID() string {
+	switch b := s.Storage.(type) {
+	case *Connection:
+		return b.StorageUrl
+	case *LocalBackend:
+		return "file://" + b.root
+	}
+	return ""
+}
+
+var (
+	_ Backend = (*Connection)(nil)
+)
@@ -0,0 +1,310 @@
+package svfs
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// blockCacheTmpSuffix is part of the name of blocks being written.
+const blockCacheTmpSuffix = ".tmp"
+
+// BlockCache is a persistent LRU cache of object blocks. Blocks are
+// keyed by container, object path and object version, so that blocks
+// of an object modified since they were cached are never served.
+type BlockCache struct {
+	dir       string
+	maxSize   uint64
+	blockSize uint64
+	mutex     sync.Mutex
+	entries   map[string]*list.Element
+	lru       *list.List
+	used      uint64
+}
+
+type blockCacheEntry struct {
+	name string
+	size uint64
+}
+
+// CachedReader reads an object through the block cache, fetching
+// missing blocks using ranged requests.
+type CachedReader struct {
+	storage   Backend
+	cache     *BlockCache
+	container string
+	path      string
+	key       string
+	size      int64
+	offset    int64
+	// block is the last block read, at blockIndex
+	block      []byte
+	blockIndex int64
+}
+
+// NewBlockCache creates a block cache within the given directory,
+// holding at most maxSize bytes or an unlimited amount of data if 0.
+func NewBlockCache(dir string, maxSize, blockSize uint64) *BlockCache {
+	return &BlockCache{
+		dir:       dir,
+		maxSize:   maxSize,
+		blockSize: blockSize,
+	}
+}
+
+// Init makes sure the cache directory exists and loads blocks
+// cached by previous mounts, least recently used first.
+func (bc *BlockCache) Init() error {
+	bc.entries = make(map[string]*list.Element)
+	bc.lru = list.New()
+
+	if err := os.MkdirAll(bc.dir, 0700); err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(bc.dir)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		// Left behind by an interrupted write
+		if strings.Contains(file.Name(), blockCacheTmpSuffix) {
+			os.Remove(filepath.Join(bc.dir, file.Name()))
+			continue
+		}
+		bc.add(file.Name(), uint64(file.Size()))
+	}
+
+	return nil
+}
+
+// Enabled tells whether the block cache is in use.
+func (bc *BlockCache) Enabled() bool {
+	return bc.dir != "" && bc.lru != nil
+}
+
+// Get returns the content of a cached block or nil if missing.
+func (bc *BlockCache) Get(name string) []byte {
+	bc.mutex.Lock()
+	e, ok := bc.entries[name]
+	if ok {
+		bc.lru.MoveToBack(e)
+	}
+	bc.mutex.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	file := filepath.Join(bc.dir, name)
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		bc.remove(name)
+		return nil
+	}
+
+	// Blocks are loaded by the next mount in order of modification
+	now := time.Now()
+	os.Chtimes(file, now, now)
+
+	return data
+}
+
+// Set stores a block in the cache, evicting least recently
+// used blocks if needed.
+func (bc *BlockCache) Set(name string, data []byte) error {
+	if bc.maxSize > 0 && uint64(len(data)) > bc.maxSize {
+		return nil
+	}
+
+	// Never expose a partially written block. Readers fetching the
+	// same block concurrently each write their own temporary file.
+	tmp, err := ioutil.TempFile(bc.dir, name+blockCacheTmpSuffix)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(bc.dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	if e, ok := bc.entries[name]; ok {
+		bc.used -= e.Value.(*blockCacheEntry).size
+		bc.lru.Remove(e)
+	}
+	bc.add(name, uint64(len(data)))
+	bc.evict()
+
+	return nil
+}
+
+func (bc *BlockCache) add(name string, size uint64) {
+	bc.entries[name] = bc.lru.PushBack(&blockCacheEntry{name: name, size: size})
+	bc.used += size
+}
+
+func (bc *BlockCache) evict() {
+	for bc.maxSize > 0 && bc.used > bc.maxSize {
+		entry := bc.lru.Remove(bc.lru.Front()).(*blockCacheEntry)
+		delete(bc.entries, entry.name)
+		bc.used -= entry.size
+		if err := os.Remove(filepath.Join(bc.dir, entry.name)); err != nil && !os.IsNotExist(err) {
+			logrus.WithField("block", entry.name).Warnln("Failed to evict cached block :", err)
+		}
+	}
+}
+
+func (bc *BlockCache) remove(name string) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	if e, ok := bc.entries[name]; ok {
+		bc.used -= e.Value.(*blockCacheEntry).size
+		bc.lru.Remove(e)
+		delete(bc.entries, name)
+	}
+}
+
+// newCachedReader creates a reader for the current version of an
+// object.
+func newCachedReader(storage Backend, cache *BlockCache, container, path string) (*CachedReader, error) {
+	object, h, err := storage.Object(container, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CachedReader{
+		storage:   storage,
+		cache:     cache,
+		container: container,
+		path:      path,
+		key:       blockCacheKey(container, path, h["Etag"], h["Last-Modified"]),
+		size:      object.Bytes,
+	}, nil
+}
+
+// Read reads data at the current offset from cached blocks.
+func (cr *CachedReader) Read(p []byte) (n int, err error) {
+	if cr.offset >= cr.size {
+		return 0, io.EOF
+	}
+
+	var (
+		blockSize = int64(cr.cache.blockSize)
+		index     = cr.offset / blockSize
+	)
+
+	// Reads are smaller than blocks, keep the current one at hand
+	if cr.block == nil || index != cr.blockIndex {
+		block, err := cr.fetch(index, nil)
+		if err != nil {
+			return 0, err
+		}
+		cr.block, cr.blockIndex = block, index
+	}
+
+	start := cr.offset - index*blockSize
+	if start >= int64(len(cr.block)) {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	n = copy(p, cr.block[start:])
+	cr.offset += int64(n)
+
+	return n, nil
+}
+
+// Seek sets the offset of the next read.
+func (cr *CachedReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += cr.offset
+	case io.SeekEnd:
+		offset += cr.size
+	default:
+		return cr.offset, fmt.Errorf("Invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return cr.offset, fmt.Errorf("Negative offset %d", offset)
+	}
+	cr.offset = offset
+	return offset, nil
+}
+
+// Close releases the reader.
+func (cr *CachedReader) Close() error {
+	cr.block = nil
+	return nil
+}
+
+// fetch returns a block from the cache, fetching it from swift
+// if missing. The download is aborted once the cancel channel
+// is closed.
+func (cr *CachedReader) fetch(index int64, cancel <-chan struct{}) ([]byte, error) {
+	name := fmt.Sprintf("%s-%d", cr.key, index)
+	if data := cr.cache.Get(name); data != nil {
+		return data, nil
+	}
+
+	var (
+		start = index * int64(cr.cache.blockSize)
+		end   = start + int64(cr.cache.blockSize) - 1
+	)
+	if end >= cr.size {
+		end = cr.size - 1
+	}
+
+	data, err := fetchRange(cr.storage, cr.container, cr.path, start, end, cancel)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cr.cache.Set(name, data); err != nil {
+		logrus.WithField("block", name).Warnln("Failed to cache block :", err)
+	}
+
+	return data, nil
+}
+
+func blockCacheKey(container, path, etag, lastModified string) string {
+	hash := sha256.Sum256([]byte(strings.Join([]string{container, path, etag, lastModified}, "\x00")))
+	return hex.EncodeToString(hash[:])
+}
+
+var (
+	_ io.ReadSeeker = (*CachedReader)(nil)
+	_ io.Closer     = (*CachedReader)(nil)
+)
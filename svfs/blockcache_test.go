@@ -0,0 +1,162 @@
+package svfs
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type BlockCacheTestSuite struct {
+	suite.Suite
+	dir   string
+	cache *BlockCache
+}
+
+func (suite *BlockCacheTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "svfs-blockcache-")
+	require.Nil(suite.T(), err)
+
+	suite.dir = dir
+	suite.cache = NewBlockCache(dir, 8, 4)
+	require.Nil(suite.T(), suite.cache.Init())
+}
+
+func (suite *BlockCacheTestSuite) TearDownTest() {
+	os.RemoveAll(suite.dir)
+}
+
+func (suite *BlockCacheTestSuite) TestSetGet() {
+	assert.Nil(suite.T(), suite.cache.Set("block", []byte("data")))
+	assert.Equal(suite.T(), "data", string(suite.cache.Get("block")))
+	assert.Nil(suite.T(), suite.cache.Get("missing"))
+	assert.Equal(suite.T(), uint64(4), suite.cache.used)
+}
+
+func (suite *BlockCacheTestSuite) TestEviction() {
+	suite.cache.Set("a", []byte("aaaa"))
+	suite.cache.Set("b", []byte("bbbb"))
+	suite.cache.Get("a")
+	suite.cache.Set("c", []byte("cccc"))
+
+	assert.NotNil(suite.T(), suite.cache.Get("a"))
+	assert.Nil(suite.T(), suite.cache.Get("b"))
+	assert.NotNil(suite.T(), suite.cache.Get("c"))
+	assert.Equal(suite.T(), uint64(8), suite.cache.used)
+}
+
+func (suite *BlockCacheTestSuite) TestPersistence() {
+	suite.cache.Set("a", []byte("aaaa"))
+
+	cache := NewBlockCache(suite.dir, 8, 4)
+	require.Nil(suite.T(), cache.Init())
+
+	assert.Equal(suite.T(), "aaaa", string(cache.Get("a")))
+	assert.Equal(suite.T(), uint64(4), cache.used)
+}
+
+func (suite *BlockCacheTestSuite) TestConcurrentSet() {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(suite.T(), suite.cache.Set("block", []byte("data")))
+		}()
+	}
+	wg.Wait()
+
+	files, err := ioutil.ReadDir(suite.dir)
+	require.Nil(suite.T(), err)
+	require.Len(suite.T(), files, 1)
+	assert.Equal(suite.T(), "block", files[0].Name())
+	assert.Equal(suite.T(), "data", string(suite.cache.Get("block")))
+}
+
+func (suite *BlockCacheTestSuite) TestInitRemovesTemporaryFiles() {
+	require.Nil(suite.T(), ioutil.WriteFile(filepath.Join(suite.dir, "block.tmp123"), []byte("da"), 0600))
+
+	cache := NewBlockCache(suite.dir, 8, 4)
+	require.Nil(suite.T(), cache.Init())
+
+	files, err := ioutil.ReadDir(suite.dir)
+	require.Nil(suite.T(), err)
+	assert.Empty(suite.T(), files)
+	assert.Equal(suite.T(), uint64(0), cache.used)
+}
+
+func (suite *BlockCacheTestSuite) TestCachedReader() {
+	reader := &CachedReader{
+		cache: suite.cache,
+		key:   blockCacheKey("container", "object", "etag", "date"),
+		size:  6,
+	}
+	suite.cache.Set(fmt.Sprintf("%s-0", reader.key), []byte("cont"))
+	suite.cache.Set(fmt.Sprintf("%s-1", reader.key), []byte("ent"))
+
+	reader.Seek(2, io.SeekStart)
+	data := make([]byte, 4)
+	n, err := io.ReadFull(reader, data)
+
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), "nten", string(data[:n]))
+
+	_, err = reader.Read(data)
+	assert.Equal(suite.T(), io.EOF, err)
+}
+
+func (suite *BlockCacheTestSuite) TestCachedReaderKeepsBlock() {
+	reader := &CachedReader{
+		cache: suite.cache,
+		key:   blockCacheKey("container", "object", "etag", "date"),
+		size:  4,
+	}
+	name := fmt.Sprintf("%s-0", reader.key)
+	suite.cache.Set(name, []byte("cont"))
+
+	data := make([]byte, 2)
+	_, err := reader.Read(data)
+	require.Nil(suite.T(), err)
+
+	// The block isn't read again from disk
+	require.Nil(suite.T(), os.Remove(filepath.Join(suite.dir, name)))
+	n, err := reader.Read(data)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), "nt", string(data[:n]))
+}
+
+func (suite *BlockCacheTestSuite) TestPersistenceOrder() {
+	suite.cache.Set("a", []byte("aaaa"))
+	suite.cache.Set("b", []byte("bbbb"))
+	past := time.Now().Add(-time.Hour)
+	require.Nil(suite.T(), os.Chtimes(filepath.Join(suite.dir, "a"), past, past))
+	require.Nil(suite.T(), os.Chtimes(filepath.Join(suite.dir, "b"), past.Add(time.Minute), past.Add(time.Minute)))
+	suite.cache.Get("a")
+
+	// Blocks are loaded in order of access
+	cache := NewBlockCache(suite.dir, 8, 4)
+	require.Nil(suite.T(), cache.Init())
+	cache.Set("c", []byte("cccc"))
+
+	assert.NotNil(suite.T(), cache.Get("a"))
+	assert.Nil(suite.T(), cache.Get("b"))
+}
+
+func (suite *BlockCacheTestSuite) TestCacheKey() {
+	assert.NotEqual(suite.T(),
+		blockCacheKey("container", "object", "etag1", "date"),
+		blockCacheKey("container", "object", "etag2", "date"),
+	)
+}
+
+func TestBlockCacheSuite(t *testing.T) {
+	suite.Run(t, new(BlockCacheTestSuite))
+}
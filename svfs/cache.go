@@ -2,27 +2,20 @@ package svfs
 
 import (
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 )
 
-var (
-	// CacheTimeout represents cache entries timeout.
-	CacheTimeout time.Duration
-	// CacheMaxEntries represents the cache size.
-	CacheMaxEntries int64
-	// CacheMaxAccess represents cache entries max access count.
-	CacheMaxAccess int64
-	changeCache    = NewSimpleCache() // Cache for mutating objects
-	directoryCache = NewCache()       // Cache for directories content
-)
-
 // Cache holds a map of cache entries. Its size can be configured
 // as well as cache entries access limit and expiration time.
 type Cache struct {
-	content   map[string]*CacheValue
-	mutex     sync.Mutex
-	nodeCount uint64
+	content    map[string]*CacheValue
+	mutex      sync.Mutex
+	nodeCount  uint64
+	timeout    time.Duration
+	maxEntries int64
+	maxAccess  int64
 }
 
 // CacheValue is the representation of a cache entry.
@@ -39,10 +32,14 @@ type CacheValue struct {
 	nodes       map[string]Node
 }
 
-// NewCache creates a new cache
-func NewCache() *Cache {
+// NewCache creates a new cache. Entries expire after the given timeout
+// or access count, a negative limit meaning unlimited.
+func NewCache(timeout time.Duration, maxEntries, maxAccess int64) *Cache {
 	return &Cache{
-		content: make(map[string]*CacheValue),
+		content:    make(map[string]*CacheValue),
+		timeout:    timeout,
+		maxEntries: maxEntries,
+		maxAccess:  maxAccess,
 	}
 }
 
@@ -63,9 +60,9 @@ func (c *Cache) AddAll(container, path string, node Node, nodes map[string]Node)
 		nodes: nodes,
 	}
 
-	if !(CacheMaxEntries < 0) &&
-		(c.nodeCount+uint64(len(nodes)) >= uint64(CacheMaxEntries)) ||
-		CacheMaxAccess == 0 {
+	if !(c.maxEntries < 0) &&
+		(c.nodeCount+uint64(len(nodes)) >= uint64(c.maxEntries)) ||
+		c.maxAccess == 0 {
 		entry.temporary = true
 	} else {
 		c.nodeCount += uint64(len(nodes))
@@ -115,6 +112,48 @@ func (c *Cache) deleteAll(container, path string, lock bool) {
 	}
 }
 
+// DeletePrefix removes all cache entries with a key starting with
+// container:prefix. It returns the count of removed entries.
+func (c *Cache) DeletePrefix(container, prefix string) (count int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key := range c.content {
+		if strings.HasPrefix(key, c.key(container, prefix)) {
+			c.remove(key)
+			count++
+		}
+	}
+
+	return count
+}
+
+// Flush removes all cache entries. It returns the count of removed
+// entries.
+func (c *Cache) Flush() (count int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key := range c.content {
+		c.remove(key)
+		count++
+	}
+
+	return count
+}
+
+func (c *Cache) remove(key string) {
+	v := c.content[key]
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if !v.temporary {
+		c.nodeCount -= uint64(len(v.nodes))
+	}
+	delete(c.content, key)
+}
+
 // Get retrieves a specific node from the cache. It returns nil if
 // the cache key container:path is missing.
 func (c *Cache) Get(container, path, name string) Node {
@@ -143,6 +182,7 @@ func (c *Cache) GetAll(container, path string) (Node, map[string]Node) {
 
 	// Not found
 	if !found {
+		cacheMisses.Inc()
 		return nil, nil
 	}
 
@@ -153,16 +193,21 @@ func (c *Cache) GetAll(container, path string) (Node, map[string]Node) {
 	v.accessCount++
 
 	// Found but expired
-	if time.Now().After(v.date.Add(CacheTimeout)) {
+	if time.Now().After(v.date.Add(c.timeout)) {
+		cacheMisses.Inc()
+		cacheEvictions.Inc()
 		defer c.deleteAll(container, path, false)
 		return nil, nil
 	}
 
 	if v.temporary ||
-		(!(CacheMaxAccess < 0) && v.accessCount == uint64(CacheMaxAccess)) {
+		(!(c.maxAccess < 0) && v.accessCount == uint64(c.maxAccess)) {
+		cacheEvictions.Inc()
 		defer c.deleteAll(container, path, false)
 	}
 
+	cacheHits.Inc()
+
 	return v.node, v.nodes
 }
 
@@ -184,13 +229,49 @@ func (c *Cache) Peek(container, path string) (Node, bool) {
 	defer v.mutex.Unlock()
 
 	// Found but expired
-	if time.Now().After(v.date.Add(CacheTimeout)) {
+	if time.Now().After(v.date.Add(c.timeout)) {
 		return nil, false
 	}
 
 	return v.node, true
 }
 
+// Rekey moves all cache entries with a key starting with container:oldPrefix
+// to the same key starting with container:newPrefix. The update function is
+// called on every parent and children node of moved entries.
+func (c *Cache) Rekey(container, oldPrefix, newPrefix string, update func(Node)) {
+	c.rekey(c.key(container, oldPrefix), c.key(container, newPrefix), update)
+}
+
+// RekeyContainer moves all cache entries of a container to another container.
+func (c *Cache) RekeyContainer(oldContainer, newContainer string) {
+	c.rekey(c.key(oldContainer, ""), c.key(newContainer, ""), func(Node) {})
+}
+
+func (c *Cache) rekey(oldKey, newKey string, update func(Node)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	moved := make(map[string]*CacheValue)
+
+	for key, v := range c.content {
+		if strings.HasPrefix(key, oldKey) {
+			moved[newKey+strings.TrimPrefix(key, oldKey)] = v
+			delete(c.content, key)
+		}
+	}
+
+	for key, v := range moved {
+		v.mutex.Lock()
+		update(v.node)
+		for _, node := range v.nodes {
+			update(node)
+		}
+		v.mutex.Unlock()
+		c.content[key] = v
+	}
+}
+
 // Set adds a specific node in cache, given a previous peek
 // operation succeeded.
 func (c *Cache) Set(container, path, name string, node Node) {
@@ -233,6 +314,23 @@ func (c *SimpleCache) Add(container, path string, node Node) {
 	c.changes[c.key(container, path)] = node
 }
 
+// Children retrieves cache entries located right under the path
+// of a directory.
+func (c *SimpleCache) Children(container, path string) (nodes []Node) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, node := range c.changes {
+		if !strings.HasPrefix(key, c.key(container, path)) {
+			continue
+		}
+		name := strings.TrimPrefix(key, c.key(container, path))
+		if name != "" && !strings.Contains(name, "/") {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
 // Exist checks whether a cache key exist or not.
 func (c *SimpleCache) Exist(container, path string) bool {
 	c.mutex.Lock()
@@ -240,6 +338,29 @@ func (c *SimpleCache) Exist(container, path string) bool {
 	return c.changes[c.key(container, path)] != nil
 }
 
+// ExistPrefix checks whether a cache key starting with container:prefix
+// exists or not.
+func (c *SimpleCache) ExistPrefix(container, prefix string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key := range c.changes {
+		if strings.HasPrefix(key, c.key(container, prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// All retrieves all cache entries.
+func (c *SimpleCache) All() (nodes []Node) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, node := range c.changes {
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
 // Get retrieves a cache entry for the given key.
 func (c *SimpleCache) Get(container, path string) Node {
 	c.mutex.Lock()
@@ -12,13 +12,14 @@ import (
 
 type ChangeCacheTestSuite struct {
 	suite.Suite
-	key  string
-	item *Object
+	cache *SimpleCache
+	key   string
+	item  *Object
 }
 
 func (suite *ChangeCacheTestSuite) SetupTest() {
 	// Reset cache
-	changeCache = NewSimpleCache()
+	suite.cache = NewSimpleCache()
 
 	// Sample data
 	suite.item = &Object{
@@ -28,26 +29,46 @@ func (suite *ChangeCacheTestSuite) SetupTest() {
 			Name: "container",
 		},
 	}
-	suite.key = changeCache.key(suite.item.c.Name, suite.item.path)
+	suite.key = suite.cache.key(suite.item.c.Name, suite.item.path)
 }
 
 func (suite *ChangeCacheTestSuite) TestAdd() {
-	changeCache.Add(suite.item.c.Name, suite.item.path, suite.item)
+	suite.cache.Add(suite.item.c.Name, suite.item.path, suite.item)
 
-	require.NotNil(suite.T(), changeCache.changes[suite.key])
-	assert.Equal(suite.T(), changeCache.changes[suite.key], suite.item)
+	require.NotNil(suite.T(), suite.cache.changes[suite.key])
+	assert.Equal(suite.T(), suite.cache.changes[suite.key], suite.item)
+}
+
+func (suite *ChangeCacheTestSuite) TestAll() {
+	suite.TestAdd()
+
+	assert.Equal(suite.T(), []Node{suite.item}, suite.cache.All())
 }
 
 func (suite *ChangeCacheTestSuite) TestExist() {
 	suite.TestAdd()
 
-	assert.True(suite.T(), changeCache.Exist(suite.item.c.Name, suite.item.path))
+	assert.True(suite.T(), suite.cache.Exist(suite.item.c.Name, suite.item.path))
+}
+
+func (suite *ChangeCacheTestSuite) TestExistPrefix() {
+	suite.TestAdd()
+
+	assert.True(suite.T(), suite.cache.ExistPrefix(suite.item.c.Name, "dir/"))
+	assert.False(suite.T(), suite.cache.ExistPrefix(suite.item.c.Name, "other/"))
+}
+
+func (suite *ChangeCacheTestSuite) TestChildren() {
+	suite.TestAdd()
+
+	assert.Equal(suite.T(), []Node{suite.item}, suite.cache.Children(suite.item.c.Name, "dir/"))
+	assert.Empty(suite.T(), suite.cache.Children(suite.item.c.Name, ""))
 }
 
 func (suite *ChangeCacheTestSuite) TestGet() {
 	suite.TestAdd()
 
-	node := changeCache.Get(suite.item.c.Name, suite.item.path)
+	node := suite.cache.Get(suite.item.c.Name, suite.item.path)
 
 	require.NotNil(suite.T(), node)
 	assert.Equal(suite.T(), node, suite.item)
@@ -56,9 +77,9 @@ func (suite *ChangeCacheTestSuite) TestGet() {
 func (suite *ChangeCacheTestSuite) TestRemove() {
 	suite.TestAdd()
 
-	changeCache.Remove(suite.item.c.Name, suite.item.path)
+	suite.cache.Remove(suite.item.c.Name, suite.item.path)
 
-	assert.Nil(suite.T(), changeCache.changes[suite.key])
+	assert.Nil(suite.T(), suite.cache.changes[suite.key])
 }
 
 func TestChangeCacheTestSuite(t *testing.T) {
@@ -67,6 +88,7 @@ func TestChangeCacheTestSuite(t *testing.T) {
 
 type CacheTestSuite struct {
 	suite.Suite
+	cache  *Cache
 	nodes  map[string]Node
 	key    string
 	item1  *Object
@@ -75,13 +97,8 @@ type CacheTestSuite struct {
 }
 
 func (suite *CacheTestSuite) SetupTest() {
-	// Cache settings
-	CacheTimeout = 5 * time.Minute
-	CacheMaxEntries = -1
-	CacheMaxAccess = -1
-
 	// Reset cache
-	directoryCache = NewCache()
+	suite.cache = NewCache(5*time.Minute, -1, -1)
 
 	// Sample data
 	suite.nodes = make(map[string]Node)
@@ -94,21 +111,21 @@ func (suite *CacheTestSuite) SetupTest() {
 	}
 	suite.item1 = &Object{name: "item1"}
 	suite.item2 = &Object{name: "item2"}
-	suite.key = directoryCache.key(suite.parent.c.Name, suite.parent.path)
+	suite.key = suite.cache.key(suite.parent.c.Name, suite.parent.path)
 	suite.nodes[suite.item1.Name()] = suite.item1
 }
 
 func (suite *CacheTestSuite) TestAddAll() {
-	directoryCache.AddAll(suite.parent.c.Name, suite.parent.path, suite.parent, suite.nodes)
+	suite.cache.AddAll(suite.parent.c.Name, suite.parent.path, suite.parent, suite.nodes)
 
-	assert.Equal(suite.T(), directoryCache.nodeCount, uint64(1))
-	assert.Len(suite.T(), directoryCache.content[suite.key].nodes, 1)
+	assert.Equal(suite.T(), suite.cache.nodeCount, uint64(1))
+	assert.Len(suite.T(), suite.cache.content[suite.key].nodes, 1)
 }
 
 func (suite *CacheTestSuite) TestGetAll() {
 	suite.TestAddAll()
 
-	cachedParent, cachedNodes := directoryCache.GetAll(suite.parent.c.Name, suite.parent.path)
+	cachedParent, cachedNodes := suite.cache.GetAll(suite.parent.c.Name, suite.parent.path)
 
 	assert.Len(suite.T(), cachedNodes, 1)
 	assert.IsType(suite.T(), &Object{}, cachedNodes[suite.item1.Name()])
@@ -118,24 +135,43 @@ func (suite *CacheTestSuite) TestGetAll() {
 func (suite *CacheTestSuite) TestDeleteAll() {
 	suite.TestAddAll()
 
-	directoryCache.DeleteAll(suite.parent.c.Name, suite.parent.path)
+	suite.cache.DeleteAll(suite.parent.c.Name, suite.parent.path)
 
-	assert.Nil(suite.T(), directoryCache.content[suite.key])
-	assert.Len(suite.T(), directoryCache.content, 0)
-	assert.Equal(suite.T(), directoryCache.nodeCount, uint64(0))
+	assert.Nil(suite.T(), suite.cache.content[suite.key])
+	assert.Len(suite.T(), suite.cache.content, 0)
+	assert.Equal(suite.T(), suite.cache.nodeCount, uint64(0))
+}
+
+func (suite *CacheTestSuite) TestDeletePrefix() {
+	suite.TestAddAll()
+	suite.cache.AddAll(suite.parent.c.Name, "dir/sub/", suite.parent, map[string]Node{"item2": suite.item2})
+	suite.cache.AddAll(suite.parent.c.Name, "directory/", suite.parent, map[string]Node{"item2": suite.item2})
+
+	assert.Equal(suite.T(), 2, suite.cache.DeletePrefix(suite.parent.c.Name, "dir/"))
+	assert.Len(suite.T(), suite.cache.content, 1)
+	assert.NotNil(suite.T(), suite.cache.content[suite.cache.key(suite.parent.c.Name, "directory/")])
+	assert.Equal(suite.T(), suite.cache.nodeCount, uint64(1))
+}
+
+func (suite *CacheTestSuite) TestFlush() {
+	suite.TestAddAll()
+
+	assert.Equal(suite.T(), 1, suite.cache.Flush())
+	assert.Len(suite.T(), suite.cache.content, 0)
+	assert.Equal(suite.T(), suite.cache.nodeCount, uint64(0))
 }
 
 func (suite *CacheTestSuite) TestDelete() {
 	suite.TestSet()
 
 	var (
-		entries   = directoryCache.content[suite.key].nodes
+		entries   = suite.cache.content[suite.key].nodes
 		nodeCount = len(entries)
 	)
 
 	for _, node := range []Node{suite.item1, suite.item2} {
 		nodeCount--
-		directoryCache.Delete(suite.parent.c.Name, suite.parent.path, node.Name())
+		suite.cache.Delete(suite.parent.c.Name, suite.parent.path, node.Name())
 		assert.Nil(suite.T(), entries[node.Name()])
 		assert.Len(suite.T(), entries, nodeCount)
 	}
@@ -145,7 +181,7 @@ func (suite *CacheTestSuite) TestGet() {
 	suite.TestSet()
 
 	for _, node := range []Node{suite.item1, suite.item2} {
-		cached := directoryCache.Get(suite.parent.c.Name, suite.parent.path, node.Name())
+		cached := suite.cache.Get(suite.parent.c.Name, suite.parent.path, node.Name())
 		require.NotNil(suite.T(), cached)
 		assert.Equal(suite.T(), cached, node)
 	}
@@ -154,20 +190,33 @@ func (suite *CacheTestSuite) TestGet() {
 func (suite *CacheTestSuite) TestPeek() {
 	suite.TestAddAll()
 
-	parent, found := directoryCache.Peek(suite.parent.c.Name, suite.parent.path)
+	parent, found := suite.cache.Peek(suite.parent.c.Name, suite.parent.path)
 
 	assert.True(suite.T(), found)
 	require.NotNil(suite.T(), parent)
 	assert.Equal(suite.T(), parent, suite.parent)
 }
 
+func (suite *CacheTestSuite) TestRekey() {
+	suite.TestAddAll()
+
+	var updated []Node
+	suite.cache.Rekey(suite.parent.c.Name, "dir/", "moved/", func(n Node) {
+		updated = append(updated, n)
+	})
+
+	assert.Nil(suite.T(), suite.cache.content[suite.key])
+	assert.NotNil(suite.T(), suite.cache.content[suite.cache.key(suite.parent.c.Name, "moved/")])
+	assert.Len(suite.T(), updated, 2)
+}
+
 func (suite *CacheTestSuite) TestSet() {
 	suite.TestAddAll()
 
-	directoryCache.Set(suite.parent.c.Name, suite.parent.path, suite.item2.name, suite.item2)
+	suite.cache.Set(suite.parent.c.Name, suite.parent.path, suite.item2.name, suite.item2)
 
-	require.NotNil(suite.T(), directoryCache.content[suite.key])
-	assert.NotNil(suite.T(), directoryCache.content[suite.key].nodes[suite.item2.name])
+	require.NotNil(suite.T(), suite.cache.content[suite.key])
+	assert.NotNil(suite.T(), suite.cache.content[suite.key].nodes[suite.item2.name])
 }
 
 func TestCacheTestSuite(t *testing.T) {
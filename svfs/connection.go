@@ -0,0 +1,64 @@
+package svfs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/xlucas/swift"
+)
+
+// Connection is a swift connection retrying requests failing with
+// transient errors such as server errors, throttled requests or
+// connection resets. Attempts are spaced by a jittered exponential
+// backoff, honouring delays requested by swift through Retry-After
+// headers. Requests rejected because the authentication token expired
+// are sent again once the token is renewed.
+type Connection struct {
+	*swift.Connection
+	// MaxRetries represents how many times a swift request failing
+	// with a transient error is retried. Requests are only sent again
+	// after renewing a rejected token if 0.
+	MaxRetries uint64
+	// RetryDelay is the delay before retrying a request for the
+	// first time. It doubles on each following attempt.
+	RetryDelay time.Duration
+	// RetryMaxDelay is the maximum delay between two attempts.
+	RetryMaxDelay time.Duration
+	// TokenRefreshMargin represents how long before its expiry the
+	// authentication token is renewed in the background. Tokens are
+	// only renewed once rejected by swift if 0 or if their expiry is
+	// unknown, as with keystone v1 and v2 authentication.
+	TokenRefreshMargin time.Duration
+	// HubicAuthorization is the basicAuth header used
+	// within requests to Hubic OAUTH2 API.
+	HubicAuthorization string
+	// HubicRefreshToken is the OAUTH2 refresh token.
+	HubicRefreshToken string
+	// KeystoneApplicationCredentialID is the ID of the keystone application
+	// credential used to authenticate instead of a user name and password.
+	KeystoneApplicationCredentialID string
+	// KeystoneApplicationCredentialSecret is the secret of the keystone
+	// application credential.
+	KeystoneApplicationCredentialSecret string
+	// KeystoneApplicationCredentialSecretFile is a file holding the secret
+	// of the keystone application credential.
+	KeystoneApplicationCredentialSecretFile string
+	// KeystonePasswordFile is a file holding the keystone user password.
+	KeystonePasswordFile string
+	// KeystoneTokenFile is a file holding a valid keystone token.
+	KeystoneTokenFile string
+
+	authMutex      sync.Mutex
+	authGeneration uint64
+	refreshOnce    sync.Once
+	refreshStop    chan struct{}
+	refreshDone    chan struct{}
+	stopOnce       sync.Once
+	retryAfter     retryAfterTracker
+	spool          *Spool
+	streamMaxSize  uint64
+}
+
+func newConnection() *Connection {
+	return &Connection{Connection: new(swift.Connection)}
+}
@@ -1,12 +1,17 @@
 package svfs
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/xlucas/swift"
 
 	"bazil.org/fuse"
@@ -26,25 +31,32 @@ var (
 
 // Directory represents a standard directory entry.
 type Directory struct {
-	apex bool
-	name string
-	path string
-	so   *swift.Object
-	sh   swift.Headers
-	c    *swift.Container
-	cs   *swift.Container
+	// childMtime is the newest mtime in nanoseconds of children
+	// of a pseudo directory, as seen when listing it.
+	childMtime int64
+	fs         *SVFS
+	apex       bool
+	name       string
+	path       string
+	so         *swift.Object
+	sh         swift.Headers
+	c          *swift.Container
+	cs         *swift.Container
+	m          sync.Mutex
 }
 
 // Attr fills file attributes of a directory within the current context.
 func (d *Directory) Attr(ctx context.Context, a *fuse.Attr) error {
-	a.Mode = os.ModeDir | os.FileMode(DefaultMode)
-	a.Gid = uint32(DefaultGID)
-	a.Uid = uint32(DefaultUID)
-	a.Size = uint64(BlockSize)
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	a.Mode = os.ModeDir | d.fs.getMode(d.sh)
+	a.Uid, a.Gid = d.fs.getOwner(d.sh)
+	a.Size = uint64(d.fs.BlockSize)
 
 	if d.so != nil {
 		a.Atime = time.Now()
-		a.Mtime = MountTime
+		a.Mtime = d.mtime()
 		a.Ctime = a.Mtime
 		a.Crtime = a.Mtime
 	}
@@ -59,12 +71,14 @@ func (d *Directory) Create(ctx context.Context, req *fuse.CreateRequest, resp *f
 	path := d.path + req.Name
 
 	// New node
-	node := &Object{name: req.Name, path: path, c: d.c, cs: d.cs, p: d}
+	node := &Object{fs: d.fs, name: req.Name, path: path, c: d.c, cs: d.cs, p: d}
+	node.sh = d.fs.newPermissionHeaders(req.Mode&^req.Umask, req.Uid, req.Gid)
 
 	// Don't create an empty file in transfer mode since we assume the file
 	// has been created to be immediately written to with some content.
-	if TransferMode&SkipCreate == 0 {
-		err := SwiftConnection.ObjectPutBytes(node.c.Name, node.path, nil, "")
+	// Neither in write-back mode since the file is uploaded once closed.
+	if d.fs.TransferMode&SkipCreate == 0 && !d.fs.WriteBack {
+		_, err := d.fs.Storage.ObjectPut(node.c.Name, node.path, bytes.NewReader(nil), false, "", d.fs.contentType(node.path), node.sh)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -75,6 +89,7 @@ func (d *Directory) Create(ctx context.Context, req *fuse.CreateRequest, resp *f
 	if err != nil {
 		return nil, nil, err
 	}
+	d.fs.openHandles.add(fh)
 
 	// Get object info
 	obj := &swift.Object{
@@ -84,10 +99,10 @@ func (d *Directory) Create(ctx context.Context, req *fuse.CreateRequest, resp *f
 	}
 
 	node.so = obj
-	node.sh = map[string]string{}
 
 	// Cache it
-	directoryCache.Set(d.c.Name, d.path, req.Name, node)
+	d.fs.directoryCache.Set(d.c.Name, d.path, req.Name, node)
+	d.childChanged()
 
 	return node, fh, nil
 }
@@ -106,14 +121,14 @@ func (d *Directory) Export() fuse.Dirent {
 func (d *Directory) ReadDirAll(ctx context.Context) (direntries []fuse.Dirent, err error) {
 	var (
 		dirs  = make(map[string]bool)
-		tasks = make(chan Node, ListerConcurrency)
+		tasks = make(chan Node, d.fs.ListerConcurrency)
 		count = 0
 	)
 
 	defer close(tasks)
 
 	// Cache check
-	if _, nodes := directoryCache.GetAll(d.c.Name, d.path); nodes != nil {
+	if _, nodes := d.fs.directoryCache.GetAll(d.c.Name, d.path); nodes != nil {
 		for _, node := range nodes {
 			direntries = append(direntries, node.Export())
 		}
@@ -121,7 +136,7 @@ func (d *Directory) ReadDirAll(ctx context.Context) (direntries []fuse.Dirent, e
 	}
 
 	// Fetch objects
-	objects, err := SwiftConnection.ObjectsAll(d.c.Name, &swift.ObjectsOpts{
+	objects, err := d.fs.Storage.ObjectsAll(d.c.Name, &swift.ObjectsOpts{
 		Delimiter: '/',
 		Prefix:    d.path,
 	})
@@ -142,8 +157,8 @@ func (d *Directory) ReadDirAll(ctx context.Context) (direntries []fuse.Dirent, e
 
 		// This is a symlink
 		if isSymlink(o, d.path) {
-			child = &Symlink{path: path, name: fileName, c: d.c, so: &o, sh: swift.Headers{}, p: d}
-			directoryLister.AddTask(child, tasks)
+			child = &Symlink{fs: d.fs, path: path, name: fileName, c: d.c, so: &o, sh: swift.Headers{}, p: d}
+			d.fs.directoryLister.AddTask(child, tasks)
 			child = nil
 			count++
 			goto finish
@@ -154,32 +169,32 @@ func (d *Directory) ReadDirAll(ctx context.Context) (direntries []fuse.Dirent, e
 			if !strings.HasSuffix(o.Name, "/") {
 				path += "/"
 			}
-			child = &Directory{c: d.c, cs: d.cs, so: &o, sh: swift.Headers{}, path: path, name: fileName}
+			child = &Directory{fs: d.fs, c: d.c, cs: d.cs, so: &o, sh: swift.Headers{}, path: path, name: fileName}
 			dirs[fileName] = true
 			goto finish
 		}
 
 		// This is a pseudo directory. Add it only if the real directory is missing
 		if isPseudoDirectory(o, d.path) && !dirs[fileName] {
-			child = &Directory{c: d.c, cs: d.cs, so: &o, sh: swift.Headers{}, path: path, name: fileName}
+			child = &Directory{fs: d.fs, c: d.c, cs: d.cs, so: &o, sh: swift.Headers{}, path: path, name: fileName}
 			dirs[fileName] = true
 			goto finish
 		}
 
 		// This is a pure swift object
 		if !strings.HasSuffix(o.Name, "/") {
-			child = &Object{path: path, name: fileName, c: d.c, cs: d.cs, so: &o, sh: swift.Headers{}, p: d}
+			child = &Object{fs: d.fs, path: path, name: fileName, c: d.c, cs: d.cs, so: &o, sh: swift.Headers{}, p: d}
 
 			// If we are writing to this object at the moment
 			// we don't want to update the cache with this.
-			if changeCache.Exist(d.c.Name, path) {
-				child = changeCache.Get(d.c.Name, path)
+			if d.fs.changeCache.Exist(d.c.Name, path) {
+				child = d.fs.changeCache.Get(d.c.Name, path)
 				goto export
 			}
 
 			// Large objects needs extra information
 			if isLargeObject(&o) {
-				directoryLister.AddTask(child, tasks)
+				d.fs.directoryLister.AddTask(child, tasks)
 				child = nil
 				count++
 			}
@@ -187,8 +202,8 @@ func (d *Directory) ReadDirAll(ctx context.Context) (direntries []fuse.Dirent, e
 
 	finish:
 		// Always fetch extra info if asked
-		if child != nil && (Attr || Xattr) {
-			directoryLister.AddTask(child, tasks)
+		if child != nil && (d.fs.Attr || d.fs.Xattr || d.fs.FilePermissions) {
+			d.fs.directoryLister.AddTask(child, tasks)
 			child = nil
 			count++
 		}
@@ -215,20 +230,53 @@ func (d *Directory) ReadDirAll(ctx context.Context) (direntries []fuse.Dirent, e
 		}
 	}
 
-	directoryCache.AddAll(d.c.Name, d.path, d, children)
+	// Add files not uploaded yet
+	for _, node := range d.fs.changeCache.Children(d.c.Name, d.path) {
+		if children[node.Name()] == nil {
+			direntries = append(direntries, node.Export())
+			children[node.Name()] = node
+		}
+	}
+
+	d.fs.directoryCache.AddAll(d.c.Name, d.path, d, children)
+
+	// Pseudo directories are as recent as their newest child
+	if d.so != nil && d.so.PseudoDirectory {
+		var newest time.Time
+		for _, child := range children {
+			var mtime time.Time
+			switch n := child.(type) {
+			case *Object:
+				mtime = d.fs.getMtime(n.so, n.sh)
+			case *Symlink:
+				mtime = d.fs.getMtime(n.so, n.sh)
+			}
+			if mtime.After(newest) {
+				newest = mtime
+			}
+		}
+		if !newest.IsZero() {
+			atomic.StoreInt64(&d.childMtime, newest.UnixNano())
+		}
+	}
 
 	return direntries, nil
 }
 
 // Link creates a hard link between two nodes.
 func (d *Directory) Link(ctx context.Context, req *fuse.LinkRequest, old fs.Node) (node fs.Node, err error) {
-	if object, ok := old.(*Object); ok {
-		return object.copy(d, req.NewName)
+	switch n := old.(type) {
+	case *Object:
+		node, err = n.copy(d, req.NewName)
+	case *Symlink:
+		node, err = n.copy(d, req.NewName)
+	default:
+		return nil, fuse.ENOTSUP
 	}
-	if symlink, ok := old.(*Symlink); ok {
-		return symlink.copy(d, req.NewName)
+	if err == nil {
+		d.childChanged()
 	}
-	return nil, fuse.ENOTSUP
+	return node, err
 }
 
 // Lookup gets a children node if its name matches the requested direntry name.
@@ -236,11 +284,11 @@ func (d *Directory) Link(ctx context.Context, req *fuse.LinkRequest, old fs.Node
 // match the requested direnty after this operation.
 // It returns ENOENT if not found.
 func (d *Directory) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
-	if _, found := directoryCache.Peek(d.c.Name, d.path); !found {
+	if _, found := d.fs.directoryCache.Peek(d.c.Name, d.path); !found {
 		d.ReadDirAll(ctx)
 	}
 	// Find matching child
-	if item := directoryCache.Get(d.c.Name, d.path, req.Name); item != nil {
+	if item := d.fs.directoryCache.Get(d.c.Name, d.path, req.Name); item != nil {
 		if n, ok := item.(fs.Node); ok {
 			return n, nil
 		}
@@ -252,22 +300,26 @@ func (d *Directory) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *f
 // Mkdir creates a new directory node within the current directory. It is represented
 // by an empty object ending with a slash in the Swift container.
 func (d *Directory) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error) {
-	absPath := d.path + req.Name + "/"
+	var (
+		absPath = d.path + req.Name + "/"
+		headers = d.fs.newPermissionHeaders(req.Mode&^req.Umask, req.Uid, req.Gid)
+	)
 
 	// Create the file in swift
-	if TransferMode&SkipMkdir == 0 {
-		if err := SwiftConnection.ObjectPutBytes(d.c.Name, absPath, nil, dirContentType); err != nil {
+	if d.fs.TransferMode&SkipMkdir == 0 {
+		if _, err := d.fs.Storage.ObjectPut(d.c.Name, absPath, bytes.NewReader(nil), false, "", dirContentType, headers); err != nil {
 			return nil, fuse.EIO
 		}
 	}
 
 	// Directory object
 	node := &Directory{
+		fs:   d.fs,
 		c:    d.c,
 		cs:   d.cs,
 		name: req.Name,
 		path: absPath,
-		sh:   swift.Headers{},
+		sh:   headers,
 		so: &swift.Object{
 			Name:         absPath,
 			ContentType:  dirContentType,
@@ -276,7 +328,8 @@ func (d *Directory) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node,
 	}
 
 	// Cache eviction
-	directoryCache.Set(d.c.Name, d.path, req.Name, node)
+	d.fs.directoryCache.Set(d.c.Name, d.path, req.Name, node)
+	d.childChanged()
 
 	return node, nil
 }
@@ -286,16 +339,115 @@ func (d *Directory) Name() string {
 	return d.name
 }
 
+// Getxattr retrieves extended attributes of a directory node. Standard
+// directories store them in their marker object, containers expose
+// their metadata.
+func (d *Directory) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) (err error) {
+	if !d.fs.Xattr {
+		return fuse.ENOTSUP
+	}
+
+	name, system := systemXattr(req.Name)
+
+	switch {
+	case d.c == nil:
+		return fuse.ErrNoXattr
+	case d.isContainer() && system:
+		resp.Xattr, err = d.fs.getContainerSystemXattr(d.c.Name, name)
+	case d.isContainer():
+		resp.Xattr, err = d.fs.getContainerXattr(d.c.Name, req.Name)
+	case system:
+		d.m.Lock()
+		defer d.m.Unlock()
+		resp.Xattr, err = d.fs.getObjectSystemXattr(d.c.Name, d.sh, name)
+	case d.isMarker():
+		d.m.Lock()
+		defer d.m.Unlock()
+		resp.Xattr, err = getXattr(d.sh, req.Name)
+	default:
+		// Pseudo directories have no marker holding attributes
+		return fuse.ErrNoXattr
+	}
+
+	return err
+}
+
+// Listxattr lists extended attributes associated with this directory node.
+func (d *Directory) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	if !d.fs.Xattr {
+		return fuse.ENOTSUP
+	}
+
+	switch {
+	case d.isContainer():
+		return d.fs.listContainerXattr(d.c.Name, resp)
+	case d.isMarker():
+		d.m.Lock()
+		defer d.m.Unlock()
+		listXattr(d.sh, resp)
+	}
+
+	return nil
+}
+
+// Removexattr removes an extended attribute on this directory node.
+func (d *Directory) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
+	return d.setXattr(req.Name, nil)
+}
+
+// Setxattr changes an extended attribute on this directory node.
+func (d *Directory) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
+	return d.setXattr(req.Name, req.Xattr)
+}
+
+// setXattr stores an extended attribute, an empty value removing it.
+// Pseudo directories have nowhere to store them.
+func (d *Directory) setXattr(name string, value []byte) error {
+	if !d.fs.Xattr {
+		return fuse.ENOTSUP
+	}
+
+	// System metadata are read-only
+	if _, ok := systemXattr(name); ok {
+		return fuse.EPERM
+	}
+
+	switch {
+	case d.isContainer():
+		if len(value) == 0 {
+			return d.fs.removeContainerXattr(d.c.Name, name)
+		}
+		return d.fs.setContainerXattr(d.c.Name, name, value)
+	case d.isMarker():
+		d.m.Lock()
+		defer d.m.Unlock()
+		if changes := xattrChanges(d.sh, name, value); changes != nil {
+			return d.updateMarker(changes)
+		}
+		return nil
+	}
+
+	return fuse.ENOTSUP
+}
+
 // Remove deletes a direntry and relevant node. It is not supported on container
 // nodes. It handles standard and segmented object deletion.
 func (d *Directory) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	err := d.remove(req)
+	if err == nil {
+		d.childChanged()
+	}
+	return err
+}
+
+func (d *Directory) remove(req *fuse.RemoveRequest) error {
 	var (
 		path = d.path + req.Name
-		node = directoryCache.Get(d.c.Name, d.path, req.Name)
+		node = d.fs.directoryCache.Get(d.c.Name, d.path, req.Name)
 	)
 
 	if directory, ok := node.(*Directory); ok {
-		if TransferMode&SkipRmdir == 0 {
+		if d.fs.TransferMode&SkipRmdir == 0 {
 			empty, err := directory.isEmpty()
 			if err != nil {
 				return err
@@ -316,14 +468,87 @@ func (d *Directory) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
 	return fuse.ENOTSUP
 }
 
-// Setattr changes file attributes on the current object. Not supported on directories.
+// Setattr changes file attributes stored in the marker object of a
+// standard directory. Changes are ignored on other directories.
 func (d *Directory) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
-	return nil
+	if !d.isMarker() {
+		return nil
+	}
+
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	changes, err := d.fs.attrChanges(d.so, d.sh, req)
+	if err == fuse.ENOTSUP || len(changes) == 0 {
+		return nil
+	}
+
+	return d.updateMarker(changes)
+}
+
+// isContainer tells whether the directory is a container.
+func (d *Directory) isContainer() bool {
+	return d.c != nil && d.so == nil
+}
+
+// isMarker tells whether the directory is backed by a marker object.
+func (d *Directory) isMarker() bool {
+	return d.so != nil && !d.so.PseudoDirectory && d.so.ContentType == dirContentType
+}
+
+// mtime returns the modification time of the directory.
+func (d *Directory) mtime() time.Time {
+	if d.so.PseudoDirectory {
+		if mtime := atomic.LoadInt64(&d.childMtime); mtime != 0 {
+			return time.Unix(0, mtime)
+		}
+	}
+	if d.isMarker() && d.fs.Attr && len(d.sh) > 0 {
+		return d.fs.getMtime(d.so, d.sh)
+	}
+	return d.fs.MountTime
+}
+
+// updateMarker stores metadata changes in the marker object.
+func (d *Directory) updateMarker(changes map[string]string) error {
+	// Markers may be listed without fetching their metadata, which
+	// swift replaces as a whole
+	if len(d.sh) == 0 {
+		_, h, err := d.fs.Storage.Object(d.c.Name, d.so.Name)
+		if err != nil {
+			return err
+		}
+		d.sh = h
+	}
+	return d.fs.Storage.ObjectUpdate(d.c.Name, d.so.Name, updateMetadata(d.sh, changes))
+}
+
+// childChanged records that an entry has been added to or removed
+// from the directory.
+func (d *Directory) childChanged() {
+	now := time.Now()
+
+	if d.so != nil && d.so.PseudoDirectory {
+		atomic.StoreInt64(&d.childMtime, now.UnixNano())
+		return
+	}
+
+	if !d.fs.UpdateParentMtime || !d.isMarker() {
+		return
+	}
+
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	err := d.updateMarker(map[string]string{d.fs.mtimeHeader(): d.fs.formatTime(now)})
+	if err != nil {
+		logrus.WithField("directory", d.path).Warnln("Failed to update modification time :", err)
+	}
 }
 
 func (d *Directory) isEmpty() (bool, error) {
 	// Fetch objects
-	objects, err := SwiftConnection.ObjectsAll(d.c.Name, &swift.ObjectsOpts{
+	objects, err := d.fs.Storage.ObjectsAll(d.c.Name, &swift.ObjectsOpts{
 		Delimiter: '/',
 		Prefix:    d.path,
 		Limit:     2,
@@ -343,21 +568,128 @@ func (d *Directory) isEmpty() (bool, error) {
 
 func (d *Directory) move(oldContainer, oldPath, oldName, newContainer, newPath, newName string) error {
 	// Get the old node from cache
-	return fuse.ENOTSUP
+	dir, ok := d.fs.directoryCache.Get(oldContainer, oldPath, oldName).(*Directory)
+	if !ok || oldContainer != newContainer {
+		return fuse.ENOTSUP
+	}
+
+	var (
+		source = oldPath + oldName + "/"
+		target = newPath + newName + "/"
+	)
+
+	// Moving a directory within itself
+	if strings.HasPrefix(target, source) {
+		return fuse.Errno(syscall.EINVAL)
+	}
+
+	// Files are being written within this directory
+	if err := d.fs.writeBackQueue.WaitPrefix(oldContainer, source); err != nil {
+		return err
+	}
+	if d.fs.changeCache.ExistPrefix(oldContainer, source) {
+		return fuse.Errno(syscall.EBUSY)
+	}
+
+	// Only an empty directory can be replaced. The target may no
+	// longer be cached, so it is looked up in the storage.
+	if node := d.fs.directoryCache.Get(newContainer, newPath, newName); node != nil {
+		if _, ok := node.(*Directory); !ok {
+			return fuse.Errno(syscall.ENOTDIR)
+		}
+	}
+
+	// Markers of the target are kept if the rename is rolled back
+	var existing []string
+	for _, name := range []string{target, strings.TrimSuffix(target, "/")} {
+		object, _, err := d.fs.Storage.Object(newContainer, name)
+		if err == swift.ObjectNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if name != target && object.ContentType != dirContentType {
+			return fuse.Errno(syscall.ENOTDIR)
+		}
+		existing = append(existing, name)
+	}
+
+	empty, err := (&Directory{fs: d.fs, c: d.c, path: target}).isEmpty()
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return fuse.ENOTEMPTY
+	}
+
+	// Find all objects to move
+	objects, err := d.fs.Storage.ObjectsAll(oldContainer, &swift.ObjectsOpts{
+		Prefix: source,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Directory marker without trailing slash
+	if dir.so != nil && !dir.so.PseudoDirectory && dir.so.Name != source {
+		objects = append(objects, *dir.so)
+	}
+
+	names := make([]string, 0, len(objects))
+	for _, object := range objects {
+		names = append(names, object.Name)
+	}
+
+	journal, err := d.fs.newRenameJournal(oldContainer, source, target, names, existing)
+	if err != nil {
+		return err
+	}
+
+	// Server-side copy
+	err = forEachConcurrently(d.fs.RenameConcurrency, len(objects), func(i int) error {
+		return d.fs.copyObject(oldContainer, &objects[i], journal.target(objects[i].Name))
+	})
+	if err != nil {
+		if rollbackErr := journal.rollback(); rollbackErr != nil {
+			logrus.WithError(rollbackErr).Errorf("Failed to rollback rename of %s", source)
+		}
+		return err
+	}
+
+	// Remove originals
+	journal.Phase = renamePhaseDelete
+	if err := journal.save(); err != nil {
+		return err
+	}
+	if err := journal.complete(); err != nil {
+		return err
+	}
+
+	// Update cache
+	d.fs.directoryCache.Delete(oldContainer, oldPath, oldName)
+	d.fs.directoryCache.Rekey(oldContainer, source, target, func(n Node) {
+		renameNode(n, source, target)
+	})
+	renameNode(dir, source, target)
+	dir.name = newName
+	d.fs.directoryCache.Set(newContainer, newPath, newName, dir)
+
+	return nil
 }
 
 func (d *Directory) moveObject(oldContainer, oldPath, oldName, newContainer, newPath, newName string, o *Object, manifest bool) error {
 	if manifest {
-		err := SwiftConnection.ObjectMove(oldContainer, oldPath+oldName, newContainer, newPath+newName)
+		err := d.fs.Storage.ObjectMove(oldContainer, oldPath+oldName, newContainer, newPath+newName)
 		if err != nil {
 			return err
 		}
 	} else {
-		_, err := SwiftConnection.ManifestCopy(oldContainer, oldPath+oldName, newContainer, newPath+newName, nil)
+		_, err := d.fs.Storage.ManifestCopy(oldContainer, oldPath+oldName, newContainer, newPath+newName, nil)
 		if err != nil {
 			return err
 		}
-		err = SwiftConnection.ObjectDelete(oldContainer, oldPath+oldName)
+		err = d.fs.Storage.ObjectDelete(oldContainer, oldPath+oldName)
 		if err != nil {
 			return err
 		}
@@ -366,79 +698,110 @@ func (d *Directory) moveObject(oldContainer, oldPath, oldName, newContainer, new
 	o.name = newName
 	o.path = newPath + newName
 
-	directoryCache.Delete(oldContainer, oldPath, oldName)
-	directoryCache.Set(newContainer, newPath, newName, o)
+	d.fs.directoryCache.Delete(oldContainer, oldPath, oldName)
+	d.fs.directoryCache.Set(newContainer, newPath, newName, o)
 
 	return nil
 }
 
 func (d *Directory) removeDirectory(directory *Directory, name string) error {
-	SwiftConnection.ObjectDelete(directory.c.Name, directory.so.Name)
-	if _, found := directoryCache.Peek(directory.c.Name, directory.path); found {
-		directoryCache.DeleteAll(directory.c.Name, directory.path)
+	d.fs.Storage.ObjectDelete(directory.c.Name, directory.so.Name)
+	if _, found := d.fs.directoryCache.Peek(directory.c.Name, directory.path); found {
+		d.fs.directoryCache.DeleteAll(directory.c.Name, directory.path)
 	}
 
-	directoryCache.Delete(directory.c.Name, d.path, directory.name)
+	d.fs.directoryCache.Delete(directory.c.Name, d.path, directory.name)
 
 	return nil
 }
 
 func (d *Directory) removeObject(object *Object, name, path string) error {
-	if object.segmented {
-		_, h, err := SwiftConnection.Object(d.c.Name, path)
-		if err != nil {
+	// Pending uploads are useless now
+	d.fs.writeBackQueue.Cancel(d.c.Name, path)
+
+	// Segmented objects or objects we don't know anything about
+	// yet may reference segments.
+	if object.segmented || len(object.sh) == 0 {
+		_, h, err := d.fs.Storage.Object(d.c.Name, path)
+		if err != nil && err != swift.ObjectNotFound {
 			return err
 		}
-		if !segmentPathRegex.Match([]byte(h[manifestHeader])) {
+		if isStaticLargeObject(h) {
+			if err := d.fs.Storage.DeleteStaticLargeObject(d.c.Name, path); err != nil {
+				return err
+			}
+			d.fs.directoryCache.Delete(d.c.Name, d.path, name)
+			return nil
+		}
+		if object.segmented && !segmentPathRegex.Match([]byte(h[manifestHeader])) {
 			return fmt.Errorf("Invalid segment path for manifest %s", name)
 		}
-		if err := deleteSegments(d.cs.Name, h[manifestHeader]); err != nil {
-			return err
+		if segmentPathRegex.Match([]byte(h[manifestHeader])) {
+			if err := d.fs.deleteSegments(d.cs.Name, h[manifestHeader]); err != nil {
+				return err
+			}
 		}
 	}
 
-	SwiftConnection.ObjectDelete(d.c.Name, path)
-	directoryCache.Delete(d.c.Name, d.path, name)
+	d.fs.Storage.ObjectDelete(d.c.Name, path)
+	d.fs.directoryCache.Delete(d.c.Name, d.path, name)
 
 	return nil
 }
 
 func (d *Directory) removeSymlink(symlink *Symlink, name, path string) error {
-	err := SwiftConnection.ObjectDelete(d.c.Name, path)
+	err := d.fs.Storage.ObjectDelete(d.c.Name, path)
 	if err != nil {
 		return err
 	}
 
-	directoryCache.Delete(d.c.Name, d.path, name)
+	d.fs.directoryCache.Delete(d.c.Name, d.path, name)
 	return nil
 }
 
 // Rename moves a node from its current directory to a new directory and updates the cache.
 func (d *Directory) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Node) error {
-	if t, ok := newDir.(*Directory); ok && (t.c.Name == d.c.Name) {
-		// Get object from cache
-		oldNode := directoryCache.Get(d.c.Name, d.path, req.OldName)
+	t, ok := newDir.(*Directory)
+	if !ok || t.c.Name != d.c.Name {
+		return fuse.ENOTSUP
+	}
 
-		// Rename it
-		if oldObject, ok := oldNode.(*Object); ok {
-			return oldObject.rename(t, req.NewName)
-		}
-		if oldSymlink, ok := oldNode.(*Symlink); ok {
-			return oldSymlink.rename(t, req.NewName)
-		}
+	// Get object from cache
+	oldNode := d.fs.directoryCache.Get(d.c.Name, d.path, req.OldName)
+
+	// Rename it
+	var err error
+	switch n := oldNode.(type) {
+	case *Object:
+		err = n.rename(t, req.NewName)
+	case *Symlink:
+		err = n.rename(t, req.NewName)
+	case *Directory:
+		err = d.move(d.c.Name, d.path, req.OldName, t.c.Name, t.path, req.NewName)
+	default:
+		return fuse.ENOTSUP
 	}
-	return fuse.ENOTSUP
+	if err != nil {
+		return err
+	}
+
+	d.childChanged()
+	if t != d {
+		t.childChanged()
+	}
+	return nil
 }
 
 // Symlink creates a new symbolic link to the specified target in the current directory.
 func (d *Directory) Symlink(ctx context.Context, req *fuse.SymlinkRequest) (fs.Node, error) {
 	var (
 		absPath = d.path + req.NewName
-		headers = map[string]string{objectSymlinkHeader: req.Target}
+		headers = d.fs.newPermissionHeaders(os.ModePerm, req.Uid, req.Gid)
 	)
+	headers[objectSymlinkHeader] = req.Target
 
 	// Create the file in swift
-	w, err := SwiftConnection.ObjectCreate(d.c.Name, absPath, false, "", linkContentType, headers)
+	w, err := d.fs.Storage.ObjectCreate(d.c.Name, absPath, false, "", linkContentType, headers)
 	if err != nil {
 		return nil, err
 	}
@@ -446,6 +809,7 @@ func (d *Directory) Symlink(ctx context.Context, req *fuse.SymlinkRequest) (fs.N
 	w.Close()
 
 	link := &Symlink{
+		fs:   d.fs,
 		c:    d.c,
 		p:    d,
 		name: req.NewName,
@@ -458,19 +822,24 @@ func (d *Directory) Symlink(ctx context.Context, req *fuse.SymlinkRequest) (fs.N
 		},
 	}
 
-	directoryCache.Set(d.c.Name, d.path, req.NewName, link)
+	d.fs.directoryCache.Set(d.c.Name, d.path, req.NewName, link)
+	d.childChanged()
 
 	return link, nil
 }
 
 var (
-	_ Node             = (*Directory)(nil)
-	_ fs.Node          = (*Directory)(nil)
-	_ fs.NodeCreater   = (*Directory)(nil)
-	_ fs.NodeLinker    = (*Directory)(nil)
-	_ fs.NodeRemover   = (*Directory)(nil)
-	_ fs.NodeMkdirer   = (*Directory)(nil)
-	_ fs.NodeRenamer   = (*Directory)(nil)
-	_ fs.NodeSetattrer = (*Directory)(nil)
-	_ fs.NodeSymlinker = (*Directory)(nil)
+	_ Node                 = (*Directory)(nil)
+	_ fs.Node              = (*Directory)(nil)
+	_ fs.NodeCreater       = (*Directory)(nil)
+	_ fs.NodeGetxattrer    = (*Directory)(nil)
+	_ fs.NodeLinker        = (*Directory)(nil)
+	_ fs.NodeListxattrer   = (*Directory)(nil)
+	_ fs.NodeRemover       = (*Directory)(nil)
+	_ fs.NodeRemovexattrer = (*Directory)(nil)
+	_ fs.NodeMkdirer       = (*Directory)(nil)
+	_ fs.NodeRenamer       = (*Directory)(nil)
+	_ fs.NodeSetattrer     = (*Directory)(nil)
+	_ fs.NodeSetxattrer    = (*Directory)(nil)
+	_ fs.NodeSymlinker     = (*Directory)(nil)
 )
@@ -0,0 +1,138 @@
+package svfs
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/xlucas/swift"
+)
+
+type DirectoryAttrTestSuite struct {
+	localFSSuite
+	container *Directory
+}
+
+func (suite *DirectoryAttrTestSuite) SetupTest() {
+	suite.localFSSuite.SetupTest()
+	suite.fs.changeCache = NewSimpleCache()
+	suite.fs.directoryCache = NewCache(time.Minute, -1, -1)
+	suite.fs.directoryLister = NewLister(suite.backend, 2)
+	suite.fs.directoryLister.Start()
+	suite.fs.Attr = true
+	suite.fs.MountTime = time.Unix(1000, 0)
+	suite.container = &Directory{fs: suite.fs, c: &swift.Container{Name: "container"}}
+}
+
+func (suite *DirectoryAttrTestSuite) mkdir(parent *Directory, name string) *Directory {
+	node, err := parent.Mkdir(nil, &fuse.MkdirRequest{Name: name})
+	require.NoError(suite.T(), err)
+	return node.(*Directory)
+}
+
+func (suite *DirectoryAttrTestSuite) mtime(d *Directory) time.Time {
+	var a fuse.Attr
+	require.NoError(suite.T(), d.Attr(nil, &a))
+	return a.Mtime
+}
+
+func (suite *DirectoryAttrTestSuite) storedMtime(name string) string {
+	_, h, err := suite.backend.Object("container", name)
+	require.NoError(suite.T(), err)
+	return h[objectMtimeHeader]
+}
+
+func (suite *DirectoryAttrTestSuite) TestSetattr() {
+	d := suite.mkdir(suite.container, "dir")
+	mtime := time.Unix(1500000000, 0)
+
+	req := &fuse.SetattrRequest{Valid: fuse.SetattrMtime, Mtime: mtime}
+	require.NoError(suite.T(), d.Setattr(nil, req, &fuse.SetattrResponse{}))
+
+	assert.True(suite.T(), mtime.Equal(suite.mtime(d)))
+	assert.Equal(suite.T(), suite.fs.formatTime(mtime), suite.storedMtime("dir/"))
+}
+
+func (suite *DirectoryAttrTestSuite) TestSetattrPermissions() {
+	suite.fs.FilePermissions = true
+	d := suite.mkdir(suite.container, "dir")
+
+	req := &fuse.SetattrRequest{Valid: fuse.SetattrMode | fuse.SetattrUid, Mode: os.ModeDir | 0750, Uid: 33}
+	require.NoError(suite.T(), d.Setattr(nil, req, &fuse.SetattrResponse{}))
+
+	var a fuse.Attr
+	require.NoError(suite.T(), d.Attr(nil, &a))
+	assert.Equal(suite.T(), os.ModeDir|0750, a.Mode)
+	assert.Equal(suite.T(), uint32(33), a.Uid)
+
+	_, h, err := suite.backend.Object("container", "dir/")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "0750", h[objectModeHeader])
+}
+
+func (suite *DirectoryAttrTestSuite) TestSetattrPseudoDirectory() {
+	d := &Directory{fs: suite.fs, c: suite.container.c, path: "dir/", so: &swift.Object{Name: "dir/", PseudoDirectory: true}}
+
+	req := &fuse.SetattrRequest{Valid: fuse.SetattrMtime, Mtime: time.Now()}
+	assert.NoError(suite.T(), d.Setattr(nil, req, &fuse.SetattrResponse{}))
+	assert.Equal(suite.T(), suite.fs.MountTime, suite.mtime(d))
+}
+
+func (suite *DirectoryAttrTestSuite) TestPseudoDirectoryMtime() {
+	require.NoError(suite.T(), suite.backend.ObjectPutBytes("container", "dir/old", nil, ""))
+	require.NoError(suite.T(), suite.backend.ObjectPutBytes("container", "dir/new", nil, ""))
+	newest, _, err := suite.backend.Object("container", "dir/new")
+	require.NoError(suite.T(), err)
+
+	d := &Directory{fs: suite.fs, c: suite.container.c, path: "dir/", so: &swift.Object{Name: "dir/", PseudoDirectory: true}}
+	assert.Equal(suite.T(), suite.fs.MountTime, suite.mtime(d))
+
+	_, err = d.ReadDirAll(nil)
+	require.NoError(suite.T(), err)
+	assert.True(suite.T(), newest.LastModified.Equal(suite.mtime(d)))
+}
+
+func (suite *DirectoryAttrTestSuite) TestUpdateParentMtime() {
+	d := suite.mkdir(suite.container, "dir")
+	suite.mkdir(d, "sub")
+	assert.Empty(suite.T(), suite.storedMtime("dir/"))
+
+	_, err := d.ReadDirAll(nil)
+	require.NoError(suite.T(), err)
+
+	suite.fs.UpdateParentMtime = true
+	before := time.Now().Add(-time.Second)
+	require.NoError(suite.T(), d.Remove(nil, &fuse.RemoveRequest{Name: "sub", Dir: true}))
+	assert.NotEmpty(suite.T(), suite.storedMtime("dir/"))
+	assert.True(suite.T(), suite.mtime(d).After(before))
+}
+
+func (suite *DirectoryAttrTestSuite) TestUpdateParentMtimeKeepsMetadata() {
+	suite.fs.Attr = false
+	suite.fs.UpdateParentMtime = true
+	_, err := suite.backend.ObjectPut("container", "dir/", strings.NewReader(""), false, "", dirContentType, swift.Headers{
+		objectMetaHeader + "Color": "blue",
+	})
+	require.NoError(suite.T(), err)
+
+	// Listed without its metadata
+	so, _, err := suite.backend.Object("container", "dir/")
+	require.NoError(suite.T(), err)
+	d := &Directory{fs: suite.fs, c: suite.container.c, name: "dir", path: "dir/", so: &so, sh: swift.Headers{}}
+
+	suite.mkdir(d, "sub")
+
+	_, h, err := suite.backend.Object("container", "dir/")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "blue", h[objectMetaHeader+"Color"])
+	assert.NotEmpty(suite.T(), h[objectMtimeHeader])
+}
+
+func TestDirectoryAttrSuite(t *testing.T) {
+	suite.Run(t, new(DirectoryAttrTestSuite))
+}
@@ -14,6 +14,7 @@ const (
 	oldFileName   = "file_1"
 	newFileName   = "file_2"
 	symlinkName   = "symlink"
+	movedDirName  = "moved_directory"
 	hardlinkName  = "hardlink"
 )
 
@@ -86,9 +87,16 @@ func TestDirectory(t *testing.T) {
 	t.Run("Directory_ReadDirAll", testDirectoryReadDirAll)
 	t.Run("Directory_LookupMiss", testDirectoryLookupMiss)
 
+	// Directory renaming
+	ctx.rc = 1
+	ctx.it = movedDirName
+	t.Run("Container_RenameDirectory", testContainerRenameDirectory)
+	t.Run("Container_ReadDirAll", testContainerReadDirAll)
+	t.Run("Container_Lookup", testContainerLookup)
+
 	// Directory removal
 	ctx.rc = 0
-	ctx.it = directoryName
+	ctx.it = movedDirName
 	t.Run("Container_Rmdir", testContainerRmdir)
 	t.Run("Container_ReadDirAll", testContainerReadDirAll)
 	t.Run("Container_LookupMiss", testContainerLookupMiss)
@@ -132,6 +140,13 @@ func testContainerRename(t *testing.T) {
 	assert.Equal(t, err, fuse.ENOTSUP)
 }
 
+func testContainerRenameDirectory(t *testing.T) {
+	req := &fuse.RenameRequest{OldName: ctx.d.Name(), NewName: movedDirName}
+	assert.Nil(t, ctx.c.Rename(nil, req, ctx.c))
+	assert.Equal(t, movedDirName, ctx.d.Name())
+	assert.Equal(t, movedDirName+"/", ctx.d.path)
+}
+
 func testContainerRmdir(t *testing.T) {
 	req := &fuse.RemoveRequest{Name: ctx.d.Name(), Dir: true}
 	assert.Nil(t, ctx.c.Remove(nil, req))
@@ -1,6 +1,7 @@
 package svfs
 
 import (
+	"path/filepath"
 	"time"
 
 	"bazil.org/fuse"
@@ -24,10 +25,8 @@ const (
 	SkipOpenRead = 1 << 3
 )
 
-var (
-	// SwiftConnection represents a connection to a swift provider.
-	// It should be ready for authentication before initializing svfs.
-	SwiftConnection = new(swift.Connection)
+// Options are the settings of a mounted filesystem.
+type Options struct {
 	// TargetContainer is an existing container ready to be served.
 	TargetContainer string
 	// StoragePolicy represents a storage policy configured by the
@@ -37,11 +36,24 @@ var (
 	Attr bool
 	// Xattr represents extended attributes fetching mode activation.
 	Xattr bool
+	// UpdateParentMtime represents the update of the directory marker
+	// mtime whenever an entry is added to or removed from a directory.
+	UpdateParentMtime bool
 	// HubicTimes represents the usage of hubiC synchronization clients
 	// meta headers to read and store file times.
 	HubicTimes bool
 	// SegmentSize is the size of a segment in bytes.
 	SegmentSize uint64
+	// SegmentConcurrency represents how many segments of a
+	// file can be uploaded concurrently.
+	SegmentConcurrency uint64
+	// SegmentBufferSize is the size in bytes of the memory
+	// buffer backing each segment upload. It must hold a whole
+	// segment for segments to be uploaded concurrently.
+	SegmentBufferSize uint64
+	// StaticLargeObjects represents the usage of static large objects
+	// instead of dynamic large objects when writing segmented files.
+	StaticLargeObjects bool
 	// AllowRoot represents FUSE allow_root option.
 	AllowRoot bool
 	// AllowOther represents FUSE allow_other option.
@@ -54,6 +66,9 @@ var (
 	DefaultMode uint64
 	// DefaultPermissions are permissions mapped to svfs files.
 	DefaultPermissions bool
+	// FilePermissions represents the storage of per-file mode, uid
+	// and gid in object metadata.
+	FilePermissions bool
 	// BlockSize is the filesystem block size in bytes.
 	BlockSize uint
 	// ReadAheadSize is the filesystem readahead size in bytes.
@@ -64,44 +79,180 @@ var (
 	// of flags. Each flag enables an optimization that can be used by storage
 	// synchronization processes in order to reduce network access.
 	TransferMode int
+	// CacheTimeout represents cache entries timeout.
+	CacheTimeout time.Duration
+	// CacheMaxEntries represents the cache size.
+	CacheMaxEntries int64
+	// CacheMaxAccess represents cache entries max access count.
+	CacheMaxAccess int64
+	// MimeTypesFile is a file in the mime.types format mapping file
+	// extensions to content types set on uploaded objects.
+	MimeTypesFile string
+	// BlockCacheDir is the local directory where blocks of objects
+	// read are cached. The block cache is disabled if empty.
+	BlockCacheDir string
+	// BlockCacheMaxSize is the maximum size in bytes of the block cache.
+	BlockCacheMaxSize uint64
+	// BlockCacheBlockSize is the size in bytes of cached blocks.
+	BlockCacheBlockSize uint64
+	// ListerConcurrency represents how many objects can
+	// be fetched concurrently while listing directory content.
+	ListerConcurrency uint64
+	// PrefetchConcurrency represents how many chunks can be fetched
+	// ahead of a sequential reader. Prefetching is disabled if 0.
+	PrefetchConcurrency uint64
+	// PrefetchChunkSize is the size in bytes of prefetched chunks.
+	// Blocks of the block cache are prefetched instead when enabled.
+	PrefetchChunkSize uint64
+	// JournalDir is the local directory where pending directory
+	// renames and uploads are recorded.
+	JournalDir string
+	// RenameConcurrency represents how many objects can be copied
+	// or deleted concurrently while renaming a directory.
+	RenameConcurrency uint64
+	// SpoolDir is the local directory where objects opened for
+	// random access are staged.
+	SpoolDir string
+	// SpoolMaxSize is the maximum amount of data in bytes that can
+	// be staged in the spool directory at the same time.
+	SpoolMaxSize uint64
+	// RetrySpoolMaxSize is the maximum amount of data in bytes kept
+	// within the spool directory to upload streams again on failure.
+	// Uploads wait while it is full. It can't be lower than SegmentSize.
+	RetrySpoolMaxSize uint64
+	// WriteBack represents the write-back mode activation. Files are
+	// uploaded in the background once closed instead of being streamed
+	// to swift while written.
+	WriteBack bool
+	// WriteBackWorkers represents how many files can be uploaded
+	// concurrently in write-back mode.
+	WriteBackWorkers uint64
+	// WriteBackRetries represents how many times the upload of a file
+	// is retried in write-back mode before giving up.
+	WriteBackRetries uint64
+}
+
+// SVFS implements the Swift Virtual File System. Each instance owns its
+// connection, options and caches, so that several filesystems can be
+// mounted within the same process.
+type SVFS struct {
+	Options
+	// Connection represents a connection to a swift provider.
+	// It should be ready for authentication before initializing svfs.
+	Connection *Connection
+	// Storage is the object storage backing the filesystem. It defaults
+	// to the swift connection.
+	Storage Backend
 	// MountTime represents at what time the filesystem was mounted.
 	MountTime time.Time
-)
 
-// SVFS implements the Swift Virtual File System.
-type SVFS struct{}
+	changeCache     *SimpleCache // Cache for mutating objects
+	directoryCache  *Cache       // Cache for directories content
+	directoryLister *Lister
+	objectSpool     *Spool
+	blockCache      *BlockCache
+	writeBackQueue  *WriteBackQueue
+	openHandles     handleSet
+	mimeTypes       map[string]string
+}
+
+// New creates a filesystem backed by a new swift connection.
+func New() *SVFS {
+	s := &SVFS{Connection: newConnection()}
+	s.Storage = s.Connection
+	return s
+}
 
 // Init sets up the filesystem. It sets configuration settings, starts mandatory
 // services and make sure authentication in Swift has succeeded.
 func (s *SVFS) Init() (err error) {
-	// Copy storage URL option
-	overloadStorageURL := SwiftConnection.StorageUrl
+	if s.Storage == nil {
+		s.Storage = s.Connection
+	}
 
-	// Use file times set by hubic synchronization clients
-	if HubicTimes {
-		objectMtimeHeader = hubicMtimeHeader
+	// Caches
+	s.changeCache = NewSimpleCache()
+	s.directoryCache = NewCache(s.CacheTimeout, s.CacheMaxEntries, s.CacheMaxAccess)
+
+	// Start directory lister
+	s.directoryLister = NewLister(s.Storage, s.ListerConcurrency)
+	s.directoryLister.Start()
+
+	// Prepare spool for read-write file handles
+	s.objectSpool = NewSpool(s.SpoolDir, s.SpoolMaxSize)
+	if err = s.objectSpool.Init(); err != nil {
+		return err
 	}
 
-	// Hubic special authentication
-	if HubicAuthorization != "" && HubicRefreshToken != "" {
-		SwiftConnection.Auth = new(HubicAuth)
+	// Remove files staged by crashed mounts unless waiting to be uploaded
+	pending, err := s.writeBackFiles()
+	if err != nil {
+		return err
+	}
+	if err = s.objectSpool.Clean(pending); err != nil {
+		return err
 	}
 
-	// Start directory lister
-	directoryLister.Start()
+	// Streamed uploads are copied to their own spool so that retries
+	// never take space from staged files, and the other way around.
+	// Streams never exceed a segment.
+	if s.Connection != nil && s.Connection.MaxRetries > 0 {
+		s.Connection.spool = NewSpool(filepath.Join(s.SpoolDir, "retry"), s.RetrySpoolMaxSize)
+		s.Connection.streamMaxSize = s.SegmentSize
+		if err = s.Connection.spool.Init(); err != nil {
+			return err
+		}
+	}
+
+	// Content types of uploaded objects
+	if s.MimeTypesFile != "" {
+		if s.mimeTypes, err = readMimeTypes(s.MimeTypesFile); err != nil {
+			return err
+		}
+	}
 
-	// Authenticate if we don't have a token and storage URL
-	if !SwiftConnection.Authenticated() {
-		err = SwiftConnection.Authenticate()
+	// Load blocks cached by previous mounts
+	s.blockCache = NewBlockCache(s.BlockCacheDir, s.BlockCacheMaxSize, s.BlockCacheBlockSize)
+	if s.BlockCacheDir != "" {
+		if err = s.blockCache.Init(); err != nil {
+			return err
+		}
 	}
 
-	// Swift ACL special authentication
-	if overloadStorageURL != "" {
-		SwiftConnection.StorageUrl = overloadStorageURL
-		SwiftConnection.Auth = newSwiftACLAuth(SwiftConnection.Auth, overloadStorageURL)
+	// Only swift requires authentication
+	if s.usingSwift() {
+		if err = s.Connection.Authenticate(); err != nil {
+			return err
+		}
 	}
 
-	return err
+	// Finish directory renames interrupted by a crash
+	if err = s.ReplayRenameJournals(); err != nil {
+		return err
+	}
+
+	// Upload files left pending by a previous mount
+	s.writeBackQueue = NewWriteBackQueue(s)
+	s.writeBackQueue.Start()
+	return s.ReplayWriteBackJournals()
+}
+
+// Stop stops background services started by Init, once the
+// filesystem is unmounted. Pending uploads are kept in the
+// journal directory for the next mount.
+func (s *SVFS) Stop() {
+	if s.writeBackQueue != nil {
+		s.writeBackQueue.Stop()
+	}
+	if s.directoryLister != nil {
+		s.directoryLister.Stop()
+	}
+	if s.Connection != nil {
+		s.Connection.StopTokenRefresh()
+	}
+	if s.objectSpool != nil {
+		s.objectSpool.Close()
+	}
 }
 
 // Root gets the root node of the filesystem. It can either be a fake root node
@@ -109,12 +260,13 @@ func (s *SVFS) Init() (err error) {
 // node if a container name have been specified in mount options.
 func (s *SVFS) Root() (fs.Node, error) {
 	// Mount a specific container
-	if TargetContainer != "" {
-		return s.rootContainer(TargetContainer)
+	if s.TargetContainer != "" {
+		return s.rootContainer(s.TargetContainer)
 	}
 	// Mount all containers within an account
 	return &Root{
 		Directory: &Directory{
+			fs:   s,
 			apex: true,
 		},
 	}, nil
@@ -124,26 +276,26 @@ func (s *SVFS) Root() (fs.Node, error) {
 // to the host. If the target account is using quota it will be reported as the device size.
 // If no quota was found the device size will be equal to the underlying type maximum value.
 func (s *SVFS) Statfs(ctx context.Context, req *fuse.StatfsRequest, resp *fuse.StatfsResponse) error {
-	account, _, err := SwiftConnection.Account()
+	account, _, err := s.Storage.Account()
 	if err != nil {
 		return err
 	}
 
-	resp.Bsize = uint32(BlockSize)
+	resp.Bsize = uint32(s.BlockSize)
 
 	// Not mounting a specific container, then get account
 	// information.
-	if TargetContainer == "" {
+	if s.TargetContainer == "" {
 		resp.Files = uint64(account.Objects)
 		resp.Blocks = uint64(account.BytesUsed) / uint64(resp.Bsize)
 	}
 	// Mounting a specific container, then get container usage.
-	if TargetContainer != "" {
-		c, _, err := SwiftConnection.Container(TargetContainer)
+	if s.TargetContainer != "" {
+		c, _, err := s.Storage.Container(s.TargetContainer)
 		if err != nil {
 			return err
 		}
-		cs, _, err := SwiftConnection.Container(TargetContainer + segmentContainerSuffix)
+		cs, _, err := s.Storage.Container(s.TargetContainer + segmentContainerSuffix)
 		if err != nil {
 			return err
 		}
@@ -154,7 +306,7 @@ func (s *SVFS) Statfs(ctx context.Context, req *fuse.StatfsRequest, resp *fuse.S
 	if account.Quota > 0 {
 		resp.Bavail = uint64(account.Quota-account.BytesUsed) / uint64(resp.Bsize)
 		resp.Bfree = resp.Bavail
-		if TargetContainer == "" {
+		if s.TargetContainer == "" {
 			resp.Blocks = uint64(account.Quota) / uint64(resp.Bsize)
 		} else {
 			resp.Blocks = uint64(account.Quota-account.BytesUsed)/uint64(resp.Bsize) + resp.Blocks
@@ -171,19 +323,19 @@ func (s *SVFS) Statfs(ctx context.Context, req *fuse.StatfsRequest, resp *fuse.S
 }
 
 func (s *SVFS) rootContainer(container string) (fs.Node, error) {
-	baseContainer, _, err := SwiftConnection.Container(container)
+	baseContainer, _, err := s.Storage.Container(container)
 	if err != nil {
 		return nil, err
 	}
 
 	// Find segment container too
 	segmentContainerName := container + segmentContainerSuffix
-	segmentContainer, _, err := SwiftConnection.Container(segmentContainerName)
+	segmentContainer, _, err := s.Storage.Container(segmentContainerName)
 
 	// Create it if missing
 	if err == swift.ContainerNotFound {
 		var container *swift.Container
-		container, err = createContainer(segmentContainerName)
+		container, err = s.createContainer(segmentContainerName)
 		segmentContainer = *container
 	}
 	if err != nil && err != swift.ContainerNotFound {
@@ -191,6 +343,7 @@ func (s *SVFS) rootContainer(container string) (fs.Node, error) {
 	}
 
 	return &Directory{
+		fs:   s,
 		apex: true,
 		c:    &baseContainer,
 		cs:   &segmentContainer,
@@ -13,7 +13,7 @@ import (
 )
 
 var ctx = &Ctx{
-	fs: &SVFS{},
+	fs: New(),
 }
 
 type Ctx struct {
@@ -38,41 +38,52 @@ func TestFs(t *testing.T) {
 }
 
 func testFsInit(t *testing.T) {
-	if !SwiftConnection.Authenticated() {
+	if !ctx.set {
 
 		// Default options
-		Attr = true
-		Xattr = true
-		TransferMode = 0
-		CacheMaxEntries = -1
-		CacheMaxAccess = -1
-		CacheTimeout = 15 * time.Minute
-		SwiftConnection.Timeout = 5 * time.Minute
-		SwiftConnection.ConnectTimeout = 15 * time.Second
-		SegmentSize = 256 * (1 << 20)
-		ReadAheadSize = 128 * (1 << 10)
-		BlockSize = 4096
-		ListerConcurrency = 20
+		ctx.fs.Attr = true
+		ctx.fs.Xattr = true
+		ctx.fs.TransferMode = 0
+		ctx.fs.CacheMaxEntries = -1
+		ctx.fs.CacheMaxAccess = -1
+		ctx.fs.CacheTimeout = 15 * time.Minute
+		ctx.fs.SegmentSize = 256 * (1 << 20)
+		ctx.fs.ReadAheadSize = 128 * (1 << 10)
+		ctx.fs.BlockSize = 4096
+		ctx.fs.ListerConcurrency = 20
+		ctx.fs.SpoolDir = os.TempDir()
+		ctx.fs.JournalDir = os.TempDir()
+		ctx.fs.RenameConcurrency = 20
+		ctx.fs.SpoolMaxSize = 0
 
 		switch os.ExpandEnv("$SVFS_TEST_AUTH") {
 		case "HUBIC":
-			SwiftConnection = &swift.Connection{}
-			HubicAuthorization = os.ExpandEnv("$SVFS_TEST_HUBIC_AUTH")
-			HubicRefreshToken = os.ExpandEnv("$SVFS_TEST_HUBIC_TOKEN")
+			ctx.fs.Connection = newConnection()
+			ctx.fs.Connection.HubicAuthorization = os.ExpandEnv("$SVFS_TEST_HUBIC_AUTH")
+			ctx.fs.Connection.HubicRefreshToken = os.ExpandEnv("$SVFS_TEST_HUBIC_TOKEN")
+			ctx.fs.Storage = ctx.fs.Connection
 		case "OPENRC":
-			SwiftConnection = &swift.Connection{
+			ctx.fs.Connection = &Connection{Connection: &swift.Connection{
 				AuthUrl:  os.ExpandEnv("$SVFS_TEST_AUTH_URL"),
 				UserName: os.ExpandEnv("$SVFS_TEST_USERNAME"),
 				ApiKey:   os.ExpandEnv("$SVFS_TEST_PASSWORD"),
 				Tenant:   os.ExpandEnv("$SVFS_TEST_TENANT_NAME"),
 				Region:   os.ExpandEnv("$SVFS_TEST_REGION_NAME"),
-			}
+			}}
+			ctx.fs.Storage = ctx.fs.Connection
 		case "TOKEN":
-			SwiftConnection = &swift.Connection{
+			ctx.fs.Connection = &Connection{Connection: &swift.Connection{
 				AuthToken:  os.ExpandEnv("$SVFS_TEST_AUTH_TOKEN"),
 				StorageUrl: os.ExpandEnv("$SVFS_TEST_STORAGE_URL"),
-			}
+			}}
+			ctx.fs.Storage = ctx.fs.Connection
+		case "LOCAL":
+			local, err := NewLocalBackend(os.ExpandEnv("$SVFS_TEST_LOCAL_DIR"))
+			require.Nil(t, err)
+			ctx.fs.Storage = local
 		}
+		ctx.fs.Connection.Timeout = 5 * time.Minute
+		ctx.fs.Connection.ConnectTimeout = 15 * time.Second
 
 		ctx.set = true
 		assert.Nil(t, ctx.fs.Init())
@@ -0,0 +1,315 @@
+package svfs
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xlucas/swift"
+)
+
+// Checker verifies the consistency of a container along with
+// its segment container.
+type Checker struct {
+	// Storage is the object storage holding the container.
+	Storage Backend
+	// Container is the container to check.
+	Container string
+	// Concurrency represents how many objects can be inspected
+	// concurrently.
+	Concurrency uint64
+	// StaticLargeObjects inspects every object, since listings
+	// don't tell static large object manifests apart. Otherwise only
+	// objects listed as empty are checked as manifests.
+	StaticLargeObjects bool
+	// ReplaceBrokenManifests allows repairs replacing manifests
+	// referencing no segments with empty objects.
+	ReplaceBrokenManifests bool
+	listings               map[string][]swift.Object
+}
+
+// Problem is an inconsistency found by a checker.
+type Problem struct {
+	Path        string
+	Description string
+	repair      func() error
+}
+
+// Repairable tells whether this problem can be repaired.
+func (p *Problem) Repairable() bool {
+	return p.repair != nil
+}
+
+// Repair fixes this problem.
+func (p *Problem) Repair() error {
+	if p.repair == nil {
+		return fmt.Errorf("No repair action for %s", p.Path)
+	}
+	return p.repair()
+}
+
+// Check walks the container and reports problems found with
+// large object manifests, directory markers and symlinks.
+func (c *Checker) Check() (problems []Problem, err error) {
+	c.listings = make(map[string][]swift.Object)
+
+	objects, err := c.list(c.Container)
+	if err != nil {
+		return nil, err
+	}
+
+	problems = append(problems, c.checkMarkers(objects)...)
+
+	// Objects requiring a HEAD request
+	var candidates []swift.Object
+	for _, object := range objects {
+		if c.inspect(&object) {
+			candidates = append(candidates, object)
+		}
+	}
+
+	headers := make([]swift.Headers, len(candidates))
+	err = forEachConcurrently(c.Concurrency, len(candidates), func(i int) error {
+		_, h, err := c.Storage.Object(c.Container, candidates[i].Name)
+		if err == swift.ObjectNotFound {
+			return nil
+		}
+		headers[i] = h
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, object := range candidates {
+		h := headers[i]
+		switch {
+		case h == nil:
+			continue
+		case object.ContentType == linkContentType:
+			if h[objectSymlinkHeader] == "" {
+				problems = append(problems, Problem{
+					Path:        object.Name,
+					Description: "symlink has no target",
+				})
+			}
+		case h[manifestHeader] != "":
+			found, err := c.checkDynamicManifest(object, h)
+			if err != nil {
+				return nil, err
+			}
+			problems = append(problems, found...)
+		case isStaticLargeObject(h):
+			found, err := c.checkStaticManifest(object)
+			if err != nil {
+				return nil, err
+			}
+			problems = append(problems, found...)
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Path < problems[j].Path
+	})
+
+	return problems, nil
+}
+
+// inspect tells whether the headers of a listed object must be
+// fetched to check it.
+func (c *Checker) inspect(object *swift.Object) bool {
+	switch {
+	case object.ContentType == linkContentType:
+		return true
+	case c.StaticLargeObjects:
+		return object.ContentType != dirContentType && !object.PseudoDirectory
+	}
+	return isLargeObject(object)
+}
+
+// checkMarkers makes sure every directory holding objects has
+// a marker and that markers are not shadowed by objects.
+func (c *Checker) checkMarkers(objects []swift.Object) (problems []Problem) {
+	var (
+		markers = make(map[string]bool)
+		files   = make(map[string]bool)
+		parents = make(map[string]bool)
+	)
+
+	for _, object := range objects {
+		if object.ContentType == dirContentType {
+			markers[strings.TrimSuffix(object.Name, "/")] = true
+			if object.Bytes > 0 {
+				problems = append(problems, Problem{
+					Path:        object.Name,
+					Description: fmt.Sprintf("directory marker has content (%d bytes)", object.Bytes),
+				})
+			}
+		} else {
+			files[object.Name] = true
+		}
+		for dir := path.Dir(strings.TrimSuffix(object.Name, "/")); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			parents[dir] = true
+		}
+	}
+
+	for dir := range parents {
+		if files[dir] {
+			problems = append(problems, Problem{
+				Path:        dir,
+				Description: "object shadows a directory with the same name",
+			})
+			continue
+		}
+		if !markers[dir] {
+			marker := dir + "/"
+			problems = append(problems, Problem{
+				Path:        marker,
+				Description: "directory marker is missing",
+				repair: func() error {
+					return c.Storage.ObjectPutBytes(c.Container, marker, nil, dirContentType)
+				},
+			})
+		}
+	}
+
+	return problems
+}
+
+// checkDynamicManifest makes sure a manifest references contiguous
+// and non-empty segments.
+func (c *Checker) checkDynamicManifest(object swift.Object, h swift.Headers) (problems []Problem, err error) {
+	match := segmentPathRegex.FindStringSubmatch(h[manifestHeader])
+	if match == nil {
+		return []Problem{{
+			Path:        object.Name,
+			Description: fmt.Sprintf("invalid manifest header %q", h[manifestHeader]),
+		}}, nil
+	}
+
+	container := match[1]
+	prefix := strings.Replace(match[2], "%26", "&", -1)
+	prefix = strings.Replace(prefix, "%3F", "?", -1)
+
+	segments, err := c.segments(container, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// Replacing the manifest is destructive, segments may have
+	// been moved by another client
+	if len(segments) == 0 {
+		problem := Problem{
+			Path:        object.Name,
+			Description: fmt.Sprintf("manifest references no segments in %s/%s", container, prefix),
+		}
+		if c.ReplaceBrokenManifests {
+			problem.repair = func() error {
+				return c.Storage.ObjectPutBytes(c.Container, object.Name, nil, h["Content-Type"])
+			}
+		}
+		return []Problem{problem}, nil
+	}
+
+	var expected uint64 = 1
+	for _, segment := range segments {
+		if segment.Bytes == 0 {
+			problems = append(problems, Problem{
+				Path:        object.Name,
+				Description: fmt.Sprintf("segment %s/%s is empty", container, segment.Name),
+			})
+		}
+
+		id, err := strconv.ParseUint(strings.TrimPrefix(segment.Name, prefix+"/"), 10, 0)
+		if err != nil {
+			continue
+		}
+		if id != expected {
+			problems = append(problems, Problem{
+				Path:        object.Name,
+				Description: fmt.Sprintf("segments %08d to %08d are missing in %s/%s", expected, id-1, container, prefix),
+			})
+		}
+		expected = id + 1
+	}
+
+	return problems, nil
+}
+
+// checkStaticManifest makes sure segments referenced by a static
+// large object manifest exist with the expected size.
+func (c *Checker) checkStaticManifest(object swift.Object) (problems []Problem, err error) {
+	segments, err := c.Storage.GetStaticManifest(c.Container, object.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, segment := range segments {
+		match := segmentPathRegex.FindStringSubmatch(strings.TrimPrefix(segment.Path, "/"))
+		if match == nil {
+			continue
+		}
+
+		found, err := c.segments(match[1], match[2])
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case len(found) == 0 || found[0].Name != match[2]:
+			problems = append(problems, Problem{
+				Path:        object.Name,
+				Description: fmt.Sprintf("segment %s is missing", segment.Path),
+			})
+		case uint64(found[0].Bytes) != segment.Size:
+			problems = append(problems, Problem{
+				Path:        object.Name,
+				Description: fmt.Sprintf("segment %s has %d bytes, %d expected", segment.Path, found[0].Bytes, segment.Size),
+			})
+		}
+	}
+
+	return problems, nil
+}
+
+// segments returns objects of a container whose name starts
+// with the given prefix, sorted by name.
+func (c *Checker) segments(container, prefix string) ([]swift.Object, error) {
+	objects, err := c.list(container)
+	if err != nil {
+		return nil, err
+	}
+
+	start := sort.Search(len(objects), func(i int) bool {
+		return objects[i].Name >= prefix
+	})
+	end := start
+	for end < len(objects) && strings.HasPrefix(objects[end].Name, prefix) {
+		end++
+	}
+
+	return objects[start:end], nil
+}
+
+func (c *Checker) list(container string) ([]swift.Object, error) {
+	if objects, ok := c.listings[container]; ok {
+		return objects, nil
+	}
+
+	objects, err := c.Storage.ObjectsAll(container, nil)
+	if err == swift.ContainerNotFound && container != c.Container {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Name < objects[j].Name
+	})
+	c.listings[container] = objects
+
+	return objects, nil
+}
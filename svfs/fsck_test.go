@@ -0,0 +1,116 @@
+package svfs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"github.com/xlucas/swift"
+)
+
+type FsckTestSuite struct {
+	suite.Suite
+	checker *Checker
+}
+
+func (suite *FsckTestSuite) SetupTest() {
+	suite.checker = &Checker{
+		Container: "c",
+		listings: map[string][]swift.Object{
+			"c_segments": {
+				{Name: "file/1480000000/00000001", Bytes: 10},
+				{Name: "file/1480000000/00000002", Bytes: 0},
+				{Name: "file/1480000000/00000004", Bytes: 10},
+				{Name: "other/1480000000/00000001", Bytes: 10},
+			},
+		},
+	}
+}
+
+func (suite *FsckTestSuite) TestCheckMarkers() {
+	problems := suite.checker.checkMarkers([]swift.Object{
+		{Name: "a/", ContentType: dirContentType},
+		{Name: "a/b/c", ContentType: "text/plain"},
+		{Name: "d/", ContentType: dirContentType, Bytes: 3},
+		{Name: "e", ContentType: "text/plain"},
+		{Name: "e/f", ContentType: "text/plain"},
+	})
+
+	descriptions := make(map[string]string)
+	for _, problem := range problems {
+		descriptions[problem.Path] = problem.Description
+	}
+
+	assert.Len(suite.T(), problems, 3)
+	assert.Equal(suite.T(), "directory marker is missing", descriptions["a/b/"])
+	assert.Equal(suite.T(), "directory marker has content (3 bytes)", descriptions["d/"])
+	assert.Equal(suite.T(), "object shadows a directory with the same name", descriptions["e"])
+}
+
+func (suite *FsckTestSuite) TestCheckDynamicManifest() {
+	problems, err := suite.checker.checkDynamicManifest(
+		swift.Object{Name: "file"},
+		swift.Headers{manifestHeader: "c_segments/file/1480000000"},
+	)
+
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), problems, 2)
+	assert.Equal(suite.T(), "segment c_segments/file/1480000000/00000002 is empty", problems[0].Description)
+	assert.Equal(suite.T(), "segments 00000003 to 00000003 are missing in c_segments/file/1480000000", problems[1].Description)
+}
+
+func (suite *FsckTestSuite) TestCheckDynamicManifestNoSegments() {
+	problems, err := suite.checker.checkDynamicManifest(
+		swift.Object{Name: "file"},
+		swift.Headers{manifestHeader: "c_segments/file/1470000000"},
+	)
+
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), problems, 1)
+	assert.False(suite.T(), problems[0].Repairable())
+
+	// Replacing the manifest must be allowed
+	suite.checker.ReplaceBrokenManifests = true
+	problems, err = suite.checker.checkDynamicManifest(
+		swift.Object{Name: "file"},
+		swift.Headers{manifestHeader: "c_segments/file/1470000000"},
+	)
+
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), problems, 1)
+	assert.True(suite.T(), problems[0].Repairable())
+}
+
+func (suite *FsckTestSuite) TestCheckDynamicManifestInvalid() {
+	problems, err := suite.checker.checkDynamicManifest(
+		swift.Object{Name: "file"},
+		swift.Headers{manifestHeader: "invalid"},
+	)
+
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), problems, 1)
+	assert.False(suite.T(), problems[0].Repairable())
+}
+
+func (suite *FsckTestSuite) TestInspect() {
+	var (
+		link   = &swift.Object{Name: "link", ContentType: linkContentType, Bytes: 4}
+		marker = &swift.Object{Name: "dir/", ContentType: dirContentType}
+		empty  = &swift.Object{Name: "dlo", ContentType: "text/plain"}
+		file   = &swift.Object{Name: "slo", ContentType: "text/plain", Bytes: 10}
+	)
+
+	assert.True(suite.T(), suite.checker.inspect(link))
+	assert.True(suite.T(), suite.checker.inspect(empty))
+	assert.False(suite.T(), suite.checker.inspect(marker))
+	assert.False(suite.T(), suite.checker.inspect(file))
+
+	// Static large objects are listed with their size
+	suite.checker.StaticLargeObjects = true
+	assert.True(suite.T(), suite.checker.inspect(file))
+	assert.False(suite.T(), suite.checker.inspect(marker))
+}
+
+func TestFsckSuite(t *testing.T) {
+	suite.Run(t, new(FsckTestSuite))
+}
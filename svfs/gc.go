@@ -0,0 +1,353 @@
+package svfs
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xlucas/swift"
+)
+
+const defaultBulkDeleteSize = 1000
+
+var orphanSegmentRegex = regexp.MustCompile("^(.+/([0-9]+))/[0-9]{8}$")
+
+// GarbageCollector finds segments left behind by interrupted writes.
+// Segments are grouped by their <path>/<unix-ts> prefix, and a prefix
+// is considered orphaned when no manifest references it.
+type GarbageCollector struct {
+	// Storage is the object storage holding segments.
+	Storage Backend
+	// Container restricts the collection to segments of a single
+	// container. References are still looked up in every container.
+	Container string
+	// MinAge excludes prefixes created recently, since they may
+	// belong to files still being written.
+	MinAge time.Duration
+	// Concurrency represents how many objects can be inspected
+	// or deleted concurrently.
+	Concurrency uint64
+}
+
+// OrphanedSegments is a set of segments sharing a prefix no
+// manifest references.
+type OrphanedSegments struct {
+	Container string
+	Prefix    string
+	Segments  []string
+	Bytes     int64
+}
+
+// gcReferences records prefixes referenced by dynamic large object
+// manifests and segments referenced by static large object manifests.
+type gcReferences struct {
+	prefixes map[string][]string
+	segments map[string]map[string]bool
+}
+
+// Find lists orphaned segment prefixes.
+func (gc *GarbageCollector) Find() (orphans []OrphanedSegments, err error) {
+	containers, err := gc.containers()
+	if err != nil {
+		return nil, err
+	}
+
+	// Candidate prefixes found in segment containers
+	candidates := make(map[string]map[string]*OrphanedSegments)
+	for _, container := range containers {
+		if gc.Container != "" && container != gc.Container {
+			continue
+		}
+		segmentContainer := container + segmentContainerSuffix
+		prefixes, err := gc.segmentPrefixes(segmentContainer)
+		if err != nil {
+			return nil, err
+		}
+		if len(prefixes) > 0 {
+			candidates[segmentContainer] = prefixes
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	// Manifests may reference segments from any container
+	refs := &gcReferences{
+		prefixes: make(map[string][]string),
+		segments: make(map[string]map[string]bool),
+	}
+	for _, container := range containers {
+		if err := gc.collectReferences(container, refs); err != nil {
+			return nil, err
+		}
+	}
+
+	for container, prefixes := range candidates {
+		for _, candidate := range prefixes {
+			if !refs.match(container, candidate) {
+				orphans = append(orphans, *candidate)
+			}
+		}
+	}
+
+	sort.Slice(orphans, func(i, j int) bool {
+		if orphans[i].Container != orphans[j].Container {
+			return orphans[i].Container < orphans[j].Container
+		}
+		return orphans[i].Prefix < orphans[j].Prefix
+	})
+
+	return orphans, nil
+}
+
+// Delete removes orphaned segments. Bulk deletion is used when
+// the cluster supports it.
+func (gc *GarbageCollector) Delete(orphans []OrphanedSegments) error {
+	conn, _ := gc.Storage.(*Connection)
+	bulkSize := bulkDeleteSize(conn)
+
+	for _, orphan := range orphans {
+		if bulkSize > 0 {
+			err := bulkDelete(conn, orphan.Container, orphan.Segments, bulkSize)
+			if err != swift.Forbidden {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			// Bulk deletion denied, don't try again
+			bulkSize = 0
+		}
+
+		err := forEachConcurrently(gc.Concurrency, len(orphan.Segments), func(i int) error {
+			err := gc.Storage.ObjectDelete(orphan.Container, orphan.Segments[i])
+			if err == swift.ObjectNotFound {
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// containers lists every container of the account but segment
+// containers. Containers other than the one collected are still
+// needed to find manifests referencing its segments.
+func (gc *GarbageCollector) containers() (containers []string, err error) {
+	names, err := gc.Storage.ContainerNamesAll(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		if !segmentRegex.MatchString(name) {
+			containers = append(containers, name)
+		}
+	}
+
+	return containers, nil
+}
+
+func (gc *GarbageCollector) segmentPrefixes(container string) (map[string]*OrphanedSegments, error) {
+	segments, err := gc.Storage.ObjectsAll(container, nil)
+	if err == swift.ContainerNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		prefixes = make(map[string]*OrphanedSegments)
+		recent   = make(map[string]bool)
+		limit    = time.Now().Add(-gc.MinAge)
+	)
+
+	for _, segment := range segments {
+		match := orphanSegmentRegex.FindStringSubmatch(segment.Name)
+		if match == nil {
+			continue
+		}
+
+		prefix := match[1]
+		if !recent[prefix] {
+			ts, _ := strconv.ParseInt(match[2], 10, 64)
+			if time.Unix(ts, 0).After(limit) || segment.LastModified.After(limit) {
+				recent[prefix] = true
+			}
+		}
+
+		if prefixes[prefix] == nil {
+			prefixes[prefix] = &OrphanedSegments{
+				Container: container,
+				Prefix:    prefix,
+			}
+		}
+		prefixes[prefix].Segments = append(prefixes[prefix].Segments, segment.Name)
+		prefixes[prefix].Bytes += segment.Bytes
+	}
+
+	for prefix := range recent {
+		delete(prefixes, prefix)
+	}
+
+	return prefixes, nil
+}
+
+// collectReferences inspects every object of a container looking
+// for large object manifests.
+func (gc *GarbageCollector) collectReferences(container string, refs *gcReferences) error {
+	objects, err := gc.Storage.ObjectsAll(container, nil)
+	if err != nil {
+		return err
+	}
+
+	// Directory markers and symlinks are never manifests
+	var names []string
+	for _, object := range objects {
+		if object.ContentType == dirContentType || object.ContentType == linkContentType || object.PseudoDirectory {
+			continue
+		}
+		names = append(names, object.Name)
+	}
+
+	manifests := make([]swift.Headers, len(names))
+
+	err = forEachConcurrently(gc.Concurrency, len(names), func(i int) error {
+		_, h, err := gc.Storage.Object(container, names[i])
+		if err == swift.ObjectNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if h[manifestHeader] != "" || isStaticLargeObject(h) {
+			manifests[i] = h
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, h := range manifests {
+		if h == nil {
+			continue
+		}
+		if h[manifestHeader] != "" {
+			refs.addPrefix(h[manifestHeader])
+			continue
+		}
+		segments, err := gc.Storage.GetStaticManifest(container, names[i])
+		if err != nil {
+			return err
+		}
+		for _, segment := range segments {
+			refs.addSegment(segment.Path)
+		}
+	}
+
+	return nil
+}
+
+// addPrefix records the prefix found in a dynamic large object
+// manifest header.
+func (r *gcReferences) addPrefix(header string) {
+	match := segmentPathRegex.FindStringSubmatch(header)
+	if match == nil {
+		return
+	}
+
+	// Swift URL-decodes the whole manifest header
+	prefix, err := url.PathUnescape(match[2])
+	if err != nil {
+		prefix = match[2]
+	}
+
+	// Segments of svfs manifests are found under <path>/<unix-ts>/
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	r.prefixes[match[1]] = append(r.prefixes[match[1]], prefix)
+}
+
+// addSegment records a segment path found in a static large
+// object manifest.
+func (r *gcReferences) addSegment(path string) {
+	match := segmentPathRegex.FindStringSubmatch(strings.TrimPrefix(path, "/"))
+	if match == nil {
+		return
+	}
+	if r.segments[match[1]] == nil {
+		r.segments[match[1]] = make(map[string]bool)
+	}
+	r.segments[match[1]][match[2]] = true
+}
+
+// match tells whether any segment sharing the given prefix is
+// referenced by a manifest.
+func (r *gcReferences) match(container string, candidate *OrphanedSegments) bool {
+	for _, segment := range candidate.Segments {
+		if r.segments[container][segment] {
+			return true
+		}
+		for _, prefix := range r.prefixes[container] {
+			if strings.HasPrefix(segment, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bulkDeleteSize returns the maximum number of objects deleted
+// per bulk request, or 0 if the cluster doesn't support it.
+func bulkDeleteSize(c *Connection) int {
+	if c == nil {
+		return 0
+	}
+
+	info, err := c.QueryInfo()
+	if err != nil {
+		return 0
+	}
+
+	bulk, ok := info["bulk_delete"].(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	if max, ok := bulk["max_deletes_per_request"].(float64); ok && max > 0 {
+		return int(max)
+	}
+
+	return defaultBulkDeleteSize
+}
+
+func bulkDelete(c *Connection, container string, objects []string, size int) error {
+	for start := 0; start < len(objects); start += size {
+		end := start + size
+		if end > len(objects) {
+			end = len(objects)
+		}
+
+		result, err := c.BulkDelete(container, objects[start:end])
+		if err != nil {
+			return err
+		}
+		if len(result.Errors) > 0 {
+			return fmt.Errorf("Failed to delete %d segments from %s", len(result.Errors), container)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package svfs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/xlucas/swift"
+)
+
+type GCTestSuite struct {
+	localFSSuite
+	refs *gcReferences
+}
+
+func (suite *GCTestSuite) SetupTest() {
+	suite.localFSSuite.SetupTest()
+	suite.refs = &gcReferences{
+		prefixes: make(map[string][]string),
+		segments: make(map[string]map[string]bool),
+	}
+}
+
+func (suite *GCTestSuite) TestOrphanSegmentRegex() {
+	match := orphanSegmentRegex.FindStringSubmatch("dir/file/1480000000/00000001")
+	assert.Equal(suite.T(), []string{"dir/file/1480000000/00000001", "dir/file/1480000000", "1480000000"}, match)
+	assert.Nil(suite.T(), orphanSegmentRegex.FindStringSubmatch("dir/file/00000001"))
+	assert.Nil(suite.T(), orphanSegmentRegex.FindStringSubmatch("dir/file/1480000000/1"))
+}
+
+func (suite *GCTestSuite) TestMatchDynamicManifest() {
+	suite.refs.addPrefix("c_segments/dir/a%26b/1480000000")
+
+	assert.True(suite.T(), suite.refs.match("c_segments", &OrphanedSegments{
+		Segments: []string{"dir/a&b/1480000000/00000001"},
+	}))
+	assert.False(suite.T(), suite.refs.match("c_segments", &OrphanedSegments{
+		Segments: []string{"dir/a&b/1470000000/00000001"},
+	}))
+	assert.False(suite.T(), suite.refs.match("d_segments", &OrphanedSegments{
+		Segments: []string{"dir/a&b/1480000000/00000001"},
+	}))
+}
+
+func (suite *GCTestSuite) TestMatchEncodedManifest() {
+	suite.refs.addPrefix("c_segments/my%20file/148")
+	suite.refs.addPrefix("c_segments/caf%C3%A9/1480000000/")
+
+	assert.True(suite.T(), suite.refs.match("c_segments", &OrphanedSegments{
+		Segments: []string{"café/1480000000/00000001"},
+	}))
+	assert.False(suite.T(), suite.refs.match("c_segments", &OrphanedSegments{
+		Segments: []string{"my file/1480000000/00000001"},
+	}))
+}
+
+func (suite *GCTestSuite) TestMatchStaticManifest() {
+	suite.refs.addSegment("/c_segments/file/1480000000/00000002")
+
+	assert.True(suite.T(), suite.refs.match("c_segments", &OrphanedSegments{
+		Segments: []string{"file/1480000000/00000001", "file/1480000000/00000002"},
+	}))
+	assert.False(suite.T(), suite.refs.match("c_segments", &OrphanedSegments{
+		Segments: []string{"file/1480000000/00000003"},
+	}))
+}
+
+func (suite *GCTestSuite) TestFindContainer() {
+	require.NoError(suite.T(), suite.backend.ContainerCreate("container_segments", nil))
+	require.NoError(suite.T(), suite.backend.ContainerCreate("other", nil))
+	require.NoError(suite.T(), suite.backend.ObjectPutBytes("container_segments", "a/1400000000/00000001", []byte("a"), ""))
+	require.NoError(suite.T(), suite.backend.ObjectPutBytes("container_segments", "b/1400000000/00000001", []byte("b"), ""))
+
+	// Segments used by a manifest of another container are referenced
+	_, err := suite.backend.ObjectPut("other", "a", bytes.NewReader(nil), false, "", "", swift.Headers{
+		manifestHeader: "container_segments/a/1400000000",
+	})
+	require.NoError(suite.T(), err)
+
+	gc := &GarbageCollector{Storage: suite.backend, Container: "container", Concurrency: 2}
+	orphans, err := gc.Find()
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), orphans, 1)
+	assert.Equal(suite.T(), "container_segments", orphans[0].Container)
+	assert.Equal(suite.T(), "b/1400000000", orphans[0].Prefix)
+
+	// Segments of other containers aren't collected
+	gc.Container = "other"
+	orphans, err = gc.Find()
+	require.NoError(suite.T(), err)
+	assert.Empty(suite.T(), orphans)
+}
+
+func (suite *GCTestSuite) TestFindEncodedManifest() {
+	require.NoError(suite.T(), suite.backend.ContainerCreate("container", nil))
+	require.NoError(suite.T(), suite.backend.ContainerCreate("container_segments", nil))
+	require.NoError(suite.T(), suite.backend.ObjectPutBytes("container_segments", "my file/1480000000/00000001", []byte("data"), ""))
+
+	// Manifest headers written by swift clients are percent-encoded
+	_, err := suite.backend.ObjectPut("container", "my file", bytes.NewReader(nil), false, "", "", swift.Headers{
+		manifestHeader: "container_segments/my%20file/1480000000/",
+	})
+	require.NoError(suite.T(), err)
+	rd, _, err := suite.backend.ObjectOpen("container", "my file", false, nil)
+	require.NoError(suite.T(), err)
+	content, err := ioutil.ReadAll(rd)
+	rd.Close()
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), "data", string(content))
+
+	gc := &GarbageCollector{Storage: suite.backend, Container: "container", Concurrency: 2}
+	orphans, err := gc.Find()
+	require.NoError(suite.T(), err)
+	assert.Empty(suite.T(), orphans)
+}
+
+func TestGCSuite(t *testing.T) {
+	suite.Run(t, new(GCTestSuite))
+}
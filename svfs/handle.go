@@ -1,12 +1,19 @@
 package svfs
 
 import (
+	"crypto/md5"
+	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
+	"github.com/xlucas/swift"
 	"golang.org/x/net/context"
 )
 
@@ -16,46 +23,127 @@ type ObjectHandle struct {
 	target        *Object
 	rd            io.ReadSeeker
 	wd            io.WriteCloser
+	sf            *SpoolFile
+	append        bool
 	create        bool
+	locked        bool
 	truncated     bool
 	wroteSegment  bool
+	slo           bool
 	segmentID     uint
 	uploaded      uint64
 	segmentPrefix string
 	segmentPath   string
+	segmentHash   hash.Hash
+	segments      []StaticSegment
+	uploads       *SegmentUploader
+	opened        time.Time
+	readMutex     sync.Mutex
+	sent          uint64 // Bytes sent to the storage, updated atomically
+}
+
+// handleSet tracks the object handles open on a filesystem.
+type handleSet struct {
+	mutex   sync.Mutex
+	handles map[*ObjectHandle]struct{}
 }
 
 // Read gets a swift object data for a request within the current context.
 // The request size is always honored. We open the file on the first write.
 func (fh *ObjectHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) (err error) {
+	if fh.sf != nil {
+		resp.Data = make([]byte, req.Size)
+		n, err := fh.sf.ReadAt(resp.Data, req.Offset)
+		resp.Data = resp.Data[:n]
+		bytesRead.Add(float64(n))
+		return err
+	}
+
+	// Readers keep their own offset
+	fh.readMutex.Lock()
+	defer fh.readMutex.Unlock()
+
 	if fh.rd == nil {
 		fh.rd, err = newReader(fh)
 		if err != nil {
 			return err
 		}
 	}
-	fh.rd.Seek(req.Offset, 0)
+	if _, err := fh.rd.Seek(req.Offset, io.SeekStart); err != nil {
+		return err
+	}
 	resp.Data = make([]byte, req.Size)
-	io.ReadFull(fh.rd, resp.Data)
+	n, err := io.ReadFull(fh.rd, resp.Data)
+	resp.Data = resp.Data[:n]
+	bytesRead.Add(float64(n))
+
+	// Reads may end past the end of the file
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil
+	}
+	return err
+}
+
+// Flush uploads the content of a staged object if it changed since
+// it was opened or last flushed, unless write-back mode is enabled.
+// Otherwise it waits for segments being uploaded in the background.
+func (fh *ObjectHandle) Flush(ctx context.Context, req *fuse.FlushRequest) error {
+	if fh.sf != nil {
+		fh.target.staging.Lock()
+		defer fh.target.staging.Unlock()
+	}
+	if fh.sf != nil && fh.sf.dirty {
+		if fh.target.fs.WriteBack {
+			return nil
+		}
+		return fh.upload()
+	}
+	if fh.uploads != nil {
+		return fh.uploads.Flush()
+	}
 	return nil
 }
 
 // Release frees the file handle, closing all readers/writers in use.
-func (fh *ObjectHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+// In write-back mode, a modified staged object is queued for upload.
+func (fh *ObjectHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) (err error) {
+	if fh.sf != nil {
+		fh.target.staging.Lock()
+		fh.target.sf = nil
+		if fh.sf.dirty && fh.target.fs.WriteBack {
+			err = fh.target.fs.writeBackQueue.Enqueue(fh.target, fh.sf)
+		}
+		if !fh.sf.dirty || !fh.target.fs.WriteBack || err != nil {
+			if fh.sf.dirty {
+				err = fh.upload()
+			}
+			fh.sf.Remove()
+		}
+		fh.target.staging.Unlock()
+	}
 	if fh.rd != nil {
 		if closer, ok := fh.rd.(io.Closer); ok {
 			closer.Close()
 		}
 	}
 	if fh.wd != nil {
-		fh.wd.Close()
+		if closeErr := fh.closeWriter(); err == nil {
+			err = closeErr
+		}
 		fh.target.writing = false
+
+		// Listings may have cached another node for this object
+		// while it was written, holding outdated headers.
+		if fh.target.p != nil {
+			fh.target.fs.directoryCache.Set(fh.target.c.Name, fh.target.p.path, fh.target.name, fh.target)
+		}
 	}
-	if changeCache.Exist(fh.target.c.Name, fh.target.path) {
+	if fh.locked {
 		defer fh.target.m.Unlock()
-		changeCache.Remove(fh.target.c.Name, fh.target.path)
+		fh.target.fs.writeBackQueue.Release(fh.target)
 	}
-	return nil
+	fh.target.fs.openHandles.remove(fh)
+	return err
 }
 
 // Write pushes data to a swift object.
@@ -66,10 +154,29 @@ func (fh *ObjectHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) e
 // file handle release is called. If we are overwriting an object
 // we handle segment deletion, and object creation.
 func (fh *ObjectHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) (err error) {
-	// Truncate the file if :
+	// Staged objects are written locally and uploaded on flush.
+	if fh.sf != nil {
+		fh.target.staging.Lock()
+		defer fh.target.staging.Unlock()
+		resp.Size, err = fh.sf.WriteAt(req.Data, req.Offset)
+		bytesWritten.Add(float64(resp.Size))
+		if size := int64(fh.sf.Size()); size > fh.target.so.Bytes {
+			fh.target.so.Bytes = size
+		}
+		return err
+	}
+
+	// Keep existing content when appending, otherwise
+	// truncate the file if :
 	// - this is the first write after creation
 	// - this is the first write after opening an existing file
-	if !fh.create && !fh.truncated ||
+	if fh.append {
+		if fh.wd == nil {
+			if err := fh.prepareAppend(); err != nil {
+				return err
+			}
+		}
+	} else if !fh.create && !fh.truncated ||
 		fh.create && !fh.target.writing {
 		if err := fh.truncate(); err != nil {
 			return err
@@ -82,86 +189,400 @@ func (fh *ObjectHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp
 	// - this filehandle has been freed
 	fh.target.writing = true
 
+	if err := fh.send(req.Data); err != nil {
+		return err
+	}
+	atomic.AddUint64(&fh.sent, uint64(len(req.Data)))
+
+	resp.Size = len(req.Data)
+	bytesWritten.Add(float64(resp.Size))
+	return nil
+}
+
+func (fh *ObjectHandle) send(data []byte) (err error) {
 	// Write first segment or file with size smaller than a segment size.
-	if fh.uploaded+uint64(len(req.Data)) <= uint64(SegmentSize) {
-		if _, err := fh.wd.Write(req.Data); err != nil {
+	if fh.uploaded+uint64(len(data)) <= fh.target.fs.SegmentSize {
+		if _, err := fh.wd.Write(data); err != nil {
 			return err
 		}
-		fh.uploaded += uint64(len(req.Data))
-		fh.target.so.Bytes += int64(len(req.Data))
-		goto EndWrite
+		if fh.slo {
+			fh.segmentHash.Write(data)
+		}
+		fh.uploaded += uint64(len(data))
+		fh.target.so.Bytes += int64(len(data))
+		return nil
 	}
 
 	// Data written on this writer will be larger than a segment size.
 	// Close current object, move it to the segment container if this
 	// is the first time this happens, then open the next segment and
 	// start writing to it.
-	if fh.uploaded+uint64(len(req.Data)) > uint64(SegmentSize) {
-		// Close current segment
-		if !fh.wroteSegment {
-			if err := fh.moveToSegment(); err != nil {
-				return err
-			}
-		}
-		fh.wd.Close()
-
-		// Open next segment
-		fh.wd, err = initSegment(fh.target.cs.Name, fh.segmentPrefix, &fh.segmentID, fh.target.so, req.Data, &fh.uploaded)
-		if err != nil {
+	// Close current segment
+	if !fh.wroteSegment {
+		if err := fh.moveToSegment(); err != nil {
 			return err
 		}
+	}
+	fh.wd.Close()
 
-		goto EndWrite
+	// Keep track of segments for static large objects
+	if fh.slo {
+		fh.addStaticSegment(hex.EncodeToString(fh.segmentHash.Sum(nil)))
+		fh.segmentHash.Reset()
+		fh.segmentHash.Write(data)
 	}
 
-EndWrite:
-	resp.Size = len(req.Data)
-	return nil
+	// Open next segment
+	fh.wd, err = initSegment(fh.segmentUploader(), fh.target.cs.Name, fh.segmentPrefix, &fh.segmentID, fh.target.so, data, &fh.uploaded)
+
+	return err
 }
 
 func (fh *ObjectHandle) moveToSegment() error {
 	// Close previous writer.
-	fh.wd.Close()
+	if fh.wd != nil {
+		fh.wd.Close()
+	}
 
 	// Get the next segment name and path
 	fh.segmentPrefix = fmt.Sprintf("%s/%d", fh.target.path, time.Now().Unix())
 	fh.segmentPath = segmentPath(fh.segmentPrefix, &fh.segmentID)
 
 	// Move data to segment container
-	err := SwiftConnection.ObjectMove(fh.target.c.Name, fh.target.path, fh.target.cs.Name, fh.segmentPath)
+	err := fh.target.fs.Storage.ObjectMove(fh.target.c.Name, fh.target.path, fh.target.cs.Name, fh.segmentPath)
 	if err != nil {
 		return err
 	}
 
-	// Create the manifest
-	createManifest(fh.target, fh.target.c.Name, fh.target.cs.Name+"/"+fh.segmentPrefix, fh.target.path)
+	// Create the manifest. Static large object manifests can only
+	// be created once all segments have been uploaded.
+	if !fh.slo {
+		fh.target.fs.createManifest(fh.target, fh.target.c.Name, fh.target.cs.Name+"/"+fh.segmentPrefix, fh.target.path)
+	}
 	fh.wroteSegment = true
 	fh.target.segmented = true
 
 	return err
 }
 
+// prepareAppend opens a writer at the end of the object. New data
+// is always written to new segments, meaning that a standard object
+// is first moved to the segment container and a manifest is created
+// in its place. Existing segments are never uploaded again.
+func (fh *ObjectHandle) prepareAppend() (err error) {
+	// Nothing to keep, this is a standard upload.
+	if !fh.target.segmented && fh.target.so.Bytes == 0 {
+		return fh.truncate()
+	}
+
+	if fh.target.segmented && fh.target.sh[manifestHeader] == "" && !isStaticLargeObject(fh.target.sh) {
+		if _, fh.target.sh, err = fh.target.fs.Storage.Object(fh.target.c.Name, fh.target.path); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	// Static large object, new segments will be added to its manifest
+	case isStaticLargeObject(fh.target.sh):
+		fh.slo = true
+		fh.segments, err = fh.target.fs.Storage.GetStaticManifest(fh.target.c.Name, fh.target.path)
+		if err != nil {
+			return err
+		}
+		// Appends within the same second share the segment prefix
+		fh.segmentPrefix = fmt.Sprintf("%s/%d", fh.target.path, time.Now().Unix())
+		fh.segmentID = lastStaticSegmentID(fh.segments, fh.target.cs.Name, fh.segmentPrefix)
+		fh.wroteSegment = true
+
+	// Dynamic large object, new segments will be found under its prefix
+	case fh.target.segmented:
+		var prefix string
+		if prefix, err = manifestPrefix(fh.target.cs.Name, fh.target.sh[manifestHeader]); err != nil {
+			return err
+		}
+		fh.segmentID, err = fh.target.fs.lastSegmentID(fh.target.cs.Name, prefix)
+		if err != nil {
+			return err
+		}
+		// Manifests written by other clients may use prefixes
+		// ending with a slash
+		fh.segmentPrefix = strings.TrimSuffix(prefix, "/")
+		fh.wroteSegment = true
+
+	// Standard object, it becomes the first segment
+	default:
+		fh.slo = fh.target.fs.StaticLargeObjects
+		if err := fh.moveToSegment(); err != nil {
+			return err
+		}
+		if fh.slo {
+			_, h, err := fh.target.fs.Storage.Object(fh.target.cs.Name, fh.segmentPath)
+			if err != nil {
+				return err
+			}
+			fh.uploaded = uint64(fh.target.so.Bytes)
+			fh.addStaticSegment(h["Etag"])
+		}
+	}
+
+	fh.segmentHash = md5.New()
+	fh.wd, err = createSegment(fh.segmentUploader(), fh.target.cs.Name, fh.segmentPrefix, &fh.segmentID, &fh.uploaded)
+
+	return err
+}
+
+// loadHeaders fetches headers of an existing object about to be
+// written, since segments of large objects are removed or extended.
+func (fh *ObjectHandle) loadHeaders() error {
+	if fh.create {
+		return nil
+	}
+	return fh.target.loadHeaders()
+}
+
+func (fh *ObjectHandle) stage() (err error) {
+	if fh.sf, err = fh.target.fs.objectSpool.Create(); err != nil {
+		return err
+	}
+
+	// Fetch current content unless the object is about to
+	// be truncated.
+	if !fh.create && !fh.truncated && fh.target.so.Bytes > 0 {
+		rd, err := newReader(fh)
+		if err != nil {
+			fh.sf.Remove()
+			return err
+		}
+		defer rd.(io.Closer).Close()
+		if err := fh.sf.Fill(rd, uint64(fh.target.so.Bytes)); err != nil {
+			fh.sf.Remove()
+			return err
+		}
+	}
+
+	fh.target.staging.Lock()
+	fh.sf.dirty = fh.create || fh.truncated
+	fh.target.sf = fh.sf
+	fh.target.staging.Unlock()
+
+	return nil
+}
+
 func (fh *ObjectHandle) truncate() (err error) {
 	// Remove referenced segments
 	if fh.target.segmented {
-		err = deleteSegments(fh.target.cs.Name, fh.target.sh[manifestHeader])
-		if err != nil {
+		if err := fh.target.removeSegments(); err != nil {
 			return err
 		}
-		delete(fh.target.sh, manifestHeader)
-		fh.target.segmented = false
 	}
 
 	// Reopen for writing
 	fh.truncated = true
+	fh.slo = fh.target.fs.StaticLargeObjects
+	fh.segments = nil
+	fh.segmentHash = md5.New()
+	fh.uploads = nil
 	fh.target.so.Bytes = 0
-	fh.wd, err = newWriter(fh.target.c.Name, fh.target.so.Name)
+	fh.wd, err = fh.target.fs.newWriter(fh.target.c.Name, fh.target.so.Name, fh.target.fs.permissionHeaders(fh.target.sh))
 
 	return err
 }
 
+func (fh *ObjectHandle) upload() (err error) {
+	var (
+		chunk = make([]byte, spoolChunkSize(fh.target.fs.SegmentSize))
+		size  = fh.sf.Size()
+		rd    = io.NewSectionReader(fh.sf.file, 0, int64(size))
+		ok    = false
+	)
+
+	// Segments of the current version are removed once replaced
+	if err := fh.target.loadHeaders(); err != nil {
+		return err
+	}
+	old, err := fh.target.segmentPaths()
+	if err != nil {
+		return err
+	}
+
+	// Never leave a partial upload running on failure
+	defer func() {
+		if !ok {
+			fh.abortWriter()
+			if fh.wroteSegment {
+				fh.target.fs.deleteSegmentPrefix(fh.target.cs.Name, fh.segmentPrefix)
+			}
+		}
+	}()
+
+	// Start a new upload from scratch. Large objects are uploaded
+	// under a new prefix, keeping the current version until the
+	// manifest is replaced.
+	fh.segmentID = 0
+	fh.uploaded = 0
+	fh.slo = fh.target.fs.StaticLargeObjects
+	fh.segments = nil
+	fh.segmentHash = md5.New()
+	fh.uploads = nil
+	fh.wroteSegment = size > fh.target.fs.SegmentSize
+	fh.target.so.Bytes = 0
+	if fh.wroteSegment {
+		fh.segmentPrefix = newSegmentPrefix(fh.target.cs.Name, fh.target.path, old)
+		fh.wd, err = createSegment(fh.segmentUploader(), fh.target.cs.Name, fh.segmentPrefix, &fh.segmentID, &fh.uploaded)
+	} else {
+		fh.wd, err = fh.target.fs.newWriter(fh.target.c.Name, fh.target.so.Name, fh.target.fs.permissionHeaders(fh.target.sh))
+	}
+	if err != nil {
+		return err
+	}
+	fh.target.writing = true
+
+	for {
+		n, err := io.ReadFull(rd, chunk)
+		if n > 0 {
+			if err := fh.send(chunk[:n]); err != nil {
+				return err
+			}
+			atomic.AddUint64(&fh.sent, uint64(n))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Replace the current version
+	if err := fh.closeWriter(); err != nil {
+		return err
+	}
+	if fh.wroteSegment && !fh.slo {
+		if err := fh.target.fs.createManifest(fh.target, fh.target.c.Name, fh.target.cs.Name+"/"+fh.segmentPrefix, fh.target.path); err != nil {
+			return err
+		}
+	}
+
+	ok = true
+	fh.target.writing = false
+	fh.sf.dirty = false
+
+	fh.target.segmented = fh.wroteSegment
+	if !fh.wroteSegment || fh.slo {
+		delete(fh.target.sh, manifestHeader)
+	}
+	if !fh.wroteSegment || !fh.slo {
+		delete(fh.target.sh, staticManifestHeader)
+	}
+	fh.target.fs.deleteSegmentPaths(old)
+
+	return nil
+}
+
+// abortWriter discards the object or segment being written and waits
+// for segments uploaded in the background.
+func (fh *ObjectHandle) abortWriter() {
+	if fh.wd != nil {
+		abortWriter(fh.wd)
+		fh.wd = nil
+	}
+	if fh.uploads != nil {
+		fh.uploads.Wait()
+	}
+	fh.target.writing = false
+}
+
+// segmentUploader returns the uploader in charge of sending
+// segments of this handle, creating it if needed.
+func (fh *ObjectHandle) segmentUploader() *SegmentUploader {
+	if fh.uploads == nil {
+		s := fh.target.fs
+		fh.uploads = NewSegmentUploader(s.Storage, s.SegmentConcurrency, s.SegmentBufferSize)
+	}
+	return fh.uploads
+}
+
+func (fh *ObjectHandle) addStaticSegment(etag string) {
+	fh.segments = append(fh.segments, StaticSegment{
+		Path: staticSegmentPath(fh.target.cs.Name, fmt.Sprintf("%s/%08d", fh.segmentPrefix, fh.segmentID)),
+		Etag: etag,
+		Size: fh.uploaded,
+	})
+}
+
+// closeWriter closes the current writer. If a static large object
+// is being written, its manifest is uploaded.
+func (fh *ObjectHandle) closeWriter() error {
+	err := fh.wd.Close()
+	fh.wd = nil
+
+	// Wait for segments uploaded in the background
+	if fh.uploads != nil {
+		if uploadErr := fh.uploads.Wait(); err == nil {
+			err = uploadErr
+		}
+	}
+
+	if err != nil || !fh.slo || !fh.wroteSegment {
+		return err
+	}
+
+	fh.addStaticSegment(hex.EncodeToString(fh.segmentHash.Sum(nil)))
+	headers := fh.target.fs.permissionHeaders(fh.target.sh)
+	for k, v := range fh.target.fs.contentTypeHeaders(fh.target.path) {
+		headers[k] = v
+	}
+	if err := fh.target.fs.Storage.CreateStaticManifest(fh.target.c.Name, fh.target.path, fh.segments, headers); err != nil {
+		return err
+	}
+
+	fh.target.segmented = true
+	if fh.target.sh == nil {
+		fh.target.sh = make(swift.Headers)
+	}
+	fh.target.sh[staticManifestHeader] = "True"
+
+	return nil
+}
+
+// mode describes how the handle accesses its object.
+func (fh *ObjectHandle) mode() string {
+	switch {
+	case fh.sf != nil:
+		return "staged"
+	case fh.locked:
+		return "write"
+	}
+	return "read"
+}
+
+func (hs *handleSet) add(fh *ObjectHandle) {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	if hs.handles == nil {
+		hs.handles = make(map[*ObjectHandle]struct{})
+	}
+	hs.handles[fh] = struct{}{}
+}
+
+func (hs *handleSet) remove(fh *ObjectHandle) {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	delete(hs.handles, fh)
+}
+
+func (hs *handleSet) list() (handles []*ObjectHandle) {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	for fh := range hs.handles {
+		handles = append(handles, fh)
+	}
+	return handles
+}
+
 var (
 	_ fs.Handle         = (*ObjectHandle)(nil)
+	_ fs.HandleFlusher  = (*ObjectHandle)(nil)
 	_ fs.HandleReleaser = (*ObjectHandle)(nil)
 	_ fs.HandleReader   = (*ObjectHandle)(nil)
 	_ fs.HandleWriter   = (*ObjectHandle)(nil)
@@ -34,15 +34,56 @@ func TestHandle(t *testing.T) {
 	t.Run("ObjectHandle_Read", testObjectHandleRead)
 	t.Run("ObjectHandle_Close", testObjectHandleClose)
 
+	// Update object in place
+	t.Run("Object_OpenReadWrite", testObjectOpenReadWrite)
+	t.Run("ObjectHandle_WriteAt", testObjectHandleWriteAt)
+	t.Run("ObjectHandle_Read", testObjectHandleRead)
+	t.Run("ObjectHandle_Flush", testObjectHandleFlush)
+	t.Run("ObjectHandle_Close", testObjectHandleClose)
+
+	// Read updated object
+	t.Run("Object_OpenReadOnly", testObjectOpenReadOnly)
+	t.Run("ObjectHandle_Read", testObjectHandleRead)
+	t.Run("ObjectHandle_Close", testObjectHandleClose)
+
+	// Append to object
+	t.Run("Object_OpenAppend", testObjectOpenAppend)
+	t.Run("ObjectHandle_Append", testObjectHandleAppend)
+	t.Run("ObjectHandle_Close", testObjectHandleClose)
+
+	// Previous content is kept
+	t.Run("Object_OpenReadOnly", testObjectOpenReadOnly)
+	t.Run("ObjectHandle_Read", testObjectHandleRead)
+	t.Run("ObjectHandle_Close", testObjectHandleClose)
+
 	t.Run("Directory_Remove", testDirectoryRemove)
 	t.Run("Container_Rmdir", testContainerRmdir)
 	t.Run("RootRemove", testRootRemove)
 }
 
+func testObjectHandleAppend(t *testing.T) {
+	size := ctx.f.so.Bytes
+
+	req := &fuse.WriteRequest{Data: ctx.b[:]}
+	rep := &fuse.WriteResponse{}
+
+	err := ctx.h.Write(nil, req, rep)
+
+	assert.Nil(t, err)
+	assert.Equal(t, rep.Size, len(ctx.b))
+	assert.Equal(t, ctx.f.so.Bytes, size+int64(len(ctx.b)))
+	assert.True(t, ctx.f.segmented)
+}
+
 func testObjectHandleClose(t *testing.T) {
 	assert.Nil(t, ctx.h.Release(nil, nil))
 }
 
+func testObjectHandleFlush(t *testing.T) {
+	assert.Nil(t, ctx.h.Flush(nil, &fuse.FlushRequest{}))
+	assert.False(t, ctx.h.sf.dirty)
+}
+
 func testObjectHandleRead(t *testing.T) {
 	req := &fuse.ReadRequest{Size: len(ctx.b)}
 	rep := &fuse.ReadResponse{Data: make([]byte, len(ctx.b))}
@@ -57,8 +98,22 @@ func testObjectHandleRead(t *testing.T) {
 	}
 }
 
+func testObjectHandleWriteAt(t *testing.T) {
+	offset := len(ctx.b) / 2
+
+	rand.Read(ctx.b[offset:])
+	req := &fuse.WriteRequest{Data: ctx.b[offset:], Offset: int64(offset)}
+	rep := &fuse.WriteResponse{}
+
+	err := ctx.h.Write(nil, req, rep)
+
+	assert.Nil(t, err)
+	assert.Equal(t, rep.Size, len(ctx.b)-offset)
+	assert.Equal(t, ctx.f.so.Bytes, int64(len(ctx.b)))
+}
+
 func testObjectHandleWrite(t *testing.T) {
-	SegmentSize = uint64(len(ctx.b))
+	ctx.fs.SegmentSize = uint64(len(ctx.b))
 
 	rand.Read(ctx.b[:])
 	req := &fuse.WriteRequest{Data: ctx.b[:]}
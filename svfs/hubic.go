@@ -1,6 +1,7 @@
 package svfs
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -8,55 +9,60 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/xlucas/swift"
 )
 
 const (
-	// HubicEndpoint is the HubiC API URL
-	HubicEndpoint    = "https://api.hubic.com"
 	hubicMtimeHeader = objectMetaHeader + "Hubiclocallastmodified"
+	hubicScope       = "credentials.r"
 )
 
 var (
-	// HubicRefreshToken is the OAUTH2 refresh token.
-	HubicRefreshToken string
-	// HubicAuthorization is the basicAuth header used
-	// within requests to Hubic OAUTH2 API.
-	HubicAuthorization string
-	hubicDateRegex     = regexp.MustCompile("Z.*")
+	// HubicEndpoint is the HubiC API URL
+	HubicEndpoint  = "https://api.hubic.com"
+	hubicDateRegex = regexp.MustCompile("Z.*")
 )
 
 type hubicCredentials struct {
 	Endpoint string `json:"endpoint"`
 	Token    string `json:"token"`
+	Expires  string `json:"expires"`
 }
 
 type hubicToken struct {
-	AccessToken string `json:"access_token"`
-	TokenType   string `json:"token_type"`
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
 }
 
 // HubicAuth is a swift-compliant authenticatior
 // for hubic.
 type HubicAuth struct {
-	client       http.Client
-	credentials  hubicCredentials
-	apiToken     hubicToken
-	refreshToken string
+	client        http.Client
+	credentials   hubicCredentials
+	apiToken      hubicToken
+	authorization string
+	refreshToken  string
 }
 
-// Request constructs the authentication request.
-func (h *HubicAuth) Request(*swift.Connection) (*http.Request, error) {
+// Request fetches a new keystone token from the hubic API. No
+// authentication request is left for the swift library to send.
+func (h *HubicAuth) Request(c *swift.Connection) (*http.Request, error) {
+	// Share the swift transport, tracking authentication requests
+	h.client.Transport = c.Transport
+	h.client.Timeout = c.ConnectTimeout
+
 	form := url.Values{}
-	form.Add("refresh_token", HubicRefreshToken)
+	form.Add("refresh_token", h.refreshToken)
 	form.Add("grant_type", "refresh_token")
 	req, err := http.NewRequest("POST", HubicEndpoint+"/oauth/token", strings.NewReader(form.Encode()))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Add("User-Agent", swift.DefaultUserAgent)
-	req.Header.Add("Authorization", "Basic "+HubicAuthorization)
+	req.Header.Add("Authorization", "Basic "+h.authorization)
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
 	// Request new API token
@@ -128,4 +134,66 @@ func (h *HubicAuth) CdnUrl() string {
 	return ""
 }
 
-var _ swift.Authenticator = (*HubicAuth)(nil)
+// Expires tells when the keystone token expires.
+func (h *HubicAuth) Expires() time.Time {
+	expires, _ := time.Parse(time.RFC3339, h.credentials.Expires)
+	return expires
+}
+
+// HubicAuthorizationURL returns the URL where users grant an application
+// access to their hubic credentials. Browsers are then redirected to the
+// redirect URL of the application with an authorization code and the
+// given state.
+func HubicAuthorizationURL(clientID, redirectURL, state string) string {
+	query := url.Values{}
+	query.Set("client_id", clientID)
+	query.Set("redirect_uri", redirectURL)
+	query.Set("scope", hubicScope)
+	query.Set("response_type", "code")
+	query.Set("state", state)
+	return HubicEndpoint + "/oauth/auth/?" + query.Encode()
+}
+
+// HubicRequestRefreshToken exchanges an authorization code for a refresh
+// token. It returns the authorization header value to use along with the
+// refresh token.
+func HubicRequestRefreshToken(clientID, clientSecret, redirectURL, code string) (authorization, refreshToken string, err error) {
+	authorization = base64.StdEncoding.EncodeToString([]byte(clientID + ":" + clientSecret))
+
+	form := url.Values{}
+	form.Add("code", code)
+	form.Add("redirect_uri", redirectURL)
+	form.Add("grant_type", "authorization_code")
+	req, err := http.NewRequest("POST", HubicEndpoint+"/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Add("User-Agent", swift.DefaultUserAgent)
+	req.Header.Add("Authorization", "Basic "+authorization)
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return "", "", fmt.Errorf("Invalid reply from server when fetching hubic refresh token : %s", resp.Status)
+	}
+
+	var token hubicToken
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", "", err
+	}
+	if token.RefreshToken == "" {
+		return "", "", fmt.Errorf("No refresh token in reply from server")
+	}
+
+	return authorization, token.RefreshToken, nil
+}
+
+var (
+	_ swift.Authenticator = (*HubicAuth)(nil)
+	_ expiringAuth        = (*HubicAuth)(nil)
+)
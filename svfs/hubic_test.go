@@ -0,0 +1,86 @@
+package svfs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type HubicTestSuite struct {
+	suite.Suite
+	endpoint string
+	server   *httptest.Server
+	form     url.Values
+	auth     string
+	status   int
+	reply    string
+}
+
+func (suite *HubicTestSuite) SetupTest() {
+	suite.status = http.StatusOK
+	suite.reply = `{"access_token": "access", "token_type": "Bearer", "refresh_token": "refresh"}`
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(suite.T(), "/oauth/token", r.URL.Path)
+		r.ParseForm()
+		suite.form = r.PostForm
+		suite.auth = r.Header.Get("Authorization")
+		w.WriteHeader(suite.status)
+		w.Write([]byte(suite.reply))
+	}))
+	suite.endpoint = HubicEndpoint
+	HubicEndpoint = suite.server.URL
+}
+
+func (suite *HubicTestSuite) TearDownTest() {
+	HubicEndpoint = suite.endpoint
+	suite.server.Close()
+}
+
+func (suite *HubicTestSuite) TestAuthorizationURL() {
+	u, err := url.Parse(HubicAuthorizationURL("id", "http://localhost:8080/", "state"))
+	require.NoError(suite.T(), err)
+
+	assert.Equal(suite.T(), "/oauth/auth/", u.Path)
+	assert.Equal(suite.T(), url.Values{
+		"client_id":     {"id"},
+		"redirect_uri":  {"http://localhost:8080/"},
+		"scope":         {"credentials.r"},
+		"response_type": {"code"},
+		"state":         {"state"},
+	}, u.Query())
+}
+
+func (suite *HubicTestSuite) TestRequestRefreshToken() {
+	auth, token, err := HubicRequestRefreshToken("id", "secret", "http://localhost:8080/", "code")
+	require.NoError(suite.T(), err)
+
+	assert.Equal(suite.T(), "aWQ6c2VjcmV0", auth)
+	assert.Equal(suite.T(), "refresh", token)
+	assert.Equal(suite.T(), "Basic aWQ6c2VjcmV0", suite.auth)
+	assert.Equal(suite.T(), "code", suite.form.Get("code"))
+	assert.Equal(suite.T(), "http://localhost:8080/", suite.form.Get("redirect_uri"))
+	assert.Equal(suite.T(), "authorization_code", suite.form.Get("grant_type"))
+}
+
+func (suite *HubicTestSuite) TestRequestRefreshTokenRejected() {
+	suite.status = http.StatusUnauthorized
+
+	_, _, err := HubicRequestRefreshToken("id", "wrong", "http://localhost:8080/", "code")
+	assert.Error(suite.T(), err)
+}
+
+func (suite *HubicTestSuite) TestRequestRefreshTokenMissing() {
+	suite.reply = `{"access_token": "access", "token_type": "Bearer"}`
+
+	_, _, err := HubicRequestRefreshToken("id", "secret", "http://localhost:8080/", "code")
+	assert.Error(suite.T(), err)
+}
+
+func TestHubicSuite(t *testing.T) {
+	suite.Run(t, new(HubicTestSuite))
+}
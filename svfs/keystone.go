@@ -0,0 +1,259 @@
+package svfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xlucas/swift"
+)
+
+const (
+	keystoneMethodPassword              = "password"
+	keystoneMethodApplicationCredential = "application_credential"
+	keystoneObjectStoreType             = "object-store"
+	keystoneTokenHeader                 = "X-Subject-Token"
+)
+
+type keystoneDomain struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+type keystoneUser struct {
+	Name     string          `json:"name"`
+	Password string          `json:"password"`
+	Domain   *keystoneDomain `json:"domain,omitempty"`
+}
+
+type keystonePassword struct {
+	User keystoneUser `json:"user"`
+}
+
+type keystoneProject struct {
+	ID     string          `json:"id,omitempty"`
+	Name   string          `json:"name,omitempty"`
+	Domain *keystoneDomain `json:"domain,omitempty"`
+}
+
+type keystoneApplicationCredential struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+type keystoneScope struct {
+	Project keystoneProject `json:"project"`
+}
+
+type keystoneRequest struct {
+	Auth struct {
+		Identity struct {
+			Methods               []string                       `json:"methods"`
+			Password              *keystonePassword              `json:"password,omitempty"`
+			ApplicationCredential *keystoneApplicationCredential `json:"application_credential,omitempty"`
+		} `json:"identity"`
+		Scope *keystoneScope `json:"scope,omitempty"`
+	} `json:"auth"`
+}
+
+type keystoneResponse struct {
+	Token struct {
+		ExpiresAt string `json:"expires_at"`
+		Catalog   []struct {
+			Type      string `json:"type"`
+			Endpoints []struct {
+				Interface string `json:"interface"`
+				Region    string `json:"region"`
+				RegionID  string `json:"region_id"`
+				URL       string `json:"url"`
+			} `json:"endpoints"`
+		} `json:"catalog"`
+	} `json:"token"`
+}
+
+// KeystoneAuth is a swift-compliant authenticator for keystone v3,
+// supporting application credentials and domain-scoped projects.
+type KeystoneAuth struct {
+	applicationCredentialID     string
+	applicationCredentialSecret string
+	region                      string
+	token                       string
+	response                    keystoneResponse
+}
+
+// Request constructs the authentication request.
+func (k *KeystoneAuth) Request(c *swift.Connection) (*http.Request, error) {
+	var body keystoneRequest
+
+	identity := &body.Auth.Identity
+
+	if k.applicationCredentialID != "" {
+		// Application credentials are already scoped to a project
+		identity.Methods = []string{keystoneMethodApplicationCredential}
+		identity.ApplicationCredential = &keystoneApplicationCredential{
+			ID:     k.applicationCredentialID,
+			Secret: k.applicationCredentialSecret,
+		}
+	} else {
+		identity.Methods = []string{keystoneMethodPassword}
+		identity.Password = &keystonePassword{
+			User: keystoneUser{
+				Name:     c.UserName,
+				Password: c.ApiKey,
+				Domain:   newKeystoneDomain(c.Domain, c.DomainId),
+			},
+		}
+
+		// User names are only unique within a domain
+		if identity.Password.User.Domain == nil {
+			identity.Password.User.Domain = &keystoneDomain{Name: "Default"}
+		}
+
+		if c.TenantId != "" || c.Tenant != "" {
+			body.Auth.Scope = new(keystoneScope)
+			project := &body.Auth.Scope.Project
+			if c.TenantId != "" {
+				project.ID = c.TenantId
+			} else {
+				project.Name = c.Tenant
+				project.Domain = newKeystoneDomain(c.TenantDomain, c.TenantDomainId)
+				if project.Domain == nil {
+					project.Domain = identity.Password.User.Domain
+				}
+			}
+		}
+	}
+
+	content, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimSuffix(c.AuthUrl, "/")+"/auth/tokens", bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", c.UserAgent)
+
+	k.region = c.Region
+
+	return req, nil
+}
+
+// Response reads the authentication response.
+func (k *KeystoneAuth) Response(resp *http.Response) error {
+	k.token = resp.Header.Get(keystoneTokenHeader)
+	k.response = keystoneResponse{}
+	return json.NewDecoder(resp.Body).Decode(&k.response)
+}
+
+// StorageUrl retrieves the swift's storage URL from
+// the service catalog of the authentication response.
+func (k *KeystoneAuth) StorageUrl(Internal bool) string {
+	iface := "public"
+	if Internal {
+		iface = "internal"
+	}
+
+	for _, service := range k.response.Token.Catalog {
+		if service.Type != keystoneObjectStoreType {
+			continue
+		}
+		for _, endpoint := range service.Endpoints {
+			if endpoint.Interface != iface {
+				continue
+			}
+			if k.region == "" || k.region == endpoint.Region || k.region == endpoint.RegionID {
+				return endpoint.URL
+			}
+		}
+	}
+
+	return ""
+}
+
+// Token retrieves keystone token from the authentication
+// response.
+func (k *KeystoneAuth) Token() string {
+	return k.token
+}
+
+// CdnUrl retrieves the CDN URL from the authentication
+// response.
+func (k *KeystoneAuth) CdnUrl() string {
+	return ""
+}
+
+// Expires tells when the token expires.
+func (k *KeystoneAuth) Expires() time.Time {
+	expires, _ := time.Parse(time.RFC3339Nano, k.response.Token.ExpiresAt)
+	return expires
+}
+
+// useKeystoneAuth tells if the keystone v3 authenticator should be used
+// for this connection. Token-based authentication is left to the swift
+// library.
+func (c *Connection) useKeystoneAuth() bool {
+	if c.KeystoneApplicationCredentialID != "" {
+		return true
+	}
+	if c.UserName == "" {
+		return false
+	}
+	if c.AuthVersion != 0 {
+		return c.AuthVersion == 3
+	}
+	return strings.Contains(c.AuthUrl, "v3")
+}
+
+// readSecretFiles loads secrets stored in files into the connection
+// settings, so they never appear on the command line.
+func (c *Connection) readSecretFiles() (err error) {
+	files := []struct {
+		path  string
+		value *string
+	}{
+		{c.KeystonePasswordFile, &c.ApiKey},
+		{c.KeystoneTokenFile, &c.AuthToken},
+		{c.KeystoneApplicationCredentialSecretFile, &c.KeystoneApplicationCredentialSecret},
+	}
+
+	for _, f := range files {
+		if f.path == "" {
+			continue
+		}
+		if *f.value, err = readSecretFile(f.path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readSecretFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read secret file : %v", err)
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+func newKeystoneDomain(name, id string) *keystoneDomain {
+	if name != "" {
+		return &keystoneDomain{Name: name}
+	}
+	if id != "" {
+		return &keystoneDomain{ID: id}
+	}
+	return nil
+}
+
+var (
+	_ swift.Authenticator = (*KeystoneAuth)(nil)
+	_ expiringAuth        = (*KeystoneAuth)(nil)
+)
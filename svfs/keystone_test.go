@@ -0,0 +1,173 @@
+package svfs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/xlucas/swift"
+)
+
+const keystoneTestCatalog = `{"token": {"catalog": [
+	{"type": "identity", "endpoints": [
+		{"interface": "public", "region": "GRA", "url": "https://identity"}
+	]},
+	{"type": "object-store", "endpoints": [
+		{"interface": "public", "region": "GRA", "url": "https://gra/public"},
+		{"interface": "internal", "region": "GRA", "url": "https://gra/internal"},
+		{"interface": "public", "region": "SBG", "url": "https://sbg/public"}
+	]}
+]}}`
+
+type KeystoneTestSuite struct {
+	suite.Suite
+	server  *httptest.Server
+	request map[string]interface{}
+	conn    *swift.Connection
+	dir     string
+}
+
+func (suite *KeystoneTestSuite) SetupTest() {
+	suite.request = nil
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(suite.T(), "/v3/auth/tokens", r.URL.Path)
+		json.NewDecoder(r.Body).Decode(&suite.request)
+		w.Header().Set("X-Subject-Token", "token")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(keystoneTestCatalog))
+	}))
+	suite.conn = &swift.Connection{
+		AuthUrl: suite.server.URL + "/v3/",
+		Auth:    new(KeystoneAuth),
+	}
+
+	var err error
+	suite.dir, err = ioutil.TempDir("", "svfs-keystone")
+	require.NoError(suite.T(), err)
+}
+
+func (suite *KeystoneTestSuite) TearDownTest() {
+	suite.server.Close()
+	os.RemoveAll(suite.dir)
+}
+
+func (suite *KeystoneTestSuite) identity() map[string]interface{} {
+	return suite.request["auth"].(map[string]interface{})["identity"].(map[string]interface{})
+}
+
+func (suite *KeystoneTestSuite) TestApplicationCredential() {
+	suite.conn.Auth = &KeystoneAuth{
+		applicationCredentialID:     "id",
+		applicationCredentialSecret: "secret",
+	}
+
+	require.NoError(suite.T(), suite.conn.Authenticate())
+
+	identity := suite.identity()
+	assert.Equal(suite.T(), []interface{}{"application_credential"}, identity["methods"])
+	assert.Equal(suite.T(), map[string]interface{}{"id": "id", "secret": "secret"}, identity["application_credential"])
+	assert.Nil(suite.T(), suite.request["auth"].(map[string]interface{})["scope"])
+	assert.Equal(suite.T(), "token", suite.conn.AuthToken)
+	assert.Equal(suite.T(), "https://gra/public", suite.conn.StorageUrl)
+}
+
+func (suite *KeystoneTestSuite) TestPasswordWithDomains() {
+	suite.conn.UserName = "user"
+	suite.conn.ApiKey = "password"
+	suite.conn.Domain = "users"
+	suite.conn.Tenant = "project"
+	suite.conn.TenantDomain = "projects"
+
+	require.NoError(suite.T(), suite.conn.Authenticate())
+
+	user := suite.identity()["password"].(map[string]interface{})["user"].(map[string]interface{})
+	assert.Equal(suite.T(), "user", user["name"])
+	assert.Equal(suite.T(), "password", user["password"])
+	assert.Equal(suite.T(), map[string]interface{}{"name": "users"}, user["domain"])
+
+	project := suite.request["auth"].(map[string]interface{})["scope"].(map[string]interface{})["project"].(map[string]interface{})
+	assert.Equal(suite.T(), "project", project["name"])
+	assert.Equal(suite.T(), map[string]interface{}{"name": "projects"}, project["domain"])
+}
+
+func (suite *KeystoneTestSuite) TestPasswordDefaultDomain() {
+	suite.conn.UserName = "user"
+	suite.conn.ApiKey = "password"
+	suite.conn.Tenant = "project"
+
+	require.NoError(suite.T(), suite.conn.Authenticate())
+
+	user := suite.identity()["password"].(map[string]interface{})["user"].(map[string]interface{})
+	assert.Equal(suite.T(), map[string]interface{}{"name": "Default"}, user["domain"])
+
+	project := suite.request["auth"].(map[string]interface{})["scope"].(map[string]interface{})["project"].(map[string]interface{})
+	assert.Equal(suite.T(), map[string]interface{}{"name": "Default"}, project["domain"])
+}
+
+func (suite *KeystoneTestSuite) TestProjectID() {
+	suite.conn.UserName = "user"
+	suite.conn.TenantId = "1234"
+
+	require.NoError(suite.T(), suite.conn.Authenticate())
+
+	project := suite.request["auth"].(map[string]interface{})["scope"].(map[string]interface{})["project"].(map[string]interface{})
+	assert.Equal(suite.T(), map[string]interface{}{"id": "1234"}, project)
+}
+
+func (suite *KeystoneTestSuite) TestStorageURLByRegion() {
+	suite.conn.UserName = "user"
+	suite.conn.Region = "SBG"
+	require.NoError(suite.T(), suite.conn.Authenticate())
+	assert.Equal(suite.T(), "https://sbg/public", suite.conn.StorageUrl)
+
+	suite.conn.UnAuthenticate()
+	suite.conn.Region = "GRA"
+	suite.conn.Internal = true
+	require.NoError(suite.T(), suite.conn.Authenticate())
+	assert.Equal(suite.T(), "https://gra/internal", suite.conn.StorageUrl)
+}
+
+func (suite *KeystoneTestSuite) TestReadSecretFiles() {
+	password := filepath.Join(suite.dir, "password")
+	secret := filepath.Join(suite.dir, "secret")
+	require.NoError(suite.T(), ioutil.WriteFile(password, []byte("pass\n"), 0600))
+	require.NoError(suite.T(), ioutil.WriteFile(secret, []byte("secret"), 0600))
+
+	c := &Connection{
+		Connection:                              suite.conn,
+		KeystonePasswordFile:                    password,
+		KeystoneApplicationCredentialSecretFile: secret,
+	}
+
+	require.NoError(suite.T(), c.readSecretFiles())
+	assert.Equal(suite.T(), "pass", suite.conn.ApiKey)
+	assert.Equal(suite.T(), "secret", c.KeystoneApplicationCredentialSecret)
+
+	c.KeystoneTokenFile = filepath.Join(suite.dir, "missing")
+	assert.Error(suite.T(), c.readSecretFiles())
+}
+
+func (suite *KeystoneTestSuite) TestUseKeystoneAuth() {
+	c := &Connection{Connection: &swift.Connection{AuthUrl: "https://auth.cloud.ovh.net/v3"}}
+	assert.False(suite.T(), c.useKeystoneAuth())
+
+	c.UserName = "user"
+	assert.True(suite.T(), c.useKeystoneAuth())
+
+	c.AuthUrl = "https://auth.cloud.ovh.net/v2.0"
+	assert.False(suite.T(), c.useKeystoneAuth())
+
+	c.KeystoneApplicationCredentialID = "id"
+	assert.True(suite.T(), c.useKeystoneAuth())
+}
+
+func TestKeystoneSuite(t *testing.T) {
+	suite.Run(t, new(KeystoneTestSuite))
+}
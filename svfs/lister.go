@@ -1,16 +1,19 @@
 package svfs
 
-var (
-	// ListerConcurrency represents how many objects can
-	// be fetched concurrently while listing directory content.
-	ListerConcurrency uint64
-	directoryLister   = new(Lister)
+import (
+	"sync"
+	"sync/atomic"
 )
 
 // Lister is a concurrent processor of direntries.
 // Its job is to get extra information about files.
 type Lister struct {
-	taskChan chan ListerTask
+	storage     Backend
+	concurrency uint64
+	taskChan    chan ListerTask
+	stop        chan struct{}
+	stopOnce    sync.Once
+	workers     sync.WaitGroup
 }
 
 // ListerTask represents a manifest ready to be processed by
@@ -21,35 +24,68 @@ type ListerTask struct {
 	rc chan<- Node
 }
 
+// NewLister creates a lister fetching information about at
+// most concurrency objects at once from the given storage.
+func NewLister(storage Backend, concurrency uint64) *Lister {
+	return &Lister{
+		storage:     storage,
+		concurrency: concurrency,
+	}
+}
+
 // Start spawns workers waiting for tasks. Once a task comes
 // in the task channel, one worker will process it by opening
 // a connection to swift and asking information about the
 // current object.
 func (dl *Lister) Start() {
-	dl.taskChan = make(chan ListerTask, ListerConcurrency)
-	for i := 0; uint64(i) < ListerConcurrency; i++ {
-		go processTasks(dl.taskChan)
+	dl.taskChan = make(chan ListerTask, dl.concurrency)
+	dl.stop = make(chan struct{})
+	for i := 0; uint64(i) < dl.concurrency; i++ {
+		dl.workers.Add(1)
+		go dl.processTasks()
 	}
 }
 
+// Stop makes workers exit once done with their current task
+// and waits for them. Tasks added afterwards are dropped.
+func (dl *Lister) Stop() {
+	dl.stopOnce.Do(func() { close(dl.stop) })
+	dl.workers.Wait()
+}
+
 // AddTask asynchronously adds a new task to be processed. It
 // returns immediately with no guarantee that the task has been
 // added to the channel nor retrieved by a worker.
 func (dl *Lister) AddTask(n Node, rc chan Node) {
+	atomic.AddInt64(&listerQueueDepth, 1)
 	go func() {
-		dl.taskChan <- ListerTask{
+		select {
+		case dl.taskChan <- ListerTask{
 			n:  n,
 			rc: rc,
+		}:
+		case <-dl.stop:
+			atomic.AddInt64(&listerQueueDepth, -1)
 		}
 	}()
 }
 
-func processTasks(taskChan chan ListerTask) {
-	for t := range taskChan {
+func (dl *Lister) processTasks() {
+	defer dl.workers.Done()
+
+	for {
+		var t ListerTask
+		select {
+		case t = <-dl.taskChan:
+		case <-dl.stop:
+			return
+		}
+		atomic.AddInt64(&listerQueueDepth, -1)
+
 		// Standard swift object
 		if o, ok := t.n.(*Object); ok {
-			ro, h, _ := SwiftConnection.Object(o.c.Name, o.so.Name)
-			if segmentPathRegex.Match([]byte(h[manifestHeader])) {
+			ro, h, _ := dl.storage.Object(o.c.Name, o.so.Name)
+			if isSegmented(h) {
 				o.segmented = true
 			}
 			o.sh = h
@@ -58,14 +94,17 @@ func processTasks(taskChan chan ListerTask) {
 		}
 		// Directory
 		if d, ok := t.n.(*Directory); ok {
-			rd, h, _ := SwiftConnection.Object(d.c.Name, d.so.Name)
-			d.sh = h
-			d.so = &rd
+			// Pseudo directories have no marker object
+			if !d.so.PseudoDirectory {
+				rd, h, _ := dl.storage.Object(d.c.Name, d.so.Name)
+				d.sh = h
+				d.so = &rd
+			}
 			t.rc <- d
 		}
 		// Symlink
 		if s, ok := t.n.(*Symlink); ok {
-			rs, h, _ := SwiftConnection.Object(s.c.Name, s.so.Name)
+			rs, h, _ := dl.storage.Object(s.c.Name, s.so.Name)
 			s.sh = h
 			s.so = &rs
 			t.rc <- s
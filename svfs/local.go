@@ -0,0 +1,1118 @@
+package svfs
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/xlucas/swift"
+)
+
+const (
+	localContainerFile = "container.json"
+	localDataDir       = "objects"
+	localMetaDir       = "meta"
+	localTempDir       = "tmp"
+	defaultContentType = "application/octet-stream"
+)
+
+// LocalBackend stores containers in subdirectories of a local
+// directory. Object data and metadata are kept in separate files
+// named after the escaped object name, so that objects never clash
+// with pseudo-directories. Dynamic and static large object manifests
+// are read as the concatenation of their segments, like in swift. A
+// static manifest is stored as the JSON list of its segments.
+type LocalBackend struct {
+	root  string
+	mutex sync.RWMutex
+}
+
+// localObject is the metadata of an object.
+type localObject struct {
+	ContentType  string        `json:"content_type"`
+	Hash         string        `json:"hash"`
+	LastModified time.Time     `json:"last_modified"`
+	Headers      swift.Headers `json:"headers,omitempty"`
+}
+
+// localSegment is a file holding a part of the content of an object.
+type localSegment struct {
+	file   *os.File
+	offset int64
+	size   int64
+}
+
+// localReader reads the content of an object from its files.
+type localReader struct {
+	*io.SectionReader
+	segments []localSegment
+}
+
+// localWriter writes a new object to a temporary file, moved in
+// place along with the object metadata once closed.
+type localWriter struct {
+	b         *LocalBackend
+	container string
+	name      string
+	checkHash bool
+	hash      string
+	meta      localObject
+	file      *os.File
+	md5       hash.Hash
+	headers   swift.Headers
+	closed    bool
+}
+
+// NewLocalBackend returns a backend storing containers in the given
+// directory.
+func NewLocalBackend(root string) (*LocalBackend, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+
+	return &LocalBackend{root: root}, nil
+}
+
+// Account returns usage information about the storage. The space left
+// on the underlying filesystem is reported as the account quota.
+func (b *LocalBackend) Account() (info swift.Account, headers swift.Headers, err error) {
+	containers, err := b.ContainersAll(nil)
+	if err != nil {
+		return
+	}
+
+	for _, c := range containers {
+		info.Containers++
+		info.Objects += c.Count
+		info.BytesUsed += c.Bytes
+	}
+
+	var stat syscall.Statfs_t
+	if err = syscall.Statfs(b.root, &stat); err != nil {
+		return
+	}
+	info.Quota = info.BytesUsed + int64(uint64(stat.Bavail)*uint64(stat.Bsize))
+
+	headers = swift.Headers{
+		"X-Account-Bytes-Used":      strconv.FormatInt(info.BytesUsed, 10),
+		"X-Account-Container-Count": strconv.FormatInt(info.Containers, 10),
+		"X-Account-Object-Count":    strconv.FormatInt(info.Objects, 10),
+	}
+
+	return
+}
+
+// Container returns information about a container.
+func (b *LocalBackend) Container(container string) (info swift.Container, headers swift.Headers, err error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if headers, err = b.readContainer(container); err != nil {
+		return
+	}
+
+	info.Name = container
+	if info.Count, info.Bytes, err = b.usage(container); err != nil {
+		return
+	}
+
+	headers["X-Container-Object-Count"] = strconv.FormatInt(info.Count, 10)
+	headers["X-Container-Bytes-Used"] = strconv.FormatInt(info.Bytes, 10)
+
+	return
+}
+
+// ContainerCreate creates a container or updates its metadata.
+func (b *LocalBackend) ContainerCreate(container string, h swift.Headers) error {
+	if !validContainerName(container) {
+		return fmt.Errorf("Invalid container name %q", container)
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	headers, err := b.readContainer(container)
+	if err == swift.ContainerNotFound {
+		headers = make(swift.Headers)
+	} else if err != nil {
+		return err
+	}
+
+	for _, dir := range []string{localDataDir, localMetaDir, localTempDir} {
+		if err := os.MkdirAll(filepath.Join(b.root, container, dir), 0700); err != nil {
+			return err
+		}
+	}
+
+	for k, v := range h {
+		headers[http.CanonicalHeaderKey(k)] = v
+	}
+
+	return writeJSON(filepath.Join(b.root, container, localContainerFile), headers)
+}
+
+// ContainerDelete deletes an empty container.
+func (b *LocalBackend) ContainerDelete(container string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, err := b.readContainer(container); err != nil {
+		return err
+	}
+
+	count, _, err := b.usage(container)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return swift.ContainerNotEmpty
+	}
+
+	return os.RemoveAll(filepath.Join(b.root, container))
+}
+
+// ContainerNamesAll lists container names.
+func (b *LocalBackend) ContainerNamesAll(opts *swift.ContainersOpts) ([]string, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.containerNames(opts)
+}
+
+// ContainerUpdate updates the metadata of a container. Metadata given
+// with an empty value is removed.
+func (b *LocalBackend) ContainerUpdate(container string, h swift.Headers) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	headers, err := b.readContainer(container)
+	if err != nil {
+		return err
+	}
+
+	for k, v := range h {
+		if v == "" {
+			delete(headers, http.CanonicalHeaderKey(k))
+			continue
+		}
+		headers[http.CanonicalHeaderKey(k)] = v
+	}
+
+	return writeJSON(filepath.Join(b.root, container, localContainerFile), headers)
+}
+
+// ContainersAll lists containers.
+func (b *LocalBackend) ContainersAll(opts *swift.ContainersOpts) ([]swift.Container, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	names, err := b.containerNames(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	containers := make([]swift.Container, len(names))
+	for i, name := range names {
+		containers[i].Name = name
+		if containers[i].Count, containers[i].Bytes, err = b.usage(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return containers, nil
+}
+
+// ManifestCopy copies a manifest, not the content it references.
+func (b *LocalBackend) ManifestCopy(srcContainer, srcName, dstContainer, dstName string, h swift.Headers) (swift.Headers, error) {
+	return b.copyObject(srcContainer, srcName, dstContainer, dstName, h, false)
+}
+
+// ManifestUpdate replaces the metadata of a manifest.
+func (b *LocalBackend) ManifestUpdate(container, name string, h swift.Headers) error {
+	return b.ObjectUpdate(container, name, h)
+}
+
+// Object returns information about an object. The size of a manifest
+// is the overall size of its segments.
+func (b *LocalBackend) Object(container, name string) (info swift.Object, headers swift.Headers, err error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	meta, size, err := b.readObject(container, name)
+	if err != nil {
+		return
+	}
+
+	if manifest := meta.Headers[manifestHeader]; manifest != "" {
+		var segments []swift.Object
+		if segments, err = b.manifestSegments(manifest); err != nil {
+			return
+		}
+		size, meta.Hash = 0, manifestHash(segments)
+		for _, segment := range segments {
+			size += segment.Bytes
+		}
+	}
+
+	info = meta.object(name, size)
+	headers = meta.headers(size)
+
+	return
+}
+
+// ObjectCopy copies an object, along with its metadata overridden by
+// the given headers. Manifests are copied as regular objects holding
+// the content of their segments.
+func (b *LocalBackend) ObjectCopy(srcContainer, srcName, dstContainer, dstName string, h swift.Headers) (swift.Headers, error) {
+	return b.copyObject(srcContainer, srcName, dstContainer, dstName, h, true)
+}
+
+// ObjectCreate returns a writer streaming data to a new object. The
+// object is only visible once the writer is closed.
+func (b *LocalBackend) ObjectCreate(container, name string, checkHash bool, hash, contentType string, h swift.Headers) (io.WriteCloser, error) {
+	return b.create(container, name, checkHash, hash, contentType, h)
+}
+
+// ObjectDelete deletes an object.
+func (b *LocalBackend) ObjectDelete(container, name string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	data, meta := b.objectPaths(container, name)
+	if err := os.Remove(data); os.IsNotExist(err) {
+		return swift.ObjectNotFound
+	} else if err != nil {
+		return err
+	}
+
+	if err := os.Remove(meta); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// ObjectMove moves an object, along with its metadata. Manifests are
+// copied then deleted, like swift does.
+func (b *LocalBackend) ObjectMove(srcContainer, srcName, dstContainer, dstName string) error {
+	moved, err := b.moveObject(srcContainer, srcName, dstContainer, dstName)
+	if err != nil || moved {
+		return err
+	}
+
+	if _, err := b.ObjectCopy(srcContainer, srcName, dstContainer, dstName, nil); err != nil {
+		return err
+	}
+
+	return b.ObjectDelete(srcContainer, srcName)
+}
+
+// ObjectNamesAll lists object names of a container.
+func (b *LocalBackend) ObjectNamesAll(container string, opts *swift.ObjectsOpts) ([]string, error) {
+	objects, err := b.ObjectsAll(container, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(objects))
+	for i, object := range objects {
+		names[i] = object.Name
+	}
+
+	return names, nil
+}
+
+// ObjectOpen opens an object for reading. A single range of bytes
+// may be requested through the Range header. Hashes are never checked
+// since data isn't sent over the network.
+func (b *LocalBackend) ObjectOpen(container, name string, checkHash bool, h swift.Headers) (ObjectReader, swift.Headers, error) {
+	start, end := int64(0), int64(-1)
+	if value := h["Range"]; value != "" {
+		var err error
+		if start, end, err = parseRange(value); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	rd, meta, err := b.open(container, name, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	size := rd.Size()
+	if end < 0 || end >= size {
+		end = size - 1
+	}
+	if start > end+1 {
+		start = end + 1
+	}
+	rd.SectionReader = io.NewSectionReader(rd, start, end-start+1)
+
+	return rd, meta.headers(size), nil
+}
+
+// ObjectPut uploads an object from a reader.
+func (b *LocalBackend) ObjectPut(container, name string, contents io.Reader, checkHash bool, hash, contentType string, h swift.Headers) (swift.Headers, error) {
+	w, err := b.create(container, name, checkHash, hash, contentType, h)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.Copy(w, contents); err != nil {
+		w.abort()
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return w.headers, nil
+}
+
+// ObjectPutBytes uploads an object from a byte slice.
+func (b *LocalBackend) ObjectPutBytes(container, name string, contents []byte, contentType string) error {
+	_, err := b.ObjectPut(container, name, bytes.NewReader(contents), false, "", contentType, nil)
+	return err
+}
+
+// ObjectUpdate replaces the metadata of an object. Like swift, headers
+// not sent again are removed, including the manifest header, while
+// static manifests stay so.
+func (b *LocalBackend) ObjectUpdate(container, name string, h swift.Headers) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	meta, _, err := b.readObject(container, name)
+	if err != nil {
+		return err
+	}
+
+	headers := objectHeaders(h)
+	delete(headers, staticManifestHeader)
+	if isStaticLargeObject(meta.Headers) {
+		headers[staticManifestHeader] = meta.Headers[staticManifestHeader]
+	}
+	if contentType := h["Content-Type"]; contentType != "" {
+		meta.ContentType = contentType
+	}
+	meta.Headers = headers
+
+	_, metaPath := b.objectPaths(container, name)
+	return writeJSON(metaPath, meta)
+}
+
+// ObjectsAll lists objects of a container.
+func (b *LocalBackend) ObjectsAll(container string, opts *swift.ObjectsOpts) ([]swift.Object, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.objects(container, opts)
+}
+
+// CreateStaticManifest stores a static large object manifest referencing
+// the given segments, along with extra headers. Segments must exist and
+// match the given size and hash, if any.
+func (b *LocalBackend) CreateStaticManifest(container, name string, segments []StaticSegment, h swift.Headers) error {
+	b.mutex.RLock()
+	for _, segment := range segments {
+		segmentContainer, segmentName := splitStaticSegmentPath(segment.Path)
+		meta, size, err := b.readObject(segmentContainer, segmentName)
+		if err == nil && (segment.Size != 0 && uint64(size) != segment.Size ||
+			segment.Etag != "" && strings.Trim(meta.Hash, "\"") != strings.Trim(segment.Etag, "\"")) {
+			err = fmt.Errorf("Segment %s doesn't match the static manifest", segment.Path)
+		}
+		if err != nil {
+			b.mutex.RUnlock()
+			return err
+		}
+	}
+	b.mutex.RUnlock()
+
+	content, err := json.Marshal(segments)
+	if err != nil {
+		return err
+	}
+
+	headers := swift.Headers{staticManifestHeader: "True"}
+	for k, v := range h {
+		headers[k] = v
+	}
+
+	_, err = b.ObjectPut(container, name, bytes.NewReader(content), false, "", h["Content-Type"], headers)
+	return err
+}
+
+// DeleteStaticLargeObject removes a static large object manifest along
+// with all its segments. Other objects are removed as usual.
+func (b *LocalBackend) DeleteStaticLargeObject(container, name string) error {
+	segments, err := b.GetStaticManifest(container, name)
+	if err != nil && err != errNotStaticLargeObject {
+		return err
+	}
+
+	for _, segment := range segments {
+		segmentContainer, segmentName := splitStaticSegmentPath(segment.Path)
+		if err := b.ObjectDelete(segmentContainer, segmentName); err != nil && err != swift.ObjectNotFound {
+			return err
+		}
+	}
+
+	return b.ObjectDelete(container, name)
+}
+
+// GetStaticManifest retrieves segments referenced by a static large
+// object manifest.
+func (b *LocalBackend) GetStaticManifest(container, name string) ([]StaticSegment, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	meta, _, err := b.readObject(container, name)
+	if err != nil {
+		return nil, err
+	}
+	if !isStaticLargeObject(meta.Headers) {
+		return nil, errNotStaticLargeObject
+	}
+
+	return b.readStaticManifest(container, name)
+}
+
+// copyObject copies an object, its content being read from the segments
+// it references if resolve is set.
+func (b *LocalBackend) copyObject(srcContainer, srcName, dstContainer, dstName string, h swift.Headers, resolve bool) (swift.Headers, error) {
+	rd, meta, err := b.open(srcContainer, srcName, resolve)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	headers := make(swift.Headers)
+	for k, v := range meta.Headers {
+		if (k != manifestHeader && k != staticManifestHeader) || !resolve {
+			headers[k] = v
+		}
+	}
+	for k, v := range objectHeaders(h) {
+		headers[k] = v
+	}
+
+	contentType := meta.ContentType
+	if h["Content-Type"] != "" {
+		contentType = h["Content-Type"]
+	}
+
+	return b.ObjectPut(dstContainer, dstName, rd, false, "", contentType, headers)
+}
+
+// moveObject renames an object unless it's a manifest, which must
+// be copied instead.
+func (b *LocalBackend) moveObject(srcContainer, srcName, dstContainer, dstName string) (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	meta, _, err := b.readObject(srcContainer, srcName)
+	if err != nil {
+		return false, err
+	}
+	if meta.Headers[manifestHeader] != "" || isStaticLargeObject(meta.Headers) {
+		return false, nil
+	}
+	if _, err := b.readContainer(dstContainer); err != nil {
+		return false, swift.ObjectNotFound
+	}
+
+	srcData, srcMeta := b.objectPaths(srcContainer, srcName)
+	dstData, dstMeta := b.objectPaths(dstContainer, dstName)
+
+	meta.LastModified = time.Now().UTC()
+	if err := writeJSON(dstMeta, meta); err != nil {
+		return false, err
+	}
+	if err := os.Rename(srcData, dstData); err != nil {
+		return false, err
+	}
+	if err := os.Remove(srcMeta); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// open opens the files holding the content of an object.
+func (b *LocalBackend) open(container, name string, resolve bool) (rd *localReader, meta localObject, err error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if meta, _, err = b.readObject(container, name); err != nil {
+		return
+	}
+
+	var files []string
+	if manifest := meta.Headers[manifestHeader]; manifest != "" && resolve {
+		var segments []swift.Object
+		if segments, err = b.manifestSegments(manifest); err != nil {
+			return
+		}
+		segmentContainer, _ := splitManifest(manifest)
+		for _, segment := range segments {
+			data, _ := b.objectPaths(segmentContainer, segment.Name)
+			files = append(files, data)
+		}
+		meta.Hash = manifestHash(segments)
+	} else if isStaticLargeObject(meta.Headers) && resolve {
+		var segments []StaticSegment
+		if segments, err = b.readStaticManifest(container, name); err != nil {
+			return
+		}
+		for _, segment := range segments {
+			segmentContainer, segmentName := splitStaticSegmentPath(segment.Path)
+			data, _ := b.objectPaths(segmentContainer, segmentName)
+			files = append(files, data)
+		}
+	} else {
+		data, _ := b.objectPaths(container, name)
+		files = append(files, data)
+	}
+
+	rd = new(localReader)
+	var offset int64
+	for _, path := range files {
+		var file *os.File
+		var info os.FileInfo
+		if file, err = os.Open(path); err == nil {
+			info, err = file.Stat()
+		}
+		if err != nil {
+			if file != nil {
+				file.Close()
+			}
+			rd.Close()
+			if os.IsNotExist(err) {
+				err = swift.ObjectNotFound
+			}
+			return nil, meta, err
+		}
+		rd.segments = append(rd.segments, localSegment{
+			file:   file,
+			offset: offset,
+			size:   info.Size(),
+		})
+		offset += info.Size()
+	}
+	rd.SectionReader = io.NewSectionReader(rd, 0, offset)
+
+	return rd, meta, nil
+}
+
+// create prepares the upload of a new object.
+func (b *LocalBackend) create(container, name string, checkHash bool, hash, contentType string, h swift.Headers) (*localWriter, error) {
+	if _, err := b.readContainer(container); err != nil {
+		return nil, swift.ObjectNotFound
+	}
+
+	file, err := ioutil.TempFile(filepath.Join(b.root, container, localTempDir), "upload")
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType == "" {
+		contentType = h["Content-Type"]
+	}
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(name))
+	}
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+
+	return &localWriter{
+		b:         b,
+		container: container,
+		name:      name,
+		checkHash: checkHash,
+		hash:      hash,
+		file:      file,
+		md5:       md5.New(),
+		meta: localObject{
+			ContentType: contentType,
+			Headers:     objectHeaders(h),
+		},
+	}, nil
+}
+
+// containerNames lists container names matching options.
+func (b *LocalBackend) containerNames(opts *swift.ContainersOpts) ([]string, error) {
+	if opts == nil {
+		opts = new(swift.ContainersOpts)
+	}
+
+	entries, err := ioutil.ReadDir(b.root)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || !strings.HasPrefix(name, opts.Prefix) {
+			continue
+		}
+		if (opts.Marker != "" && name <= opts.Marker) || (opts.EndMarker != "" && name >= opts.EndMarker) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(b.root, name, localContainerFile)); err != nil {
+			continue
+		}
+		names = append(names, name)
+		if opts.Limit > 0 && len(names) == opts.Limit {
+			break
+		}
+	}
+
+	return names, nil
+}
+
+// manifestSegments lists segments referenced by a manifest header.
+func (b *LocalBackend) manifestSegments(manifest string) ([]swift.Object, error) {
+	container, prefix := splitManifest(manifest)
+	segments, err := b.objects(container, &swift.ObjectsOpts{Prefix: prefix})
+	if err == swift.ContainerNotFound {
+		return nil, nil
+	}
+	return segments, err
+}
+
+// objectPaths returns the data and metadata file paths of an object.
+func (b *LocalBackend) objectPaths(container, name string) (data, meta string) {
+	dir := filepath.Join(b.root, container)
+	escaped := escapeObjectName(name)
+	return filepath.Join(dir, localDataDir, escaped), filepath.Join(dir, localMetaDir, escaped)
+}
+
+// objects lists objects of a container matching options.
+func (b *LocalBackend) objects(container string, opts *swift.ObjectsOpts) ([]swift.Object, error) {
+	if opts == nil {
+		opts = new(swift.ObjectsOpts)
+	}
+
+	prefix, delimiter := opts.Prefix, opts.Delimiter
+	if opts.Path != "" {
+		prefix, delimiter = strings.TrimSuffix(opts.Path, "/")+"/", '/'
+	}
+
+	if _, err := b.readContainer(container); err != nil {
+		return nil, err
+	}
+
+	entries, err := ioutil.ReadDir(filepath.Join(b.root, container, localDataDir))
+	if err != nil {
+		return nil, err
+	}
+
+	// Escaped names don't sort like object names
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if name, err := url.PathUnescape(entry.Name()); err == nil && strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	objects := []swift.Object{}
+	for _, name := range names {
+		if delimiter != 0 {
+			if i := strings.IndexRune(name[len(prefix):], delimiter); i >= 0 {
+				name = name[:len(prefix)+i+1]
+				if n := len(objects); n > 0 && objects[n-1].Name == name {
+					continue
+				}
+				if !inListing(name, opts) {
+					continue
+				}
+				objects = append(objects, swift.Object{
+					Name:            name,
+					SubDir:          name,
+					PseudoDirectory: true,
+				})
+				if opts.Limit > 0 && len(objects) == opts.Limit {
+					break
+				}
+				continue
+			}
+		}
+
+		if !inListing(name, opts) {
+			continue
+		}
+
+		meta, size, err := b.readObject(container, name)
+		if err == swift.ObjectNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, meta.object(name, size))
+		if opts.Limit > 0 && len(objects) == opts.Limit {
+			break
+		}
+	}
+
+	return objects, nil
+}
+
+// readContainer returns the metadata of a container.
+func (b *LocalBackend) readContainer(container string) (swift.Headers, error) {
+	if !validContainerName(container) {
+		return nil, swift.ContainerNotFound
+	}
+
+	headers := make(swift.Headers)
+	err := readJSON(filepath.Join(b.root, container, localContainerFile), &headers)
+	if os.IsNotExist(err) {
+		return nil, swift.ContainerNotFound
+	}
+
+	return headers, err
+}
+
+// readObject returns the metadata and size of an object. Files put in
+// the directory by other means get default metadata.
+func (b *LocalBackend) readObject(container, name string) (meta localObject, size int64, err error) {
+	if name == "" || !validContainerName(container) {
+		return meta, 0, swift.ObjectNotFound
+	}
+
+	data, metaPath := b.objectPaths(container, name)
+	info, err := os.Stat(data)
+	if os.IsNotExist(err) {
+		return meta, 0, swift.ObjectNotFound
+	}
+	if err != nil {
+		return meta, 0, err
+	}
+
+	err = readJSON(metaPath, &meta)
+	if os.IsNotExist(err) {
+		meta.ContentType = mime.TypeByExtension(path.Ext(name))
+		if meta.ContentType == "" {
+			meta.ContentType = defaultContentType
+		}
+		meta.LastModified = info.ModTime().UTC()
+		err = nil
+	}
+	if err != nil {
+		return meta, 0, err
+	}
+
+	// Static large objects are listed with the size of their segments
+	if isStaticLargeObject(meta.Headers) {
+		segments, err := b.readStaticManifest(container, name)
+		if err != nil {
+			return meta, 0, err
+		}
+		size, meta.Hash = 0, staticManifestHash(segments)
+		for _, segment := range segments {
+			size += int64(segment.Size)
+		}
+		return meta, size, nil
+	}
+
+	return meta, info.Size(), nil
+}
+
+// readStaticManifest returns the segments referenced by a static
+// large object manifest.
+func (b *LocalBackend) readStaticManifest(container, name string) (segments []StaticSegment, err error) {
+	data, _ := b.objectPaths(container, name)
+	if err = readJSON(data, &segments); err != nil {
+		return nil, fmt.Errorf("Invalid static manifest %s : %v", name, err)
+	}
+	return segments, nil
+}
+
+// usage returns how many objects a container holds and their size.
+func (b *LocalBackend) usage(container string) (count, size int64, err error) {
+	entries, err := ioutil.ReadDir(filepath.Join(b.root, container, localDataDir))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, entry := range entries {
+		count++
+		size += entry.Size()
+	}
+
+	return count, size, nil
+}
+
+// object describes the object in a listing.
+func (o localObject) object(name string, size int64) swift.Object {
+	return swift.Object{
+		Name:               name,
+		ContentType:        o.ContentType,
+		Bytes:              size,
+		LastModified:       o.LastModified,
+		ServerLastModified: o.LastModified.Format(http.TimeFormat),
+		Hash:               o.Hash,
+	}
+}
+
+// headers returns the headers of the object as swift would.
+func (o localObject) headers(size int64) swift.Headers {
+	headers := swift.Headers{
+		"Content-Type":   o.ContentType,
+		"Content-Length": strconv.FormatInt(size, 10),
+		"Etag":           o.Hash,
+		"Last-Modified":  o.LastModified.Format(http.TimeFormat),
+		"X-Timestamp":    swift.TimeToFloatString(o.LastModified),
+	}
+	for k, v := range o.Headers {
+		headers[k] = v
+	}
+	return headers
+}
+
+// ReadAt reads data from the segments holding the given offset.
+func (r *localReader) ReadAt(p []byte, off int64) (n int, err error) {
+	for _, segment := range r.segments {
+		if n == len(p) {
+			break
+		}
+		if off+int64(n) >= segment.offset+segment.size {
+			continue
+		}
+		read, err := segment.file.ReadAt(p[n:min64(int64(len(p)), segment.offset+segment.size-off)], off+int64(n)-segment.offset)
+		n += read
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Close closes the files holding the object content.
+func (r *localReader) Close() (err error) {
+	for _, segment := range r.segments {
+		if e := segment.file.Close(); e != nil {
+			err = e
+		}
+	}
+	r.segments = nil
+	return err
+}
+
+// Write writes data to the temporary file.
+func (w *localWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.md5.Write(p[:n])
+	return n, err
+}
+
+// Close moves the object in place unless its hash doesn't match the
+// expected one.
+func (w *localWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if err := w.file.Close(); err != nil {
+		os.Remove(w.file.Name())
+		return err
+	}
+
+	w.meta.Hash = fmt.Sprintf("%x", w.md5.Sum(nil))
+	if w.checkHash && w.hash != "" && w.hash != w.meta.Hash {
+		os.Remove(w.file.Name())
+		return swift.ObjectCorrupted
+	}
+	w.meta.LastModified = time.Now().UTC()
+
+	w.b.mutex.Lock()
+	defer w.b.mutex.Unlock()
+
+	data, meta := w.b.objectPaths(w.container, w.name)
+	if err := writeJSON(meta, w.meta); err != nil {
+		os.Remove(w.file.Name())
+		return err
+	}
+	if err := os.Rename(w.file.Name(), data); err != nil {
+		os.Remove(w.file.Name())
+		return err
+	}
+
+	info, err := os.Stat(data)
+	if err != nil {
+		return err
+	}
+	w.headers = w.meta.headers(info.Size())
+
+	return nil
+}
+
+// abort discards the object.
+func (w *localWriter) abort() {
+	if !w.closed {
+		w.closed = true
+		w.file.Close()
+		os.Remove(w.file.Name())
+	}
+}
+
+// escapeObjectName turns an object name into a file name.
+func escapeObjectName(name string) string {
+	escaped := url.PathEscape(name)
+	if strings.HasPrefix(escaped, ".") {
+		escaped = "%2E" + escaped[1:]
+	}
+	return escaped
+}
+
+// objectHeaders returns headers stored along with an object.
+func objectHeaders(h swift.Headers) swift.Headers {
+	headers := make(swift.Headers)
+	for k, v := range h {
+		k = http.CanonicalHeaderKey(k)
+		if v == "" {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(k, objectMetaHeader):
+		case k == staticManifestHeader:
+		case !isPostedHeader(k):
+			continue
+		}
+		headers[k] = v
+	}
+	return headers
+}
+
+// isPostedHeader tells whether a header is kept by swift on metadata
+// updates as long as it is sent again.
+func isPostedHeader(key string) bool {
+	for _, k := range postedHeaders {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// inListing tells whether a name is within the bounds of a listing.
+func inListing(name string, opts *swift.ObjectsOpts) bool {
+	return (opts.Marker == "" || name > opts.Marker) && (opts.EndMarker == "" || name < opts.EndMarker)
+}
+
+// manifestHash computes the hash of a dynamic large object the way
+// swift does, from the hashes of its segments.
+func manifestHash(segments []swift.Object) string {
+	h := md5.New()
+	for _, segment := range segments {
+		io.WriteString(h, segment.Hash)
+	}
+	return fmt.Sprintf("\"%x\"", h.Sum(nil))
+}
+
+// staticManifestHash computes the hash of a static large object the way
+// swift does, from the hashes of its segments.
+func staticManifestHash(segments []StaticSegment) string {
+	h := md5.New()
+	for _, segment := range segments {
+		io.WriteString(h, segment.Etag)
+	}
+	return fmt.Sprintf("\"%x\"", h.Sum(nil))
+}
+
+// splitManifest returns the segment container and prefix referenced
+// by a manifest header.
+func splitManifest(manifest string) (container, prefix string) {
+	if decoded, err := url.PathUnescape(manifest); err == nil {
+		manifest = decoded
+	}
+	parts := strings.SplitN(manifest, "/", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+func validContainerName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func readJSON(path string, v interface{}) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, v)
+}
+
+// writeJSON atomically replaces a file with the JSON encoding of v.
+func writeJSON(path string, v interface{}) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(content); err == nil {
+		err = file.Close()
+	} else {
+		file.Close()
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+
+	return err
+}
+
+var (
+	_ Backend        = (*LocalBackend)(nil)
+	_ ObjectReader   = (*localReader)(nil)
+	_ io.ReaderAt    = (*localReader)(nil)
+	_ io.WriteCloser = (*localWriter)(nil)
+	_ writeAborter   = (*localWriter)(nil)
+)
@@ -0,0 +1,350 @@
+package svfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/xlucas/swift"
+)
+
+// localFSSuite is embedded by suites testing filesystem nodes on top
+// of a local storage holding an empty container. The storage lives in
+// a subdirectory of dir, leaving dir free for other test files.
+type localFSSuite struct {
+	suite.Suite
+	dir     string
+	backend *LocalBackend
+	fs      *SVFS
+}
+
+func (suite *localFSSuite) SetupTest() {
+	var err error
+	suite.dir, err = ioutil.TempDir("", "svfs-test")
+	require.NoError(suite.T(), err)
+
+	storage := filepath.Join(suite.dir, "storage")
+	require.NoError(suite.T(), os.Mkdir(storage, 0700))
+
+	suite.backend, err = NewLocalBackend(storage)
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), suite.backend.ContainerCreate("container", nil))
+
+	suite.fs = &SVFS{Storage: suite.backend}
+}
+
+func (suite *localFSSuite) TearDownTest() {
+	os.RemoveAll(suite.dir)
+}
+
+// object returns a node for an object of the test container.
+func (suite *localFSSuite) object(name string) *Object {
+	so, sh, err := suite.backend.Object("container", name)
+	require.NoError(suite.T(), err)
+	return &Object{
+		fs:   suite.fs,
+		name: name,
+		path: name,
+		so:   &so,
+		sh:   sh,
+		c:    &swift.Container{Name: "container"},
+	}
+}
+
+type LocalTestSuite struct {
+	suite.Suite
+	dir string
+	b   *LocalBackend
+}
+
+func (suite *LocalTestSuite) SetupTest() {
+	var err error
+	suite.dir, err = ioutil.TempDir("", "svfs-local")
+	require.NoError(suite.T(), err)
+	suite.b, err = NewLocalBackend(suite.dir)
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), suite.b.ContainerCreate("container", nil))
+	require.NoError(suite.T(), suite.b.ContainerCreate("container_segments", nil))
+}
+
+func (suite *LocalTestSuite) TearDownTest() {
+	os.RemoveAll(suite.dir)
+}
+
+func (suite *LocalTestSuite) put(container, name, content string) {
+	require.NoError(suite.T(), suite.b.ObjectPutBytes(container, name, []byte(content), ""))
+}
+
+func (suite *LocalTestSuite) read(container, name string, h swift.Headers) string {
+	rd, _, err := suite.b.ObjectOpen(container, name, false, h)
+	require.NoError(suite.T(), err)
+	defer rd.Close()
+	content, err := ioutil.ReadAll(rd)
+	require.NoError(suite.T(), err)
+	return string(content)
+}
+
+func (suite *LocalTestSuite) TestContainers() {
+	require.NoError(suite.T(), suite.b.ContainerCreate("other", swift.Headers{storagePolicyHeader: "PCA"}))
+
+	names, err := suite.b.ContainerNamesAll(nil)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []string{"container", "container_segments", "other"}, names)
+
+	_, h, err := suite.b.Container("other")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "PCA", h[storagePolicyHeader])
+
+	_, _, err = suite.b.Container("missing")
+	assert.Equal(suite.T(), swift.ContainerNotFound, err)
+
+	suite.put("other", "file", "content")
+	assert.Equal(suite.T(), swift.ContainerNotEmpty, suite.b.ContainerDelete("other"))
+	require.NoError(suite.T(), suite.b.ObjectDelete("other", "file"))
+	assert.NoError(suite.T(), suite.b.ContainerDelete("other"))
+	assert.Equal(suite.T(), swift.ContainerNotFound, suite.b.ContainerDelete("other"))
+}
+
+func (suite *LocalTestSuite) TestObject() {
+	suite.put("container", "dir/file.txt", "content")
+
+	object, h, err := suite.b.Object("container", "dir/file.txt")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(7), object.Bytes)
+	assert.Equal(suite.T(), "9a0364b9e99bb480dd25e1f0284c8555", object.Hash)
+	assert.True(suite.T(), strings.HasPrefix(object.ContentType, "text/plain"))
+	assert.Equal(suite.T(), "7", h["Content-Length"])
+
+	_, _, err = suite.b.Object("container", "dir")
+	assert.Equal(suite.T(), swift.ObjectNotFound, err)
+	assert.Equal(suite.T(), swift.ObjectNotFound, suite.b.ObjectDelete("container", "dir"))
+}
+
+func (suite *LocalTestSuite) TestListing() {
+	suite.put("container", "a", "")
+	suite.put("container", "a/b", "")
+	suite.put("container", "a/c/d", "")
+	suite.put("container", ".hidden", "")
+	suite.put("container", "e", "")
+
+	names, err := suite.b.ObjectNamesAll("container", nil)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []string{".hidden", "a", "a/b", "a/c/d", "e"}, names)
+
+	objects, err := suite.b.ObjectsAll("container", &swift.ObjectsOpts{Prefix: "a/", Delimiter: '/'})
+	assert.NoError(suite.T(), err)
+	require.Len(suite.T(), objects, 2)
+	assert.Equal(suite.T(), "a/b", objects[0].Name)
+	assert.Equal(suite.T(), "a/c/", objects[1].Name)
+	assert.True(suite.T(), objects[1].PseudoDirectory)
+
+	names, err = suite.b.ObjectNamesAll("container", &swift.ObjectsOpts{Marker: "a", Limit: 2})
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []string{"a/b", "a/c/d"}, names)
+}
+
+func (suite *LocalTestSuite) TestRange() {
+	suite.put("container", "file", "0123456789")
+
+	assert.Equal(suite.T(), "2345", suite.read("container", "file", swift.Headers{"Range": "bytes=2-5"}))
+	assert.Equal(suite.T(), "789", suite.read("container", "file", swift.Headers{"Range": "bytes=7-"}))
+	assert.Equal(suite.T(), "", suite.read("container", "file", swift.Headers{"Range": "bytes=20-"}))
+}
+
+func (suite *LocalTestSuite) TestManifest() {
+	suite.put("container_segments", "file/1/00000001", "0123")
+	suite.put("container_segments", "file/1/00000002", "4567")
+	suite.put("container_segments", "file/1/00000003", "89")
+	_, err := suite.b.ObjectPut("container", "file", strings.NewReader(""), false, "", "", swift.Headers{
+		manifestHeader: "container_segments/file/1",
+	})
+	require.NoError(suite.T(), err)
+
+	object, h, err := suite.b.Object("container", "file")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(10), object.Bytes)
+	assert.True(suite.T(), isSegmented(h))
+
+	listed, err := suite.b.ObjectsAll("container", nil)
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), isLargeObject(&listed[0]))
+
+	assert.Equal(suite.T(), "0123456789", suite.read("container", "file", nil))
+	assert.Equal(suite.T(), "3456", suite.read("container", "file", swift.Headers{"Range": "bytes=3-6"}))
+
+	// Copying a manifest keeps referencing segments
+	_, err = suite.b.ManifestCopy("container", "file", "container", "manifest", nil)
+	require.NoError(suite.T(), err)
+	_, h, err = suite.b.Object("container", "manifest")
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), isSegmented(h))
+
+	// Copying an object concatenates segments
+	_, err = suite.b.ObjectCopy("container", "file", "container", "copy", nil)
+	require.NoError(suite.T(), err)
+	_, h, err = suite.b.Object("container", "copy")
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), isSegmented(h))
+	assert.Equal(suite.T(), "0123456789", suite.read("container", "copy", nil))
+}
+
+func (suite *LocalTestSuite) TestStaticManifest() {
+	suite.put("container_segments", "file/1/00000001", "0123")
+	suite.put("container_segments", "file/1/00000002", "456789")
+	segments := []StaticSegment{
+		{Path: "/container_segments/file/1/00000001", Etag: "eb62f6b9306db575c2d596b1279627a4", Size: 4},
+		{Path: "/container_segments/file/1/00000002", Size: 6},
+	}
+	require.NoError(suite.T(), suite.b.CreateStaticManifest("container", "file", segments, swift.Headers{
+		objectMetaHeader + "A": "1",
+	}))
+
+	object, h, err := suite.b.Object("container", "file")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(10), object.Bytes)
+	assert.True(suite.T(), isStaticLargeObject(h))
+	assert.Equal(suite.T(), "1", h[objectMetaHeader+"A"])
+
+	listed, err := suite.b.ObjectsAll("container", nil)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(10), listed[0].Bytes)
+
+	assert.Equal(suite.T(), "0123456789", suite.read("container", "file", nil))
+	assert.Equal(suite.T(), "3456", suite.read("container", "file", swift.Headers{"Range": "bytes=3-6"}))
+
+	manifest, err := suite.b.GetStaticManifest("container", "file")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), segments, manifest)
+
+	// Metadata updates keep the manifest
+	require.NoError(suite.T(), suite.b.ObjectUpdate("container", "file", swift.Headers{objectMetaHeader + "B": "2"}))
+	_, h, err = suite.b.Object("container", "file")
+	assert.NoError(suite.T(), err)
+	assert.True(suite.T(), isStaticLargeObject(h))
+
+	// Copying a manifest keeps referencing segments
+	_, err = suite.b.ManifestCopy("container", "file", "container", "manifest", nil)
+	require.NoError(suite.T(), err)
+	manifest, err = suite.b.GetStaticManifest("container", "manifest")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), segments, manifest)
+
+	// Copying an object concatenates segments
+	_, err = suite.b.ObjectCopy("container", "file", "container", "copy", nil)
+	require.NoError(suite.T(), err)
+	_, h, err = suite.b.Object("container", "copy")
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), isStaticLargeObject(h))
+	assert.Equal(suite.T(), "0123456789", suite.read("container", "copy", nil))
+	_, err = suite.b.GetStaticManifest("container", "copy")
+	assert.Equal(suite.T(), errNotStaticLargeObject, err)
+
+	// Deleting the object removes its segments
+	require.NoError(suite.T(), suite.b.DeleteStaticLargeObject("container", "file"))
+	names, err := suite.b.ObjectNamesAll("container_segments", nil)
+	assert.NoError(suite.T(), err)
+	assert.Empty(suite.T(), names)
+}
+
+func (suite *LocalTestSuite) TestStaticManifestInvalidSegments() {
+	suite.put("container_segments", "file/1/00000001", "0123")
+
+	for _, segment := range []StaticSegment{
+		{Path: "/container_segments/file/1/00000002"},
+		{Path: "/container_segments/file/1/00000001", Size: 5},
+		{Path: "/container_segments/file/1/00000001", Etag: "d41d8cd98f00b204e9800998ecf8427e"},
+	} {
+		err := suite.b.CreateStaticManifest("container", "file", []StaticSegment{segment}, nil)
+		assert.Error(suite.T(), err)
+	}
+
+	_, _, err := suite.b.Object("container", "file")
+	assert.Equal(suite.T(), swift.ObjectNotFound, err)
+}
+
+func (suite *LocalTestSuite) TestUpdate() {
+	_, err := suite.b.ObjectPut("container", "file", strings.NewReader(""), false, "", "", swift.Headers{
+		manifestHeader:         "container_segments/file/1",
+		objectMetaHeader + "A": "1",
+	})
+	require.NoError(suite.T(), err)
+
+	require.NoError(suite.T(), suite.b.ManifestUpdate("container", "file", swift.Headers{
+		objectMetaHeader + "B": "2",
+	}))
+
+	// Headers not sent again are removed, as swift does
+	_, h, err := suite.b.Object("container", "file")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "", h[manifestHeader])
+	assert.Equal(suite.T(), "", h[objectMetaHeader+"A"])
+	assert.Equal(suite.T(), "2", h[objectMetaHeader+"B"])
+
+	require.NoError(suite.T(), suite.b.ManifestUpdate("container", "file", swift.Headers{
+		manifestHeader:     "container_segments/file/2",
+		"Content-Language": "en",
+	}))
+
+	_, h, err = suite.b.Object("container", "file")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "container_segments/file/2", h[manifestHeader])
+	assert.Equal(suite.T(), "en", h["Content-Language"])
+	assert.Equal(suite.T(), "", h[objectMetaHeader+"B"])
+}
+
+func (suite *LocalTestSuite) TestMove() {
+	_, err := suite.b.ObjectPut("container", "file", strings.NewReader("content"), false, "", "", swift.Headers{
+		objectMetaHeader + "A": "1",
+	})
+	require.NoError(suite.T(), err)
+
+	require.NoError(suite.T(), suite.b.ObjectMove("container", "file", "container_segments", "file/1/00000001"))
+
+	_, _, err = suite.b.Object("container", "file")
+	assert.Equal(suite.T(), swift.ObjectNotFound, err)
+	_, h, err := suite.b.Object("container_segments", "file/1/00000001")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "1", h[objectMetaHeader+"A"])
+	assert.Equal(suite.T(), "content", suite.read("container_segments", "file/1/00000001", nil))
+}
+
+func (suite *LocalTestSuite) TestStreamedUpload() {
+	w, err := suite.b.ObjectCreate("container", "file", true, "9a0364b9e99bb480dd25e1f0284c8555", "", nil)
+	require.NoError(suite.T(), err)
+
+	// Objects are only visible once uploaded
+	w.Write([]byte("content"))
+	_, _, err = suite.b.Object("container", "file")
+	assert.Equal(suite.T(), swift.ObjectNotFound, err)
+
+	require.NoError(suite.T(), w.Close())
+	assert.Equal(suite.T(), "content", suite.read("container", "file", nil))
+
+	w, err = suite.b.ObjectCreate("container", "corrupted", true, "0000", "", nil)
+	require.NoError(suite.T(), err)
+	w.Write([]byte("content"))
+	assert.Equal(suite.T(), swift.ObjectCorrupted, w.Close())
+
+	_, err = suite.b.ObjectCreate("missing", "file", false, "", "", nil)
+	assert.Equal(suite.T(), swift.ObjectNotFound, err)
+}
+
+func (suite *LocalTestSuite) TestAccount() {
+	suite.put("container", "file", "content")
+
+	account, _, err := suite.b.Account()
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(2), account.Containers)
+	assert.Equal(suite.T(), int64(1), account.Objects)
+	assert.Equal(suite.T(), int64(7), account.BytesUsed)
+	assert.True(suite.T(), account.Quota >= account.BytesUsed)
+}
+
+func TestLocalSuite(t *testing.T) {
+	suite.Run(t, new(LocalTestSuite))
+}
@@ -0,0 +1,239 @@
+package svfs
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"bazil.org/fuse"
+	"golang.org/x/net/context"
+)
+
+var (
+	fuseRequestDuration = newHistogram([]float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}, "op")
+	swiftRequests       = newCounter("method", "status")
+	authRequests        = newCounter("status")
+	bytesRead           = newCounter()
+	bytesWritten        = newCounter()
+	cacheHits           = newCounter()
+	cacheMisses         = newCounter()
+	cacheEvictions      = newCounter()
+	listerQueueDepth    int64
+	segmentUploads      int64
+	metrics             = []metricFamily{
+		{"svfs_fuse_request_duration_seconds", "Latency of FUSE requests by operation.", "histogram", fuseRequestDuration},
+		{"svfs_swift_requests_total", "Requests sent to swift by method and status.", "counter", swiftRequests},
+		{"svfs_auth_requests_total", "Authentication requests by status.", "counter", authRequests},
+		{"svfs_read_bytes_total", "Bytes read from files.", "counter", bytesRead},
+		{"svfs_written_bytes_total", "Bytes written to files.", "counter", bytesWritten},
+		{"svfs_directory_cache_hits_total", "Directory listings served from cache.", "counter", cacheHits},
+		{"svfs_directory_cache_misses_total", "Directory listings missing from cache.", "counter", cacheMisses},
+		{"svfs_directory_cache_evictions_total", "Directory listings evicted from cache.", "counter", cacheEvictions},
+		{"svfs_lister_queue_depth", "Objects waiting to be fetched by the directory lister.", "gauge", &gauge{&listerQueueDepth}},
+		{"svfs_segment_uploads_in_flight", "Segments being uploaded.", "gauge", &gauge{&segmentUploads}},
+	}
+)
+
+// metric is a set of samples sharing the same name.
+type metric interface {
+	write(buf *bytes.Buffer, name string)
+}
+
+type metricFamily struct {
+	name   string
+	help   string
+	kind   string
+	metric metric
+}
+
+// counter is a monotonic value, optionally split by labels.
+type counter struct {
+	mutex  sync.Mutex
+	labels []string
+	values map[string]float64
+}
+
+// histogram counts observations within buckets, optionally
+// split by labels.
+type histogram struct {
+	mutex   sync.Mutex
+	labels  []string
+	buckets []float64
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// gauge reads an integer updated atomically.
+type gauge struct {
+	value *int64
+}
+
+func newCounter(labels ...string) *counter {
+	return &counter{labels: labels, values: make(map[string]float64)}
+}
+
+func newHistogram(buckets []float64, labels ...string) *histogram {
+	return &histogram{labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+}
+
+// MetricsHandler returns an HTTP handler serving metrics in
+// the Prometheus text format.
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(writeMetrics())
+	})
+}
+
+// WithMetrics records the duration of a FUSE request, which ends when
+// its context is canceled.
+func WithMetrics(ctx context.Context, req fuse.Request) context.Context {
+	var (
+		start = time.Now()
+		op    = strings.TrimSuffix(reflect.Indirect(reflect.ValueOf(req)).Type().Name(), "Request")
+	)
+	go func() {
+		<-ctx.Done()
+		fuseRequestDuration.Observe(time.Since(start).Seconds(), op)
+	}()
+	return ctx
+}
+
+func writeMetrics() []byte {
+	var buf bytes.Buffer
+	for _, family := range metrics {
+		fmt.Fprintf(&buf, "# HELP %s %s\n", family.name, family.help)
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", family.name, family.kind)
+		family.metric.write(&buf, family.name)
+	}
+	return buf.Bytes()
+}
+
+// Add increases the counter value for the given label values.
+func (c *counter) Add(value float64, labels ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.values[strings.Join(labels, "\xff")] += value
+}
+
+// Inc increases the counter value by one for the given label values.
+func (c *counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+func (c *counter) write(buf *bytes.Buffer, name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.values) == 0 && len(c.labels) == 0 {
+		fmt.Fprintf(buf, "%s 0\n", name)
+		return
+	}
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(buf, "%s%s %s\n", name, braces(formatLabels(c.labels, key)), formatValue(c.values[key]))
+	}
+}
+
+// Observe adds an observation for the given label values.
+func (h *histogram) Observe(value float64, labels ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	key := strings.Join(labels, "\xff")
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+func (h *histogram) write(buf *bytes.Buffer, name string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var (
+			s      = h.series[key]
+			labels = formatLabels(h.labels, key)
+			sep    = ""
+		)
+		if labels != "" {
+			sep = ","
+		}
+		for i, bound := range h.buckets {
+			fmt.Fprintf(buf, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, sep, formatValue(bound), s.counts[i])
+		}
+		fmt.Fprintf(buf, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, s.count)
+		fmt.Fprintf(buf, "%s_sum%s %s\n", name, braces(labels), formatValue(s.sum))
+		fmt.Fprintf(buf, "%s_count%s %d\n", name, braces(labels), s.count)
+	}
+}
+
+func (g *gauge) write(buf *bytes.Buffer, name string) {
+	fmt.Fprintf(buf, "%s %d\n", name, atomic.LoadInt64(g.value))
+}
+
+func formatLabels(names []string, key string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	values := strings.Split(key, "\xff")
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		var value string
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", name, escapeLabel(value))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
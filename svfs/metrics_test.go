@@ -0,0 +1,121 @@
+package svfs
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type MetricsTestSuite struct {
+	suite.Suite
+	buf *bytes.Buffer
+}
+
+func (suite *MetricsTestSuite) SetupTest() {
+	suite.buf = new(bytes.Buffer)
+}
+
+func (suite *MetricsTestSuite) TestCounterWithoutLabels() {
+	c := newCounter()
+	c.write(suite.buf, "test_total")
+	assert.Equal(suite.T(), "test_total 0\n", suite.buf.String())
+
+	suite.buf.Reset()
+	c.Add(2.5)
+	c.Inc()
+	c.write(suite.buf, "test_total")
+	assert.Equal(suite.T(), "test_total 3.5\n", suite.buf.String())
+}
+
+func (suite *MetricsTestSuite) TestCounterWithLabels() {
+	c := newCounter("method", "status")
+	c.write(suite.buf, "test_total")
+	assert.Empty(suite.T(), suite.buf.String())
+
+	c.Inc("PUT", "201")
+	c.Inc("GET", "200")
+	c.Inc("GET", "200")
+	c.write(suite.buf, "test_total")
+	assert.Equal(suite.T(),
+		"test_total{method=\"GET\",status=\"200\"} 2\n"+
+			"test_total{method=\"PUT\",status=\"201\"} 1\n",
+		suite.buf.String())
+}
+
+func (suite *MetricsTestSuite) TestHistogram() {
+	h := newHistogram([]float64{0.1, 1}, "op")
+	h.Observe(0.05, "Read")
+	h.Observe(0.5, "Read")
+	h.Observe(2, "Read")
+	h.write(suite.buf, "test_seconds")
+	assert.Equal(suite.T(),
+		"test_seconds_bucket{op=\"Read\",le=\"0.1\"} 1\n"+
+			"test_seconds_bucket{op=\"Read\",le=\"1\"} 2\n"+
+			"test_seconds_bucket{op=\"Read\",le=\"+Inf\"} 3\n"+
+			"test_seconds_sum{op=\"Read\"} 2.55\n"+
+			"test_seconds_count{op=\"Read\"} 3\n",
+		suite.buf.String())
+}
+
+func (suite *MetricsTestSuite) TestHistogramWithoutLabels() {
+	h := newHistogram([]float64{1})
+	h.Observe(0.5)
+	h.write(suite.buf, "test_seconds")
+	assert.Equal(suite.T(),
+		"test_seconds_bucket{le=\"1\"} 1\n"+
+			"test_seconds_bucket{le=\"+Inf\"} 1\n"+
+			"test_seconds_sum 0.5\n"+
+			"test_seconds_count 1\n",
+		suite.buf.String())
+}
+
+func (suite *MetricsTestSuite) TestLabelEscaping() {
+	c := newCounter("path")
+	c.Inc("a\"b\\c\nd")
+	c.write(suite.buf, "test_total")
+	assert.Equal(suite.T(), "test_total{path=\"a\\\"b\\\\c\\nd\"} 1\n", suite.buf.String())
+}
+
+func (suite *MetricsTestSuite) TestGauge() {
+	value := int64(3)
+	(&gauge{&value}).write(suite.buf, "test_depth")
+	assert.Equal(suite.T(), "test_depth 3\n", suite.buf.String())
+}
+
+func (suite *MetricsTestSuite) TestHandler() {
+	rec := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(suite.T(), http.StatusOK, rec.Code)
+	assert.Contains(suite.T(), rec.Header().Get("Content-Type"), "text/plain")
+	assert.Contains(suite.T(), rec.Body.String(), "# TYPE svfs_swift_requests_total counter\n")
+	assert.Contains(suite.T(), rec.Body.String(), "# TYPE svfs_lister_queue_depth gauge\n")
+}
+
+func (suite *MetricsTestSuite) TestTransportCountsRequests() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	swiftRequests.mutex.Lock()
+	before := swiftRequests.values["HEAD\xff204"]
+	swiftRequests.mutex.Unlock()
+
+	req, _ := http.NewRequest("HEAD", server.URL, nil)
+	req.Header.Set("X-Auth-Token", "token")
+	resp, err := newSwiftTransport(new(retryAfterTracker)).RoundTrip(req)
+	assert.NoError(suite.T(), err)
+	resp.Body.Close()
+
+	swiftRequests.mutex.Lock()
+	defer swiftRequests.mutex.Unlock()
+	assert.Equal(suite.T(), before+1, swiftRequests.values["HEAD\xff204"])
+}
+
+func TestMetricsSuite(t *testing.T) {
+	suite.Run(t, new(MetricsTestSuite))
+}
@@ -0,0 +1,51 @@
+package svfs
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/xlucas/swift"
+)
+
+// readMimeTypes reads a file mapping content types to file extensions,
+// using the mime.types format : each line holds a content type followed
+// by extensions, lines starting with # being ignored. It returns the
+// content type of each extension, prefixed with a dot.
+func readMimeTypes(file string) (map[string]string, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	types := make(map[string]string)
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		for _, ext := range fields[1:] {
+			types["."+strings.ToLower(strings.TrimPrefix(ext, "."))] = fields[0]
+		}
+	}
+
+	return types, scanner.Err()
+}
+
+// contentType returns the content type mapped to the extension of the
+// given object name, or an empty string to let the storage detect it.
+func (s *SVFS) contentType(name string) string {
+	return s.mimeTypes[strings.ToLower(path.Ext(name))]
+}
+
+// contentTypeHeaders returns headers setting the content type mapped to
+// the extension of the given object name, if any.
+func (s *SVFS) contentTypeHeaders(name string) swift.Headers {
+	if contentType := s.contentType(name); contentType != "" {
+		return swift.Headers{"Content-Type": contentType}
+	}
+	return nil
+}
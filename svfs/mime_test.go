@@ -0,0 +1,72 @@
+package svfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type MimeTestSuite struct {
+	localFSSuite
+}
+
+func (suite *MimeTestSuite) SetupTest() {
+	suite.localFSSuite.SetupTest()
+
+	file := filepath.Join(suite.dir, "mime.types")
+	require.NoError(suite.T(), ioutil.WriteFile(file, []byte(
+		"# Web content\n"+
+			"text/html\t\thtml .htm\n"+
+			"\n"+
+			"application/x-custom    CUSTOM\n"+
+			"application/x-empty\n",
+	), 0600))
+
+	var err error
+	suite.fs.mimeTypes, err = readMimeTypes(file)
+	require.NoError(suite.T(), err)
+}
+
+func (suite *MimeTestSuite) TestReadMimeTypes() {
+	assert.Equal(suite.T(), map[string]string{
+		".html":   "text/html",
+		".htm":    "text/html",
+		".custom": "application/x-custom",
+	}, suite.fs.mimeTypes)
+}
+
+func (suite *MimeTestSuite) TestReadMissing() {
+	_, err := readMimeTypes(filepath.Join(suite.dir, "missing"))
+	assert.True(suite.T(), os.IsNotExist(err))
+}
+
+func (suite *MimeTestSuite) TestContentType() {
+	assert.Equal(suite.T(), "application/x-custom", suite.fs.contentType("dir/file.Custom"))
+	assert.Empty(suite.T(), suite.fs.contentType("dir/file.txt"))
+	assert.Empty(suite.T(), suite.fs.contentType("dir/file"))
+	assert.Nil(suite.T(), suite.fs.contentTypeHeaders("file.txt"))
+}
+
+func (suite *MimeTestSuite) TestNewWriter() {
+	for name, contentType := range map[string]string{
+		"file.custom": "application/x-custom",
+		"file.txt":    "text/plain; charset=utf-8",
+	} {
+		w, err := suite.fs.newWriter("container", name, nil)
+		require.NoError(suite.T(), err)
+		require.NoError(suite.T(), w.Close())
+
+		object, _, err := suite.backend.Object("container", name)
+		require.NoError(suite.T(), err)
+		assert.Equal(suite.T(), contentType, object.ContentType, name)
+	}
+}
+
+func TestMimeSuite(t *testing.T) {
+	suite.Run(t, new(MimeTestSuite))
+}
@@ -1,13 +1,10 @@
 package svfs
 
 import (
-	"bytes"
-	"encoding/hex"
-	"os"
 	"regexp"
-	"sort"
-	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
@@ -21,17 +18,21 @@ const (
 	manifestHeader        = "X-Object-Manifest"
 	objectMetaHeader      = "X-Object-Meta-"
 	objectMetaHeaderXattr = objectMetaHeader + "Xattr-"
+	objectMtimeHeader     = objectMetaHeader + "Mtime"
+	objectModeHeader      = objectMetaHeader + "Mode"
+	objectUIDHeader       = objectMetaHeader + "Uid"
+	objectGIDHeader       = objectMetaHeader + "Gid"
 )
 
 var (
-	objectMtimeHeader = objectMetaHeader + "Mtime"
-	segmentPathRegex  = regexp.MustCompile("^([^/]+)/(.*)$")
+	segmentPathRegex = regexp.MustCompile("^([^/]+)/(.*)$")
 )
 
 // Object is a node representing a swift object.
 // It belongs to a container and segmented objects
 // are bound to a container of segments.
 type Object struct {
+	fs        *SVFS
 	name      string
 	path      string
 	so        *swift.Object
@@ -39,20 +40,29 @@ type Object struct {
 	c         *swift.Container
 	cs        *swift.Container
 	p         *Directory
+	sf        *SpoolFile
 	m         sync.Mutex
 	segmented bool
 	writing   bool
+	// staging guards sf and its content, since m is held by
+	// write handles as long as they are open.
+	staging sync.Mutex
+	// writers counts handles modifying the object, guarded
+	// by the write-back queue.
+	writers int
+	// uploaded is the sequence number of the last write-back
+	// upload recorded in the node, guarded by m.
+	uploaded uint64
 }
 
 // Attr fills the file attributes for an object node.
 func (o *Object) Attr(ctx context.Context, a *fuse.Attr) (err error) {
 	a.Size = o.size()
-	a.BlockSize = uint32(BlockSize)
+	a.BlockSize = uint32(o.fs.BlockSize)
 	a.Blocks = (a.Size / uint64(a.BlockSize)) * 8
-	a.Mode = os.FileMode(DefaultMode)
-	a.Gid = uint32(DefaultGID)
-	a.Uid = uint32(DefaultUID)
-	a.Mtime = getMtime(o.so, o.sh)
+	a.Mode = o.fs.getMode(o.sh)
+	a.Uid, a.Gid = o.fs.getOwner(o.sh)
+	a.Mtime = o.fs.getMtime(o.so, o.sh)
 	a.Ctime = a.Mtime
 	a.Crtime = a.Mtime
 	return nil
@@ -60,17 +70,22 @@ func (o *Object) Attr(ctx context.Context, a *fuse.Attr) (err error) {
 
 // Getxattr retrieves extended attributes of an object node.
 func (o *Object) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
-	if !Xattr {
+	if !o.fs.Xattr {
 		return fuse.ENOTSUP
 	}
 
-	key := canonicalHeaderKey(objectMetaHeaderXattr + req.Name)
-	value, err := hex.DecodeString(o.sh[key])
+	if name, ok := systemXattr(req.Name); ok {
+		value, err := o.fs.getObjectSystemXattr(o.c.Name, o.sh, name)
+		resp.Xattr = value
+		return err
+	}
+
+	value, err := getXattr(o.sh, req.Name)
 	if err != nil {
 		return err
 	}
 
-	resp.Xattr = []byte(value)
+	resp.Xattr = value
 	return nil
 }
 
@@ -83,56 +98,79 @@ func (o *Object) Export() fuse.Dirent {
 }
 
 // Fsync synchronizes a file's in-core state with the storage device.
-// This is a no-op since we are in a network, fully synchronous, filesystem.
+// This is a no-op unless write-back mode is enabled, in which case it
+// waits for the current content of the file to be uploaded.
 func (o *Object) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
-	return nil
+	if !o.fs.WriteBack {
+		return nil
+	}
+
+	if err := o.enqueueStaged(); err != nil {
+		return err
+	}
+
+	return o.fs.writeBackQueue.Wait(o.c.Name, o.path)
 }
 
-// Listxattr lists extended attributes associated with this object node.
-func (o *Object) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
-	var keys []string
+// enqueueStaged queues a snapshot of the content being written.
+func (o *Object) enqueueStaged() error {
+	o.staging.Lock()
+	defer o.staging.Unlock()
 
-	if !Xattr {
-		return fuse.ENOTSUP
+	sf := o.sf
+	if sf == nil || !sf.dirty {
+		return nil
 	}
 
-	for k := range o.sh.ObjectMetadataXattr().Headers(objectMetaHeaderXattr) {
-		keys = append(keys, k)
+	clone, err := sf.Clone()
+	if err != nil {
+		return err
+	}
+	if err := o.fs.writeBackQueue.Enqueue(o, clone); err != nil {
+		clone.Remove()
+		return err
 	}
+	sf.dirty = false
 
-	sort.Strings(keys)
+	return nil
+}
 
-	for _, key := range keys {
-		resp.Append(strings.TrimPrefix(key, objectMetaHeaderXattr))
+// Listxattr lists extended attributes associated with this object node.
+func (o *Object) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	if !o.fs.Xattr {
+		return fuse.ENOTSUP
 	}
 
+	listXattr(o.sh, resp)
 	return nil
 }
 
 // Open returns the file handle associated with this object node.
 func (o *Object) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
-	return o.open(req.Flags, &resp.Flags)
+	fh, err := o.open(req.Flags, &resp.Flags)
+	if err != nil {
+		return nil, err
+	}
+	o.fs.openHandles.add(fh)
+	return fh, nil
 }
 
 // Removexattr removes an extended attribute on this object node.
 func (o *Object) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
-	if !Xattr {
+	if !o.fs.Xattr {
 		return fuse.ENOTSUP
 	}
 
-	if _, ok := o.sh[objectMetaHeaderXattr+req.Name]; ok {
+	if name, ok := systemXattr(req.Name); ok {
+		return o.setSystemXattr(name, nil)
+	}
+
+	if changes := xattrChanges(o.sh, req.Name, nil); changes != nil {
 		if o.writing {
 			o.m.Lock()
 			defer o.m.Unlock()
 		}
-		key := canonicalHeaderKey(objectMetaHeaderXattr + req.Name)
-		h := o.sh.ObjectMetadataXattr().Headers(objectMetaHeaderXattr)
-		delete(h, key)
-		delete(o.sh, key)
-		if o.segmented {
-			return SwiftConnection.ManifestUpdate(o.c.Name, o.so.Name, h)
-		}
-		return SwiftConnection.ObjectUpdate(o.c.Name, o.so.Name, h)
+		return o.updateMetadata(changes)
 	}
 
 	return nil
@@ -144,110 +182,162 @@ func (o *Object) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fu
 	// be used by the kernel to truncate files instead of opening
 	// them with O_TRUNC flag.
 	if req.Valid.Size() {
+		o.staging.Lock()
 		o.so.Bytes = int64(req.Size)
-		if req.Size == 0 && o.segmented {
+		if o.sf != nil {
+			defer o.staging.Unlock()
+			return o.sf.Truncate(req.Size)
+		}
+		o.staging.Unlock()
+		if req.Size != 0 {
+			return nil
+		}
+		if err := o.loadHeaders(); err != nil {
+			return err
+		}
+		if o.segmented {
 			return o.removeSegments()
 		}
 		return nil
 	}
 
-	if !Attr || !req.Valid.Mtime() {
-		return fuse.ENOTSUP
+	changes, err := o.fs.attrChanges(o.so, o.sh, req)
+	if err != nil || len(changes) == 0 {
+		return err
 	}
 
-	// Change mtime
-	if !req.Mtime.Equal(getMtime(o.so, o.sh)) {
-		if o.writing {
-			o.m.Lock()
-			defer o.m.Unlock()
-		}
-		h := o.sh.ObjectMetadata().Headers(objectMetaHeader)
-		o.sh[objectMtimeHeader] = formatTime(req.Mtime)
-		h[objectMtimeHeader] = o.sh[objectMtimeHeader]
-		if o.segmented {
-			return SwiftConnection.ManifestUpdate(o.c.Name, o.so.Name, h)
-		}
-		return SwiftConnection.ObjectUpdate(o.c.Name, o.so.Name, h)
+	if o.writing {
+		o.m.Lock()
+		defer o.m.Unlock()
 	}
-
-	return nil
+	return o.updateMetadata(changes)
 }
 
 // Setxattr changes an extended attribute on the current node.
 func (o *Object) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
-	if !Xattr {
+	if !o.fs.Xattr {
 		return fuse.ENOTSUP
 	}
 
-	if !bytes.Equal(req.Xattr, []byte(o.sh[objectMetaHeaderXattr+req.Name])) {
+	if name, ok := systemXattr(req.Name); ok {
+		return o.setSystemXattr(name, req.Xattr)
+	}
+
+	if changes := xattrChanges(o.sh, req.Name, req.Xattr); changes != nil {
 		if o.writing {
 			o.m.Lock()
 			defer o.m.Unlock()
 		}
-		key := canonicalHeaderKey(objectMetaHeaderXattr + req.Name)
-		value := hex.EncodeToString(req.Xattr)
-		h := o.sh.ObjectMetadataXattr().Headers(objectMetaHeaderXattr)
-		o.sh[key] = value
-		h[key] = o.sh[key]
+		return o.updateMetadata(changes)
+	}
 
-		if o.segmented {
-			return SwiftConnection.ManifestUpdate(o.c.Name, o.so.Name, h)
-		}
-		return SwiftConnection.ObjectUpdate(o.c.Name, o.so.Name, h)
+	return nil
+}
+
+// setSystemXattr changes an attribute of the svfs namespace stored in
+// object headers, an empty value removing it. Other system metadata
+// are read-only.
+func (o *Object) setSystemXattr(name string, value []byte) error {
+	header := objectSystemXattrs[name]
+	if !objectWritableXattrs[name] || (header == "Content-Type" && len(value) == 0) {
+		return fuse.EPERM
+	}
+	if !validHeaderValue(value) {
+		return fuse.Errno(syscall.EINVAL)
+	}
+	if o.sh[header] == string(value) {
+		return nil
+	}
+
+	if o.writing {
+		o.m.Lock()
+		defer o.m.Unlock()
+	}
+	if err := o.updateMetadata(map[string]string{header: string(value)}); err != nil {
+		return err
+	}
+	if header == "Content-Type" {
+		o.so.ContentType = string(value)
 	}
 
 	return nil
 }
 
+// updateMetadata stores metadata changes in the object.
+func (o *Object) updateMetadata(changes map[string]string) error {
+	// Headers not sent again are removed by swift, including
+	// the manifest of segmented objects.
+	if err := o.loadHeaders(); err != nil {
+		return err
+	}
+	if o.sh == nil {
+		o.sh = swift.Headers{}
+	}
+	h := updateMetadata(o.sh, changes)
+	if o.segmented {
+		return o.fs.Storage.ManifestUpdate(o.c.Name, o.so.Name, h)
+	}
+	return o.fs.Storage.ObjectUpdate(o.c.Name, o.so.Name, h)
+}
+
 // Name gets the name of the underlying swift object.
 func (o *Object) Name() string {
 	return o.name
 }
 
 func (o *Object) copy(dir *Directory, name string) (copy *Object, err error) {
-	if o.segmented {
-		_, err = SwiftConnection.ManifestCopy(o.c.Name, o.path, dir.c.Name, dir.path+name, nil)
-	} else {
-		_, err = SwiftConnection.ObjectCopy(o.c.Name, o.path, dir.c.Name, dir.path+name, nil)
+	// Files not uploaded yet can't be copied server-side
+	if err = o.fs.writeBackQueue.Wait(o.c.Name, o.path); err != nil {
+		return nil, err
 	}
 
+	// Listings don't tell static large objects apart, always copy
+	// manifests as is
+	_, err = o.fs.Storage.ManifestCopy(o.c.Name, o.path, dir.c.Name, dir.path+name, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	object := *o
-	*object.so = *o.so
-	object.c = dir.c
-	object.cs = dir.cs
-	object.p = dir
-	object.name = name
-	object.path = dir.path + name
-	object.so.Name = dir.path + name
+	so := *o.so
+	so.Name = dir.path + name
 
-	directoryCache.Set(dir.c.Name, dir.path, name, &object)
+	sh := make(swift.Headers, len(o.sh))
+	for k, v := range o.sh {
+		sh[k] = v
+	}
 
-	return &object, nil
+	object := &Object{
+		fs:        o.fs,
+		name:      name,
+		path:      dir.path + name,
+		so:        &so,
+		sh:        sh,
+		c:         dir.c,
+		cs:        dir.cs,
+		p:         dir,
+		segmented: o.segmented,
+	}
+
+	o.fs.directoryCache.Set(dir.c.Name, dir.path, name, object)
+
+	return object, nil
 }
 
 func (o *Object) delete() error {
-	directoryCache.Delete(o.c.Name, o.p.path, o.name)
-	return SwiftConnection.ObjectDelete(o.c.Name, o.path)
+	o.fs.directoryCache.Delete(o.c.Name, o.p.path, o.name)
+	return o.fs.Storage.ObjectDelete(o.c.Name, o.path)
 }
 
 func (o *Object) open(mode fuse.OpenFlags, flags *fuse.OpenResponseFlags) (*ObjectHandle, error) {
 	oh := &ObjectHandle{
 		target: o,
 		create: mode&fuse.OpenCreate == fuse.OpenCreate,
-	}
-
-	// Unsupported flags
-	if mode&fuse.OpenAppend == fuse.OpenAppend {
-		return nil, fuse.ENOTSUP
+		opened: time.Now(),
 	}
 
 	// Supported flags
 	if mode.IsReadOnly() {
-		if TransferMode&SkipOpenRead == 0 {
+		if o.fs.TransferMode&SkipOpenRead == 0 {
 			rd, err := newReader(oh)
 			if err == swift.TooManyRequests {
 				return nil, fuse.EAGAIN
@@ -259,9 +349,22 @@ func (o *Object) open(mode fuse.OpenFlags, flags *fuse.OpenResponseFlags) (*Obje
 
 		return oh, nil
 	}
-	if mode.IsWriteOnly() {
-		o.m.Lock()
-		changeCache.Add(o.c.Name, o.path, o)
+	if !mode.IsWriteOnly() && !mode.IsReadWrite() {
+		return nil, fuse.ENOTSUP
+	}
+
+	o.m.Lock()
+	if err := oh.loadHeaders(); err != nil {
+		o.m.Unlock()
+		return nil, err
+	}
+	oh.locked = true
+	oh.append = mode&fuse.OpenAppend == fuse.OpenAppend
+
+	// Appending to an object not waiting to be uploaded adds segments
+	// to swift directly, even in write-back mode.
+	if mode.IsWriteOnly() && (!o.fs.WriteBack || oh.append && o.fs.writeBackQueue.State(o.c.Name, o.path) == "") {
+		o.fs.writeBackQueue.Acquire(o)
 
 		*flags |= fuse.OpenNonSeekable
 		*flags |= fuse.OpenDirectIO
@@ -269,7 +372,17 @@ func (o *Object) open(mode fuse.OpenFlags, flags *fuse.OpenResponseFlags) (*Obje
 		return oh, nil
 	}
 
-	return nil, fuse.ENOTSUP
+	// Files are otherwise staged in write-back mode. Writing without
+	// appending replaces the content, as when writing to swift.
+	oh.truncated = mode&fuse.OpenTruncate == fuse.OpenTruncate ||
+		mode.IsWriteOnly() && !oh.append
+	if err := oh.stage(); err != nil {
+		o.m.Unlock()
+		return nil, err
+	}
+	o.fs.writeBackQueue.Acquire(o)
+
+	return oh, nil
 }
 
 func (o *Object) rename(dir *Directory, name string) error {
@@ -283,20 +396,92 @@ func (o *Object) rename(dir *Directory, name string) error {
 		return err
 	}
 
-	*o = *copy
+	o.name = copy.name
+	o.path = copy.path
+	o.so = copy.so
+	o.c = copy.c
+	o.cs = copy.cs
+	o.p = copy.p
+
+	o.fs.directoryCache.Set(o.c.Name, o.p.path, o.name, o)
+
+	return nil
+}
+
+// loadHeaders fetches object headers unless already known. Listings
+// report the full size of static large objects, which are only told
+// apart from standard objects by their headers.
+func (o *Object) loadHeaders() error {
+	if len(o.sh) > 0 {
+		return nil
+	}
+
+	_, h, err := o.fs.Storage.Object(o.c.Name, o.path)
+	if err == swift.ObjectNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	o.sh = h
+	o.segmented = isSegmented(h)
 
 	return nil
 }
 
 func (o *Object) removeSegments() error {
 	o.segmented = false
-	if err := deleteSegments(o.cs.Name, o.sh[manifestHeader]); err != nil {
+
+	// Static large objects can't exist without their segments,
+	// so they are replaced with an empty object.
+	if isStaticLargeObject(o.sh) {
+		if err := o.fs.Storage.DeleteStaticLargeObject(o.c.Name, o.path); err != nil {
+			return err
+		}
+		delete(o.sh, staticManifestHeader)
+		return o.fs.Storage.ObjectPutBytes(o.c.Name, o.path, nil, o.fs.contentType(o.path))
+	}
+
+	if err := o.fs.deleteSegments(o.cs.Name, o.sh[manifestHeader]); err != nil {
 		return err
 	}
 	delete(o.sh, manifestHeader)
 	return nil
 }
 
+// segmentPaths returns the segments of a large object, as referenced
+// within static large object manifests.
+func (o *Object) segmentPaths() (paths []string, err error) {
+	switch {
+	case isStaticLargeObject(o.sh):
+		segments, err := o.fs.Storage.GetStaticManifest(o.c.Name, o.path)
+		if err != nil {
+			return nil, err
+		}
+		for _, segment := range segments {
+			paths = append(paths, segment.Path)
+		}
+
+	case o.sh[manifestHeader] != "":
+		prefix, err := manifestPrefix(o.cs.Name, o.sh[manifestHeader])
+		if err != nil {
+			return nil, err
+		}
+		segments, err := o.fs.Storage.ObjectNamesAll(o.cs.Name, &swift.ObjectsOpts{
+			Prefix: prefix,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, segment := range segments {
+			paths = append(paths, staticSegmentPath(o.cs.Name, segment))
+		}
+	}
+
+	return paths, nil
+}
+
 func (o *Object) size() uint64 {
 	return uint64(o.so.Bytes)
 }
@@ -1,10 +1,20 @@
 package svfs
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/xlucas/swift"
 
 	"bazil.org/fuse"
 )
@@ -24,9 +34,13 @@ func TestObject(t *testing.T) {
 	ctx.rc = 1
 	t.Run("Directory_ReadDirAll", testDirectoryReadDirAll)
 
-	// Unsupported operations
+	// Open append
 	t.Run("Object_OpenAppend", testObjectOpenAppend)
+	t.Run("ObjectHandle_Close", testObjectHandleClose)
+
+	// Open RW
 	t.Run("Object_OpenReadWrite", testObjectOpenReadWrite)
+	t.Run("ObjectHandle_Close", testObjectHandleClose)
 
 	// Open WO
 	t.Run("Object_OpenWriteOnly", testObjectOpenWriteOnly)
@@ -49,15 +63,29 @@ func TestObject(t *testing.T) {
 }
 
 func testObjectOpenAppend(t *testing.T) {
-	req := &fuse.OpenRequest{Flags: fuse.OpenAppend}
-	_, err := ctx.f.Open(nil, req, &fuse.OpenResponse{})
-	assert.Equal(t, err, fuse.ENOTSUP)
+	req := &fuse.OpenRequest{Flags: fuse.OpenWriteOnly | fuse.OpenAppend}
+	fh, err := ctx.f.Open(nil, req, &fuse.OpenResponse{})
+
+	assert.Nil(t, err)
+	require.NotNil(t, fh)
+	require.IsType(t, &ObjectHandle{}, fh)
+
+	ctx.h, _ = fh.(*ObjectHandle)
+	assert.True(t, ctx.h.append)
 }
 
 func testObjectOpenReadWrite(t *testing.T) {
 	req := &fuse.OpenRequest{Flags: fuse.OpenReadWrite}
-	_, err := ctx.f.Open(nil, req, &fuse.OpenResponse{})
-	assert.Equal(t, err, fuse.ENOTSUP)
+	rep := &fuse.OpenResponse{}
+
+	fh, err := ctx.f.Open(nil, req, rep)
+
+	assert.Nil(t, err)
+	require.NotNil(t, fh)
+	require.IsType(t, &ObjectHandle{}, fh)
+
+	ctx.h, _ = fh.(*ObjectHandle)
+	assert.NotNil(t, ctx.h.sf)
 }
 
 func testObjectOpenReadOnly(t *testing.T) {
@@ -137,3 +165,119 @@ func testObjectListxattr(t *testing.T) {
 	key2 := append([]byte("Key2"), '\x00')
 	assert.Equal(t, append(key1, key2...), rep.Xattr)
 }
+
+type LargeObjectTestSuite struct {
+	localFSSuite
+	object *Object
+}
+
+func (suite *LargeObjectTestSuite) SetupTest() {
+	suite.localFSSuite.SetupTest()
+	suite.fs.directoryCache = NewCache(time.Minute, -1, -1)
+	suite.fs.writeBackQueue = NewWriteBackQueue(suite.fs)
+
+	require.NoError(suite.T(), suite.backend.ContainerCreate("container_segments", nil))
+	require.NoError(suite.T(), suite.backend.ObjectPutBytes("container_segments", "file/1480000000/00000001", []byte("segment"), ""))
+	_, err := suite.backend.ObjectPut("container", "file", bytes.NewReader(nil), false, "", "", swift.Headers{
+		manifestHeader: "container_segments/file/1480000000",
+	})
+	require.NoError(suite.T(), err)
+
+	// Listed without fetching its headers
+	suite.object = suite.localFSSuite.object("file")
+	suite.object.sh = swift.Headers{}
+	suite.object.cs = &swift.Container{Name: "container_segments"}
+	suite.object.p = &Directory{fs: suite.fs, c: suite.object.c, cs: suite.object.cs}
+}
+
+func (suite *LargeObjectTestSuite) TestCopy() {
+	_, err := suite.object.copy(suite.object.p, "copy")
+	require.NoError(suite.T(), err)
+
+	_, h, err := suite.backend.Object("container", "copy")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "container_segments/file/1480000000", h[manifestHeader])
+}
+
+func (suite *LargeObjectTestSuite) TestTruncate() {
+	req := &fuse.SetattrRequest{Valid: fuse.SetattrSize, Size: 0}
+	require.NoError(suite.T(), suite.object.Setattr(nil, req, &fuse.SetattrResponse{}))
+
+	names, err := suite.backend.ObjectNamesAll("container_segments", nil)
+	require.NoError(suite.T(), err)
+	assert.Empty(suite.T(), names)
+}
+
+// stage returns a spool file holding new content for the object.
+func (suite *LargeObjectTestSuite) stage(content string) *SpoolFile {
+	spool := NewSpool(filepath.Join(suite.dir, "spool"), 0)
+	require.NoError(suite.T(), spool.Init())
+	sf, err := spool.Create()
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), sf.Fill(strings.NewReader(content), uint64(len(content))))
+	return sf
+}
+
+func (suite *LargeObjectTestSuite) TestUploadStaged() {
+	suite.fs.SegmentSize = 4
+	suite.fs.SegmentBufferSize = 4
+	sf := suite.stage("replaced")
+	defer sf.Remove()
+
+	require.NoError(suite.T(), uploadStaged(suite.object, sf))
+
+	// Segments of the replaced version are removed
+	names, err := suite.backend.ObjectNamesAll("container_segments", nil)
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), names, 2)
+	assert.NotContains(suite.T(), names, "file/1480000000/00000001")
+
+	rd, _, err := suite.backend.ObjectOpen("container", "file", false, nil)
+	require.NoError(suite.T(), err)
+	defer rd.Close()
+	data, err := ioutil.ReadAll(rd)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "replaced", string(data))
+}
+
+func (suite *LargeObjectTestSuite) TestUploadStagedFailure() {
+	suite.fs.SegmentSize = 4
+	suite.fs.SegmentBufferSize = 4
+	sf := suite.stage("replaced")
+	defer sf.Remove()
+
+	// The second segment can't be uploaded
+	suite.fs.Storage = &failingPutBackend{LocalBackend: suite.backend, puts: 1}
+	assert.Error(suite.T(), uploadStaged(suite.object, sf))
+
+	// The current version is kept
+	_, h, err := suite.backend.Object("container", "file")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "container_segments/file/1480000000", h[manifestHeader])
+	names, err := suite.backend.ObjectNamesAll("container_segments", nil)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []string{"file/1480000000/00000001"}, names)
+}
+
+// failingPutBackend is a local storage failing uploads once the
+// given number of objects were uploaded.
+type failingPutBackend struct {
+	*LocalBackend
+	mutex sync.Mutex
+	puts  int
+}
+
+func (b *failingPutBackend) ObjectPut(container, name string, contents io.Reader, checkHash bool, hash, contentType string, h swift.Headers) (swift.Headers, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.puts == 0 {
+		return nil, errors.New("upload failed")
+	}
+	b.puts--
+	return b.LocalBackend.ObjectPut(container, name, contents, checkHash, hash, contentType, h)
+}
+
+func TestLargeObjectSuite(t *testing.T) {
+	suite.Run(t, new(LargeObjectTestSuite))
+}
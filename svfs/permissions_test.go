@@ -0,0 +1,209 @@
+package svfs
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/xlucas/swift"
+)
+
+type PermissionsTestSuite struct {
+	localFSSuite
+	object    *Object
+	container *Directory
+}
+
+func (suite *PermissionsTestSuite) SetupTest() {
+	suite.localFSSuite.SetupTest()
+	require.NoError(suite.T(), suite.backend.ObjectPutBytes("container", "file", []byte("content"), ""))
+
+	suite.fs.BlockSize = 4096
+	suite.fs.DefaultMode = 0700
+	suite.fs.DefaultUID = 1000
+	suite.fs.DefaultGID = 1000
+	suite.fs.FilePermissions = true
+	suite.fs.SegmentSize = 1 << 20
+	suite.fs.changeCache = NewSimpleCache()
+	suite.fs.directoryCache = NewCache(time.Minute, -1, -1)
+	suite.fs.writeBackQueue = NewWriteBackQueue(suite.fs)
+	suite.object = suite.localFSSuite.object("file")
+
+	c := &swift.Container{Name: "container"}
+	suite.container = &Directory{fs: suite.fs, c: c, cs: c}
+}
+
+// headers returns the headers stored along with an object.
+func (suite *PermissionsTestSuite) headers(name string) swift.Headers {
+	_, h, err := suite.fs.Storage.Object("container", name)
+	require.NoError(suite.T(), err)
+	return h
+}
+
+func (suite *PermissionsTestSuite) attr() fuse.Attr {
+	var a fuse.Attr
+	require.NoError(suite.T(), suite.object.Attr(nil, &a))
+	return a
+}
+
+func (suite *PermissionsTestSuite) TestDefaults() {
+	a := suite.attr()
+	assert.Equal(suite.T(), os.FileMode(0700), a.Mode)
+	assert.Equal(suite.T(), uint32(1000), a.Uid)
+	assert.Equal(suite.T(), uint32(1000), a.Gid)
+}
+
+func (suite *PermissionsTestSuite) TestSetattr() {
+	req := &fuse.SetattrRequest{
+		Valid: fuse.SetattrMode | fuse.SetattrUid | fuse.SetattrGid,
+		Mode:  0640,
+		Uid:   33,
+		Gid:   44,
+	}
+	require.NoError(suite.T(), suite.object.Setattr(nil, req, &fuse.SetattrResponse{}))
+
+	a := suite.attr()
+	assert.Equal(suite.T(), os.FileMode(0640), a.Mode)
+	assert.Equal(suite.T(), uint32(33), a.Uid)
+	assert.Equal(suite.T(), uint32(44), a.Gid)
+
+	// Stored metadata is used by nodes fetched again
+	_, sh, err := suite.fs.Storage.Object("container", "file")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "0640", sh[objectModeHeader])
+	assert.Equal(suite.T(), "33", sh[objectUIDHeader])
+	assert.Equal(suite.T(), "44", sh[objectGIDHeader])
+}
+
+func (suite *PermissionsTestSuite) TestSetattrKeepsMetadata() {
+	suite.fs.Attr = true
+	req := &fuse.SetattrRequest{Valid: fuse.SetattrMode, Mode: 0600}
+	require.NoError(suite.T(), suite.object.Setattr(nil, req, &fuse.SetattrResponse{}))
+
+	req = &fuse.SetattrRequest{Valid: fuse.SetattrUid, Uid: 33}
+	require.NoError(suite.T(), suite.object.Setattr(nil, req, &fuse.SetattrResponse{}))
+
+	_, sh, err := suite.fs.Storage.Object("container", "file")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "0600", sh[objectModeHeader])
+	assert.Equal(suite.T(), "33", sh[objectUIDHeader])
+	assert.Empty(suite.T(), sh[objectGIDHeader])
+}
+
+func (suite *PermissionsTestSuite) TestSetattrKeepsManifest() {
+	require.NoError(suite.T(), suite.backend.ContainerCreate("container_segments", nil))
+	require.NoError(suite.T(), suite.backend.ObjectPutBytes("container_segments", "large/1480000000/00000001", []byte("content"), ""))
+	require.NoError(suite.T(), suite.backend.ObjectPutBytes("container", "large", nil, ""))
+	require.NoError(suite.T(), suite.backend.ObjectUpdate("container", "large", swift.Headers{
+		manifestHeader:     "container_segments/large/1480000000",
+		"Content-Language": "en",
+	}))
+
+	// Headers of listed objects are unknown
+	object := suite.localFSSuite.object("large")
+	object.sh = swift.Headers{}
+	object.segmented = true
+
+	req := &fuse.SetattrRequest{Valid: fuse.SetattrMode, Mode: 0600}
+	require.NoError(suite.T(), object.Setattr(nil, req, &fuse.SetattrResponse{}))
+
+	so, sh, err := suite.fs.Storage.Object("container", "large")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(7), so.Bytes)
+	assert.Equal(suite.T(), "container_segments/large/1480000000", sh[manifestHeader])
+	assert.Equal(suite.T(), "en", sh["Content-Language"])
+	assert.Equal(suite.T(), "0600", sh[objectModeHeader])
+}
+
+func (suite *PermissionsTestSuite) TestSetattrSpecialBits() {
+	req := &fuse.SetattrRequest{Valid: fuse.SetattrMode, Mode: os.ModeSetuid | os.ModeSetgid | 0755}
+	require.NoError(suite.T(), suite.object.Setattr(nil, req, &fuse.SetattrResponse{}))
+
+	assert.Equal(suite.T(), os.ModeSetuid|os.ModeSetgid|0755, suite.attr().Mode)
+	assert.Equal(suite.T(), "6755", suite.headers("file")[objectModeHeader])
+}
+
+func (suite *PermissionsTestSuite) TestCreate() {
+	req := &fuse.CreateRequest{
+		Header: fuse.Header{Uid: 33, Gid: 44},
+		Name:   "new",
+		Flags:  fuse.OpenWriteOnly | fuse.OpenCreate,
+		Mode:   0666,
+		Umask:  0022,
+	}
+	node, fh, err := suite.container.Create(nil, req, &fuse.CreateResponse{})
+	require.NoError(suite.T(), err)
+
+	h := suite.headers("new")
+	assert.Equal(suite.T(), "0644", h[objectModeHeader])
+	assert.Equal(suite.T(), "33", h[objectUIDHeader])
+	assert.Equal(suite.T(), "44", h[objectGIDHeader])
+
+	// Uploading content keeps them
+	handle := fh.(*ObjectHandle)
+	require.NoError(suite.T(), handle.Write(nil, &fuse.WriteRequest{Data: []byte("content")}, &fuse.WriteResponse{}))
+	require.NoError(suite.T(), handle.Release(nil, &fuse.ReleaseRequest{}))
+
+	h = suite.headers("new")
+	assert.Equal(suite.T(), "7", h["Content-Length"])
+	assert.Equal(suite.T(), "0644", h[objectModeHeader])
+	assert.Equal(suite.T(), "33", h[objectUIDHeader])
+
+	var a fuse.Attr
+	require.NoError(suite.T(), node.Attr(nil, &a))
+	assert.Equal(suite.T(), os.FileMode(0644), a.Mode)
+}
+
+func (suite *PermissionsTestSuite) TestMkdir() {
+	req := &fuse.MkdirRequest{
+		Header: fuse.Header{Uid: 33, Gid: 44},
+		Name:   "dir",
+		Mode:   os.ModeDir | 0777,
+		Umask:  0027,
+	}
+	node, err := suite.container.Mkdir(nil, req)
+	require.NoError(suite.T(), err)
+
+	h := suite.headers("dir/")
+	assert.Equal(suite.T(), "0750", h[objectModeHeader])
+	assert.Equal(suite.T(), "33", h[objectUIDHeader])
+	assert.Equal(suite.T(), "44", h[objectGIDHeader])
+
+	var a fuse.Attr
+	require.NoError(suite.T(), node.Attr(nil, &a))
+	assert.Equal(suite.T(), os.ModeDir|0750, a.Mode)
+}
+
+func (suite *PermissionsTestSuite) TestSymlink() {
+	req := &fuse.SymlinkRequest{
+		Header:  fuse.Header{Uid: 33, Gid: 44},
+		NewName: "link",
+		Target:  "file",
+	}
+	_, err := suite.container.Symlink(nil, req)
+	require.NoError(suite.T(), err)
+
+	h := suite.headers("link")
+	assert.Equal(suite.T(), "file", h[objectSymlinkHeader])
+	assert.Equal(suite.T(), "0777", h[objectModeHeader])
+	assert.Equal(suite.T(), "33", h[objectUIDHeader])
+	assert.Equal(suite.T(), "44", h[objectGIDHeader])
+}
+
+func (suite *PermissionsTestSuite) TestDisabled() {
+	suite.object.sh[objectModeHeader] = "0644"
+	suite.fs.FilePermissions = false
+
+	assert.Equal(suite.T(), os.FileMode(0700), suite.attr().Mode)
+
+	req := &fuse.SetattrRequest{Valid: fuse.SetattrMode, Mode: 0600}
+	assert.Equal(suite.T(), fuse.ENOTSUP, suite.object.Setattr(nil, req, &fuse.SetattrResponse{}))
+}
+
+func TestPermissionsSuite(t *testing.T) {
+	suite.Run(t, new(PermissionsTestSuite))
+}
@@ -0,0 +1,229 @@
+package svfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/xlucas/swift"
+)
+
+const (
+	// prefetchSequentialReads is the number of consecutive sequential
+	// reads after which chunks are prefetched.
+	prefetchSequentialReads = 2
+	prefetchReadSize        = 64 * 1024
+)
+
+var errPrefetchCanceled = errors.New("Prefetch canceled")
+
+// Prefetcher reads an object by chunks. Once sequential access is
+// detected, following chunks are fetched concurrently ahead of the
+// reader. A random seek cancels all prefetches in progress.
+type Prefetcher struct {
+	mutex       sync.Mutex
+	fetch       func(index int64, cancel <-chan struct{}) ([]byte, error)
+	concurrency uint64
+	chunkSize   int64
+	size        int64
+	offset      int64
+	lastEnd     int64
+	sequential  int
+	chunks      map[int64]*prefetchChunk
+	cancel      chan struct{}
+	closed      bool
+}
+
+type prefetchChunk struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+func newPrefetcher(size, chunkSize int64, concurrency uint64, fetch func(index int64, cancel <-chan struct{}) ([]byte, error)) *Prefetcher {
+	return &Prefetcher{
+		fetch:       fetch,
+		concurrency: concurrency,
+		chunkSize:   chunkSize,
+		size:        size,
+		chunks:      make(map[int64]*prefetchChunk),
+		cancel:      make(chan struct{}),
+	}
+}
+
+// newRangeFetcher returns a function fetching chunks of an object
+// using ranged requests.
+func newRangeFetcher(storage Backend, container, path string, size, chunkSize int64) func(int64, <-chan struct{}) ([]byte, error) {
+	return func(index int64, cancel <-chan struct{}) ([]byte, error) {
+		start := index * chunkSize
+		end := start + chunkSize - 1
+		if end >= size {
+			end = size - 1
+		}
+		return fetchRange(storage, container, path, start, end, cancel)
+	}
+}
+
+// fetchRange downloads bytes from start to end inclusive. The download
+// is aborted once the cancel channel is closed.
+func fetchRange(storage Backend, container, path string, start, end int64, cancel <-chan struct{}) ([]byte, error) {
+	rd, _, err := storage.ObjectOpen(container, path, false, swift.Headers{
+		"Range": fmt.Sprintf("bytes=%d-%d", start, end),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	data := make([]byte, end-start+1)
+	for n := 0; n < len(data); {
+		select {
+		case <-cancel:
+			return nil, errPrefetchCanceled
+		default:
+		}
+
+		chunk := len(data) - n
+		if chunk > prefetchReadSize {
+			chunk = prefetchReadSize
+		}
+		read, err := io.ReadFull(rd, data[n:n+chunk])
+		n += read
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// Read reads data at the current offset, waiting for the chunk
+// holding it to be fetched.
+func (p *Prefetcher) Read(b []byte) (n int, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// The offset may be moved while waiting for the chunk
+	offset := p.offset
+	if offset >= p.size {
+		return 0, io.EOF
+	}
+
+	// Detect access pattern
+	if offset == p.lastEnd {
+		p.sequential++
+	} else {
+		p.reset()
+	}
+
+	index := offset / p.chunkSize
+	chunk := p.request(index)
+
+	// Keep a bounded window of chunks ahead of the reader
+	if p.sequential >= prefetchSequentialReads {
+		for i := int64(1); i <= int64(p.concurrency); i++ {
+			if (index+i)*p.chunkSize >= p.size {
+				break
+			}
+			p.request(index + i)
+		}
+	}
+
+	for {
+		p.mutex.Unlock()
+		<-chunk.done
+		p.mutex.Lock()
+
+		if chunk.err == nil {
+			break
+		}
+		if p.chunks[index] == chunk {
+			delete(p.chunks, index)
+		}
+
+		// Fetch the chunk again if another read canceled it
+		if chunk.err != errPrefetchCanceled || p.closed {
+			return 0, chunk.err
+		}
+		chunk = p.request(index)
+	}
+
+	start := offset - index*p.chunkSize
+	if start >= int64(len(chunk.data)) {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	n = copy(b, chunk.data[start:])
+	p.offset = offset + int64(n)
+	p.lastEnd = p.offset
+
+	// Release chunks behind the reader
+	for i := range p.chunks {
+		if i < p.offset/p.chunkSize {
+			delete(p.chunks, i)
+		}
+	}
+
+	return n, nil
+}
+
+// Seek sets the offset of the next read.
+func (p *Prefetcher) Seek(offset int64, whence int) (int64, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += p.offset
+	case io.SeekEnd:
+		offset += p.size
+	default:
+		return p.offset, fmt.Errorf("Invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return p.offset, fmt.Errorf("Negative offset %d", offset)
+	}
+	p.offset = offset
+	return offset, nil
+}
+
+// Close cancels all prefetches in progress.
+func (p *Prefetcher) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.closed = true
+	p.reset()
+	return nil
+}
+
+// request returns a chunk, starting to fetch it if needed.
+func (p *Prefetcher) request(index int64) *prefetchChunk {
+	if chunk, ok := p.chunks[index]; ok {
+		return chunk
+	}
+
+	chunk := &prefetchChunk{done: make(chan struct{})}
+	p.chunks[index] = chunk
+
+	go func(cancel <-chan struct{}) {
+		chunk.data, chunk.err = p.fetch(index, cancel)
+		close(chunk.done)
+	}(p.cancel)
+
+	return chunk
+}
+
+// reset cancels prefetches in progress and forgets fetched chunks.
+func (p *Prefetcher) reset() {
+	close(p.cancel)
+	p.cancel = make(chan struct{})
+	p.chunks = make(map[int64]*prefetchChunk)
+	p.sequential = 0
+}
+
+var (
+	_ io.ReadSeeker = (*Prefetcher)(nil)
+	_ io.Closer     = (*Prefetcher)(nil)
+)
@@ -0,0 +1,142 @@
+package svfs
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type PrefetchTestSuite struct {
+	suite.Suite
+	content    string
+	mutex      sync.Mutex
+	fetched    []int64
+	canceled   int
+	block      chan struct{}
+	prefetcher *Prefetcher
+}
+
+func (suite *PrefetchTestSuite) SetupTest() {
+	suite.content = "0123456789abcdef"
+	suite.fetched = nil
+	suite.canceled = 0
+	suite.block = nil
+	suite.prefetcher = newPrefetcher(int64(len(suite.content)), 4, 2, suite.fetch)
+}
+
+func (suite *PrefetchTestSuite) fetch(index int64, cancel <-chan struct{}) ([]byte, error) {
+	suite.mutex.Lock()
+	suite.fetched = append(suite.fetched, index)
+	block := suite.block
+	suite.mutex.Unlock()
+
+	// Chunks ahead of the reader may be held until canceled
+	if block != nil && index > 0 {
+		select {
+		case <-block:
+		case <-cancel:
+			suite.mutex.Lock()
+			suite.canceled++
+			suite.mutex.Unlock()
+			return nil, errPrefetchCanceled
+		}
+	}
+
+	end := (index + 1) * 4
+	if end > int64(len(suite.content)) {
+		end = int64(len(suite.content))
+	}
+	return []byte(suite.content[index*4 : end]), nil
+}
+
+func (suite *PrefetchTestSuite) TestSequentialRead() {
+	data := make([]byte, 2)
+	var result []string
+
+	for {
+		n, err := suite.prefetcher.Read(data)
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(suite.T(), err)
+		result = append(result, string(data[:n]))
+	}
+
+	assert.Equal(suite.T(), suite.content, strings.Join(result, ""))
+	assert.Len(suite.T(), suite.fetched, 4)
+	assert.True(suite.T(), len(suite.prefetcher.chunks) <= int(suite.prefetcher.concurrency)+1)
+}
+
+func (suite *PrefetchTestSuite) TestNoPrefetchOnFirstRead() {
+	data := make([]byte, 2)
+	suite.prefetcher.Read(data)
+
+	assert.Equal(suite.T(), []int64{0}, suite.fetched)
+}
+
+func (suite *PrefetchTestSuite) TestRandomSeekCancels() {
+	suite.block = make(chan struct{})
+	data := make([]byte, 2)
+
+	// Two sequential reads start prefetching chunks 1 and 2
+	suite.prefetcher.Read(data)
+	suite.prefetcher.Read(data)
+	assert.Len(suite.T(), suite.prefetcher.chunks, 2)
+	pending := []*prefetchChunk{suite.prefetcher.chunks[1], suite.prefetcher.chunks[2]}
+
+	// Random access cancels them
+	suite.prefetcher.Seek(0, io.SeekStart)
+	n, err := suite.prefetcher.Read(data)
+
+	for _, chunk := range pending {
+		<-chunk.done
+		assert.Equal(suite.T(), errPrefetchCanceled, chunk.err)
+	}
+
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), "01", string(data[:n]))
+	assert.Equal(suite.T(), 2, suite.canceled)
+	assert.Len(suite.T(), suite.prefetcher.chunks, 1)
+}
+
+func (suite *PrefetchTestSuite) TestConcurrentReads() {
+	suite.block = make(chan struct{})
+	data := make([]byte, 2)
+	suite.prefetcher.Read(data)
+
+	// A read waits for chunk 1
+	suite.prefetcher.Seek(4, io.SeekStart)
+	done := make(chan string)
+	go func() {
+		data := make([]byte, 2)
+		n, err := suite.prefetcher.Read(data)
+		assert.Nil(suite.T(), err)
+		done <- string(data[:n])
+	}()
+	for fetched := false; !fetched; {
+		time.Sleep(time.Millisecond)
+		suite.mutex.Lock()
+		fetched = len(suite.fetched) == 2
+		suite.mutex.Unlock()
+	}
+
+	// Another read moves the offset and cancels chunk 1
+	suite.prefetcher.Seek(0, io.SeekStart)
+	n, err := suite.prefetcher.Read(data)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), "01", string(data[:n]))
+
+	// The first read fetches it again
+	close(suite.block)
+	assert.Equal(suite.T(), "45", <-done)
+	assert.Equal(suite.T(), 1, suite.canceled)
+}
+
+func TestPrefetchSuite(t *testing.T) {
+	suite.Run(t, new(PrefetchTestSuite))
+}
@@ -0,0 +1,234 @@
+package svfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/xlucas/swift"
+)
+
+const (
+	renamePhaseCopy   = "copy"
+	renamePhaseDelete = "delete"
+)
+
+// RenameJournal records a directory rename in progress. Since swift
+// has no atomic rename, objects are first copied to their new location
+// and only then originals are deleted. A rename interrupted during the
+// copy phase is rolled back while a rename interrupted during the delete
+// phase is completed. Targets already present before the rename, like
+// the marker of an empty target directory, are kept by a rollback.
+// Journals only apply to the storage they were recorded for.
+type RenameJournal struct {
+	Storage   string   `json:"storage"`
+	Container string   `json:"container"`
+	Source    string   `json:"source"`
+	Target    string   `json:"target"`
+	Phase     string   `json:"phase"`
+	Objects   []string `json:"objects"`
+	Existing  []string `json:"existing,omitempty"`
+	fs        *SVFS
+	file      string
+}
+
+// ReplayRenameJournals finishes or rolls back every directory rename
+// of the mounted storage found in the journal directory. A journal
+// that can't be replayed is kept for the next mount.
+func (s *SVFS) ReplayRenameJournals() error {
+	if err := os.MkdirAll(s.JournalDir, 0700); err != nil {
+		return err
+	}
+
+	files, err := filepath.Glob(filepath.Join(s.JournalDir, "rename-*.json"))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		log := logrus.WithField("journal", file)
+
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Errorln("Failed to read rename journal :", err)
+			continue
+		}
+
+		j := &RenameJournal{fs: s, file: file}
+		if err := json.Unmarshal(content, j); err != nil {
+			log.Errorln("Invalid rename journal :", err)
+			continue
+		}
+
+		// Left by a mount of another storage
+		if j.Storage != s.storageID() {
+			logrus.WithFields(logrus.Fields{
+				"journal": file,
+				"storage": j.Storage,
+			}).Debugln("Skipping rename journal of another storage")
+			continue
+		}
+
+		log = log.WithFields(logrus.Fields{
+			"container": j.Container,
+			"source":    j.Source,
+			"target":    j.Target,
+			"phase":     j.Phase,
+		})
+		log.Infoln("Replaying interrupted directory rename")
+
+		// Keep the journal for the next mount
+		if j.Phase == renamePhaseDelete {
+			err = j.complete()
+		} else {
+			err = j.rollback()
+		}
+		if err != nil {
+			log.Errorln("Failed to replay interrupted directory rename :", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *SVFS) newRenameJournal(container, source, target string, objects, existing []string) (*RenameJournal, error) {
+	j := &RenameJournal{
+		fs:        s,
+		Storage:   s.storageID(),
+		Container: container,
+		Source:    source,
+		Target:    target,
+		Phase:     renamePhaseCopy,
+		Objects:   objects,
+		Existing:  existing,
+		file:      filepath.Join(s.JournalDir, fmt.Sprintf("rename-%d.json", time.Now().UnixNano())),
+	}
+	return j, j.save()
+}
+
+func (j *RenameJournal) complete() error {
+	err := forEachConcurrently(j.fs.RenameConcurrency, len(j.Objects), func(i int) error {
+		err := j.fs.Storage.ObjectDelete(j.Container, j.Objects[i])
+		if err == swift.ObjectNotFound {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return os.Remove(j.file)
+}
+
+func (j *RenameJournal) rollback() error {
+	existing := make(map[string]bool, len(j.Existing))
+	for _, name := range j.Existing {
+		existing[name] = true
+	}
+
+	err := forEachConcurrently(j.fs.RenameConcurrency, len(j.Objects), func(i int) error {
+		target := j.target(j.Objects[i])
+		if existing[target] {
+			return nil
+		}
+		err := j.fs.Storage.ObjectDelete(j.Container, target)
+		if err == swift.ObjectNotFound {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return os.Remove(j.file)
+}
+
+func (j *RenameJournal) save() error {
+	content, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+
+	// Never leave a partially written journal behind
+	tmp := j.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, j.file)
+}
+
+func (j *RenameJournal) target(name string) string {
+	if name == strings.TrimSuffix(j.Source, "/") {
+		return strings.TrimSuffix(j.Target, "/")
+	}
+	return j.Target + strings.TrimPrefix(name, j.Source)
+}
+
+// forEachConcurrently calls fn for every index from 0 to count using
+// at most the given number of workers. It returns the first error met.
+func forEachConcurrently(workers uint64, count int, fn func(i int) error) (err error) {
+	var (
+		once  sync.Once
+		wg    sync.WaitGroup
+		tasks = make(chan int)
+	)
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	for w := uint64(0); w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range tasks {
+				if e := fn(i); e != nil {
+					once.Do(func() { err = e })
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		tasks <- i
+	}
+
+	close(tasks)
+	wg.Wait()
+
+	return err
+}
+
+// renameNode updates paths of a node moved from one prefix
+// to another.
+func renameNode(node Node, oldPrefix, newPrefix string) {
+	rename := func(path string) string {
+		if path == strings.TrimSuffix(oldPrefix, "/") {
+			return strings.TrimSuffix(newPrefix, "/")
+		}
+		if strings.HasPrefix(path, oldPrefix) {
+			return newPrefix + strings.TrimPrefix(path, oldPrefix)
+		}
+		return path
+	}
+
+	switch n := node.(type) {
+	case *Directory:
+		n.path = rename(n.path)
+		if n.so != nil {
+			n.so.Name = rename(n.so.Name)
+		}
+	case *Object:
+		n.path = rename(n.path)
+		n.so.Name = rename(n.so.Name)
+	case *Symlink:
+		n.path = rename(n.path)
+		n.so.Name = rename(n.so.Name)
+	}
+}
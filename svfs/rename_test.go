@@ -0,0 +1,204 @@
+package svfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/xlucas/swift"
+)
+
+type RenameTestSuite struct {
+	localFSSuite
+	concurrency uint64
+	journal     *RenameJournal
+}
+
+func (suite *RenameTestSuite) SetupTest() {
+	suite.localFSSuite.SetupTest()
+	suite.fs.JournalDir = filepath.Join(suite.dir, "journal")
+	suite.fs.RenameConcurrency = 2
+	suite.concurrency = 4
+	suite.journal = &RenameJournal{
+		Container: "container",
+		Source:    "dir/",
+		Target:    "moved/",
+	}
+}
+
+func (suite *RenameTestSuite) TestTarget() {
+	assert.Equal(suite.T(), "moved/", suite.journal.target("dir/"))
+	assert.Equal(suite.T(), "moved", suite.journal.target("dir"))
+	assert.Equal(suite.T(), "moved/sub/file", suite.journal.target("dir/sub/file"))
+}
+
+func (suite *RenameTestSuite) TestRenameNode() {
+	object := &Object{path: "dir/sub/file", so: &swift.Object{Name: "dir/sub/file"}}
+	other := &Object{path: "directory/file", so: &swift.Object{Name: "directory/file"}}
+
+	renameNode(object, "dir/", "moved/")
+	renameNode(other, "dir/", "moved/")
+
+	assert.Equal(suite.T(), "moved/sub/file", object.path)
+	assert.Equal(suite.T(), "moved/sub/file", object.so.Name)
+	assert.Equal(suite.T(), "directory/file", other.path)
+}
+
+func (suite *RenameTestSuite) TestForEachConcurrently() {
+	var count int64
+
+	err := forEachConcurrently(suite.concurrency, 100, func(i int) error {
+		atomic.AddInt64(&count, 1)
+		return nil
+	})
+
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), int64(100), count)
+}
+
+func (suite *RenameTestSuite) TestForEachConcurrentlyError() {
+	err := forEachConcurrently(suite.concurrency, 10, func(i int) error {
+		if i == 5 {
+			return swift.ObjectNotFound
+		}
+		return nil
+	})
+
+	assert.Equal(suite.T(), swift.ObjectNotFound, err)
+}
+
+func (suite *RenameTestSuite) TestReplay() {
+	for _, name := range []string{"dir/file", "moved/file", "copy/file"} {
+		require.NoError(suite.T(), suite.backend.ObjectPutBytes("container", name, nil, ""))
+	}
+	require.NoError(suite.T(), os.MkdirAll(suite.fs.JournalDir, 0700))
+
+	// Interrupted renames of this storage and of another one
+	_, err := suite.fs.newRenameJournal("container", "dir/", "moved/", []string{"dir/file"}, nil)
+	require.NoError(suite.T(), err)
+	other := &RenameJournal{
+		Storage:   "https://storage.example.com/v1/AUTH_other",
+		Container: "container",
+		Source:    "dir/",
+		Target:    "copy/",
+		Phase:     renamePhaseCopy,
+		Objects:   []string{"dir/file"},
+		file:      filepath.Join(suite.fs.JournalDir, "rename-1.json"),
+	}
+	require.NoError(suite.T(), other.save())
+
+	require.NoError(suite.T(), suite.fs.ReplayRenameJournals())
+
+	names, err := suite.backend.ObjectNamesAll("container", nil)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []string{"copy/file", "dir/file"}, names)
+
+	journals, err := filepath.Glob(filepath.Join(suite.fs.JournalDir, "rename-*.json"))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []string{other.file}, journals)
+}
+
+// root returns the root directory with only the source directory
+// cached.
+func (suite *RenameTestSuite) root() *Directory {
+	suite.fs.changeCache = NewSimpleCache()
+	suite.fs.directoryCache = NewCache(time.Minute, -1, -1)
+	suite.fs.writeBackQueue = NewWriteBackQueue(suite.fs)
+
+	c := &swift.Container{Name: "container"}
+	root := &Directory{fs: suite.fs, c: c}
+	suite.fs.directoryCache.AddAll("container", "", root, map[string]Node{
+		"dir": &Directory{
+			fs:   suite.fs,
+			c:    c,
+			name: "dir",
+			path: "dir/",
+			so:   &swift.Object{Name: "dir/", PseudoDirectory: true},
+		},
+	})
+	return root
+}
+
+func (suite *RenameTestSuite) TestReplayInvalid() {
+	require.NoError(suite.T(), suite.backend.ObjectPutBytes("container", "moved/file", nil, ""))
+	require.NoError(suite.T(), os.MkdirAll(suite.fs.JournalDir, 0700))
+
+	invalid := filepath.Join(suite.fs.JournalDir, "rename-0.json")
+	require.NoError(suite.T(), ioutil.WriteFile(invalid, []byte("{"), 0600))
+	_, err := suite.fs.newRenameJournal("container", "dir/", "moved/", []string{"dir/file"}, nil)
+	require.NoError(suite.T(), err)
+
+	// Other journals are still replayed
+	require.NoError(suite.T(), suite.fs.ReplayRenameJournals())
+
+	names, err := suite.backend.ObjectNamesAll("container", nil)
+	require.NoError(suite.T(), err)
+	assert.Empty(suite.T(), names)
+
+	journals, err := filepath.Glob(filepath.Join(suite.fs.JournalDir, "rename-*.json"))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []string{invalid}, journals)
+}
+
+func (suite *RenameTestSuite) TestRollbackExisting() {
+	for _, name := range []string{"dir/", "dir/file", "moved/", "moved/file"} {
+		require.NoError(suite.T(), suite.backend.ObjectPutBytes("container", name, nil, dirContentType))
+	}
+	require.NoError(suite.T(), os.MkdirAll(suite.fs.JournalDir, 0700))
+
+	j, err := suite.fs.newRenameJournal("container", "dir/", "moved/", []string{"dir/", "dir/file"}, []string{"moved/"})
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), j.rollback())
+
+	names, err := suite.backend.ObjectNamesAll("container", nil)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []string{"dir/", "dir/file", "moved/"}, names)
+}
+
+func (suite *RenameTestSuite) TestMoveNotEmpty() {
+	for _, name := range []string{"dir/file", "moved/other"} {
+		require.NoError(suite.T(), suite.backend.ObjectPutBytes("container", name, nil, ""))
+	}
+
+	assert.Equal(suite.T(), fuse.ENOTEMPTY, suite.root().move("container", "", "dir", "container", "", "moved"))
+
+	names, err := suite.backend.ObjectNamesAll("container", nil)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []string{"dir/file", "moved/other"}, names)
+}
+
+func (suite *RenameTestSuite) TestMoveOntoFile() {
+	for _, name := range []string{"dir/file", "moved"} {
+		require.NoError(suite.T(), suite.backend.ObjectPutBytes("container", name, nil, ""))
+	}
+
+	assert.Equal(suite.T(), fuse.Errno(syscall.ENOTDIR), suite.root().move("container", "", "dir", "container", "", "moved"))
+
+	names, err := suite.backend.ObjectNamesAll("container", nil)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []string{"dir/file", "moved"}, names)
+}
+
+func (suite *RenameTestSuite) TestMoveOntoEmpty() {
+	require.NoError(suite.T(), suite.backend.ObjectPutBytes("container", "dir/file", nil, ""))
+	require.NoError(suite.T(), suite.backend.ObjectPutBytes("container", "moved/", nil, dirContentType))
+	require.NoError(suite.T(), os.MkdirAll(suite.fs.JournalDir, 0700))
+
+	require.NoError(suite.T(), suite.root().move("container", "", "dir", "container", "", "moved"))
+
+	names, err := suite.backend.ObjectNamesAll("container", nil)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []string{"moved/", "moved/file"}, names)
+}
+
+func TestRenameTestSuite(t *testing.T) {
+	suite.Run(t, new(RenameTestSuite))
+}
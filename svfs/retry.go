@@ -0,0 +1,703 @@
+package svfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/xlucas/swift"
+)
+
+// swiftConnectionPool holds idle connections to swift, shared by all
+// filesystems mounted by the process.
+var swiftConnectionPool = &http.Transport{
+	Proxy:               http.ProxyFromEnvironment,
+	MaxIdleConnsPerHost: 2048,
+}
+
+// errStreamTooLarge is returned when a stream being uploaded exceeds
+// the space reserved in the retry spool to upload it again.
+var errStreamTooLarge = errors.New("Stream exceeds the space reserved to retry its upload")
+
+// retryState tracks the attempts of an operation.
+type retryState struct {
+	attempt    uint64
+	renewed    bool
+	generation uint64
+}
+
+// objectReader reads a swift object. The download is resumed from
+// where it stopped if interrupted by a transient error.
+type objectReader struct {
+	c         *Connection
+	container string
+	name      string
+	checkHash bool
+	headers   swift.Headers
+	start     int64
+	end       int64
+	pos       int64
+	resumes   uint64
+	file      *swift.ObjectOpenFile
+}
+
+// retryAfterTracker remembers until when swift asked clients
+// to hold off.
+type retryAfterTracker struct {
+	mutex sync.Mutex
+	until time.Time
+}
+
+// swiftTransport sends requests to swift, recording metrics and delays
+// requested through Retry-After headers of throttled or unavailable
+// responses.
+type swiftTransport struct {
+	*http.Transport
+	retryAfter *retryAfterTracker
+}
+
+// streamCopy is a copy of a stream being uploaded, kept in the spool
+// until the upload completes. Space for the largest stream expected
+// is reserved up front, so that the copy never runs out of space.
+type streamCopy struct {
+	sf       *SpoolFile
+	size     int64
+	reserved uint64
+}
+
+// objectWriter streams data written to it to a new object.
+type objectWriter struct {
+	pw   *io.PipeWriter
+	done chan struct{}
+	err  error
+}
+
+// newSwiftTransport creates a transport recording delays requested by
+// swift in the given tracker.
+func newSwiftTransport(retryAfter *retryAfterTracker) *swiftTransport {
+	return &swiftTransport{
+		Transport:  swiftConnectionPool,
+		retryAfter: retryAfter,
+	}
+}
+
+// Account returns info about the account.
+func (c *Connection) Account() (info swift.Account, headers swift.Headers, err error) {
+	err = c.retry(func() (err error) {
+		info, headers, err = c.Connection.Account()
+		return err
+	})
+	return
+}
+
+// BulkDelete deletes multiple objects within a single request.
+func (c *Connection) BulkDelete(container string, objectNames []string) (result swift.BulkDeleteResult, err error) {
+	err = c.retry(func() (err error) {
+		result, err = c.Connection.BulkDelete(container, objectNames)
+		return err
+	})
+	return
+}
+
+// Call runs a remote command. Requests with a body are only retried
+// if the body can be read again, the token being renewed anyway if
+// rejected.
+func (c *Connection) Call(targetURL string, p swift.RequestOpts) (resp *http.Response, headers swift.Headers, err error) {
+	start, rewind := rewinder(p.Body)
+	if !rewind {
+		generation := c.generation()
+		if resp, headers, err = c.Connection.Call(targetURL, p); isAuthFailure(err) {
+			c.renewRejectedToken(generation)
+		}
+		return
+	}
+	err = c.retry(func() (err error) {
+		if err = start(); err != nil {
+			return err
+		}
+		resp, headers, err = c.Connection.Call(targetURL, p)
+		return err
+	})
+	return
+}
+
+// Container returns info about a container.
+func (c *Connection) Container(container string) (info swift.Container, headers swift.Headers, err error) {
+	err = c.retry(func() (err error) {
+		info, headers, err = c.Connection.Container(container)
+		return err
+	})
+	return
+}
+
+// ContainerCreate creates a container.
+func (c *Connection) ContainerCreate(container string, h swift.Headers) error {
+	return c.retry(func() error {
+		return c.Connection.ContainerCreate(container, h)
+	})
+}
+
+// ContainerDelete deletes a container. A container missing after a
+// retry is considered deleted by a previous attempt.
+func (c *Connection) ContainerDelete(container string) error {
+	retried := false
+	return c.retry(func() error {
+		err := c.Connection.ContainerDelete(container)
+		if err == swift.ContainerNotFound && retried {
+			return nil
+		}
+		retried = true
+		return err
+	})
+}
+
+// ContainerNamesAll returns the names of all containers.
+func (c *Connection) ContainerNamesAll(opts *swift.ContainersOpts) (names []string, err error) {
+	err = c.retry(func() (err error) {
+		names, err = c.Connection.ContainerNamesAll(opts)
+		return err
+	})
+	return
+}
+
+// ContainerUpdate updates the metadata of a container.
+func (c *Connection) ContainerUpdate(container string, h swift.Headers) error {
+	return c.retry(func() error {
+		return c.Connection.ContainerUpdate(container, h)
+	})
+}
+
+// ContainersAll returns all containers.
+func (c *Connection) ContainersAll(opts *swift.ContainersOpts) (containers []swift.Container, err error) {
+	err = c.retry(func() (err error) {
+		containers, err = c.Connection.ContainersAll(opts)
+		return err
+	})
+	return
+}
+
+// ManifestCopy copies a manifest.
+func (c *Connection) ManifestCopy(srcContainer, srcName, dstContainer, dstName string, h swift.Headers) (headers swift.Headers, err error) {
+	err = c.retry(func() (err error) {
+		headers, err = c.Connection.ManifestCopy(srcContainer, srcName, dstContainer, dstName, h)
+		return err
+	})
+	return
+}
+
+// ManifestUpdate updates the metadata of a manifest.
+func (c *Connection) ManifestUpdate(container, name string, h swift.Headers) error {
+	return c.retry(func() error {
+		return c.Connection.ManifestUpdate(container, name, h)
+	})
+}
+
+// Object returns info about an object.
+func (c *Connection) Object(container, name string) (info swift.Object, headers swift.Headers, err error) {
+	err = c.retry(func() (err error) {
+		info, headers, err = c.Connection.Object(container, name)
+		return err
+	})
+	return
+}
+
+// ObjectCopy copies an object.
+func (c *Connection) ObjectCopy(srcContainer, srcName, dstContainer, dstName string, h swift.Headers) (headers swift.Headers, err error) {
+	err = c.retry(func() (err error) {
+		headers, err = c.Connection.ObjectCopy(srcContainer, srcName, dstContainer, dstName, h)
+		return err
+	})
+	return
+}
+
+// ObjectCreate returns a writer uploading data written to it to a new
+// object. The upload completes once the writer is closed.
+func (c *Connection) ObjectCreate(container, name string, checkHash bool, hash, contentType string, h swift.Headers) (io.WriteCloser, error) {
+	pr, pw := io.Pipe()
+	w := &objectWriter{pw: pw, done: make(chan struct{})}
+
+	go func() {
+		defer close(w.done)
+		_, w.err = c.putStream(container, name, pr, checkHash, hash, contentType, h)
+		pr.CloseWithError(w.err)
+	}()
+
+	return w, nil
+}
+
+// ObjectDelete deletes an object. An object missing after a retry is
+// considered deleted by a previous attempt.
+func (c *Connection) ObjectDelete(container, name string) error {
+	retried := false
+	return c.retry(func() error {
+		err := c.Connection.ObjectDelete(container, name)
+		if err == swift.ObjectNotFound && retried {
+			return nil
+		}
+		retried = true
+		return err
+	})
+}
+
+// ObjectMove moves an object.
+func (c *Connection) ObjectMove(srcContainer, srcName, dstContainer, dstName string) error {
+	if _, err := c.ObjectCopy(srcContainer, srcName, dstContainer, dstName, nil); err != nil {
+		return err
+	}
+	return c.ObjectDelete(srcContainer, srcName)
+}
+
+// ObjectNamesAll returns the names of all objects of a container.
+func (c *Connection) ObjectNamesAll(container string, opts *swift.ObjectsOpts) (names []string, err error) {
+	err = c.retry(func() (err error) {
+		names, err = c.Connection.ObjectNamesAll(container, opts)
+		return err
+	})
+	return
+}
+
+// ObjectOpen opens an object for reading. A single range of bytes
+// may be requested through the Range header.
+func (c *Connection) ObjectOpen(container, name string, checkHash bool, h swift.Headers) (ObjectReader, swift.Headers, error) {
+	r := &objectReader{
+		c:         c,
+		container: container,
+		name:      name,
+		checkHash: checkHash,
+		headers:   make(swift.Headers, len(h)),
+		end:       -1,
+	}
+	for k, v := range h {
+		if k == "Range" {
+			var err error
+			if r.start, r.end, err = parseRange(v); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		r.headers[k] = v
+	}
+
+	headers, err := r.open()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return r, headers, nil
+}
+
+// ObjectPut uploads an object from a reader. If the reader can't be
+// read again, it's copied to the spool while uploaded so that the
+// whole object can be uploaded again on failure.
+func (c *Connection) ObjectPut(container, name string, contents io.Reader, checkHash bool, hash, contentType string, h swift.Headers) (headers swift.Headers, err error) {
+	start, rewind := rewinder(contents)
+	if !rewind {
+		return c.putStream(container, name, contents, checkHash, hash, contentType, h)
+	}
+	err = c.retry(func() (err error) {
+		if err = start(); err != nil {
+			return err
+		}
+		headers, err = c.Connection.ObjectPut(container, name, contents, checkHash, hash, contentType, h)
+		return err
+	})
+	return
+}
+
+// ObjectPutBytes uploads an object from a byte slice.
+func (c *Connection) ObjectPutBytes(container, name string, contents []byte, contentType string) error {
+	return c.retry(func() error {
+		return c.Connection.ObjectPutBytes(container, name, contents, contentType)
+	})
+}
+
+// ObjectUpdate updates the metadata of an object.
+func (c *Connection) ObjectUpdate(container, name string, h swift.Headers) error {
+	return c.retry(func() error {
+		return c.Connection.ObjectUpdate(container, name, h)
+	})
+}
+
+// ObjectsAll returns all objects of a container.
+func (c *Connection) ObjectsAll(container string, opts *swift.ObjectsOpts) (objects []swift.Object, err error) {
+	err = c.retry(func() (err error) {
+		objects, err = c.Connection.ObjectsAll(container, opts)
+		return err
+	})
+	return
+}
+
+// QueryInfo returns the capabilities of the swift cluster.
+func (c *Connection) QueryInfo() (info swift.SwiftInfo, err error) {
+	err = c.retry(func() (err error) {
+		info, err = c.Connection.QueryInfo()
+		return err
+	})
+	return
+}
+
+// putStream uploads an object from a stream, copied to the retry spool
+// while uploaded if retries are enabled. Uploads wait for space in the
+// spool while it is full. If the upload fails, the rest of the stream
+// is copied as well and the whole object is uploaded again from the
+// spool.
+func (c *Connection) putStream(container, name string, rd io.Reader, checkHash bool, hash, contentType string, h swift.Headers) (headers swift.Headers, err error) {
+	if c.spool == nil || c.MaxRetries == 0 {
+		return c.Connection.ObjectPut(container, name, rd, checkHash, hash, contentType, h)
+	}
+
+	stream, err := newStreamCopy(c.spool, c.streamMaxSize)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.remove()
+
+	state := retryState{generation: c.generation()}
+	headers, err = c.Connection.ObjectPut(container, name, io.TeeReader(rd, stream), checkHash, hash, contentType, h)
+	if !(c.shouldRetry(0, err) || isAuthFailure(err)) {
+		return headers, err
+	}
+
+	// Keep the rest of the stream
+	if _, copyErr := io.Copy(stream, rd); copyErr != nil {
+		logrus.WithFields(logrus.Fields{
+			"container": container,
+			"path":      name,
+		}).Errorln("Can't retry upload, stream copy failed :", copyErr)
+		return headers, err
+	}
+
+	for c.recover(&state, err) {
+		state.generation = c.generation()
+		headers, err = c.Connection.ObjectPut(container, name, stream.reader(), checkHash, hash, contentType, h)
+	}
+
+	return headers, err
+}
+
+// retry runs a swift operation until it succeeds, fails with an
+// error that isn't transient or retries are exhausted. A request
+// rejected because of an expired token is sent again once the
+// token is renewed.
+func (c *Connection) retry(operation func() error) (err error) {
+	var state retryState
+	for {
+		state.generation = c.generation()
+		if err = operation(); !c.recover(&state, err) {
+			return err
+		}
+	}
+}
+
+// recover prepares the next attempt of a failed operation, renewing
+// the token once if rejected or waiting before retrying on transient
+// errors. It tells whether the operation should be attempted again.
+func (c *Connection) recover(state *retryState, err error) bool {
+	if err == nil {
+		return false
+	}
+	if isAuthFailure(err) && !state.renewed {
+		state.renewed = true
+		return c.renewRejectedToken(state.generation) == nil
+	}
+	if !c.shouldRetry(state.attempt, err) {
+		return false
+	}
+	c.backoff(state.attempt, err)
+	state.attempt++
+	return true
+}
+
+func (c *Connection) shouldRetry(attempt uint64, err error) bool {
+	return err != nil && attempt < c.MaxRetries && isTransient(err)
+}
+
+// backoff waits before the next attempt of a failed operation.
+func (c *Connection) backoff(attempt uint64, err error) {
+	delay := c.retryDelay(attempt)
+	logrus.WithFields(logrus.Fields{
+		"attempt": attempt + 1,
+		"delay":   delay,
+	}).Warnln("Retrying swift request :", err)
+	time.Sleep(delay)
+}
+
+// retryDelay returns the delay before the next attempt. The exponential
+// backoff is jittered to avoid retrying concurrent requests all at once,
+// unless swift asked for a longer delay.
+func (c *Connection) retryDelay(attempt uint64) time.Duration {
+	delay := c.RetryMaxDelay
+	if attempt < 32 && c.RetryDelay<<attempt < c.RetryMaxDelay {
+		delay = c.RetryDelay << attempt
+	}
+	if delay > 1 {
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+	}
+	if wait := c.retryAfter.remaining(); wait > delay {
+		delay = wait
+	}
+	return delay
+}
+
+// isTransient tells whether an error may not happen again when
+// retrying the request.
+func isTransient(err error) bool {
+	if e, ok := err.(*swift.Error); ok {
+		return e == swift.TooManyRequests || e == swift.TimeoutError || e.StatusCode >= 500
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	return err == io.ErrUnexpectedEOF ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// parseRange parses a Range header requesting a single range of bytes,
+// returning -1 as end if the range extends to the end of the object.
+func parseRange(value string) (start, end int64, err error) {
+	bounds := strings.SplitN(strings.TrimPrefix(value, "bytes="), "-", 2)
+	if !strings.HasPrefix(value, "bytes=") || len(bounds) != 2 {
+		return 0, 0, fmt.Errorf("Unsupported range %q", value)
+	}
+	if start, err = strconv.ParseInt(bounds[0], 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("Unsupported range %q", value)
+	}
+	if bounds[1] == "" {
+		return start, -1, nil
+	}
+	if end, err = strconv.ParseInt(bounds[1], 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("Unsupported range %q", value)
+	}
+	return start, end, nil
+}
+
+// rewinder returns a function seeking a reader back to its current
+// offset, if the reader supports it.
+func rewinder(rd io.Reader) (func() error, bool) {
+	if rd == nil {
+		return func() error { return nil }, true
+	}
+	seeker, ok := rd.(io.Seeker)
+	if !ok {
+		return nil, false
+	}
+	offset, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, false
+	}
+	return func() error {
+		_, err := seeker.Seek(offset, io.SeekStart)
+		return err
+	}, true
+}
+
+// RoundTrip sends a request, recording the delay requested by swift
+// if the response tells to retry later.
+func (t *swiftTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Transport.RoundTrip(req)
+
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+
+	// Storage requests always carry a token, unlike authentication ones
+	if req.Header.Get("X-Auth-Token") == "" {
+		authRequests.Inc(status)
+	} else {
+		swiftRequests.Inc(req.Method, status)
+	}
+
+	if err == nil && (resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode == http.StatusServiceUnavailable) {
+		t.retryAfter.set(resp.Header.Get("Retry-After"))
+	}
+
+	return resp, err
+}
+
+// set records a Retry-After header value, given either as a number
+// of seconds or as a date.
+func (r *retryAfterTracker) set(value string) {
+	var until time.Time
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		until = time.Now().Add(time.Duration(seconds) * time.Second)
+	} else if date, err := http.ParseTime(value); err == nil {
+		until = date
+	} else {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if until.After(r.until) {
+		r.until = until
+	}
+}
+
+// remaining returns how long clients should still hold off.
+func (r *retryAfterTracker) remaining() time.Duration {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.until.Sub(time.Now())
+}
+
+// Read reads data from the object, reopening it from the current
+// offset if the download was interrupted.
+func (r *objectReader) Read(p []byte) (n int, err error) {
+	if r.file == nil {
+		_, err = r.open()
+		if e, ok := err.(*swift.Error); ok && e.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err = r.file.Read(p)
+	r.pos += int64(n)
+
+	if err != nil && err != io.EOF && isTransient(err) && r.resumes < r.c.MaxRetries {
+		r.resumes++
+		logrus.WithFields(logrus.Fields{
+			"container": r.container,
+			"path":      r.name,
+			"offset":    r.start + r.pos,
+		}).Warnln("Resuming interrupted download :", err)
+		r.file.Close()
+		r.file = nil
+		if n == 0 {
+			return r.Read(p)
+		}
+		return n, nil
+	}
+
+	return n, err
+}
+
+// Seek sets the offset of the next read. The object is reopened
+// from this offset on the next read if it changed.
+func (r *objectReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	default:
+		return r.pos, fmt.Errorf("Seeking from the end of an object isn't supported")
+	}
+	if offset < 0 {
+		return r.pos, fmt.Errorf("Negative offset")
+	}
+
+	if offset != r.pos && r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+	r.pos = offset
+
+	return r.pos, nil
+}
+
+// Close ends the download.
+func (r *objectReader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// open requests the object from the current offset.
+func (r *objectReader) open() (headers swift.Headers, err error) {
+	h := make(swift.Headers, len(r.headers)+1)
+	for k, v := range r.headers {
+		h[k] = v
+	}
+	if start := r.start + r.pos; start > 0 || r.end >= 0 {
+		h["Range"] = fmt.Sprintf("bytes=%d-", start)
+		if r.end >= 0 {
+			h["Range"] += strconv.FormatInt(r.end, 10)
+		}
+	}
+
+	err = r.c.retry(func() (err error) {
+		r.file, headers, err = r.c.Connection.ObjectOpen(r.container, r.name, r.checkHash && r.pos == 0, h)
+		return err
+	})
+
+	return headers, err
+}
+
+// newStreamCopy reserves maxSize bytes in the spool for a stream copy,
+// waiting for space to be freed while the spool is full.
+func newStreamCopy(spool *Spool, maxSize uint64) (*streamCopy, error) {
+	if err := spool.wait(maxSize); err != nil {
+		return nil, err
+	}
+	sf, err := spool.Create()
+	if err != nil {
+		spool.release(maxSize)
+		return nil, err
+	}
+	return &streamCopy{sf: sf, reserved: maxSize}, nil
+}
+
+// Write copies data to the spool. Copying more than the reserved
+// space fails, failing the upload along with it rather than leaving
+// an upload that can't be retried.
+func (s *streamCopy) Write(p []byte) (int, error) {
+	if uint64(s.size)+uint64(len(p)) > s.reserved {
+		return 0, errStreamTooLarge
+	}
+	n, err := s.sf.file.WriteAt(p, s.size)
+	s.size += int64(n)
+	return n, err
+}
+
+func (s *streamCopy) reader() io.Reader {
+	return io.NewSectionReader(s.sf.file, 0, s.size)
+}
+
+func (s *streamCopy) remove() {
+	s.sf.Remove()
+	s.sf.spool.release(s.reserved)
+}
+
+// Write sends data to the object.
+func (w *objectWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close waits for the upload to complete.
+func (w *objectWriter) Close() error {
+	w.pw.Close()
+	<-w.done
+	return w.err
+}
+
+// abort fails the upload and waits for it to stop.
+func (w *objectWriter) abort() {
+	w.pw.CloseWithError(errUploadAborted)
+	<-w.done
+}
+
+var (
+	_ http.RoundTripper = (*swiftTransport)(nil)
+	_ ObjectReader      = (*objectReader)(nil)
+	_ io.WriteCloser    = (*objectWriter)(nil)
+	_ writeAborter      = (*objectWriter)(nil)
+	_ io.Writer         = (*streamCopy)(nil)
+)
@@ -0,0 +1,170 @@
+package svfs
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/xlucas/swift"
+)
+
+type RetryTestSuite struct {
+	suite.Suite
+	attempts int
+	conn     *Connection
+}
+
+func (suite *RetryTestSuite) SetupTest() {
+	suite.attempts = 0
+	suite.conn = newConnection()
+	suite.conn.MaxRetries = 3
+	suite.conn.RetryDelay = time.Millisecond
+	suite.conn.RetryMaxDelay = 4 * time.Millisecond
+	suite.conn.spool = NewSpool(os.TempDir(), 0)
+}
+
+func (suite *RetryTestSuite) failing(errs ...error) func() error {
+	return func() error {
+		suite.attempts++
+		if suite.attempts <= len(errs) {
+			return errs[suite.attempts-1]
+		}
+		return nil
+	}
+}
+
+func (suite *RetryTestSuite) TestRetryTransient() {
+	err := suite.conn.retry(suite.failing(swift.TooManyRequests, swift.TimeoutError))
+
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 3, suite.attempts)
+}
+
+func (suite *RetryTestSuite) TestRetryExhausted() {
+	err := suite.conn.retry(suite.failing(swift.TooManyRequests, swift.TooManyRequests,
+		swift.TooManyRequests, swift.TooManyRequests))
+
+	assert.Equal(suite.T(), swift.TooManyRequests, err)
+	assert.Equal(suite.T(), 4, suite.attempts)
+}
+
+func (suite *RetryTestSuite) TestRetryPermanent() {
+	err := suite.conn.retry(suite.failing(swift.ObjectNotFound))
+
+	assert.Equal(suite.T(), swift.ObjectNotFound, err)
+	assert.Equal(suite.T(), 1, suite.attempts)
+}
+
+func (suite *RetryTestSuite) TestRetryDisabled() {
+	suite.conn.MaxRetries = 0
+
+	err := suite.conn.retry(suite.failing(swift.TooManyRequests))
+
+	assert.Equal(suite.T(), swift.TooManyRequests, err)
+	assert.Equal(suite.T(), 1, suite.attempts)
+}
+
+func (suite *RetryTestSuite) TestIsTransient() {
+	assert.True(suite.T(), isTransient(&swift.Error{StatusCode: 503}))
+	assert.True(suite.T(), isTransient(io.ErrUnexpectedEOF))
+	assert.True(suite.T(), isTransient(&os.SyscallError{Syscall: "read", Err: syscall.ECONNRESET}))
+	assert.False(suite.T(), isTransient(swift.ObjectNotFound))
+	assert.False(suite.T(), isTransient(errors.New("other")))
+}
+
+func (suite *RetryTestSuite) TestRetryDelay() {
+	for attempt := uint64(0); attempt < 64; attempt++ {
+		delay := suite.conn.retryDelay(attempt)
+		assert.True(suite.T(), delay > 0)
+		assert.True(suite.T(), delay <= suite.conn.RetryMaxDelay)
+	}
+}
+
+func (suite *RetryTestSuite) TestRetryAfter() {
+	suite.conn.retryAfter.set("2")
+	suite.conn.retryAfter.set("invalid")
+	suite.conn.retryAfter.set("1")
+
+	delay := suite.conn.retryDelay(0)
+
+	assert.True(suite.T(), delay > time.Second)
+	assert.True(suite.T(), delay <= 2*time.Second)
+}
+
+func (suite *RetryTestSuite) TestRewinder() {
+	rd := strings.NewReader("content")
+	io.CopyN(ioutil.Discard, rd, 3)
+
+	start, ok := rewinder(rd)
+	require.True(suite.T(), ok)
+	ioutil.ReadAll(rd)
+	require.Nil(suite.T(), start())
+
+	data, _ := ioutil.ReadAll(rd)
+	assert.Equal(suite.T(), "tent", string(data))
+
+	_, ok = rewinder(newSegmentBuffer(1))
+	assert.False(suite.T(), ok)
+}
+
+func (suite *RetryTestSuite) TestStreamCopy() {
+	stream, err := newStreamCopy(suite.conn.spool, 16)
+	require.NoError(suite.T(), err)
+	defer stream.remove()
+
+	io.Copy(stream, strings.NewReader("content"))
+
+	data, err := ioutil.ReadAll(stream.reader())
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), "content", string(data))
+}
+
+func (suite *RetryTestSuite) TestStreamCopyTooLarge() {
+	stream, err := newStreamCopy(suite.conn.spool, 4)
+	require.NoError(suite.T(), err)
+	defer stream.remove()
+
+	_, err = io.Copy(stream, strings.NewReader("content"))
+
+	assert.Equal(suite.T(), errStreamTooLarge, err)
+}
+
+func (suite *RetryTestSuite) TestStreamCopySpoolFull() {
+	spool := NewSpool(os.TempDir(), 8)
+
+	_, err := newStreamCopy(spool, 16)
+	assert.Equal(suite.T(), errSpoolFull, err)
+
+	// Copies wait for space to be freed
+	stream, err := newStreamCopy(spool, 8)
+	require.NoError(suite.T(), err)
+
+	created := make(chan *streamCopy)
+	go func() {
+		other, err := newStreamCopy(spool, 4)
+		assert.NoError(suite.T(), err)
+		created <- other
+	}()
+
+	select {
+	case <-created:
+		suite.T().Fatal("Stream copy created while the spool is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	stream.remove()
+	(<-created).remove()
+	assert.Equal(suite.T(), uint64(0), spool.used)
+}
+
+func TestRetrySuite(t *testing.T) {
+	suite.Run(t, new(RetryTestSuite))
+}
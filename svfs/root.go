@@ -3,18 +3,23 @@ package svfs
 import (
 	"regexp"
 	"strings"
+	"sync/atomic"
+	"syscall"
 
 	"golang.org/x/net/context"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/xlucas/swift"
 )
 
 const (
 	segmentContainerSuffix = "_segments"
 	storagePolicyHeader    = "X-Storage-Policy"
+	containerReadHeader    = "X-Container-Read"
+	containerWriteHeader   = "X-Container-Write"
 )
 
 var (
@@ -40,10 +45,10 @@ func (r *Root) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, erro
 
 	for _, name := range []string{req.Name, segmentContainer} {
 		headers := make(map[string]string)
-		if StoragePolicy != "" {
-			headers[storagePolicyHeader] = StoragePolicy
+		if r.fs.StoragePolicy != "" {
+			headers[storagePolicyHeader] = r.fs.StoragePolicy
 		}
-		err := SwiftConnection.ContainerCreate(name, headers)
+		err := r.fs.Storage.ContainerCreate(name, headers)
 		if err != nil {
 			return nil, err
 		}
@@ -51,12 +56,13 @@ func (r *Root) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, erro
 	}
 
 	container := &Directory{
+		fs:   r.fs,
 		c:    containers[req.Name],
 		cs:   containers[segmentContainer],
 		name: req.Name,
 	}
 
-	directoryCache.Set("", r.path, req.Name, container)
+	r.fs.directoryCache.Set("", r.path, req.Name, container)
 
 	return container, nil
 }
@@ -67,7 +73,7 @@ func (r *Root) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
 		return fuse.ENOTSUP
 	}
 	for _, container := range []string{req.Name + segmentContainerSuffix, req.Name} {
-		if err := SwiftConnection.ContainerDelete(container); err != nil {
+		if err := r.fs.Storage.ContainerDelete(container); err != nil {
 			if err == swift.ContainerNotEmpty {
 				return fuse.ENOTEMPTY
 			}
@@ -77,14 +83,113 @@ func (r *Root) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
 		}
 	}
 
-	directoryCache.Delete("", r.path, req.Name)
+	r.fs.directoryCache.Delete("", r.path, req.Name)
 
 	return nil
 }
 
-// Rename is not supported on a root node.
+// Rename moves a container and its segment container to new ones. Since swift
+// can't rename containers, new containers are created using the same storage
+// policy and metadata, then all objects are copied server-side before removing
+// old containers.
 func (r *Root) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Node) error {
-	return fuse.ENOTSUP
+	if _, ok := newDir.(*Root); !ok {
+		return fuse.ENOTSUP
+	}
+
+	// Segment containers are hidden
+	if segmentRegex.MatchString(req.NewName) {
+		return fuse.Errno(syscall.EINVAL)
+	}
+
+	container, err := r.container(req.OldName)
+	if err != nil {
+		return err
+	}
+
+	// Files are being written within this container
+	if err := r.fs.writeBackQueue.WaitPrefix(req.OldName, ""); err != nil {
+		return err
+	}
+	if r.fs.changeCache.ExistPrefix(req.OldName, "") {
+		return fuse.Errno(syscall.EBUSY)
+	}
+
+	var (
+		oldSegments = req.OldName + segmentContainerSuffix
+		newSegments = req.NewName + segmentContainerSuffix
+		log         = logrus.WithFields(logrus.Fields{
+			"source": req.OldName,
+			"target": req.NewName,
+		})
+	)
+
+	// Only empty containers can be replaced
+	created := make(map[string]bool)
+	for _, name := range []string{req.NewName, newSegments} {
+		existing, _, err := r.fs.Storage.Container(name)
+		if err == nil && existing.Count > 0 {
+			return fuse.ENOTEMPTY
+		}
+		if err != nil && err != swift.ContainerNotFound {
+			return err
+		}
+		created[name] = err == swift.ContainerNotFound
+	}
+
+	// Undo the copy if it can't complete, so that the rename
+	// can be attempted again.
+	copied := false
+	defer func() {
+		if !copied {
+			r.fs.removeRenameTargets([]string{req.NewName, newSegments}, created, log)
+		}
+	}()
+
+	// Create new containers, segments first
+	log.Infoln("Creating containers")
+	for _, names := range [][2]string{{oldSegments, newSegments}, {req.OldName, req.NewName}} {
+		if err := r.fs.cloneContainer(names[0], names[1]); err != nil {
+			return err
+		}
+	}
+
+	// Copy segments before manifests referencing them
+	for _, names := range [][2]string{{oldSegments, newSegments}, {req.OldName, req.NewName}} {
+		if err := r.fs.copyContainerObjects(names[0], names[1], oldSegments, newSegments, log); err != nil {
+			return err
+		}
+	}
+
+	// Delete old containers, manifests first. New containers hold
+	// a complete copy, so old ones are kept partially deleted on
+	// failure and cached content of both containers is dropped.
+	copied = true
+	for _, name := range []string{req.OldName, oldSegments} {
+		err := r.fs.emptyContainer(name, log)
+		if err == nil {
+			err = r.fs.Storage.ContainerDelete(name)
+		}
+		if err != nil {
+			r.fs.directoryCache.DeletePrefix("", r.path)
+			r.fs.directoryCache.DeletePrefix(req.OldName, "")
+			r.fs.directoryCache.DeletePrefix(req.NewName, "")
+			r.fs.reportRenameSources([]string{req.OldName, oldSegments}, log)
+			return err
+		}
+	}
+
+	// Update cache
+	r.fs.directoryCache.Delete("", r.path, req.OldName)
+	r.fs.directoryCache.RekeyContainer(req.OldName, req.NewName)
+	container.c.Name = req.NewName
+	container.cs.Name = newSegments
+	container.name = req.NewName
+	r.fs.directoryCache.Set("", r.path, req.NewName, container)
+
+	log.Infoln("Container renamed")
+
+	return nil
 }
 
 // ReadDirAll retrieves all containers within the current Openstack tenant, as direntries.
@@ -97,7 +202,7 @@ func (r *Root) ReadDirAll(ctx context.Context) (direntries []fuse.Dirent, err er
 	)
 
 	// Cache hit
-	if _, nodes := directoryCache.GetAll("", r.path); nodes != nil {
+	if _, nodes := r.fs.directoryCache.GetAll("", r.path); nodes != nil {
 		for _, node := range nodes {
 			direntries = append(direntries, node.Export())
 		}
@@ -105,7 +210,7 @@ func (r *Root) ReadDirAll(ctx context.Context) (direntries []fuse.Dirent, err er
 	}
 
 	// Retrieve all containers
-	cs, err := SwiftConnection.ContainersAll(nil)
+	cs, err := r.fs.Storage.ContainersAll(nil)
 	if err != nil {
 		return nil, err
 	}
@@ -113,12 +218,12 @@ func (r *Root) ReadDirAll(ctx context.Context) (direntries []fuse.Dirent, err er
 	// Sort base and segment containers
 	for _, segmentContainer := range cs {
 		s := segmentContainer
-		if StoragePolicy != "" {
-			_, headers, err := SwiftConnection.Container(s.Name)
+		if r.fs.StoragePolicy != "" {
+			_, headers, err := r.fs.Storage.Container(s.Name)
 			if err != nil {
 				return nil, err
 			}
-			if headers[storagePolicyHeader] != StoragePolicy {
+			if headers[storagePolicyHeader] != r.fs.StoragePolicy {
 				continue
 			}
 		}
@@ -136,7 +241,7 @@ func (r *Root) ReadDirAll(ctx context.Context) (direntries []fuse.Dirent, err er
 		c := baseContainer
 		// Create segment container if missing
 		if segmentContainers[c.Name] == nil {
-			segmentContainers[c.Name], err = createContainer(c.Name + segmentContainerSuffix)
+			segmentContainers[c.Name], err = r.fs.createContainer(c.Name + segmentContainerSuffix)
 			if err != nil {
 				return nil, err
 			}
@@ -144,6 +249,7 @@ func (r *Root) ReadDirAll(ctx context.Context) (direntries []fuse.Dirent, err er
 
 		// Register direntries and cache entries
 		child := &Directory{
+			fs:   r.fs,
 			c:    c,
 			cs:   segmentContainers[c.Name],
 			name: c.Name,
@@ -153,7 +259,7 @@ func (r *Root) ReadDirAll(ctx context.Context) (direntries []fuse.Dirent, err er
 		direntries = append(direntries, child.Export())
 	}
 
-	directoryCache.AddAll("", r.path, r, children)
+	r.fs.directoryCache.AddAll("", r.path, r, children)
 
 	return direntries, nil
 }
@@ -162,12 +268,12 @@ func (r *Root) ReadDirAll(ctx context.Context) (direntries []fuse.Dirent, err er
 // name within the current context.
 func (r *Root) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
 	// Fill cache if expired
-	if _, found := directoryCache.Peek("", r.path); !found {
+	if _, found := r.fs.directoryCache.Peek("", r.path); !found {
 		r.ReadDirAll(ctx)
 	}
 
 	// Find matching child
-	if item := directoryCache.Get("", r.path, req.Name); item != nil {
+	if item := r.fs.directoryCache.Get("", r.path, req.Name); item != nil {
 		if n, ok := item.(fs.Node); ok {
 			return n, nil
 		}
@@ -176,6 +282,173 @@ func (r *Root) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.L
 	return nil, fuse.ENOENT
 }
 
+// container returns the node of a container, built from the storage
+// if the cache no longer holds it.
+func (r *Root) container(name string) (*Directory, error) {
+	if container, ok := r.fs.directoryCache.Get("", r.path, name).(*Directory); ok {
+		return container, nil
+	}
+
+	node, err := r.fs.rootContainer(name)
+	if err == swift.ContainerNotFound {
+		return nil, fuse.ENOENT
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	container := node.(*Directory)
+	container.apex = false
+	container.name = name
+
+	return container, nil
+}
+
+// cloneContainer creates a container using the storage policy
+// and metadata of another one.
+func (s *SVFS) cloneContainer(source, target string) error {
+	_, h, err := s.Storage.Container(source)
+	if err != nil {
+		return err
+	}
+
+	headers := h.ContainerMetadata().ContainerHeaders()
+	for _, key := range []string{storagePolicyHeader, containerReadHeader, containerWriteHeader} {
+		if value := h[key]; value != "" {
+			headers[key] = value
+		}
+	}
+
+	return s.Storage.ContainerCreate(target, headers)
+}
+
+// copyContainerObjects copies all objects from a container to another
+// one. Manifests referencing segments within the old segment container
+// are rewritten to reference the new segment container.
+func (s *SVFS) copyContainerObjects(source, target, oldSegments, newSegments string, log *logrus.Entry) error {
+	var copied uint64
+
+	objects, err := s.Storage.ObjectsAll(source, nil)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Copying %d objects from %s to %s", len(objects), source, target)
+
+	err = forEachConcurrently(s.RenameConcurrency, len(objects), func(i int) error {
+		object := &objects[i]
+
+		// Segments are never manifests
+		if source == oldSegments {
+			_, err := s.Storage.ObjectCopy(source, object.Name, target, object.Name, nil)
+			return err
+		}
+
+		_, h, err := s.Storage.Object(source, object.Name)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case isStaticLargeObject(h):
+			err = s.copyStaticManifest(source, target, object.Name, oldSegments, newSegments, h)
+		case h[manifestHeader] != "":
+			manifest := h[manifestHeader]
+			if prefix, err := manifestPrefix(oldSegments, manifest); err == nil {
+				manifest = encodeManifest(newSegments + "/" + prefix)
+			}
+			_, err = s.Storage.ManifestCopy(source, object.Name, target, object.Name, swift.Headers{
+				manifestHeader: manifest,
+			})
+		default:
+			_, err = s.Storage.ObjectCopy(source, object.Name, target, object.Name, nil)
+		}
+		if err != nil {
+			return err
+		}
+
+		if n := atomic.AddUint64(&copied, 1); n%1000 == 0 {
+			log.Infof("Copied %d/%d objects from %s", n, len(objects), source)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// copyStaticManifest creates a copy of a static large object manifest
+// with segments of the old segment container moved to the new one.
+func (s *SVFS) copyStaticManifest(source, target, name, oldSegments, newSegments string, h swift.Headers) error {
+	segments, err := s.Storage.GetStaticManifest(source, name)
+	if err != nil {
+		return err
+	}
+
+	for i, segment := range segments {
+		if strings.HasPrefix(segment.Path, "/"+oldSegments+"/") {
+			segments[i].Path = "/" + newSegments + strings.TrimPrefix(segment.Path, "/"+oldSegments)
+		}
+	}
+
+	headers := h.ObjectMetadata().ObjectHeaders()
+	headers["Content-Type"] = h["Content-Type"]
+
+	return s.Storage.CreateStaticManifest(target, name, segments, headers)
+}
+
+// emptyContainer deletes all objects within a container.
+func (s *SVFS) emptyContainer(name string, log *logrus.Entry) error {
+	objects, err := s.Storage.ObjectNamesAll(name, nil)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Deleting %d objects from %s", len(objects), name)
+
+	return forEachConcurrently(s.RenameConcurrency, len(objects), func(i int) error {
+		err := s.Storage.ObjectDelete(name, objects[i])
+		if err == swift.ObjectNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+// removeRenameTargets empties containers filled by a container rename
+// that failed, manifests first, and deletes those it created.
+func (s *SVFS) removeRenameTargets(names []string, created map[string]bool, log *logrus.Entry) {
+	log.Warnln("Removing copied objects")
+	for _, name := range names {
+		if err := s.emptyContainer(name, log); err != nil && err != swift.ContainerNotFound {
+			log.Errorf("Failed to empty %s : %v", name, err)
+			continue
+		}
+		if !created[name] {
+			continue
+		}
+		if err := s.Storage.ContainerDelete(name); err != nil && err != swift.ContainerNotFound {
+			log.Errorf("Failed to delete %s : %v", name, err)
+		}
+	}
+}
+
+// reportRenameSources logs objects left within old containers by a
+// container rename that failed to delete them.
+func (s *SVFS) reportRenameSources(names []string, log *logrus.Entry) {
+	for _, name := range names {
+		objects, err := s.Storage.ObjectNamesAll(name, nil)
+		if err == swift.ContainerNotFound {
+			continue
+		}
+		if err != nil {
+			log.Errorf("Failed to list %s : %v", name, err)
+			continue
+		}
+		log.WithField("objects", objects).Errorf("Rename is incomplete, %d objects remain in %s", len(objects), name)
+	}
+}
+
 var (
 	_ Node           = (*Root)(nil)
 	_ fs.Node        = (*Root)(nil)
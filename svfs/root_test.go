@@ -1,10 +1,19 @@
 package svfs
 
 import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/xlucas/swift"
 
 	"bazil.org/fuse"
 )
@@ -18,13 +27,17 @@ func TestRoot(t *testing.T) {
 
 	// Unsupported operations
 	t.Run("RootCreate", testRootCreate)
-	t.Run("RootRename", testRootRename)
+	t.Run("RootRenameMiss", testRootRenameMiss)
 	t.Run("RootRemoveFile", testRootRemoveFile)
 
 	// Container creation
 	t.Run("RootMkdir", testRootMkdir)
 	t.Run("RootLookup", testRootLookup)
 
+	// Container renaming
+	t.Run("RootRename", testRootRename)
+	t.Run("RootLookup", testRootLookup)
+
 	// Container removal
 	t.Run("RootRemove", testRootRemove)
 	t.Run("RootLookupMiss", testRootLookupMiss)
@@ -66,8 +79,19 @@ func testRootReadDirAll(t *testing.T) {
 }
 
 func testRootRename(t *testing.T) {
+	req := &fuse.RenameRequest{OldName: containerName, NewName: containerName + "_renamed"}
+	assert.Nil(t, ctx.r.Rename(nil, req, ctx.r))
+	assert.Equal(t, req.NewName, ctx.c.Name())
+	assert.Equal(t, req.NewName+segmentContainerSuffix, ctx.c.cs.Name)
+
+	// Move it back
+	req = &fuse.RenameRequest{OldName: req.NewName, NewName: containerName}
+	assert.Nil(t, ctx.r.Rename(nil, req, ctx.r))
+}
+
+func testRootRenameMiss(t *testing.T) {
 	req := &fuse.RenameRequest{OldName: "foo", NewName: "bar"}
-	assert.Equal(t, ctx.r.Rename(nil, req, ctx.r), fuse.ENOTSUP)
+	assert.Equal(t, ctx.r.Rename(nil, req, ctx.r), fuse.ENOENT)
 }
 
 func testRootRemove(t *testing.T) {
@@ -79,3 +103,178 @@ func testRootRemoveFile(t *testing.T) {
 	req := &fuse.RemoveRequest{Name: "foo"}
 	assert.Equal(t, ctx.r.Remove(nil, req), fuse.ENOTSUP)
 }
+
+type RootRenameTestSuite struct {
+	localFSSuite
+	root *Root
+}
+
+func (suite *RootRenameTestSuite) SetupTest() {
+	suite.localFSSuite.SetupTest()
+	suite.fs.RenameConcurrency = 2
+	suite.fs.changeCache = NewSimpleCache()
+	suite.fs.directoryCache = NewCache(time.Minute, -1, -1)
+	suite.fs.writeBackQueue = NewWriteBackQueue(suite.fs)
+	suite.root = &Root{Directory: &Directory{fs: suite.fs, apex: true}}
+
+	require.NoError(suite.T(), suite.backend.ContainerCreate("container_segments", nil))
+	require.NoError(suite.T(), suite.backend.ObjectPutBytes("container_segments", "file/1480000000/00000001", []byte("segment"), ""))
+	require.NoError(suite.T(), suite.backend.ObjectPutBytes("container", "plain", []byte("plain"), ""))
+	_, err := suite.backend.ObjectPut("container", "file", bytes.NewReader(nil), false, "", "", swift.Headers{
+		manifestHeader: "container_segments/file/1480000000",
+	})
+	require.NoError(suite.T(), err)
+}
+
+func (suite *RootRenameTestSuite) read(container, name string) string {
+	rd, _, err := suite.backend.ObjectOpen(container, name, false, nil)
+	require.NoError(suite.T(), err)
+	defer rd.Close()
+	content, err := ioutil.ReadAll(rd)
+	require.NoError(suite.T(), err)
+	return string(content)
+}
+
+func (suite *RootRenameTestSuite) TestRename() {
+	// The container isn't cached
+	req := &fuse.RenameRequest{OldName: "container", NewName: "renamed"}
+	require.NoError(suite.T(), suite.root.Rename(nil, req, suite.root))
+
+	names, err := suite.backend.ContainerNamesAll(nil)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []string{"renamed", "renamed_segments"}, names)
+
+	// Manifests reference the new segment container
+	_, h, err := suite.backend.Object("renamed", "file")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "renamed_segments/file/1480000000", h[manifestHeader])
+	assert.Equal(suite.T(), "segment", suite.read("renamed", "file"))
+	assert.Equal(suite.T(), "plain", suite.read("renamed", "plain"))
+}
+
+func (suite *RootRenameTestSuite) TestRenameStaticLargeObject() {
+	require.NoError(suite.T(), suite.backend.ObjectPutBytes("container_segments", "slo/1480000000/00000001", []byte("static"), ""))
+	require.NoError(suite.T(), suite.backend.CreateStaticManifest("container", "slo", []StaticSegment{
+		{Path: "/container_segments/slo/1480000000/00000001", Size: 6},
+	}, swift.Headers{objectMetaHeader + "A": "1"}))
+
+	req := &fuse.RenameRequest{OldName: "container", NewName: "renamed"}
+	require.NoError(suite.T(), suite.root.Rename(nil, req, suite.root))
+
+	// Manifests reference the new segment container
+	segments, err := suite.backend.GetStaticManifest("renamed", "slo")
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), segments, 1)
+	assert.Equal(suite.T(), "/renamed_segments/slo/1480000000/00000001", segments[0].Path)
+
+	_, h, err := suite.backend.Object("renamed", "slo")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "1", h[objectMetaHeader+"A"])
+	assert.Equal(suite.T(), "static", suite.read("renamed", "slo"))
+}
+
+func (suite *RootRenameTestSuite) TestRenameMissing() {
+	req := &fuse.RenameRequest{OldName: "missing", NewName: "renamed"}
+	assert.Equal(suite.T(), fuse.ENOENT, suite.root.Rename(nil, req, suite.root))
+}
+
+func (suite *RootRenameTestSuite) TestRenameSegmentContainer() {
+	req := &fuse.RenameRequest{OldName: "container", NewName: "renamed_segments"}
+	assert.Equal(suite.T(), fuse.Errno(syscall.EINVAL), suite.root.Rename(nil, req, suite.root))
+}
+
+func (suite *RootRenameTestSuite) TestRenameNotEmpty() {
+	require.NoError(suite.T(), suite.backend.ContainerCreate("renamed_segments", nil))
+	require.NoError(suite.T(), suite.backend.ObjectPutBytes("renamed_segments", "file/1/00000001", nil, ""))
+
+	req := &fuse.RenameRequest{OldName: "container", NewName: "renamed"}
+	assert.Equal(suite.T(), fuse.ENOTEMPTY, suite.root.Rename(nil, req, suite.root))
+
+	_, _, err := suite.backend.Container("renamed")
+	assert.Equal(suite.T(), swift.ContainerNotFound, err)
+}
+
+func (suite *RootRenameTestSuite) TestRenameKeepsACL() {
+	require.NoError(suite.T(), suite.backend.ContainerUpdate("container", swift.Headers{
+		containerReadHeader:  ".r:*",
+		containerWriteHeader: "account:user",
+	}))
+
+	req := &fuse.RenameRequest{OldName: "container", NewName: "renamed"}
+	require.NoError(suite.T(), suite.root.Rename(nil, req, suite.root))
+
+	_, h, err := suite.backend.Container("renamed")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), ".r:*", h[containerReadHeader])
+	assert.Equal(suite.T(), "account:user", h[containerWriteHeader])
+}
+
+func (suite *RootRenameTestSuite) TestRenameFailure() {
+	// Content of this object can't be read
+	broken := filepath.Join(suite.dir, "storage", "container", localDataDir, "broken")
+	require.NoError(suite.T(), os.Mkdir(broken, 0700))
+
+	req := &fuse.RenameRequest{OldName: "container", NewName: "renamed"}
+	assert.Error(suite.T(), suite.root.Rename(nil, req, suite.root))
+
+	// Copied objects are removed along with new containers
+	names, err := suite.backend.ContainerNamesAll(nil)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []string{"container", "container_segments"}, names)
+	assert.Equal(suite.T(), "segment", suite.read("container", "file"))
+
+	// The rename can be attempted again
+	require.NoError(suite.T(), os.Remove(broken))
+	require.NoError(suite.T(), suite.root.Rename(nil, req, suite.root))
+	assert.Equal(suite.T(), "segment", suite.read("renamed", "file"))
+}
+
+func (suite *RootRenameTestSuite) TestRenameEncodedManifest() {
+	require.NoError(suite.T(), suite.backend.ContainerCreate("a&b", nil))
+	require.NoError(suite.T(), suite.backend.ContainerCreate("a&b_segments", nil))
+	_, err := suite.backend.ObjectPut("a&b", "file?", bytes.NewReader(nil), false, "", "", swift.Headers{
+		manifestHeader: "a%26b_segments/file%3F/1480000000",
+	})
+	require.NoError(suite.T(), err)
+
+	req := &fuse.RenameRequest{OldName: "a&b", NewName: "c&d"}
+	require.NoError(suite.T(), suite.root.Rename(nil, req, suite.root))
+
+	_, h, err := suite.backend.Object("c&d", "file?")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "c%26d_segments/file%3F/1480000000", h[manifestHeader])
+}
+
+func (suite *RootRenameTestSuite) TestRenameDeleteFailure() {
+	suite.fs.Storage = &failingDeleteBackend{LocalBackend: suite.backend, name: "plain"}
+	suite.fs.directoryCache.AddAll("container", "", &Directory{}, map[string]Node{
+		"plain": &Object{},
+	})
+
+	req := &fuse.RenameRequest{OldName: "container", NewName: "renamed"}
+	assert.Error(suite.T(), suite.root.Rename(nil, req, suite.root))
+
+	// The copy is kept while the old container isn't cached anymore
+	assert.Equal(suite.T(), "plain", suite.read("renamed", "plain"))
+	assert.Equal(suite.T(), "plain", suite.read("container", "plain"))
+	_, found := suite.fs.directoryCache.Peek("container", "")
+	assert.False(suite.T(), found)
+}
+
+// failingDeleteBackend is a local storage failing to delete objects
+// with the given name.
+type failingDeleteBackend struct {
+	*LocalBackend
+	name string
+}
+
+func (b *failingDeleteBackend) ObjectDelete(container, name string) error {
+	if name == b.name {
+		return errors.New("delete failed")
+	}
+	return b.LocalBackend.ObjectDelete(container, name)
+}
+
+func TestRootRenameSuite(t *testing.T) {
+	suite.Run(t, new(RootRenameTestSuite))
+}
@@ -0,0 +1,162 @@
+package svfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/xlucas/swift"
+)
+
+const (
+	staticManifestHeader = "X-Static-Large-Object"
+	multipartManifest    = "multipart-manifest"
+)
+
+var errNotStaticLargeObject = errors.New("Not a static large object")
+
+// StaticSegment is a segment description as expected by swift
+// when uploading a static large object manifest.
+type StaticSegment struct {
+	Path string `json:"path"`
+	Etag string `json:"etag"`
+	Size uint64 `json:"size_bytes"`
+}
+
+// staticManifestEntry is a segment description as returned by
+// swift when downloading a static large object manifest.
+type staticManifestEntry struct {
+	Name  string `json:"name"`
+	Hash  string `json:"hash"`
+	Bytes uint64 `json:"bytes"`
+}
+
+func isStaticLargeObject(headers swift.Headers) bool {
+	value, _ := strconv.ParseBool(headers[staticManifestHeader])
+	return value
+}
+
+func (c *Connection) storageCall(p swift.RequestOpts) (*http.Response, swift.Headers, error) {
+	p.OnReAuth = func() (string, error) {
+		return c.StorageUrl, nil
+	}
+	return c.Call(c.StorageUrl, p)
+}
+
+// CreateStaticManifest uploads a static large object manifest
+// referencing the given segments, along with extra headers.
+func (c *Connection) CreateStaticManifest(container, path string, segments []StaticSegment, h swift.Headers) error {
+	body, err := json.Marshal(segments)
+	if err != nil {
+		return err
+	}
+
+	headers := swift.Headers{
+		"Content-Length": strconv.Itoa(len(body)),
+	}
+	for k, v := range h {
+		headers[k] = v
+	}
+	if headers["Content-Type"] == "" {
+		headers[autoContentHeader] = "true"
+	}
+
+	_, _, err = c.storageCall(swift.RequestOpts{
+		Container:  container,
+		ObjectName: path,
+		Operation:  "PUT",
+		Parameters: url.Values{multipartManifest: []string{"put"}},
+		Headers:    headers,
+		Body:       bytes.NewReader(body),
+		NoResponse: true,
+	})
+
+	return err
+}
+
+// GetStaticManifest retrieves segments referenced by a static large
+// object manifest.
+func (c *Connection) GetStaticManifest(container, path string) (segments []StaticSegment, err error) {
+	var entries []staticManifestEntry
+
+	resp, _, err := c.storageCall(swift.RequestOpts{
+		Container:  container,
+		ObjectName: path,
+		Operation:  "GET",
+		Parameters: url.Values{multipartManifest: []string{"get"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("Invalid static manifest %s : %v", path, err)
+	}
+
+	for _, entry := range entries {
+		segments = append(segments, StaticSegment{
+			Path: entry.Name,
+			Etag: strings.Trim(entry.Hash, "\""),
+			Size: entry.Bytes,
+		})
+	}
+
+	return segments, nil
+}
+
+// DeleteStaticLargeObject removes a static large object manifest
+// along with all its segments.
+func (c *Connection) DeleteStaticLargeObject(container, path string) error {
+	_, _, err := c.storageCall(swift.RequestOpts{
+		Container:  container,
+		ObjectName: path,
+		Operation:  "DELETE",
+		Parameters: url.Values{multipartManifest: []string{"delete"}},
+		NoResponse: true,
+	})
+	return err
+}
+
+// lastStaticSegmentID returns the highest number of the segments
+// referenced by a static large object manifest under the given prefix,
+// so that segments appended later never replace referenced ones.
+func lastStaticSegmentID(segments []StaticSegment, container, prefix string) uint {
+	var (
+		id   uint
+		base = staticSegmentPath(container, prefix) + "/"
+	)
+
+	for _, segment := range segments {
+		if !strings.HasPrefix(segment.Path, base) {
+			continue
+		}
+		n, err := strconv.ParseUint(strings.TrimPrefix(segment.Path, base), 10, 0)
+		if err == nil && uint(n) > id {
+			id = uint(n)
+		}
+	}
+
+	return id
+}
+
+// staticSegmentPath gives the path of a segment as referenced
+// within a static large object manifest.
+func staticSegmentPath(container, segment string) string {
+	return "/" + container + "/" + segment
+}
+
+// splitStaticSegmentPath returns the container and name of a segment
+// referenced within a static large object manifest.
+func splitStaticSegmentPath(path string) (container, segment string) {
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
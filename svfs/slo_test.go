@@ -0,0 +1,45 @@
+package svfs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"github.com/xlucas/swift"
+)
+
+type StaticLargeObjectTestSuite struct {
+	suite.Suite
+}
+
+func (suite *StaticLargeObjectTestSuite) TestIsStaticLargeObject() {
+	assert.True(suite.T(), isStaticLargeObject(swift.Headers{staticManifestHeader: "True"}))
+	assert.False(suite.T(), isStaticLargeObject(swift.Headers{manifestHeader: "c_segments/file"}))
+	assert.False(suite.T(), isStaticLargeObject(nil))
+}
+
+func (suite *StaticLargeObjectTestSuite) TestIsSegmented() {
+	assert.True(suite.T(), isSegmented(swift.Headers{staticManifestHeader: "True"}))
+	assert.True(suite.T(), isSegmented(swift.Headers{manifestHeader: "c_segments/file/1"}))
+	assert.False(suite.T(), isSegmented(swift.Headers{}))
+}
+
+func (suite *StaticLargeObjectTestSuite) TestStaticSegmentPath() {
+	assert.Equal(suite.T(), "/c_segments/file/1/00000001", staticSegmentPath("c_segments", "file/1/00000001"))
+}
+
+func (suite *StaticLargeObjectTestSuite) TestLastStaticSegmentID() {
+	segments := []StaticSegment{
+		{Path: "/c_segments/file/1/00000001"},
+		{Path: "/c_segments/file/2/00000001"},
+		{Path: "/c_segments/file/2/00000003"},
+		{Path: "/c_segments/file/20/00000009"},
+		{Path: "/other/file/2/00000007"},
+	}
+	assert.Equal(suite.T(), uint(3), lastStaticSegmentID(segments, "c_segments", "file/2"))
+	assert.Equal(suite.T(), uint(0), lastStaticSegmentID(segments, "c_segments", "file/3"))
+}
+
+func TestStaticLargeObjectTestSuite(t *testing.T) {
+	suite.Run(t, new(StaticLargeObjectTestSuite))
+}
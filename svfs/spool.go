@@ -0,0 +1,265 @@
+package svfs
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"bazil.org/fuse"
+	"github.com/Sirupsen/logrus"
+)
+
+const spoolLockFile = ".lock"
+
+var errSpoolFull = fuse.Errno(syscall.ENOSPC)
+
+// Spool is a bounded local storage area holding staged copies
+// of objects opened in read-write mode.
+type Spool struct {
+	dir     string
+	maxSize uint64
+	mutex   sync.Mutex
+	freed   *sync.Cond
+	used    uint64
+	lock    *os.File
+}
+
+// SpoolFile is a staged copy of an object. Reads and writes
+// happen against this file until it is uploaded back to swift.
+type SpoolFile struct {
+	file  *os.File
+	spool *Spool
+	size  uint64
+	dirty bool
+}
+
+// NewSpool creates a spool within the given directory, holding at
+// most maxSize bytes or an unlimited amount of data if 0.
+func NewSpool(dir string, maxSize uint64) *Spool {
+	s := &Spool{dir: dir, maxSize: maxSize}
+	s.freed = sync.NewCond(&s.mutex)
+	return s
+}
+
+// Init makes sure the spool directory exists.
+func (s *Spool) Init() error {
+	return os.MkdirAll(s.dir, 0700)
+}
+
+// Clean removes files left in the spool directory by previous
+// mounts, except the given ones. Nothing is removed while another
+// mount uses the directory, which is locked until the spool is
+// closed.
+func (s *Spool) Clean(keep map[string]bool) error {
+	lock, err := os.OpenFile(filepath.Join(s.dir, spoolLockFile), os.O_RDONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err == nil {
+		files, _ := filepath.Glob(filepath.Join(s.dir, "svfs-*"))
+		for _, file := range files {
+			if keep[file] {
+				continue
+			}
+			logrus.WithField("file", file).Infoln("Removing staged file left by a previous mount")
+			os.Remove(file)
+		}
+	}
+
+	// Other mounts may use the directory from now on
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_SH); err != nil {
+		lock.Close()
+		return err
+	}
+	s.lock = lock
+
+	return nil
+}
+
+// Close releases the spool directory locked by Clean.
+func (s *Spool) Close() error {
+	if s.lock == nil {
+		return nil
+	}
+	err := s.lock.Close()
+	s.lock = nil
+	return err
+}
+
+// Create allocates a new empty file in the spool directory.
+func (s *Spool) Create() (*SpoolFile, error) {
+	f, err := ioutil.TempFile(s.dir, "svfs-")
+	if err != nil {
+		return nil, err
+	}
+	return &SpoolFile{file: f, spool: s}, nil
+}
+
+// Open adopts a file left in the spool directory by a previous
+// mount. Its content is considered modified.
+func (s *Spool) Open(file *os.File) (*SpoolFile, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	sf := &SpoolFile{file: file, spool: s, dirty: true}
+	if err := sf.resize(uint64(info.Size())); err != nil {
+		return nil, err
+	}
+
+	return sf, nil
+}
+
+func (s *Spool) reserve(size uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.maxSize > 0 && s.used+size > s.maxSize {
+		return errSpoolFull
+	}
+	s.used += size
+
+	return nil
+}
+
+// wait reserves space within the spool, waiting for other files
+// to free it while the spool is full. It fails if the spool can't
+// hold that much data.
+func (s *Spool) wait(size uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.maxSize > 0 && size > s.maxSize {
+		return errSpoolFull
+	}
+	for s.maxSize > 0 && s.used+size > s.maxSize {
+		s.freed.Wait()
+	}
+	s.used += size
+
+	return nil
+}
+
+func (s *Spool) release(size uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.used -= size
+	s.freed.Broadcast()
+}
+
+// Clone copies the spool file to a new file within the spool.
+func (sf *SpoolFile) Clone() (*SpoolFile, error) {
+	clone, err := sf.spool.Create()
+	if err != nil {
+		return nil, err
+	}
+	if err := clone.Fill(io.NewSectionReader(sf.file, 0, int64(sf.size)), sf.size); err != nil {
+		clone.Remove()
+		return nil, err
+	}
+	clone.dirty = true
+	return clone, nil
+}
+
+// Close closes the spool file, freeing its space within the spool
+// but keeping it on disk.
+func (sf *SpoolFile) Close() error {
+	sf.spool.release(sf.size)
+	sf.size = 0
+	return sf.file.Close()
+}
+
+// Fill copies content from the reader to the spool file. It
+// expects size bytes to be read.
+func (sf *SpoolFile) Fill(rd io.Reader, size uint64) error {
+	if err := sf.resize(size); err != nil {
+		return err
+	}
+	_, err := io.CopyN(sf.file, rd, int64(size))
+	return err
+}
+
+// ReadAt reads data from the spool file at the given offset.
+func (sf *SpoolFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := sf.file.ReadAt(p, off)
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// Remove closes and deletes the spool file, freeing its space
+// within the spool.
+func (sf *SpoolFile) Remove() error {
+	sf.spool.release(sf.size)
+	sf.size = 0
+	sf.file.Close()
+	return os.Remove(sf.file.Name())
+}
+
+// Size returns the current size of the spool file.
+func (sf *SpoolFile) Size() uint64 {
+	return sf.size
+}
+
+// Truncate changes the size of the spool file.
+func (sf *SpoolFile) Truncate(size uint64) error {
+	// Reserve space before growing the file
+	if size > sf.size {
+		if err := sf.spool.reserve(size - sf.size); err != nil {
+			return err
+		}
+		if err := sf.file.Truncate(int64(size)); err != nil {
+			sf.spool.release(size - sf.size)
+			return err
+		}
+	} else {
+		if err := sf.file.Truncate(int64(size)); err != nil {
+			return err
+		}
+		sf.spool.release(sf.size - size)
+	}
+
+	sf.size = size
+	sf.dirty = true
+
+	return nil
+}
+
+// WriteAt writes data to the spool file at the given offset, growing
+// it if needed.
+func (sf *SpoolFile) WriteAt(p []byte, off int64) (int, error) {
+	if end := uint64(off) + uint64(len(p)); end > sf.size {
+		if err := sf.resize(end); err != nil {
+			return 0, err
+		}
+	}
+	sf.dirty = true
+	return sf.file.WriteAt(p, off)
+}
+
+func (sf *SpoolFile) resize(size uint64) error {
+	if size > sf.size {
+		if err := sf.spool.reserve(size - sf.size); err != nil {
+			return err
+		}
+	} else {
+		sf.spool.release(sf.size - size)
+	}
+	sf.size = size
+	return nil
+}
+
+// spoolChunkSize returns the size of chunks read from spool files
+// while uploading them. It never exceeds the segment size.
+func spoolChunkSize(segmentSize uint64) uint64 {
+	if segmentSize < 1<<20 {
+		return segmentSize
+	}
+	return 1 << 20
+}
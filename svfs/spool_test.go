@@ -0,0 +1,168 @@
+package svfs
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type SpoolTestSuite struct {
+	suite.Suite
+	spool *Spool
+	file  *SpoolFile
+}
+
+func (suite *SpoolTestSuite) SetupTest() {
+	suite.spool = NewSpool(os.TempDir(), 16)
+	require.Nil(suite.T(), suite.spool.Init())
+
+	file, err := suite.spool.Create()
+	require.Nil(suite.T(), err)
+	suite.file = file
+}
+
+func (suite *SpoolTestSuite) TearDownTest() {
+	suite.file.Remove()
+}
+
+func (suite *SpoolTestSuite) TestFill() {
+	err := suite.file.Fill(strings.NewReader("content"), 7)
+
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), uint64(7), suite.spool.used)
+	assert.False(suite.T(), suite.file.dirty)
+}
+
+func (suite *SpoolTestSuite) TestReadAt() {
+	suite.TestFill()
+
+	data := make([]byte, 10)
+	n, err := suite.file.ReadAt(data, 3)
+
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), "tent", string(data[:n]))
+}
+
+func (suite *SpoolTestSuite) TestWriteAt() {
+	suite.TestFill()
+
+	n, err := suite.file.WriteAt([]byte("ents"), 5)
+
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 4, n)
+	assert.Equal(suite.T(), uint64(9), suite.file.Size())
+	assert.Equal(suite.T(), uint64(9), suite.spool.used)
+	assert.True(suite.T(), suite.file.dirty)
+}
+
+func (suite *SpoolTestSuite) TestWriteAtFull() {
+	_, err := suite.file.WriteAt(make([]byte, 17), 0)
+
+	assert.Equal(suite.T(), errSpoolFull, err)
+	assert.Equal(suite.T(), uint64(0), suite.spool.used)
+}
+
+func (suite *SpoolTestSuite) TestTruncate() {
+	suite.TestWriteAt()
+
+	err := suite.file.Truncate(2)
+
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), uint64(2), suite.file.Size())
+	assert.Equal(suite.T(), uint64(2), suite.spool.used)
+}
+
+func (suite *SpoolTestSuite) TestTruncateFull() {
+	suite.TestFill()
+
+	err := suite.file.Truncate(17)
+
+	assert.Equal(suite.T(), errSpoolFull, err)
+	assert.Equal(suite.T(), uint64(7), suite.file.Size())
+	assert.Equal(suite.T(), uint64(7), suite.spool.used)
+
+	info, err := suite.file.file.Stat()
+	require.Nil(suite.T(), err)
+	assert.Equal(suite.T(), int64(7), info.Size())
+}
+
+func (suite *SpoolTestSuite) TestRemove() {
+	suite.TestWriteAt()
+
+	name := suite.file.file.Name()
+	assert.Nil(suite.T(), suite.file.Remove())
+
+	_, err := os.Stat(name)
+	assert.True(suite.T(), os.IsNotExist(err))
+	assert.Equal(suite.T(), uint64(0), suite.spool.used)
+}
+
+func (suite *SpoolTestSuite) TestClone() {
+	suite.TestFill()
+
+	clone, err := suite.file.Clone()
+	require.Nil(suite.T(), err)
+	defer clone.Remove()
+
+	data := make([]byte, 10)
+	n, err := clone.ReadAt(data, 0)
+
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), "content", string(data[:n]))
+	assert.Equal(suite.T(), uint64(14), suite.spool.used)
+	assert.True(suite.T(), clone.dirty)
+}
+
+func (suite *SpoolTestSuite) TestOpen() {
+	suite.TestFill()
+	name := suite.file.file.Name()
+	suite.file.Close()
+
+	file, err := os.OpenFile(name, os.O_RDWR, 0600)
+	require.Nil(suite.T(), err)
+	suite.file, err = suite.spool.Open(file)
+
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), uint64(7), suite.file.Size())
+	assert.Equal(suite.T(), uint64(7), suite.spool.used)
+	assert.True(suite.T(), suite.file.dirty)
+}
+
+func (suite *SpoolTestSuite) TestClean() {
+	dir, err := ioutil.TempDir("", "svfs-spool")
+	require.Nil(suite.T(), err)
+	defer os.RemoveAll(dir)
+
+	spool := NewSpool(dir, 0)
+	require.Nil(suite.T(), spool.Init())
+	pending, err := spool.Create()
+	require.Nil(suite.T(), err)
+	pending.Close()
+	left, err := spool.Create()
+	require.Nil(suite.T(), err)
+	left.Close()
+
+	// Files waiting to be uploaded are kept
+	require.Nil(suite.T(), spool.Clean(map[string]bool{pending.file.Name(): true}))
+	defer spool.Close()
+	_, err = os.Stat(pending.file.Name())
+	assert.Nil(suite.T(), err)
+	_, err = os.Stat(left.file.Name())
+	assert.True(suite.T(), os.IsNotExist(err))
+
+	// Files are kept while another mount uses the spool
+	other := NewSpool(dir, 0)
+	require.Nil(suite.T(), other.Clean(nil))
+	defer other.Close()
+	_, err = os.Stat(pending.file.Name())
+	assert.Nil(suite.T(), err)
+}
+
+func TestSpoolTestSuite(t *testing.T) {
+	suite.Run(t, new(SpoolTestSuite))
+}
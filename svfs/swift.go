@@ -4,11 +4,14 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"bazil.org/fuse"
-
+	"github.com/Sirupsen/logrus"
 	"github.com/xlucas/swift"
 )
 
@@ -17,70 +20,116 @@ func canonicalHeaderKey(header string) string {
 }
 
 func newReader(fh *ObjectHandle) (io.ReadSeeker, error) {
-	rd, _, err := SwiftConnection.ObjectOpen(fh.target.c.Name, fh.target.path, false, nil)
+	var (
+		s               = fh.target.fs
+		container, path = fh.target.c.Name, fh.target.path
+	)
+
+	// Read files not uploaded yet locally
+	if file, err := s.writeBackQueue.Open(container, path); file != nil || err != nil {
+		if err != nil {
+			return nil, err
+		}
+		return file, nil
+	}
+
+	// Read through the block cache, prefetching blocks. Chunks
+	// are cache blocks, the prefetch chunk size isn't used.
+	if s.blockCache.Enabled() {
+		cr, err := newCachedReader(s.Storage, s.blockCache, container, path)
+		if err != nil {
+			return nil, err
+		}
+		if s.PrefetchConcurrency > 0 {
+			return newPrefetcher(cr.size, int64(s.BlockCacheBlockSize), s.PrefetchConcurrency, cr.fetch), nil
+		}
+		return cr, nil
+	}
+
+	// Prefetch chunks using ranged requests
+	if s.PrefetchConcurrency > 0 {
+		object, _, err := s.Storage.Object(container, path)
+		if err != nil {
+			return nil, err
+		}
+		fetch := newRangeFetcher(s.Storage, container, path, object.Bytes, int64(s.PrefetchChunkSize))
+		return newPrefetcher(object.Bytes, int64(s.PrefetchChunkSize), s.PrefetchConcurrency, fetch), nil
+	}
+
+	rd, _, err := s.Storage.ObjectOpen(fh.target.c.Name, fh.target.path, false, nil)
 	return rd, err
 }
 
-func newWriter(container, path string) (io.WriteCloser, error) {
+func (s *SVFS) newWriter(container, path string, h swift.Headers) (io.WriteCloser, error) {
 	headers := map[string]string{"autoContent": "true"}
-	return SwiftConnection.ObjectCreate(container, path, false, "", "", headers)
+	for k, v := range h {
+		headers[k] = v
+	}
+	return s.Storage.ObjectCreate(container, path, false, "", s.contentType(path), headers)
 }
 
-func initSegment(c, prefix string, id *uint, t *swift.Object, d []byte, up *uint64) (io.WriteCloser, error) {
-	segment, err := createSegment(c, prefix, id, up)
+func initSegment(u *SegmentUploader, c, prefix string, id *uint, t *swift.Object, d []byte, up *uint64) (io.WriteCloser, error) {
+	segment, err := createSegment(u, c, prefix, id, up)
 	if err != nil {
 		return nil, err
 	}
 	return segment, writeSegmentData(segment, t, d, up)
 }
 
-func createContainer(name string) (*swift.Container, error) {
+// copyObject copies an object server-side within a container. Manifests
+// are copied as is, their segments being shared by both copies. Standard
+// objects are not affected by the manifest copy mode.
+func (s *SVFS) copyObject(container string, object *swift.Object, target string) error {
+	_, err := s.Storage.ManifestCopy(container, object.Name, container, target, nil)
+	return err
+}
+
+func (s *SVFS) createContainer(name string) (*swift.Container, error) {
 	headers := make(map[string]string)
-	if StoragePolicy != "" {
-		headers[storagePolicyHeader] = StoragePolicy
+	if s.StoragePolicy != "" {
+		headers[storagePolicyHeader] = s.StoragePolicy
 	}
-	err := SwiftConnection.ContainerCreate(name, headers)
+	err := s.Storage.ContainerCreate(name, headers)
 	if err != nil {
 		return nil, err
 	}
-	c, _, err := SwiftConnection.Container(name)
+	c, _, err := s.Storage.Container(name)
 	if err != nil {
 		return nil, err
 	}
 	return &c, nil
 }
 
-func createManifest(obj *Object, container, segmentsPath, path string) error {
-	// Swift requires ampersand and question marks to be percent-encoded
-	segmentsPath = strings.Replace(segmentsPath, "&", "%26", -1)
-	segmentsPath = strings.Replace(segmentsPath, "?", "%3F", -1)
+func (s *SVFS) createManifest(obj *Object, container, segmentsPath, path string) error {
+	obj.sh = s.permissionHeaders(obj.sh)
+	obj.sh[manifestHeader] = encodeManifest(segmentsPath)
+	obj.sh["Content-Length"] = "0"
+	obj.sh[autoContentHeader] = "true"
 
-	obj.sh = map[string]string{
-		manifestHeader:    segmentsPath,
-		"Content-Length":  "0",
-		autoContentHeader: "true",
+	contentType := s.contentType(path)
+	if contentType != "" {
+		delete(obj.sh, autoContentHeader)
 	}
 
-	manifest, err := SwiftConnection.ObjectCreate(container, path, false, "", "", obj.sh)
+	manifest, err := s.Storage.ObjectCreate(container, path, false, "", contentType, obj.sh)
 	if err != nil {
 		return err
 	}
 
 	manifest.Write(nil)
-	manifest.Close()
 
-	return nil
+	return manifest.Close()
 }
 
-func createSegment(container, prefix string, id *uint, uploaded *uint64) (io.WriteCloser, error) {
+func createSegment(u *SegmentUploader, container, prefix string, id *uint, uploaded *uint64) (io.WriteCloser, error) {
 	segmentName := segmentPath(prefix, id)
 	*uploaded = 0
-	return newWriter(container, segmentName)
+	return u.Create(container, segmentName)
 }
 
-func getMtime(object *swift.Object, headers swift.Headers) time.Time {
-	if Attr && len(headers) > 0 {
-		if HubicTimes {
+func (s *SVFS) getMtime(object *swift.Object, headers swift.Headers) time.Time {
+	if s.Attr && len(headers) > 0 {
+		if s.HubicTimes {
 			if mtime, err := headers.ObjectMetadata().GetHubicModTime(); err == nil {
 				return mtime
 			}
@@ -96,14 +145,183 @@ func getMtime(object *swift.Object, headers swift.Headers) time.Time {
 	return object.LastModified
 }
 
+// modeBits are the bits of a file mode stored with file permissions.
+const modeBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid
+
+// getMode returns the permission, setuid and setgid bits stored in the
+// given headers, or the default mode if they aren't stored or not handled.
+func (s *SVFS) getMode(headers swift.Headers) os.FileMode {
+	if s.FilePermissions {
+		if mode, err := parseMode(headers[objectModeHeader]); err == nil {
+			return mode
+		}
+	}
+	return os.FileMode(s.DefaultMode).Perm()
+}
+
+// getOwner returns the uid and gid stored in the given headers,
+// or the default ones if they aren't stored or not handled.
+func (s *SVFS) getOwner(headers swift.Headers) (uid, gid uint32) {
+	uid, gid = uint32(s.DefaultUID), uint32(s.DefaultGID)
+	if s.FilePermissions {
+		if id, err := strconv.ParseUint(headers[objectUIDHeader], 10, 32); err == nil {
+			uid = uint32(id)
+		}
+		if id, err := strconv.ParseUint(headers[objectGIDHeader], 10, 32); err == nil {
+			gid = uint32(id)
+		}
+	}
+	return uid, gid
+}
+
+// newPermissionHeaders returns the headers storing the mode and ownership
+// of a new node, or none if file permissions aren't handled.
+func (s *SVFS) newPermissionHeaders(mode os.FileMode, uid, gid uint32) swift.Headers {
+	h := make(swift.Headers)
+	if s.FilePermissions {
+		h[objectModeHeader] = formatMode(mode)
+		h[objectUIDHeader] = strconv.FormatUint(uint64(uid), 10)
+		h[objectGIDHeader] = strconv.FormatUint(uint64(gid), 10)
+	}
+	return h
+}
+
+// permissionHeaders returns the headers storing the mode and ownership
+// of a node, to be sent again when its content is replaced since swift
+// drops metadata missing from uploads.
+func (s *SVFS) permissionHeaders(headers swift.Headers) swift.Headers {
+	h := make(swift.Headers)
+	if s.FilePermissions {
+		for _, key := range []string{objectModeHeader, objectUIDHeader, objectGIDHeader} {
+			if value := headers[key]; value != "" {
+				h[key] = value
+			}
+		}
+	}
+	return h
+}
+
+// attrChanges returns the metadata headers to update in order to
+// store attributes changed by the given request. It returns ENOTSUP
+// if none of these attributes can be stored.
+func (s *SVFS) attrChanges(object *swift.Object, headers swift.Headers, req *fuse.SetattrRequest) (map[string]string, error) {
+	var (
+		mtime = s.Attr && req.Valid.Mtime()
+		perms = s.FilePermissions && (req.Valid.Mode() || req.Valid.Uid() || req.Valid.Gid())
+	)
+
+	if !mtime && !perms {
+		return nil, fuse.ENOTSUP
+	}
+
+	changes := make(map[string]string)
+
+	// Change mtime
+	if mtime && !req.Mtime.Equal(s.getMtime(object, headers)) {
+		changes[s.mtimeHeader()] = s.formatTime(req.Mtime)
+	}
+
+	// Change mode and ownership
+	if perms {
+		uid, gid := s.getOwner(headers)
+		if req.Valid.Mode() && req.Mode&modeBits != s.getMode(headers) {
+			changes[objectModeHeader] = formatMode(req.Mode)
+		}
+		if req.Valid.Uid() && req.Uid != uid {
+			changes[objectUIDHeader] = strconv.FormatUint(uint64(req.Uid), 10)
+		}
+		if req.Valid.Gid() && req.Gid != gid {
+			changes[objectGIDHeader] = strconv.FormatUint(uint64(req.Gid), 10)
+		}
+	}
+
+	return changes, nil
+}
+
+// postedHeaders lists object headers swift removes on metadata
+// updates unless they're sent again, along with user metadata.
+var postedHeaders = []string{
+	"Cache-Control",
+	"Content-Disposition",
+	"Content-Encoding",
+	"Content-Language",
+	"Expires",
+	"X-Delete-At",
+	manifestHeader,
+}
+
+// updateMetadata records changes in the given object headers and
+// returns the metadata headers to send to update the object. Changes
+// with an empty value remove headers, as swift does.
+func updateMetadata(headers swift.Headers, changes map[string]string) swift.Headers {
+	h := headers.ObjectMetadata().Headers(objectMetaHeader)
+
+	// Swift removes these headers unless they're sent again
+	for _, key := range postedHeaders {
+		if value := headers[key]; value != "" {
+			h[key] = value
+		}
+	}
+
+	for key, value := range changes {
+		if value == "" {
+			delete(headers, key)
+			delete(h, key)
+			continue
+		}
+		headers[key] = value
+		h[key] = value
+	}
+	return h
+}
+
+// formatMode returns the octal representation of the permission, setuid
+// and setgid bits of a mode, as used by chmod. The sticky bit never
+// reaches svfs.
+func formatMode(mode os.FileMode) string {
+	bits := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		bits |= syscall.S_ISUID
+	}
+	if mode&os.ModeSetgid != 0 {
+		bits |= syscall.S_ISGID
+	}
+	return fmt.Sprintf("%04o", bits)
+}
+
+// parseMode parses a mode formatted by formatMode.
+func parseMode(value string) (os.FileMode, error) {
+	bits, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	mode := os.FileMode(bits).Perm()
+	if bits&syscall.S_ISUID != 0 {
+		mode |= os.ModeSetuid
+	}
+	if bits&syscall.S_ISGID != 0 {
+		mode |= os.ModeSetgid
+	}
+	return mode, nil
+}
+
 func isDirectory(object swift.Object, path string) bool {
 	return (object.ContentType == dirContentType) && (object.Name != path) && !object.PseudoDirectory
 }
 
+// isLargeObject tells whether a listed object may be a dynamic large
+// object manifest, which listings report as empty. Static large objects
+// are listed with their full size and can only be found by fetching
+// their headers.
 func isLargeObject(object *swift.Object) bool {
 	return (object.Bytes == 0) && !object.PseudoDirectory && (object.ContentType != dirContentType)
 }
 
+func isSegmented(headers swift.Headers) bool {
+	return segmentPathRegex.Match([]byte(headers[manifestHeader])) || isStaticLargeObject(headers)
+}
+
 func isPseudoDirectory(object swift.Object, path string) bool {
 	return object.PseudoDirectory && (object.Name != path)
 }
@@ -112,20 +330,14 @@ func isSymlink(object swift.Object, path string) bool {
 	return (object.ContentType == linkContentType)
 }
 
-func deleteSegments(container, manifestHeader string) error {
-	prefix := strings.TrimPrefix(manifestHeader, container+"/")
-
-	// Decode manifest header percent-encoded chars
-	prefix = strings.Replace(prefix, "%26", "&", -1)
-	prefix = strings.Replace(prefix, "%3F", "?", -1)
-
-	// Custom segment container name is not supported
-	if prefix == manifestHeader {
-		return fuse.ENOTSUP
+func (s *SVFS) deleteSegments(container, manifestHeader string) error {
+	prefix, err := manifestPrefix(container, manifestHeader)
+	if err != nil {
+		return err
 	}
 
 	// Find segments
-	segments, err := SwiftConnection.ObjectNamesAll(container, &swift.ObjectsOpts{
+	segments, err := s.Storage.ObjectNamesAll(container, &swift.ObjectsOpts{
 		Prefix: prefix,
 	})
 	if err != nil {
@@ -134,7 +346,7 @@ func deleteSegments(container, manifestHeader string) error {
 
 	// Delete segments
 	for _, segment := range segments {
-		if err := SwiftConnection.ObjectDelete(container, segment); err != nil {
+		if err := s.Storage.ObjectDelete(container, segment); err != nil {
 			return err
 		}
 	}
@@ -142,8 +354,123 @@ func deleteSegments(container, manifestHeader string) error {
 	return nil
 }
 
-func formatTime(t time.Time) string {
-	if HubicTimes {
+// deleteSegmentPaths deletes segments given by their path within
+// static large object manifests. Failures are only logged, segments
+// left behind being collected by the gc command.
+func (s *SVFS) deleteSegmentPaths(paths []string) {
+	for _, path := range paths {
+		container, segment := splitStaticSegmentPath(path)
+		if err := s.Storage.ObjectDelete(container, segment); err != nil && err != swift.ObjectNotFound {
+			logrus.WithFields(logrus.Fields{
+				"container": container,
+				"segment":   segment,
+			}).Warnln("Failed to delete replaced segment :", err)
+		}
+	}
+}
+
+// deleteSegmentPrefix deletes segments uploaded under a prefix.
+func (s *SVFS) deleteSegmentPrefix(container, prefix string) {
+	segments, err := s.Storage.ObjectNamesAll(container, &swift.ObjectsOpts{
+		Prefix: prefix + "/",
+	})
+	if err != nil {
+		logrus.WithField("container", container).Warnln("Failed to list segments of failed upload :", err)
+		return
+	}
+
+	paths := make([]string, len(segments))
+	for i, segment := range segments {
+		paths[i] = staticSegmentPath(container, segment)
+	}
+	s.deleteSegmentPaths(paths)
+}
+
+// newSegmentPrefix returns a prefix for segments of a new version
+// of an object, sharing no segment with its current version.
+func newSegmentPrefix(container, path string, current []string) string {
+	for ts := time.Now().Unix(); ; ts++ {
+		prefix := fmt.Sprintf("%s/%d", path, ts)
+		base := staticSegmentPath(container, prefix) + "/"
+
+		shared := false
+		for _, segment := range current {
+			if strings.HasPrefix(segment, base) {
+				shared = true
+				break
+			}
+		}
+		if !shared {
+			return prefix
+		}
+	}
+}
+
+// lastSegmentID returns the highest segment number found under the
+// prefix of a dynamic large object. New segments must sort after
+// existing ones, so appending is only supported when every segment
+// is named the way svfs does, <prefix>/<8 digits>.
+func (s *SVFS) lastSegmentID(container, prefix string) (uint, error) {
+	var id uint
+
+	segments, err := s.Storage.ObjectNamesAll(container, &swift.ObjectsOpts{
+		Prefix: prefix,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	base := strings.TrimSuffix(prefix, "/") + "/"
+	for _, segment := range segments {
+		name := strings.TrimPrefix(segment, base)
+		n, err := strconv.ParseUint(name, 10, 0)
+		if err != nil || len(name) != 8 || name == segment {
+			return 0, fuse.ENOTSUP
+		}
+		if uint(n) > id {
+			id = uint(n)
+		}
+	}
+
+	return id, nil
+}
+
+func manifestPrefix(container, manifestHeader string) (string, error) {
+	manifest := decodeManifest(manifestHeader)
+	prefix := strings.TrimPrefix(manifest, container+"/")
+
+	// Custom segment container name is not supported
+	if prefix == manifest {
+		return "", fuse.ENOTSUP
+	}
+
+	return prefix, nil
+}
+
+// encodeManifest returns the manifest header value of a segment path.
+// Swift requires ampersand and question marks to be percent-encoded.
+func encodeManifest(segmentsPath string) string {
+	segmentsPath = strings.Replace(segmentsPath, "&", "%26", -1)
+	return strings.Replace(segmentsPath, "?", "%3F", -1)
+}
+
+// decodeManifest returns the segment path of a manifest header value.
+func decodeManifest(manifestHeader string) string {
+	manifestHeader = strings.Replace(manifestHeader, "%26", "&", -1)
+	return strings.Replace(manifestHeader, "%3F", "?", -1)
+}
+
+// mtimeHeader returns the header holding file modification times.
+func (s *SVFS) mtimeHeader() string {
+	// Use file times set by hubic synchronization clients
+	if s.HubicTimes {
+		return hubicMtimeHeader
+	}
+	return objectMtimeHeader
+}
+
+func (s *SVFS) formatTime(t time.Time) string {
+	if s.HubicTimes {
 		return hubicDateRegex.ReplaceAllString(t.Format(time.RFC3339), "")
 	}
 	return swift.TimeToFloatString(t)
@@ -161,6 +488,9 @@ func writeSegmentData(fh io.WriteCloser, t *swift.Object, data []byte, uploaded
 	return err
 }
 
+// swiftACLAuth authenticates with another authenticator but always
+// uses the given storage URL, in order to access containers of another
+// account shared through ACLs.
 type swiftACLAuth struct {
 	swift.Authenticator
 	storageURL string
@@ -173,8 +503,31 @@ func newSwiftACLAuth(baseAuth swift.Authenticator, storageURL string) *swiftACLA
 	}
 }
 
-func (a *swiftACLAuth) StorageURL(Internal bool) string {
+// Request constructs the authentication request of the underlying
+// authenticator. Tokens given without credentials can't be renewed.
+func (a *swiftACLAuth) Request(c *swift.Connection) (*http.Request, error) {
+	if a.Authenticator == nil {
+		return nil, fmt.Errorf("Authentication token can't be renewed without credentials")
+	}
+	return a.Authenticator.Request(c)
+}
+
+// StorageUrl returns the storage URL given in options, ignoring
+// the one returned by the underlying authenticator.
+func (a *swiftACLAuth) StorageUrl(Internal bool) string {
 	return a.storageURL
 }
 
-var _ swift.Authenticator = (*swiftACLAuth)(nil)
+// Expires tells when the token expires, if known by the underlying
+// authenticator.
+func (a *swiftACLAuth) Expires() time.Time {
+	if auth, ok := a.Authenticator.(expiringAuth); ok {
+		return auth.Expires()
+	}
+	return time.Time{}
+}
+
+var (
+	_ swift.Authenticator = (*swiftACLAuth)(nil)
+	_ expiringAuth        = (*swiftACLAuth)(nil)
+)
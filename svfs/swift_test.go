@@ -0,0 +1,50 @@
+package svfs
+
+import (
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type SegmentTestSuite struct {
+	localFSSuite
+}
+
+func (suite *SegmentTestSuite) put(names ...string) {
+	for _, name := range names {
+		require.NoError(suite.T(), suite.backend.ObjectPutBytes("container", name, []byte("data"), ""))
+	}
+}
+
+func (suite *SegmentTestSuite) TestLastSegmentID() {
+	suite.put("file/1480000000/00000001", "file/1480000000/00000012")
+
+	for _, prefix := range []string{"file/1480000000", "file/1480000000/"} {
+		id, err := suite.fs.lastSegmentID("container", prefix)
+		assert.NoError(suite.T(), err)
+		assert.Equal(suite.T(), uint(12), id)
+	}
+
+	id, err := suite.fs.lastSegmentID("container", "missing/1480000000")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), uint(0), id)
+}
+
+func (suite *SegmentTestSuite) TestLastSegmentIDUnsupported() {
+	suite.put("file/1480000000/00000001", "file/1480000000/part-2", "other/1480000000/00000001", "other/14800000001/00000002")
+
+	// Segments not numbered by svfs
+	_, err := suite.fs.lastSegmentID("container", "file/1480000000/")
+	assert.Equal(suite.T(), fuse.ENOTSUP, err)
+
+	// Segments outside of the prefix directory
+	_, err = suite.fs.lastSegmentID("container", "other/1480000000")
+	assert.Equal(suite.T(), fuse.ENOTSUP, err)
+}
+
+func TestSegmentSuite(t *testing.T) {
+	suite.Run(t, new(SegmentTestSuite))
+}
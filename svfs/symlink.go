@@ -15,6 +15,7 @@ const (
 
 // Symlink represents a symbolic link to an object within a container.
 type Symlink struct {
+	fs   *SVFS
 	name string
 	path string
 	so   *swift.Object
@@ -28,10 +29,9 @@ func (s *Symlink) Attr(ctx context.Context, a *fuse.Attr) (err error) {
 	a.Size = uint64(s.so.Bytes)
 	a.BlockSize = 0
 	a.Blocks = 0
-	a.Mode = os.ModeSymlink | os.FileMode(DefaultMode)
-	a.Gid = uint32(DefaultGID)
-	a.Uid = uint32(DefaultUID)
-	a.Mtime = getMtime(s.so, s.sh)
+	a.Mode = os.ModeSymlink | s.fs.getMode(s.sh)
+	a.Uid, a.Gid = s.fs.getOwner(s.sh)
+	a.Mtime = s.fs.getMtime(s.so, s.sh)
 	a.Ctime = a.Mtime
 	a.Crtime = a.Mtime
 	return nil
@@ -42,6 +42,60 @@ func (s *Symlink) Export() fuse.Dirent {
 	return fuse.Dirent{Name: s.Name(), Type: fuse.DT_Link}
 }
 
+// Getxattr retrieves extended attributes of a symlink node.
+func (s *Symlink) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) (err error) {
+	if !s.fs.Xattr {
+		return fuse.ENOTSUP
+	}
+
+	if name, ok := systemXattr(req.Name); ok {
+		resp.Xattr, err = s.fs.getObjectSystemXattr(s.c.Name, s.sh, name)
+		return err
+	}
+
+	resp.Xattr, err = getXattr(s.sh, req.Name)
+	return err
+}
+
+// Listxattr lists extended attributes associated with this symlink node.
+func (s *Symlink) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	if !s.fs.Xattr {
+		return fuse.ENOTSUP
+	}
+
+	listXattr(s.sh, resp)
+	return nil
+}
+
+// Removexattr removes an extended attribute on this symlink node.
+func (s *Symlink) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
+	return s.setXattr(req.Name, nil)
+}
+
+// Setxattr changes an extended attribute on this symlink node.
+func (s *Symlink) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
+	return s.setXattr(req.Name, req.Xattr)
+}
+
+// setXattr stores an extended attribute, an empty value removing it.
+func (s *Symlink) setXattr(name string, value []byte) error {
+	if !s.fs.Xattr {
+		return fuse.ENOTSUP
+	}
+
+	// System metadata are read-only
+	if _, ok := systemXattr(name); ok {
+		return fuse.EPERM
+	}
+
+	changes := xattrChanges(s.sh, name, value)
+	if changes == nil {
+		return nil
+	}
+
+	return s.fs.Storage.ObjectUpdate(s.c.Name, s.so.Name, updateMetadata(s.sh, changes))
+}
+
 // Name gets the name of the underlying swift object.
 func (s *Symlink) Name() string {
 	return s.name
@@ -53,7 +107,7 @@ func (s *Symlink) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (stri
 }
 
 func (s *Symlink) copy(dir *Directory, name string) (*Symlink, error) {
-	_, err := SwiftConnection.ObjectCopy(s.c.Name, s.path, dir.c.Name, dir.path+name, nil)
+	_, err := s.fs.Storage.ObjectCopy(s.c.Name, s.path, dir.c.Name, dir.path+name, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -65,14 +119,14 @@ func (s *Symlink) copy(dir *Directory, name string) (*Symlink, error) {
 	link.name = name
 	link.path = dir.path + name
 
-	directoryCache.Set(dir.c.Name, dir.path, name, &link)
+	s.fs.directoryCache.Set(dir.c.Name, dir.path, name, &link)
 
 	return &link, nil
 }
 
 func (s *Symlink) delete() error {
-	directoryCache.Delete(s.c.Name, s.p.path, s.name)
-	return SwiftConnection.ObjectDelete(s.c.Name, s.path)
+	s.fs.directoryCache.Delete(s.c.Name, s.p.path, s.name)
+	return s.fs.Storage.ObjectDelete(s.c.Name, s.path)
 }
 
 func (s *Symlink) rename(dir *Directory, name string) error {
@@ -92,7 +146,11 @@ func (s *Symlink) rename(dir *Directory, name string) error {
 }
 
 var (
-	_ Node              = (*Symlink)(nil)
-	_ fs.Node           = (*Symlink)(nil)
-	_ fs.NodeReadlinker = (*Symlink)(nil)
+	_ Node                 = (*Symlink)(nil)
+	_ fs.Node              = (*Symlink)(nil)
+	_ fs.NodeGetxattrer    = (*Symlink)(nil)
+	_ fs.NodeListxattrer   = (*Symlink)(nil)
+	_ fs.NodeReadlinker    = (*Symlink)(nil)
+	_ fs.NodeRemovexattrer = (*Symlink)(nil)
+	_ fs.NodeSetxattrer    = (*Symlink)(nil)
 )
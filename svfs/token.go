@@ -0,0 +1,198 @@
+package svfs
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/xlucas/swift"
+)
+
+var tokenRefreshMinDelay = time.Minute
+
+// expiringAuth is an authenticator knowing when the token it
+// obtained expires.
+type expiringAuth interface {
+	Expires() time.Time
+}
+
+// Authenticate selects the authentication method matching connection
+// settings and authenticates against Swift unless a token and storage URL
+// were given.
+func (c *Connection) Authenticate() (err error) {
+	// Copy storage URL option
+	overloadStorageURL := c.StorageUrl
+
+	// Track requests and delays asked by swift when throttling them
+	if c.Transport == nil {
+		c.Transport = newSwiftTransport(&c.retryAfter)
+	}
+
+	// Rejected tokens are renewed by svfs which rewinds request bodies,
+	// unlike the swift library sending partially read bodies again.
+	c.Retries = -1
+
+	// Secrets may be stored in files
+	if err = c.readSecretFiles(); err != nil {
+		return err
+	}
+
+	// Hubic special authentication
+	if c.HubicAuthorization != "" && c.HubicRefreshToken != "" {
+		c.Auth = &HubicAuth{
+			authorization: c.HubicAuthorization,
+			refreshToken:  c.HubicRefreshToken,
+		}
+	} else if c.useKeystoneAuth() {
+		c.Auth = &KeystoneAuth{
+			applicationCredentialID:     c.KeystoneApplicationCredentialID,
+			applicationCredentialSecret: c.KeystoneApplicationCredentialSecret,
+		}
+	}
+
+	// Authenticate if we don't have a token and storage URL
+	if !c.Authenticated() {
+		err = c.Connection.Authenticate()
+	}
+
+	// Swift ACL special authentication
+	if overloadStorageURL != "" {
+		c.StorageUrl = overloadStorageURL
+		c.Auth = newSwiftACLAuth(c.Auth, overloadStorageURL)
+	}
+
+	if err == nil {
+		c.StartTokenRefresh()
+	}
+
+	return err
+}
+
+// Reauthenticate renews the authentication token.
+func (c *Connection) Reauthenticate() error {
+	c.authMutex.Lock()
+	defer c.authMutex.Unlock()
+	return c.reauthenticate()
+}
+
+// StartTokenRefresh renews the authentication token in the background
+// ahead of its expiry, if the authenticator tells when it expires.
+func (c *Connection) StartTokenRefresh() {
+	c.refreshOnce.Do(func() {
+		c.refreshStop = make(chan struct{})
+		c.refreshDone = make(chan struct{})
+		go c.refreshToken(c.refreshStop, c.refreshDone)
+	})
+}
+
+// StopTokenRefresh stops renewing the authentication token in the
+// background and waits for a renewal in progress. It can't be
+// started again afterwards.
+func (c *Connection) StopTokenRefresh() {
+	c.refreshOnce.Do(func() {})
+	if c.refreshStop == nil {
+		return
+	}
+	c.stopOnce.Do(func() {
+		close(c.refreshStop)
+	})
+	<-c.refreshDone
+}
+
+func (c *Connection) reauthenticate() error {
+	logrus.Infoln("Renewing authentication token")
+
+	if err := c.Connection.Authenticate(); err != nil {
+		logrus.Errorln("Failed to renew authentication token :", err)
+		return err
+	}
+	c.authGeneration++
+
+	if expires := c.expires(); !expires.IsZero() {
+		logrus.WithField("expires", expires).Infoln("Authentication token renewed")
+	} else {
+		logrus.Infoln("Authentication token renewed")
+	}
+
+	return nil
+}
+
+// renewRejectedToken renews the authentication token after swift
+// rejected it, unless it was renewed since the rejected request was
+// sent.
+func (c *Connection) renewRejectedToken(generation uint64) error {
+	c.authMutex.Lock()
+	defer c.authMutex.Unlock()
+
+	if generation != c.authGeneration {
+		return nil
+	}
+
+	logrus.Warnln("Authentication token rejected by swift")
+	return c.reauthenticate()
+}
+
+// generation identifies the current authentication token.
+func (c *Connection) generation() uint64 {
+	c.authMutex.Lock()
+	defer c.authMutex.Unlock()
+	return c.authGeneration
+}
+
+// expires returns when the current token expires, or the zero
+// time if unknown.
+func (c *Connection) expires() time.Time {
+	if auth, ok := c.Auth.(expiringAuth); ok {
+		return auth.Expires()
+	}
+	return time.Time{}
+}
+
+// refreshDelay returns how long to wait before renewing the token,
+// or false if it can't be renewed ahead of its expiry.
+func (c *Connection) refreshDelay() (time.Duration, bool) {
+	c.authMutex.Lock()
+	expires := c.expires()
+	c.authMutex.Unlock()
+
+	if c.TokenRefreshMargin <= 0 || expires.IsZero() {
+		return 0, false
+	}
+
+	// Never renew tokens in a tight loop
+	delay := expires.Sub(time.Now()) - c.TokenRefreshMargin
+	if delay < tokenRefreshMinDelay {
+		delay = tokenRefreshMinDelay
+	}
+
+	return delay, true
+}
+
+func (c *Connection) refreshToken(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	for {
+		delay, ok := c.refreshDelay()
+		if !ok {
+			if c.TokenRefreshMargin > 0 {
+				logrus.Infoln("Authentication token expiry unknown, renewing it once rejected by swift")
+			}
+			return
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+			c.Reauthenticate()
+		case <-stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// isAuthFailure tells whether swift rejected the authentication
+// token of a request.
+func isAuthFailure(err error) bool {
+	e, ok := err.(*swift.Error)
+	return ok && e.StatusCode == http.StatusUnauthorized
+}
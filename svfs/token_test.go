@@ -0,0 +1,298 @@
+package svfs
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/xlucas/swift"
+)
+
+type TokenTestSuite struct {
+	suite.Suite
+	server  *httptest.Server
+	conn    *Connection
+	mutex   sync.Mutex
+	tokens  int
+	expires time.Time
+	objects map[string]string
+	rejects int
+	drops   int
+}
+
+func (suite *TokenTestSuite) SetupTest() {
+	suite.tokens = 0
+	suite.rejects = 0
+	suite.drops = 0
+	suite.expires = time.Now().Add(time.Hour).UTC()
+	suite.objects = make(map[string]string)
+	suite.server = httptest.NewServer(http.HandlerFunc(suite.serve))
+	suite.conn = &Connection{
+		Connection: &swift.Connection{
+			AuthUrl:  suite.server.URL + "/v3",
+			UserName: "user",
+			ApiKey:   "password",
+			Auth:     new(KeystoneAuth),
+			Retries:  -1,
+		},
+		MaxRetries:         3,
+		RetryDelay:         time.Millisecond,
+		RetryMaxDelay:      time.Millisecond,
+		TokenRefreshMargin: 5 * time.Minute,
+		spool:              NewSpool(os.TempDir(), 0),
+		streamMaxSize:      1 << 20,
+	}
+	suite.conn.Transport = newSwiftTransport(&suite.conn.retryAfter)
+	require.NoError(suite.T(), suite.conn.Connection.Authenticate())
+}
+
+func (suite *TokenTestSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+func (suite *TokenTestSuite) token() string {
+	return fmt.Sprintf("token-%d", suite.tokens)
+}
+
+func (suite *TokenTestSuite) serve(w http.ResponseWriter, r *http.Request) {
+	suite.mutex.Lock()
+	defer suite.mutex.Unlock()
+
+	if r.URL.Path == "/v3/auth/tokens" {
+		suite.tokens++
+		w.Header().Set("X-Subject-Token", suite.token())
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"token": {"expires_at": %q, "catalog": [{"type": "object-store",
+			"endpoints": [{"interface": "public", "url": "%s/v1/AUTH_test"}]}]}}`,
+			suite.expires.Format(time.RFC3339Nano), suite.server.URL)
+		return
+	}
+
+	// Tokens expire once rejected
+	if r.Header.Get("X-Auth-Token") != suite.token() || suite.rejects > 0 {
+		if suite.rejects > 0 {
+			suite.rejects--
+			suite.tokens++
+		}
+		ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case "PUT":
+		body, _ := ioutil.ReadAll(r.Body)
+		suite.objects[r.URL.Path] = string(body)
+		w.Header().Set("Etag", fmt.Sprintf("%x", md5.Sum(body)))
+		w.WriteHeader(http.StatusCreated)
+	case "GET":
+		content, ok := suite.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var start int
+		if rng := r.Header.Get("Range"); rng != "" {
+			fmt.Sscanf(rng, "bytes=%d-", &start)
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(content)-1, len(content)))
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(content)-start))
+		w.WriteHeader(http.StatusOK)
+
+		// Interrupt the download halfway
+		if suite.drops > 0 {
+			suite.drops--
+			w.Write([]byte(content[start : start+(len(content)-start)/2]))
+			w.(http.Flusher).Flush()
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		w.Write([]byte(content[start:]))
+	}
+}
+
+func (suite *TokenTestSuite) reject(requests int) {
+	suite.mutex.Lock()
+	defer suite.mutex.Unlock()
+	suite.rejects = requests
+}
+
+func (suite *TokenTestSuite) TestRenewRejectedToken() {
+	suite.reject(1)
+
+	err := suite.conn.ObjectPutBytes("container", "object", []byte("content"), "")
+
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "content", suite.objects["/v1/AUTH_test/container/object"])
+	assert.Equal(suite.T(), uint64(1), suite.conn.generation())
+}
+
+func (suite *TokenTestSuite) TestRenewRejectedTokenOnce() {
+	suite.reject(2)
+
+	err := suite.conn.ObjectPutBytes("container", "object", []byte("content"), "")
+
+	assert.True(suite.T(), isAuthFailure(err))
+	assert.Equal(suite.T(), uint64(1), suite.conn.generation())
+}
+
+func (suite *TokenTestSuite) TestRenewedTokenNotRenewedAgain() {
+	generation := suite.conn.generation()
+	require.NoError(suite.T(), suite.conn.Reauthenticate())
+
+	require.NoError(suite.T(), suite.conn.renewRejectedToken(generation))
+
+	assert.Equal(suite.T(), 2, suite.tokens)
+}
+
+func (suite *TokenTestSuite) TestResumeStreamedUpload() {
+	suite.reject(1)
+
+	w, err := suite.conn.ObjectCreate("container", "object", false, "", "", nil)
+	require.NoError(suite.T(), err)
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(w, "line %d\n", i)
+	}
+	require.NoError(suite.T(), w.Close())
+
+	content := suite.objects["/v1/AUTH_test/container/object"]
+	assert.True(suite.T(), strings.HasPrefix(content, "line 0\n"))
+	assert.True(suite.T(), strings.HasSuffix(content, "line 99\n"))
+}
+
+func (suite *TokenTestSuite) TestResumeStreamedUploadRetriesDisabled() {
+	suite.conn.MaxRetries = 0
+	suite.reject(1)
+
+	// Streams aren't kept to be sent again
+	w, err := suite.conn.ObjectCreate("container", "object", false, "", "", nil)
+	require.NoError(suite.T(), err)
+	fmt.Fprint(w, "content")
+	assert.Error(suite.T(), w.Close())
+
+	_, found := suite.objects["/v1/AUTH_test/container/object"]
+	assert.False(suite.T(), found)
+}
+
+func (suite *TokenTestSuite) TestRenewRejectedTokenUnreadableBody() {
+	suite.reject(1)
+
+	_, _, err := suite.conn.storageCall(swift.RequestOpts{
+		Container:  "container",
+		ObjectName: "object",
+		Operation:  "PUT",
+		Body:       ioutil.NopCloser(strings.NewReader("content")),
+	})
+
+	// The request can't be sent again but the next one succeeds
+	assert.True(suite.T(), isAuthFailure(err))
+	assert.Equal(suite.T(), uint64(1), suite.conn.generation())
+	assert.NoError(suite.T(), suite.conn.ObjectPutBytes("container", "object", []byte("content"), ""))
+}
+
+func (suite *TokenTestSuite) TestResumeInterruptedDownload() {
+	suite.objects["/v1/AUTH_test/container/object"] = "0123456789abcdef"
+	suite.drops = 2
+
+	rd, _, err := suite.conn.ObjectOpen("container", "object", false, nil)
+	require.NoError(suite.T(), err)
+	defer rd.Close()
+
+	data, err := ioutil.ReadAll(rd)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "0123456789abcdef", string(data))
+}
+
+func (suite *TokenTestSuite) TestDownloadAfterRejectedToken() {
+	suite.objects["/v1/AUTH_test/container/object"] = "0123456789abcdef"
+
+	rd, _, err := suite.conn.ObjectOpen("container", "object", false, swift.Headers{"Range": "bytes=4-"})
+	require.NoError(suite.T(), err)
+	defer rd.Close()
+
+	// Seeking reopens the object with an expired token
+	suite.reject(1)
+	_, err = rd.Seek(2, 1)
+	require.NoError(suite.T(), err)
+
+	data, err := ioutil.ReadAll(rd)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "6789abcdef", string(data))
+}
+
+func (suite *TokenTestSuite) TestRefreshDelay() {
+	delay, ok := suite.conn.refreshDelay()
+	assert.True(suite.T(), ok)
+	assert.InDelta(suite.T(), float64(55*time.Minute), float64(delay), float64(time.Second))
+
+	// Tokens about to expire are renewed after a minimum delay
+	suite.expires = time.Now().Add(time.Minute)
+	require.NoError(suite.T(), suite.conn.Reauthenticate())
+	delay, ok = suite.conn.refreshDelay()
+	assert.True(suite.T(), ok)
+	assert.Equal(suite.T(), tokenRefreshMinDelay, delay)
+
+	suite.conn.TokenRefreshMargin = 0
+	_, ok = suite.conn.refreshDelay()
+	assert.False(suite.T(), ok)
+}
+
+func (suite *TokenTestSuite) TestStopTokenRefresh() {
+	defer func(delay time.Duration) { tokenRefreshMinDelay = delay }(tokenRefreshMinDelay)
+	tokenRefreshMinDelay = 10 * time.Millisecond
+
+	// Tokens about to expire are renewed in the background
+	suite.expires = time.Now().Add(time.Minute)
+	require.NoError(suite.T(), suite.conn.Reauthenticate())
+	suite.conn.StartTokenRefresh()
+	for i := 0; i < 100 && suite.conn.generation() < 2; i++ {
+		time.Sleep(tokenRefreshMinDelay)
+	}
+	require.True(suite.T(), suite.conn.generation() > 1)
+
+	// Not anymore once stopped
+	suite.conn.StopTokenRefresh()
+	generation := suite.conn.generation()
+	time.Sleep(5 * tokenRefreshMinDelay)
+	assert.Equal(suite.T(), generation, suite.conn.generation())
+}
+
+func (suite *TokenTestSuite) TestSwiftACLAuth() {
+	auth := newSwiftACLAuth(suite.conn.Auth, "https://shared")
+	assert.Equal(suite.T(), "https://shared", auth.StorageUrl(false))
+	assert.Equal(suite.T(), suite.conn.Auth.(expiringAuth).Expires(), auth.Expires())
+
+	// Tokens given without credentials can't be renewed
+	auth = newSwiftACLAuth(nil, "https://shared")
+	assert.True(suite.T(), auth.Expires().IsZero())
+	_, err := auth.Request(suite.conn.Connection)
+	assert.Error(suite.T(), err)
+}
+
+func (suite *TokenTestSuite) TestParseRange() {
+	start, end, err := parseRange("bytes=2-5")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []int64{2, 5}, []int64{start, end})
+
+	start, end, err = parseRange("bytes=7-")
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []int64{7, -1}, []int64{start, end})
+
+	_, _, err = parseRange("bytes=-5")
+	assert.Error(suite.T(), err)
+}
+
+func TestTokenSuite(t *testing.T) {
+	suite.Run(t, new(TokenTestSuite))
+}
@@ -0,0 +1,232 @@
+package svfs
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/xlucas/swift"
+)
+
+// SegmentUploader uploads segments of a file in the background. Data
+// written to a segment is kept in a bounded memory buffer until sent.
+// The next segment can only be written once the current one fits in
+// its buffer, so buffers must be as large as segments for uploads to
+// overlap.
+type SegmentUploader struct {
+	storage    Backend
+	bufferSize uint64
+	slots      chan struct{}
+	mutex      sync.Mutex
+	uploads    []*segmentUpload
+	err        error
+}
+
+type segmentUpload struct {
+	buffer *segmentBuffer
+	done   chan struct{}
+}
+
+// segmentBuffer is a bounded in-memory pipe. Writes block while
+// the buffer is full and reads block while it is empty.
+type segmentBuffer struct {
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	data   bytes.Buffer
+	limit  int
+	closed bool
+	err    error
+}
+
+// NewSegmentUploader creates a segment uploader allowing at most
+// concurrency uploads in flight, each one buffering at most bufferSize
+// bytes in memory.
+func NewSegmentUploader(storage Backend, concurrency, bufferSize uint64) *SegmentUploader {
+	slots := concurrency
+	if slots < 1 {
+		slots = 1
+	}
+	return &SegmentUploader{
+		storage:    storage,
+		bufferSize: bufferSize,
+		slots:      make(chan struct{}, slots),
+	}
+}
+
+// Create starts the upload of a new segment and returns a writer
+// for its content. It blocks until an upload slot is available.
+// Closing the writer doesn't wait for the upload to complete.
+func (u *SegmentUploader) Create(container, path string) (io.WriteCloser, error) {
+	if err := u.Err(); err != nil {
+		return nil, err
+	}
+
+	u.slots <- struct{}{}
+
+	upload := &segmentUpload{
+		buffer: newSegmentBuffer(int(u.bufferSize)),
+		done:   make(chan struct{}),
+	}
+
+	u.mutex.Lock()
+	u.uploads = append(u.uploads, upload)
+	u.mutex.Unlock()
+
+	go func() {
+		atomic.AddInt64(&segmentUploads, 1)
+		defer func() {
+			atomic.AddInt64(&segmentUploads, -1)
+			<-u.slots
+			close(upload.done)
+		}()
+		_, err := u.storage.ObjectPut(container, path, upload.buffer, false, "", "", swift.Headers{
+			autoContentHeader: "true",
+		})
+		if err != nil {
+			upload.buffer.closeWithError(err)
+			u.setErr(err)
+		}
+	}()
+
+	return upload.buffer, nil
+}
+
+// Err returns the first error met while uploading segments.
+func (u *SegmentUploader) Err() error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.err
+}
+
+// Flush waits for segments fully written to be uploaded.
+// It returns the first error met while uploading segments.
+func (u *SegmentUploader) Flush() error {
+	return u.wait(false)
+}
+
+// Wait waits for all segments to be uploaded. All writers
+// must have been closed. It returns the first error met while
+// uploading segments.
+func (u *SegmentUploader) Wait() error {
+	return u.wait(true)
+}
+
+func (u *SegmentUploader) setErr(err error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	if u.err == nil {
+		u.err = err
+	}
+}
+
+func (u *SegmentUploader) wait(all bool) error {
+	u.mutex.Lock()
+	uploads := u.uploads
+	u.uploads = nil
+	u.mutex.Unlock()
+
+	var pending []*segmentUpload
+
+	for _, upload := range uploads {
+		if !all && !upload.buffer.isClosed() {
+			pending = append(pending, upload)
+			continue
+		}
+		<-upload.done
+	}
+
+	u.mutex.Lock()
+	u.uploads = append(pending, u.uploads...)
+	u.mutex.Unlock()
+
+	return u.Err()
+}
+
+func newSegmentBuffer(limit int) *segmentBuffer {
+	if limit < 1 {
+		limit = 1
+	}
+	b := &segmentBuffer{limit: limit}
+	b.cond = sync.NewCond(&b.mutex)
+	return b
+}
+
+// Write appends data to the buffer, blocking while it is full.
+func (b *segmentBuffer) Write(p []byte) (n int, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for len(p) > 0 {
+		for b.data.Len() >= b.limit && b.err == nil {
+			b.cond.Wait()
+		}
+		if b.err != nil {
+			return n, b.err
+		}
+		chunk := b.limit - b.data.Len()
+		if chunk > len(p) {
+			chunk = len(p)
+		}
+		b.data.Write(p[:chunk])
+		p = p[chunk:]
+		n += chunk
+		b.cond.Broadcast()
+	}
+
+	return n, nil
+}
+
+// Read consumes data from the buffer, blocking while it is empty.
+// It returns io.EOF once the buffer is closed and drained.
+func (b *segmentBuffer) Read(p []byte) (n int, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for b.data.Len() == 0 && !b.closed && b.err == nil {
+		b.cond.Wait()
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.data.Len() == 0 {
+		return 0, io.EOF
+	}
+
+	n, _ = b.data.Read(p)
+	b.cond.Broadcast()
+
+	return n, nil
+}
+
+// Close marks the end of the data written to the buffer.
+func (b *segmentBuffer) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+	return nil
+}
+
+func (b *segmentBuffer) closeWithError(err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.err = err
+	b.cond.Broadcast()
+}
+
+// abort fails the upload reading from the buffer.
+func (b *segmentBuffer) abort() {
+	b.closeWithError(errUploadAborted)
+}
+
+func (b *segmentBuffer) isClosed() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.closed
+}
+
+var (
+	_ io.WriteCloser = (*segmentBuffer)(nil)
+	_ writeAborter   = (*segmentBuffer)(nil)
+)
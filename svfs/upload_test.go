@@ -0,0 +1,88 @@
+package svfs
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type UploadTestSuite struct {
+	suite.Suite
+	buffer *segmentBuffer
+}
+
+func (suite *UploadTestSuite) SetupTest() {
+	suite.buffer = newSegmentBuffer(4)
+}
+
+func (suite *UploadTestSuite) TestBufferTransfer() {
+	go func() {
+		suite.buffer.Write([]byte("segment content"))
+		suite.buffer.Close()
+	}()
+
+	data, err := ioutil.ReadAll(suite.buffer)
+
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), "segment content", string(data))
+	assert.True(suite.T(), suite.buffer.isClosed())
+}
+
+func (suite *UploadTestSuite) TestBufferBounded() {
+	written := make(chan int)
+
+	go func() {
+		n, _ := suite.buffer.Write([]byte("content"))
+		written <- n
+	}()
+
+	select {
+	case <-written:
+		suite.T().Fatal("Write should block while the buffer is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	suite.buffer.mutex.Lock()
+	assert.Equal(suite.T(), 4, suite.buffer.data.Len())
+	suite.buffer.mutex.Unlock()
+
+	data := make([]byte, 4)
+	suite.buffer.Read(data)
+
+	assert.Equal(suite.T(), 7, <-written)
+	assert.Equal(suite.T(), "cont", string(data))
+}
+
+func (suite *UploadTestSuite) TestBufferError() {
+	failure := errors.New("upload failed")
+	written := make(chan error)
+
+	go func() {
+		_, err := suite.buffer.Write([]byte("content"))
+		written <- err
+	}()
+
+	suite.buffer.closeWithError(failure)
+
+	assert.Equal(suite.T(), failure, <-written)
+}
+
+func (suite *UploadTestSuite) TestUploaderError() {
+	failure := errors.New("upload failed")
+	uploader := NewSegmentUploader(nil, 1, 0)
+	uploader.setErr(failure)
+	uploader.setErr(errors.New("other failure"))
+
+	_, err := uploader.Create("container", "segment")
+
+	assert.Equal(suite.T(), failure, err)
+	assert.Equal(suite.T(), failure, uploader.Wait())
+}
+
+func TestUploadSuite(t *testing.T) {
+	suite.Run(t, new(UploadTestSuite))
+}
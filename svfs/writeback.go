@@ -0,0 +1,606 @@
+package svfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/xlucas/swift"
+)
+
+// writeBackRetryMaxDelay is the maximum delay between two attempts
+// to upload a file.
+var writeBackRetryMaxDelay = 5 * time.Minute
+
+// WriteBackQueue holds files waiting to be uploaded. Each file is
+// recorded in the journal directory until uploaded, allowing uploads
+// interrupted by a crash to be replayed on the next mount. A newer
+// version of a file replaces an older one still waiting in the queue
+// and is never uploaded while an older one is being uploaded.
+type WriteBackQueue struct {
+	fs      *SVFS
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	order   []string
+	queued  map[string]*writeBackEntry
+	active  map[string]*writeBackEntry
+	failed  map[string]*writeBackEntry
+	seq     uint64
+	stop    chan struct{}
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// writeBackEntry is a file waiting to be uploaded.
+type writeBackEntry struct {
+	Storage    string `json:"storage"`
+	Container  string `json:"container"`
+	Path       string `json:"path"`
+	File       string `json:"file"`
+	journal    string
+	seq        uint64
+	object     *Object
+	target     *Object
+	sf         *SpoolFile
+	done       chan struct{}
+	err        error
+	superseded []*writeBackEntry
+}
+
+// NewWriteBackQueue creates an empty write-back queue for the
+// given filesystem.
+func NewWriteBackQueue(fs *SVFS) *WriteBackQueue {
+	q := &WriteBackQueue{
+		fs:     fs,
+		queued: make(map[string]*writeBackEntry),
+		active: make(map[string]*writeBackEntry),
+		failed: make(map[string]*writeBackEntry),
+		stop:   make(chan struct{}),
+	}
+	q.cond = sync.NewCond(&q.mutex)
+	return q
+}
+
+// Start spawns workers uploading queued files.
+func (q *WriteBackQueue) Start() {
+	for i := uint64(0); i < q.fs.WriteBackWorkers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+}
+
+// Stop makes workers exit once done with their current upload and
+// waits for them. Uploads waiting for a retry are given up. Files
+// not uploaded are kept in the journal directory for the next mount.
+func (q *WriteBackQueue) Stop() {
+	q.mutex.Lock()
+	if !q.stopped {
+		q.stopped = true
+		close(q.stop)
+		q.cond.Broadcast()
+	}
+	q.mutex.Unlock()
+
+	q.wg.Wait()
+}
+
+// Enqueue schedules the upload of a staged object. The queue takes
+// ownership of the spool file.
+func (q *WriteBackQueue) Enqueue(o *Object, sf *SpoolFile) error {
+	e := &writeBackEntry{
+		Storage:   q.fs.storageID(),
+		Container: o.c.Name,
+		Path:      o.path,
+		File:      sf.file.Name(),
+		journal:   filepath.Join(q.fs.JournalDir, fmt.Sprintf("writeback-%d.json", time.Now().UnixNano())),
+		object:    o,
+		target:    stagedTarget(o),
+		sf:        sf,
+		done:      make(chan struct{}),
+	}
+	if err := e.save(); err != nil {
+		return err
+	}
+
+	q.push(e)
+
+	return nil
+}
+
+// push adds an entry to the queue.
+func (q *WriteBackQueue) push(e *writeBackEntry) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	key := q.key(e.Container, e.Path)
+	q.seq++
+	e.seq = q.seq
+
+	// Replace an older version not uploaded yet
+	if old, ok := q.queued[key]; ok {
+		old.discard()
+		e.superseded = append(old.superseded, old)
+	} else {
+		q.order = append(q.order, key)
+	}
+	if old, ok := q.failed[key]; ok {
+		old.discard()
+		e.superseded = append(e.superseded, old)
+		delete(q.failed, key)
+	}
+
+	q.queued[key] = e
+	q.cond.Broadcast()
+}
+
+// Open returns the local content of an object waiting to be uploaded,
+// or nil if the object is not in the queue.
+func (q *WriteBackQueue) Open(container, path string) (*os.File, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	e := q.latest(q.key(container, path))
+	if e == nil {
+		return nil, nil
+	}
+
+	return os.Open(e.File)
+}
+
+// Wait blocks until the latest version of an object is uploaded.
+func (q *WriteBackQueue) Wait(container, path string) error {
+	q.mutex.Lock()
+	e := q.latest(q.key(container, path))
+	q.mutex.Unlock()
+
+	if e == nil {
+		return nil
+	}
+
+	<-e.done
+	return e.err
+}
+
+// WaitPrefix blocks until all objects whose path starts with the
+// given prefix are uploaded.
+func (q *WriteBackQueue) WaitPrefix(container, prefix string) (err error) {
+	var entries []*writeBackEntry
+
+	q.mutex.Lock()
+	for _, m := range []map[string]*writeBackEntry{q.queued, q.active, q.failed} {
+		for key, e := range m {
+			if strings.HasPrefix(key, q.key(container, prefix)) {
+				entries = append(entries, e)
+			}
+		}
+	}
+	q.mutex.Unlock()
+
+	for _, e := range entries {
+		<-e.done
+		if e.err != nil && err == nil {
+			err = e.err
+		}
+	}
+
+	return err
+}
+
+// Cancel drops an object from the queue, waiting for its upload
+// to complete if already started. The object is removed from
+// the change cache.
+func (q *WriteBackQueue) Cancel(container, path string) {
+	key := q.key(container, path)
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for q.active[key] != nil {
+		q.cond.Wait()
+	}
+
+	for _, m := range []map[string]*writeBackEntry{q.queued, q.failed} {
+		if e, ok := m[key]; ok {
+			e.discard()
+			e.finish(nil)
+			delete(m, key)
+		}
+	}
+
+	q.fs.changeCache.Remove(container, path)
+}
+
+// Acquire records an object being modified, keeping it in the
+// change cache until released.
+func (q *WriteBackQueue) Acquire(o *Object) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	o.writers++
+	q.fs.changeCache.Add(o.c.Name, o.path, o)
+}
+
+// Release forgets about an object no longer being modified, unless
+// it is waiting to be uploaded.
+func (q *WriteBackQueue) Release(o *Object) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	o.writers--
+	if o.writers == 0 && q.latest(q.key(o.c.Name, o.path)) == nil {
+		q.fs.changeCache.Remove(o.c.Name, o.path)
+	}
+}
+
+// State describes the upload of an object, either queued, uploading
+// or failed. It's empty if the object isn't in the queue.
+func (q *WriteBackQueue) State(container, path string) string {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	key := q.key(container, path)
+	switch {
+	case q.active[key] != nil:
+		return "uploading"
+	case q.queued[key] != nil:
+		return "queued"
+	case q.failed[key] != nil:
+		return "failed"
+	}
+	return ""
+}
+
+func (q *WriteBackQueue) key(container, path string) string {
+	return container + ":" + path
+}
+
+func (q *WriteBackQueue) latest(key string) *writeBackEntry {
+	if e, ok := q.queued[key]; ok {
+		return e
+	}
+	if e, ok := q.active[key]; ok {
+		return e
+	}
+	return q.failed[key]
+}
+
+// next pops the first queued object not being uploaded, or returns
+// nil once the queue is stopped.
+func (q *WriteBackQueue) next() *writeBackEntry {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for {
+		if q.stopped {
+			return nil
+		}
+		for i, key := range q.order {
+			if q.active[key] != nil {
+				continue
+			}
+			e := q.queued[key]
+			q.order = append(q.order[:i], q.order[i+1:]...)
+			delete(q.queued, key)
+			q.active[key] = e
+			return e
+		}
+		q.cond.Wait()
+	}
+}
+
+func (q *WriteBackQueue) work() {
+	defer q.wg.Done()
+
+	for {
+		e := q.next()
+		if e == nil {
+			return
+		}
+		err := e.upload(q.fs.WriteBackRetries, q.stop)
+
+		q.mutex.Lock()
+		key := q.key(e.Container, e.Path)
+		delete(q.active, key)
+
+		// Keep the local content for the next mount unless
+		// a newer version is about to be uploaded.
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"container": e.Container,
+				"path":      e.Path,
+			}).Errorln("Failed to upload file :", err)
+		}
+		if err != nil && q.queued[key] == nil {
+			q.failed[key] = e
+		} else {
+			e.discard()
+		}
+
+		// Forget about this object unless it's being modified again
+		if q.latest(key) == nil && e.object.writers == 0 {
+			q.fs.changeCache.Remove(e.Container, e.Path)
+		}
+
+		e.finish(err)
+		q.cond.Broadcast()
+		q.mutex.Unlock()
+
+		// Handles modifying the object hold its lock until
+		// released, the worker must not wait for them.
+		if err == nil {
+			go e.apply()
+		}
+	}
+}
+
+// upload sends the content of the entry to swift, retrying with an
+// exponential backoff on failure until stopped.
+func (e *writeBackEntry) upload(retries uint64, stop <-chan struct{}) (err error) {
+	for attempt := uint64(0); ; attempt++ {
+		if err = uploadStaged(e.target, e.sf); err == nil {
+			return nil
+		}
+		if attempt >= retries {
+			return err
+		}
+		logrus.WithFields(logrus.Fields{
+			"container": e.Container,
+			"path":      e.Path,
+			"attempt":   attempt + 1,
+		}).Warnln("Failed to upload file :", err)
+
+		timer := time.NewTimer(writeBackRetryDelay(attempt))
+		select {
+		case <-timer.C:
+		case <-stop:
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// writeBackRetryDelay returns the delay before the next attempt to
+// upload a file, growing exponentially up to writeBackRetryMaxDelay.
+func writeBackRetryDelay(attempt uint64) time.Duration {
+	if attempt < 32 && time.Second<<attempt < writeBackRetryMaxDelay {
+		return time.Second << attempt
+	}
+	return writeBackRetryMaxDelay
+}
+
+// apply records the state of the uploaded object in its node, unless
+// a newer version was recorded already.
+func (e *writeBackEntry) apply() {
+	o := e.object
+
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	if e.seq > o.uploaded {
+		o.sh = e.target.sh
+		o.segmented = e.target.segmented
+		o.uploaded = e.seq
+	}
+}
+
+// discard removes the local content of the entry along with
+// its journal.
+func (e *writeBackEntry) discard() {
+	e.sf.Remove()
+	os.Remove(e.journal)
+}
+
+// finish notifies waiters of the upload result.
+func (e *writeBackEntry) finish(err error) {
+	e.err = err
+	close(e.done)
+	for _, old := range e.superseded {
+		old.finish(err)
+	}
+	e.superseded = nil
+}
+
+func (e *writeBackEntry) save() error {
+	content, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	// Never leave a partially written journal behind
+	tmp := e.journal + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, e.journal)
+}
+
+// stagedTarget copies the state of a staged object, which is then
+// only updated by the write-back worker uploading it.
+func stagedTarget(o *Object) *Object {
+	so := *o.so
+	sh := make(swift.Headers, len(o.sh))
+	for k, v := range o.sh {
+		sh[k] = v
+	}
+
+	return &Object{
+		fs:        o.fs,
+		name:      o.name,
+		path:      o.path,
+		so:        &so,
+		sh:        sh,
+		c:         o.c,
+		cs:        o.cs,
+		p:         o.p,
+		segmented: o.segmented,
+	}
+}
+
+// uploadStaged uploads a staged object from a spool file. The state
+// of the target is kept between attempts, so that segments uploaded
+// by a failed attempt are removed by the next one.
+func uploadStaged(target *Object, sf *SpoolFile) error {
+	fh := &ObjectHandle{target: target, sf: sf}
+	return fh.upload()
+}
+
+// ReplayWriteBackJournals queues again every file left in the write-back
+// queue by a previous mount.
+func (s *SVFS) ReplayWriteBackJournals() error {
+	if err := os.MkdirAll(s.JournalDir, 0700); err != nil {
+		return err
+	}
+
+	files, err := filepath.Glob(filepath.Join(s.JournalDir, "writeback-*.json"))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		e := &writeBackEntry{journal: file, done: make(chan struct{})}
+		if err := json.Unmarshal(content, e); err != nil {
+			return fmt.Errorf("Invalid write-back journal %s : %v", file, err)
+		}
+
+		// Left by a mount of another storage
+		if e.Storage != s.storageID() {
+			logrus.WithFields(logrus.Fields{
+				"journal": file,
+				"storage": e.Storage,
+			}).Debugln("Skipping write-back journal of another storage")
+			continue
+		}
+
+		log := logrus.WithFields(logrus.Fields{
+			"container": e.Container,
+			"path":      e.Path,
+		})
+
+		// Local content is gone, nothing to upload
+		f, err := os.OpenFile(e.File, os.O_RDWR, 0600)
+		if os.IsNotExist(err) {
+			log.Warnln("Local content of interrupted upload is missing")
+			os.Remove(file)
+			continue
+		}
+
+		// Keep the journal and local content for the next mount
+		if err != nil {
+			log.Errorln("Failed to open interrupted upload :", err)
+			continue
+		}
+		if e.sf, err = s.objectSpool.Open(f); err != nil {
+			log.Errorln("Failed to open interrupted upload :", err)
+			f.Close()
+			continue
+		}
+
+		log.Infoln("Replaying interrupted upload")
+
+		// Keep the journal and local content for the next mount
+		if e.object, err = s.newReplayedObject(e.Container, e.Path, e.sf); err != nil {
+			log.Errorln("Failed to replay interrupted upload :", err)
+			e.sf.Close()
+			continue
+		}
+
+		e.target = stagedTarget(e.object)
+		s.changeCache.Add(e.Container, e.Path, e.object)
+		s.writeBackQueue.push(e)
+	}
+
+	return nil
+}
+
+// writeBackFiles returns the local content of uploads recorded in
+// the journal directory, whatever the storage they were recorded for.
+func (s *SVFS) writeBackFiles() (map[string]bool, error) {
+	files, err := filepath.Glob(filepath.Join(s.JournalDir, "writeback-*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make(map[string]bool)
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var e writeBackEntry
+		if err := json.Unmarshal(content, &e); err != nil {
+			return nil, fmt.Errorf("Invalid write-back journal %s : %v", file, err)
+		}
+		pending[e.File] = true
+	}
+
+	return pending, nil
+}
+
+// newReplayedObject creates a node for an object whose upload
+// is replayed.
+func (s *SVFS) newReplayedObject(container, path string, sf *SpoolFile) (*Object, error) {
+	c, _, err := s.Storage.Container(container)
+	if err != nil {
+		return nil, err
+	}
+	cs, _, err := s.Storage.Container(container + segmentContainerSuffix)
+	if err == swift.ContainerNotFound {
+		var segments *swift.Container
+		if segments, err = s.createContainer(container + segmentContainerSuffix); err == nil {
+			cs = *segments
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := sf.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Parent directory, or the container itself for top-level objects
+	p := &Directory{fs: s, c: &c, cs: &cs, name: container}
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		p.path = path[:i+1]
+		p.name = filepath.Base(p.path)
+	}
+
+	o := &Object{
+		fs:   s,
+		name: filepath.Base(path),
+		path: path,
+		c:    &c,
+		cs:   &cs,
+		p:    p,
+		so: &swift.Object{
+			Name:         path,
+			Bytes:        int64(sf.Size()),
+			LastModified: info.ModTime(),
+		},
+		sh: swift.Headers{},
+	}
+
+	// Existing segments must be removed when overwritten
+	_, h, err := s.Storage.Object(container, path)
+	if err == nil {
+		o.sh = h
+		o.segmented = isSegmented(h)
+	} else if err != swift.ObjectNotFound {
+		return nil, err
+	}
+
+	return o, nil
+}
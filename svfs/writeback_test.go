@@ -0,0 +1,360 @@
+package svfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/xlucas/swift"
+)
+
+type WriteBackTestSuite struct {
+	localFSSuite
+	queue  *WriteBackQueue
+	object *Object
+}
+
+func (suite *WriteBackTestSuite) SetupTest() {
+	suite.localFSSuite.SetupTest()
+	suite.fs.changeCache = NewSimpleCache()
+	suite.fs.objectSpool = NewSpool(filepath.Join(suite.dir, "spool"), 0)
+	suite.fs.JournalDir = filepath.Join(suite.dir, "journal")
+	require.Nil(suite.T(), suite.fs.objectSpool.Init())
+	require.Nil(suite.T(), os.Mkdir(suite.fs.JournalDir, 0700))
+
+	suite.queue = NewWriteBackQueue(suite.fs)
+	suite.object = &Object{
+		fs:   suite.fs,
+		name: "item",
+		path: "dir/item",
+		so:   &swift.Object{Name: "dir/item"},
+		c:    &swift.Container{Name: "container"},
+	}
+}
+
+func (suite *WriteBackTestSuite) enqueue(content string) *SpoolFile {
+	sf, err := suite.fs.objectSpool.Create()
+	require.Nil(suite.T(), err)
+	require.Nil(suite.T(), sf.Fill(strings.NewReader(content), uint64(len(content))))
+	require.Nil(suite.T(), suite.queue.Enqueue(suite.object, sf))
+	return sf
+}
+
+func (suite *WriteBackTestSuite) journals() []string {
+	files, _ := filepath.Glob(filepath.Join(suite.fs.JournalDir, "writeback-*.json"))
+	return files
+}
+
+func (suite *WriteBackTestSuite) TestEnqueue() {
+	suite.enqueue("content")
+
+	file, err := suite.queue.Open("container", "dir/item")
+	require.Nil(suite.T(), err)
+	require.NotNil(suite.T(), file)
+	defer file.Close()
+
+	data, _ := ioutil.ReadAll(file)
+	assert.Equal(suite.T(), "content", string(data))
+	assert.Len(suite.T(), suite.journals(), 1)
+}
+
+func (suite *WriteBackTestSuite) TestEnqueueSupersedes() {
+	old := suite.enqueue("old")
+	suite.enqueue("new")
+
+	_, err := os.Stat(old.file.Name())
+	assert.True(suite.T(), os.IsNotExist(err))
+	assert.Len(suite.T(), suite.queue.order, 1)
+	assert.Len(suite.T(), suite.journals(), 1)
+
+	file, err := suite.queue.Open("container", "dir/item")
+	require.Nil(suite.T(), err)
+	defer file.Close()
+
+	data, _ := ioutil.ReadAll(file)
+	assert.Equal(suite.T(), "new", string(data))
+}
+
+func (suite *WriteBackTestSuite) TestOpenMissing() {
+	file, err := suite.queue.Open("container", "dir/item")
+
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), file)
+}
+
+func (suite *WriteBackTestSuite) TestCancel() {
+	old := suite.enqueue("old")
+	sf := suite.enqueue("new")
+	suite.fs.changeCache.Add("container", "dir/item", suite.object)
+
+	suite.queue.Cancel("container", "dir/item")
+
+	// Waiters on every version are released
+	assert.Nil(suite.T(), suite.queue.Wait("container", "dir/item"))
+	assert.Nil(suite.T(), suite.queue.WaitPrefix("container", "dir/"))
+	for _, f := range []*SpoolFile{old, sf} {
+		_, err := os.Stat(f.file.Name())
+		assert.True(suite.T(), os.IsNotExist(err))
+	}
+	assert.Empty(suite.T(), suite.journals())
+	assert.False(suite.T(), suite.fs.changeCache.Exist("container", "dir/item"))
+}
+
+func (suite *WriteBackTestSuite) TestRelease() {
+	suite.queue.Acquire(suite.object)
+	suite.enqueue("content")
+
+	suite.queue.Release(suite.object)
+	assert.True(suite.T(), suite.fs.changeCache.Exist("container", "dir/item"))
+
+	suite.queue.Cancel("container", "dir/item")
+	suite.queue.Acquire(suite.object)
+	suite.queue.Acquire(suite.object)
+	suite.queue.Release(suite.object)
+	assert.True(suite.T(), suite.fs.changeCache.Exist("container", "dir/item"))
+	suite.queue.Release(suite.object)
+	assert.False(suite.T(), suite.fs.changeCache.Exist("container", "dir/item"))
+	assert.Equal(suite.T(), 0, suite.object.writers)
+}
+
+func (suite *WriteBackTestSuite) TestOpenAppend() {
+	suite.fs.WriteBack = true
+	suite.fs.writeBackQueue = suite.queue
+	require.Nil(suite.T(), suite.backend.ObjectPutBytes("container", "dir/item", []byte("content"), ""))
+	suite.object.so.Bytes = 7
+
+	// Segments are appended in swift
+	var flags fuse.OpenResponseFlags
+	fh, err := suite.object.open(fuse.OpenWriteOnly|fuse.OpenAppend, &flags)
+	require.Nil(suite.T(), err)
+	assert.Nil(suite.T(), fh.sf)
+	require.Nil(suite.T(), fh.Release(nil, &fuse.ReleaseRequest{}))
+
+	// Unless the object is waiting to be uploaded
+	suite.enqueue("updated")
+	fh, err = suite.object.open(fuse.OpenWriteOnly|fuse.OpenAppend, &flags)
+	require.Nil(suite.T(), err)
+	require.NotNil(suite.T(), fh.sf)
+	data := make([]byte, 7)
+	_, err = fh.sf.ReadAt(data, 0)
+	require.Nil(suite.T(), err)
+	assert.Equal(suite.T(), "updated", string(data))
+	require.Nil(suite.T(), fh.Release(nil, &fuse.ReleaseRequest{}))
+
+	suite.queue.Cancel("container", "dir/item")
+}
+
+func (suite *WriteBackTestSuite) TestNextSkipsActive() {
+	suite.enqueue("first")
+	first := suite.queue.next()
+	suite.enqueue("second")
+
+	// A newer version waits for the upload in progress
+	suite.queue.mutex.Lock()
+	assert.Len(suite.T(), suite.queue.order, 1)
+	assert.Equal(suite.T(), first, suite.queue.active["container:dir/item"])
+	delete(suite.queue.active, "container:dir/item")
+	suite.queue.mutex.Unlock()
+
+	first.discard()
+	suite.queue.Cancel("container", "dir/item")
+}
+
+func (suite *WriteBackTestSuite) TestUploadWhileModified() {
+	suite.fs.SegmentSize = 1 << 20
+	suite.fs.WriteBackWorkers = 1
+	suite.queue.Start()
+
+	// Handles modifying the object hold its lock
+	suite.object.m.Lock()
+	suite.enqueue("content")
+	require.Nil(suite.T(), suite.queue.Wait("container", "dir/item"))
+	suite.object.m.Unlock()
+
+	so, _, err := suite.backend.Object("container", "dir/item")
+	require.Nil(suite.T(), err)
+	assert.Equal(suite.T(), int64(7), so.Bytes)
+}
+
+func (suite *WriteBackTestSuite) TestUploadFailureAbortsWriter() {
+	suite.fs.SegmentSize = 1 << 20
+
+	sf, err := suite.fs.objectSpool.Create()
+	require.Nil(suite.T(), err)
+	defer sf.Remove()
+	require.Nil(suite.T(), sf.Fill(strings.NewReader("content"), 7))
+
+	// Local content can't be read anymore
+	sf.file.Close()
+
+	target := stagedTarget(suite.object)
+	assert.NotNil(suite.T(), uploadStaged(target, sf))
+	assert.False(suite.T(), target.writing)
+
+	// The partial object is discarded
+	tmp, err := ioutil.ReadDir(filepath.Join(suite.dir, "storage", "container", localTempDir))
+	require.Nil(suite.T(), err)
+	assert.Empty(suite.T(), tmp)
+	_, _, err = suite.backend.Object("container", "dir/item")
+	assert.Equal(suite.T(), swift.ObjectNotFound, err)
+}
+
+func (suite *WriteBackTestSuite) TestStopGivesUpRetries() {
+	suite.fs.SegmentSize = 1 << 20
+	suite.fs.WriteBackWorkers = 2
+	suite.fs.WriteBackRetries = 10
+	suite.queue.Start()
+
+	// Local content can't be read anymore
+	sf := suite.enqueue("content")
+	sf.file.Close()
+
+	stopped := make(chan struct{})
+	go func() {
+		suite.queue.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		suite.T().Fatal("Workers not stopped")
+	}
+
+	// The file is uploaded by the next mount
+	assert.Len(suite.T(), suite.journals(), 1)
+}
+
+func (suite *WriteBackTestSuite) TestRetryDelay() {
+	assert.Equal(suite.T(), time.Second, writeBackRetryDelay(0))
+	assert.Equal(suite.T(), 4*time.Second, writeBackRetryDelay(2))
+	assert.Equal(suite.T(), writeBackRetryMaxDelay, writeBackRetryDelay(10))
+	assert.Equal(suite.T(), writeBackRetryMaxDelay, writeBackRetryDelay(64))
+}
+
+func (suite *WriteBackTestSuite) TestFsyncWhileWriting() {
+	suite.fs.WriteBack = true
+	suite.fs.SegmentSize = 1 << 20
+	suite.fs.WriteBackWorkers = 1
+	suite.fs.writeBackQueue = suite.queue
+	suite.queue.Start()
+
+	var flags fuse.OpenResponseFlags
+	fh, err := suite.object.open(fuse.OpenReadWrite|fuse.OpenCreate, &flags)
+	require.Nil(suite.T(), err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := int64(0); i < 100; i++ {
+			fh.Write(nil, &fuse.WriteRequest{Data: []byte("x"), Offset: i}, &fuse.WriteResponse{})
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		require.Nil(suite.T(), suite.object.Fsync(nil, &fuse.FsyncRequest{}))
+	}
+	<-done
+
+	require.Nil(suite.T(), suite.object.Fsync(nil, &fuse.FsyncRequest{}))
+	require.Nil(suite.T(), fh.Release(nil, &fuse.ReleaseRequest{}))
+	so, _, err := suite.backend.Object("container", "dir/item")
+	require.Nil(suite.T(), err)
+	assert.Equal(suite.T(), int64(100), so.Bytes)
+}
+
+func (suite *WriteBackTestSuite) TestApplyKeepsNewest() {
+	older := &writeBackEntry{seq: 1, object: suite.object, target: &Object{sh: swift.Headers{"A": "1"}}}
+	newer := &writeBackEntry{seq: 2, object: suite.object, target: &Object{sh: swift.Headers{"A": "2"}, segmented: true}}
+
+	newer.apply()
+	older.apply()
+
+	assert.Equal(suite.T(), "2", suite.object.sh["A"])
+	assert.True(suite.T(), suite.object.segmented)
+}
+
+// replay replays journals as done by a new mount, returning
+// the queue they were pushed to.
+func (suite *WriteBackTestSuite) replay() *WriteBackQueue {
+	suite.fs.changeCache = NewSimpleCache()
+	suite.fs.writeBackQueue = NewWriteBackQueue(suite.fs)
+	require.Nil(suite.T(), suite.fs.ReplayWriteBackJournals())
+	return suite.fs.writeBackQueue
+}
+
+func (suite *WriteBackTestSuite) TestReplay() {
+	suite.enqueue("content")
+
+	queue := suite.replay()
+
+	e := queue.queued["container:dir/item"]
+	require.NotNil(suite.T(), e)
+	assert.Equal(suite.T(), uint64(7), e.sf.Size())
+	assert.True(suite.T(), suite.fs.changeCache.Exist("container", "dir/item"))
+
+	// Renaming or removing the node requires its parent
+	require.NotNil(suite.T(), e.object.p)
+	assert.Equal(suite.T(), "dir/", e.object.p.path)
+	assert.Equal(suite.T(), "container", e.object.p.c.Name)
+}
+
+func (suite *WriteBackTestSuite) TestReplayOtherStorage() {
+	suite.enqueue("content")
+
+	storage := filepath.Join(suite.dir, "other")
+	require.Nil(suite.T(), os.Mkdir(storage, 0700))
+	backend, err := NewLocalBackend(storage)
+	require.Nil(suite.T(), err)
+	suite.fs.Storage = backend
+
+	queue := suite.replay()
+
+	assert.Empty(suite.T(), queue.queued)
+	assert.Len(suite.T(), suite.journals(), 1)
+}
+
+func (suite *WriteBackTestSuite) TestReplayMissingContainer() {
+	suite.object.c = &swift.Container{Name: "missing"}
+	sf := suite.enqueue("content")
+
+	queue := suite.replay()
+
+	// Kept for the next mount
+	assert.Empty(suite.T(), queue.queued)
+	assert.Len(suite.T(), suite.journals(), 1)
+	_, err := os.Stat(sf.file.Name())
+	assert.Nil(suite.T(), err)
+}
+
+func (suite *WriteBackTestSuite) TestReplaySpoolFull() {
+	sf := suite.enqueue("content")
+	suite.object = &Object{
+		fs:   suite.fs,
+		name: "small",
+		path: "small",
+		so:   &swift.Object{Name: "small"},
+		c:    &swift.Container{Name: "container"},
+	}
+	suite.enqueue("ab")
+
+	// Spool files are only reserved again on replay
+	suite.fs.objectSpool = NewSpool(filepath.Join(suite.dir, "spool"), 4)
+	queue := suite.replay()
+
+	// Kept for the next mount
+	assert.Len(suite.T(), queue.queued, 1)
+	assert.NotNil(suite.T(), queue.queued["container:small"])
+	assert.Len(suite.T(), suite.journals(), 2)
+	_, err := os.Stat(sf.file.Name())
+	assert.Nil(suite.T(), err)
+}
+
+func TestWriteBackSuite(t *testing.T) {
+	suite.Run(t, new(WriteBackTestSuite))
+}
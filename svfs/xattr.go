@@ -0,0 +1,219 @@
+package svfs
+
+import (
+	"encoding/hex"
+	"sort"
+	"strings"
+	"syscall"
+
+	"bazil.org/fuse"
+	"github.com/xlucas/swift"
+)
+
+const (
+	containerMetaHeader = "X-Container-Meta-"
+	userXattrPrefix     = "user."
+	systemXattrPrefix   = "svfs."
+	policyXattr         = "policy"
+)
+
+var (
+	// objectSystemXattrs maps read-only extended attributes of the
+	// svfs namespace to the object headers holding their value.
+	objectSystemXattrs = map[string]string{
+		"cache_control":       "Cache-Control",
+		"content_disposition": "Content-Disposition",
+		"content_encoding":    "Content-Encoding",
+		"content_type":        "Content-Type",
+		"delete_at":           "X-Delete-At",
+		"etag":                "Etag",
+		"last_modified":       "Last-Modified",
+		"manifest":            manifestHeader,
+		"static_large_object": "X-Static-Large-Object",
+		"timestamp":           "X-Timestamp",
+	}
+	// objectWritableXattrs are the attributes of the svfs namespace
+	// that can be changed on objects.
+	objectWritableXattrs = map[string]bool{
+		"cache_control":       true,
+		"content_disposition": true,
+		"content_encoding":    true,
+		"content_type":        true,
+	}
+	// containerSystemXattrs maps read-only extended attributes of the
+	// svfs namespace to the container headers holding their value.
+	containerSystemXattrs = map[string]string{
+		"bytes_used":   "X-Container-Bytes-Used",
+		"object_count": "X-Container-Object-Count",
+		policyXattr:    storagePolicyHeader,
+		"read_acl":     "X-Container-Read",
+		"timestamp":    "X-Timestamp",
+		"write_acl":    "X-Container-Write",
+	}
+)
+
+// xattrHeader returns the object header holding an extended attribute.
+func xattrHeader(name string) string {
+	return canonicalHeaderKey(objectMetaHeaderXattr + name)
+}
+
+// getXattr decodes an extended attribute stored in object headers.
+func getXattr(headers swift.Headers, name string) ([]byte, error) {
+	return hex.DecodeString(headers[xattrHeader(name)])
+}
+
+// listXattr appends names of extended attributes stored in object
+// headers to the given response.
+func listXattr(headers swift.Headers, resp *fuse.ListxattrResponse) {
+	var keys []string
+
+	for k := range headers.ObjectMetadataXattr().Headers(objectMetaHeaderXattr) {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		resp.Append(strings.TrimPrefix(key, objectMetaHeaderXattr))
+	}
+}
+
+// xattrChanges returns the metadata change storing an extended
+// attribute in object headers, or nothing if it's already stored.
+// Empty values remove the attribute.
+func xattrChanges(headers swift.Headers, name string, value []byte) map[string]string {
+	key, encoded := xattrHeader(name), hex.EncodeToString(value)
+	if headers[key] == encoded {
+		return nil
+	}
+	return map[string]string{key: encoded}
+}
+
+// containerXattrHeader returns the container header holding an
+// extended attribute. Container metadata are exposed within the
+// user namespace only.
+func containerXattrHeader(name string) (string, bool) {
+	if !strings.HasPrefix(name, userXattrPrefix) {
+		return "", false
+	}
+	return canonicalHeaderKey(containerMetaHeader + strings.TrimPrefix(name, userXattrPrefix)), true
+}
+
+// getContainerXattr returns container metadata exposed as an
+// extended attribute.
+func (s *SVFS) getContainerXattr(container, name string) ([]byte, error) {
+	key, ok := containerXattrHeader(name)
+	if !ok {
+		return nil, nil
+	}
+
+	_, h, err := s.Storage.Container(container)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(h[key]), nil
+}
+
+// listContainerXattr appends names of container metadata to the
+// given response.
+func (s *SVFS) listContainerXattr(container string, resp *fuse.ListxattrResponse) error {
+	_, h, err := s.Storage.Container(container)
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+
+	for k := range h.ContainerMetadata() {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		resp.Append(userXattrPrefix + key)
+	}
+
+	return nil
+}
+
+// setContainerXattr stores an extended attribute in container
+// metadata. Values are stored as is and must be valid header values.
+func (s *SVFS) setContainerXattr(container, name string, value []byte) error {
+	key, ok := containerXattrHeader(name)
+	if !ok {
+		return fuse.ENOTSUP
+	}
+
+	if !validHeaderValue(value) {
+		return fuse.Errno(syscall.EINVAL)
+	}
+
+	return s.Storage.ContainerUpdate(container, swift.Headers{key: string(value)})
+}
+
+// removeContainerXattr removes an extended attribute from container
+// metadata.
+func (s *SVFS) removeContainerXattr(container, name string) error {
+	key, ok := containerXattrHeader(name)
+	if !ok {
+		return fuse.ENOTSUP
+	}
+
+	return s.Storage.ContainerUpdate(container, swift.Headers{key: ""})
+}
+
+// systemXattr returns the name within the svfs namespace of an extended
+// attribute. Since some platforms only allow a few namespaces, the svfs
+// namespace is also available within the user namespace. Attributes of
+// this namespace aren't listed, so that tools copying extended
+// attributes don't try to write them.
+func systemXattr(name string) (string, bool) {
+	name = strings.TrimPrefix(name, userXattrPrefix)
+	if !strings.HasPrefix(name, systemXattrPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(name, systemXattrPrefix), true
+}
+
+// getSystemXattr returns the value of an extended attribute of the
+// svfs namespace held by the given headers. It returns ErrNoXattr if
+// the attribute is unknown or missing.
+func getSystemXattr(headers swift.Headers, names map[string]string, name string) ([]byte, error) {
+	value := headers[names[name]]
+	if value == "" {
+		return nil, fuse.ErrNoXattr
+	}
+	return []byte(value), nil
+}
+
+// getObjectSystemXattr returns the value of an extended attribute of
+// the svfs namespace for an object of the given container. Objects
+// use the storage policy of their container.
+func (s *SVFS) getObjectSystemXattr(container string, headers swift.Headers, name string) ([]byte, error) {
+	if name == policyXattr {
+		return s.getContainerSystemXattr(container, name)
+	}
+	return getSystemXattr(headers, objectSystemXattrs, name)
+}
+
+// getContainerSystemXattr returns the value of an extended attribute
+// of the svfs namespace for a container.
+func (s *SVFS) getContainerSystemXattr(container, name string) ([]byte, error) {
+	_, h, err := s.Storage.Container(container)
+	if err != nil {
+		return nil, err
+	}
+	return getSystemXattr(h, containerSystemXattrs, name)
+}
+
+// validHeaderValue tells whether a value can be sent as is in a header.
+func validHeaderValue(value []byte) bool {
+	for _, b := range value {
+		if b < ' ' && b != '\t' || b == 0x7f {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,201 @@
+package svfs
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/xlucas/swift"
+)
+
+type XattrTestSuite struct {
+	localFSSuite
+	container *Directory
+}
+
+func (suite *XattrTestSuite) SetupTest() {
+	suite.localFSSuite.SetupTest()
+	require.NoError(suite.T(), suite.backend.ContainerCreate("container", swift.Headers{
+		"X-Container-Meta-Classification": "internal",
+	}))
+
+	suite.fs.directoryCache = NewCache(time.Minute, -1, -1)
+	suite.fs.Xattr = true
+	suite.container = &Directory{fs: suite.fs, c: &swift.Container{Name: "container"}}
+}
+
+func (suite *XattrTestSuite) list(n fs.NodeListxattrer) []byte {
+	resp := &fuse.ListxattrResponse{}
+	require.NoError(suite.T(), n.Listxattr(nil, &fuse.ListxattrRequest{}, resp))
+	return resp.Xattr
+}
+
+func (suite *XattrTestSuite) TestContainer() {
+	resp := &fuse.GetxattrResponse{}
+	require.NoError(suite.T(), suite.container.Getxattr(nil, &fuse.GetxattrRequest{Name: "user.classification"}, resp))
+	assert.Equal(suite.T(), []byte("internal"), resp.Xattr)
+
+	req := &fuse.SetxattrRequest{Name: "user.owner", Xattr: []byte("team")}
+	require.NoError(suite.T(), suite.container.Setxattr(nil, req))
+	assert.Equal(suite.T(), []byte("user.classification\x00user.owner\x00"), suite.list(suite.container))
+
+	require.NoError(suite.T(), suite.container.Removexattr(nil, &fuse.RemovexattrRequest{Name: "user.classification"}))
+	_, h, err := suite.backend.Container("container")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), swift.Metadata{"owner": "team"}, h.ContainerMetadata())
+}
+
+func (suite *XattrTestSuite) TestContainerInvalid() {
+	req := &fuse.SetxattrRequest{Name: "security.label", Xattr: []byte("team")}
+	assert.Equal(suite.T(), fuse.ENOTSUP, suite.container.Setxattr(nil, req))
+
+	req = &fuse.SetxattrRequest{Name: "user.owner", Xattr: []byte("line\nbreak")}
+	assert.Equal(suite.T(), fuse.Errno(syscall.EINVAL), suite.container.Setxattr(nil, req))
+}
+
+func (suite *XattrTestSuite) TestDirectory() {
+	node, err := suite.container.Mkdir(nil, &fuse.MkdirRequest{Name: "dir"})
+	require.NoError(suite.T(), err)
+	d := node.(*Directory)
+
+	require.NoError(suite.T(), suite.backend.ObjectUpdate("container", "dir/", swift.Headers{objectMtimeHeader: "1500000000"}))
+	d.sh[objectMtimeHeader] = "1500000000"
+
+	req := &fuse.SetxattrRequest{Name: "user.label", Xattr: []byte("secret")}
+	require.NoError(suite.T(), d.Setxattr(nil, req))
+
+	resp := &fuse.GetxattrResponse{}
+	require.NoError(suite.T(), d.Getxattr(nil, &fuse.GetxattrRequest{Name: "user.label"}, resp))
+	assert.Equal(suite.T(), []byte("secret"), resp.Xattr)
+	assert.Equal(suite.T(), []byte("User.label\x00"), suite.list(d))
+
+	// Other metadata are kept
+	_, h, err := suite.backend.Object("container", "dir/")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "1500000000", h[objectMtimeHeader])
+	assert.Equal(suite.T(), "736563726574", h[xattrHeader("user.label")])
+
+	require.NoError(suite.T(), d.Removexattr(nil, &fuse.RemovexattrRequest{Name: "user.label"}))
+	_, h, err = suite.backend.Object("container", "dir/")
+	require.NoError(suite.T(), err)
+	assert.Empty(suite.T(), h[xattrHeader("user.label")])
+	assert.Empty(suite.T(), suite.list(d))
+}
+
+func (suite *XattrTestSuite) TestPseudoDirectory() {
+	d := &Directory{fs: suite.fs, c: suite.container.c, path: "dir/", so: &swift.Object{Name: "dir/", PseudoDirectory: true}}
+
+	req := &fuse.SetxattrRequest{Name: "user.label", Xattr: []byte("secret")}
+	assert.Equal(suite.T(), fuse.ENOTSUP, d.Setxattr(nil, req))
+	assert.Empty(suite.T(), suite.list(d))
+	assert.Equal(suite.T(), fuse.ErrNoXattr, d.Getxattr(nil, &fuse.GetxattrRequest{Name: "user.label"}, &fuse.GetxattrResponse{}))
+}
+
+func (suite *XattrTestSuite) TestSymlink() {
+	node, err := suite.container.Symlink(nil, &fuse.SymlinkRequest{NewName: "link", Target: "file"})
+	require.NoError(suite.T(), err)
+	s := node.(*Symlink)
+
+	req := &fuse.SetxattrRequest{Name: "user.label", Xattr: []byte("secret")}
+	require.NoError(suite.T(), s.Setxattr(nil, req))
+
+	resp := &fuse.GetxattrResponse{}
+	require.NoError(suite.T(), s.Getxattr(nil, &fuse.GetxattrRequest{Name: "user.label"}, resp))
+	assert.Equal(suite.T(), []byte("secret"), resp.Xattr)
+
+	// The symlink target is kept
+	_, h, err := suite.backend.Object("container", "link")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "file", h[objectSymlinkHeader])
+	assert.Equal(suite.T(), "736563726574", h[xattrHeader("user.label")])
+}
+
+func (suite *XattrTestSuite) TestSystem() {
+	require.NoError(suite.T(), suite.backend.ContainerCreate("container", swift.Headers{storagePolicyHeader: "PCA"}))
+	require.NoError(suite.T(), suite.backend.ObjectPutBytes("container", "file", []byte("content"), "text/plain"))
+	o := suite.object("file")
+
+	for name, value := range map[string]string{
+		"svfs.etag":              "9a0364b9e99bb480dd25e1f0284c8555",
+		"user.svfs.content_type": "text/plain",
+		"svfs.policy":            "PCA",
+	} {
+		resp := &fuse.GetxattrResponse{}
+		require.NoError(suite.T(), o.Getxattr(nil, &fuse.GetxattrRequest{Name: name}, resp), name)
+		assert.Equal(suite.T(), value, string(resp.Xattr), name)
+	}
+
+	resp := &fuse.GetxattrResponse{}
+	assert.Equal(suite.T(), fuse.ErrNoXattr, o.Getxattr(nil, &fuse.GetxattrRequest{Name: "svfs.manifest"}, resp))
+	assert.Equal(suite.T(), fuse.ErrNoXattr, o.Getxattr(nil, &fuse.GetxattrRequest{Name: "svfs.unknown"}, resp))
+
+	// System metadata are read-only and not listed
+	req := &fuse.SetxattrRequest{Name: "svfs.etag", Xattr: []byte("etag")}
+	assert.Equal(suite.T(), fuse.EPERM, o.Setxattr(nil, req))
+	assert.Equal(suite.T(), fuse.EPERM, o.Removexattr(nil, &fuse.RemovexattrRequest{Name: "user.svfs.etag"}))
+	assert.Empty(suite.T(), suite.list(o))
+}
+
+func (suite *XattrTestSuite) TestSystemWritable() {
+	require.NoError(suite.T(), suite.backend.ObjectPutBytes("container", "file", []byte("content"), "text/plain"))
+	o := suite.object("file")
+
+	req := &fuse.SetxattrRequest{Name: "user.svfs.cache_control", Xattr: []byte("no-cache")}
+	require.NoError(suite.T(), o.Setxattr(nil, req))
+	req = &fuse.SetxattrRequest{Name: "svfs.content_type", Xattr: []byte("text/html")}
+	require.NoError(suite.T(), o.Setxattr(nil, req))
+	assert.Equal(suite.T(), "text/html", o.so.ContentType)
+
+	// Other headers are kept
+	so, sh, err := suite.backend.Object("container", "file")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "text/html", so.ContentType)
+	assert.Equal(suite.T(), "no-cache", sh["Cache-Control"])
+
+	require.NoError(suite.T(), o.Removexattr(nil, &fuse.RemovexattrRequest{Name: "svfs.cache_control"}))
+	_, sh, err = suite.backend.Object("container", "file")
+	require.NoError(suite.T(), err)
+	assert.Empty(suite.T(), sh["Cache-Control"])
+
+	// Content type can't be removed nor contain control characters
+	assert.Equal(suite.T(), fuse.EPERM, o.Removexattr(nil, &fuse.RemovexattrRequest{Name: "svfs.content_type"}))
+	req = &fuse.SetxattrRequest{Name: "svfs.content_type", Xattr: []byte("text/html\n")}
+	assert.Equal(suite.T(), fuse.Errno(syscall.EINVAL), o.Setxattr(nil, req))
+}
+
+func (suite *XattrTestSuite) TestSystemContainer() {
+	require.NoError(suite.T(), suite.backend.ObjectPutBytes("container", "file", []byte("content"), ""))
+
+	resp := &fuse.GetxattrResponse{}
+	require.NoError(suite.T(), suite.container.Getxattr(nil, &fuse.GetxattrRequest{Name: "user.svfs.object_count"}, resp))
+	assert.Equal(suite.T(), []byte("1"), resp.Xattr)
+
+	req := &fuse.SetxattrRequest{Name: "user.svfs.policy", Xattr: []byte("PCA")}
+	assert.Equal(suite.T(), fuse.EPERM, suite.container.Setxattr(nil, req))
+	assert.Equal(suite.T(), []byte("user.classification\x00"), suite.list(suite.container))
+}
+
+func (suite *XattrTestSuite) TestSystemPseudoDirectory() {
+	require.NoError(suite.T(), suite.backend.ContainerCreate("container", swift.Headers{storagePolicyHeader: "PCA"}))
+	d := &Directory{fs: suite.fs, c: suite.container.c, path: "dir/", so: &swift.Object{Name: "dir/", PseudoDirectory: true}}
+
+	resp := &fuse.GetxattrResponse{}
+	require.NoError(suite.T(), d.Getxattr(nil, &fuse.GetxattrRequest{Name: "svfs.policy"}, resp))
+	assert.Equal(suite.T(), []byte("PCA"), resp.Xattr)
+	assert.Equal(suite.T(), fuse.ErrNoXattr, d.Getxattr(nil, &fuse.GetxattrRequest{Name: "svfs.etag"}, resp))
+}
+
+func (suite *XattrTestSuite) TestDisabled() {
+	suite.fs.Xattr = false
+	req := &fuse.SetxattrRequest{Name: "user.owner", Xattr: []byte("team")}
+	assert.Equal(suite.T(), fuse.ENOTSUP, suite.container.Setxattr(nil, req))
+}
+
+func TestXattrSuite(t *testing.T) {
+	suite.Run(t, new(XattrTestSuite))
+}